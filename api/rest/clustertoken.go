@@ -0,0 +1,122 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/event"
+)
+
+// The bearer tokens of the kubernetes clusters in the deploy plans are only
+// set by the API, they are encrypted to store and removed from the responses.
+// The workers get them decrypted by a file when they are started.
+
+// encryptPlanTokens encrypts the cluster tokens set to the deploy plans.
+func encryptPlanTokens(plans []api.DeployPlan) error {
+	for i := range plans {
+		token, err := event.EncryptClusterToken(plans[i].Config.ClusterToken)
+		if err != nil {
+			return err
+		}
+		plans[i].Config.ClusterToken = token
+	}
+	return nil
+}
+
+// encryptServiceTokens encrypts the cluster tokens set to the deploy plans of
+// the service and its environments.
+func encryptServiceTokens(service *api.Service) error {
+	if err := encryptPlanTokens(service.DeployPlans); err != nil {
+		return err
+	}
+	for i := range service.Environments {
+		if err := encryptPlanTokens(service.Environments[i].DeployPlans); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encryptVersionTokens encrypts the cluster tokens set to the deploy plans of
+// the version.
+func encryptVersionTokens(version *api.Version) error {
+	for i := range version.DeployPlansStatuses {
+		config := &version.DeployPlansStatuses[i].Config
+		token, err := event.EncryptClusterToken(config.ClusterToken)
+		if err != nil {
+			return err
+		}
+		config.ClusterToken = token
+	}
+	return nil
+}
+
+// hidePlanTokens removes the cluster tokens from the deploy plans.
+func hidePlanTokens(plans []api.DeployPlan) {
+	for i := range plans {
+		plans[i].Config.ClusterToken = ""
+	}
+}
+
+// hideServiceTokens removes the cluster tokens from the deploy plans of the
+// service and its environments.
+func hideServiceTokens(service *api.Service) {
+	hidePlanTokens(service.DeployPlans)
+	for i := range service.Environments {
+		hidePlanTokens(service.Environments[i].DeployPlans)
+	}
+}
+
+// hideVersionTokens removes the cluster tokens from the deploy plans of the
+// version.
+func hideVersionTokens(version *api.Version) {
+	for i := range version.DeployPlansStatuses {
+		version.DeployPlansStatuses[i].Config.ClusterToken = ""
+	}
+}
+
+// keepPlanTokens sets the cluster tokens of the deploy plans updated without
+// tokens to the tokens of the previous plans of the same name and cluster, as
+// the tokens are not returned to be sent back.
+func keepPlanTokens(plans, previous []api.DeployPlan) {
+	for i := range plans {
+		config := &plans[i].Config
+		if config.ClusterToken != "" {
+			continue
+		}
+		for _, plan := range previous {
+			if plan.PlanName == plans[i].PlanName && plan.Config.ClusterHost == config.ClusterHost {
+				config.ClusterToken = plan.Config.ClusterToken
+				break
+			}
+		}
+	}
+}
+
+// keepServiceTokens keeps the cluster tokens of the deploy plans of the service
+// and its environments updated without tokens.
+func keepServiceTokens(service, previous *api.Service) {
+	keepPlanTokens(service.DeployPlans, previous.DeployPlans)
+	for i := range service.Environments {
+		for _, env := range previous.Environments {
+			if env.Name == service.Environments[i].Name {
+				keepPlanTokens(service.Environments[i].DeployPlans, env.DeployPlans)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"os"
+	"testing"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/event"
+)
+
+// newPlan creates a deploy plan to the kubernetes cluster with the token.
+func newPlan(name, host, token string) api.DeployPlan {
+	return api.DeployPlan{
+		PlanName: name,
+		Config: api.DeployConfig{
+			ClusterType:  api.ClusterTypeKubernetes,
+			ClusterHost:  host,
+			ClusterToken: token,
+		},
+	}
+}
+
+// TestHideServiceTokens tests the cluster tokens are removed from the deploy
+// plans of the service and its environments.
+func TestHideServiceTokens(t *testing.T) {
+	service := &api.Service{
+		DeployPlans: []api.DeployPlan{newPlan("web", "https://k8s", "secret")},
+		Environments: []api.Environment{
+			{Name: "staging", DeployPlans: []api.DeployPlan{newPlan("web-staging", "https://k8s", "secret")}},
+		},
+	}
+
+	hideServiceTokens(service)
+	if token := service.DeployPlans[0].Config.ClusterToken; token != "" {
+		t.Errorf("Expect the token of the plan to be removed, got %s", token)
+	}
+	if token := service.Environments[0].DeployPlans[0].Config.ClusterToken; token != "" {
+		t.Errorf("Expect the token of the environment plan to be removed, got %s", token)
+	}
+}
+
+// TestKeepServiceTokens tests the plans updated without tokens keep the tokens
+// of the previous plans of the same name and cluster.
+func TestKeepServiceTokens(t *testing.T) {
+	previous := &api.Service{
+		DeployPlans: []api.DeployPlan{
+			newPlan("web", "https://k8s", "web-secret"),
+			newPlan("moved", "https://k8s", "moved-secret"),
+		},
+		Environments: []api.Environment{
+			{Name: "staging", DeployPlans: []api.DeployPlan{newPlan("web", "https://k8s", "staging-secret")}},
+		},
+	}
+	service := &api.Service{
+		DeployPlans: []api.DeployPlan{
+			newPlan("web", "https://k8s", ""),
+			newPlan("moved", "https://other-k8s", ""),
+			newPlan("new", "https://k8s", "new-secret"),
+		},
+		Environments: []api.Environment{
+			{Name: "staging", DeployPlans: []api.DeployPlan{newPlan("web", "https://k8s", "")}},
+			{Name: "production", DeployPlans: []api.DeployPlan{newPlan("web", "https://k8s", "")}},
+		},
+	}
+
+	keepServiceTokens(service, previous)
+	expected := []string{"web-secret", "", "new-secret"}
+	for i, plan := range service.DeployPlans {
+		if plan.Config.ClusterToken != expected[i] {
+			t.Errorf("Expect token %q of plan %s, got %q", expected[i], plan.PlanName, plan.Config.ClusterToken)
+		}
+	}
+	if token := service.Environments[0].DeployPlans[0].Config.ClusterToken; token != "staging-secret" {
+		t.Errorf("Expect the token of the staging plan to be kept, got %q", token)
+	}
+	if token := service.Environments[1].DeployPlans[0].Config.ClusterToken; token != "" {
+		t.Errorf("Expect no token of the new environment, got %q", token)
+	}
+}
+
+// TestEncryptServiceTokens tests the cluster tokens of the service are stored
+// encrypted, and the plans without tokens are left empty.
+func TestEncryptServiceTokens(t *testing.T) {
+	defer os.Setenv(event.CLUSTER_TOKEN_SECRET, os.Getenv(event.CLUSTER_TOKEN_SECRET))
+	os.Setenv(event.CLUSTER_TOKEN_SECRET, "passphrase")
+
+	service := &api.Service{
+		DeployPlans: []api.DeployPlan{newPlan("web", "https://k8s", "secret"), newPlan("api", "https://k8s", "")},
+		Environments: []api.Environment{
+			{Name: "staging", DeployPlans: []api.DeployPlan{newPlan("web-staging", "https://k8s", "staging-secret")}},
+		},
+	}
+	if err := encryptServiceTokens(service); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	expected := map[string]string{
+		service.DeployPlans[0].Config.ClusterToken:                 "secret",
+		service.Environments[0].DeployPlans[0].Config.ClusterToken: "staging-secret",
+	}
+	for encrypted, token := range expected {
+		if encrypted == token {
+			t.Errorf("Expect the token %s to be encrypted", token)
+		}
+		if decrypted, err := event.DecryptClusterToken(encrypted); err != nil || decrypted != token {
+			t.Errorf("Expect token %s decrypted, got %q, %v", token, decrypted, err)
+		}
+	}
+	if token := service.DeployPlans[1].Config.ClusterToken; token != "" {
+		t.Errorf("Expect no token of the plan api, got %q", token)
+	}
+
+	os.Setenv(event.CLUSTER_TOKEN_SECRET, "")
+	if err := encryptServiceTokens(&api.Service{DeployPlans: []api.DeployPlan{newPlan("web", "https://k8s", "secret")}}); err == nil {
+		t.Errorf("Expect error to encrypt without the passphrase")
+	}
+}
@@ -19,6 +19,8 @@ package rest
 import (
 	"fmt"
 	"net/http"
+	"path/filepath"
+	"strings"
 
 	"github.com/caicloud/cyclone/api"
 	"github.com/caicloud/cyclone/pkg/log"
@@ -56,6 +58,17 @@ func createDeploy(request *restful.Request, response *restful.Response) {
 		response.WriteErrorString(http.StatusBadRequest, "Unable to use request body")
 		return
 	}
+	if err := checkDeployPlans(deploy.DeployPlans); err != nil {
+		response.AddHeader("Content-Type", "text/plain")
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := encryptPlanTokens(deploy.DeployPlans); err != nil {
+		log.ErrorWithFields("Unable to encrypt cluster tokens", log.Fields{"error": err})
+		response.AddHeader("Content-Type", "text/plain")
+		response.WriteErrorString(http.StatusInternalServerError, "Unable to encrypt cluster tokens")
+		return
+	}
 
 	log.Info("fornax receives creating deploy request")
 
@@ -122,6 +135,7 @@ func getDeploy(request *restful.Request, response *restful.Response) {
 		response.WriteHeaderAndEntity(http.StatusNotFound, message)
 		return
 	}
+	hidePlanTokens(result.DeployPlans)
 	getResponse.Deploy = *result
 
 	response.WriteEntity(getResponse)
@@ -155,6 +169,17 @@ func setDeploy(request *restful.Request, response *restful.Response) {
 		response.WriteErrorString(http.StatusBadRequest, "Unable to use request body")
 		return
 	}
+	if err := checkDeployPlans(deploy.DeployPlans); err != nil {
+		response.AddHeader("Content-Type", "text/plain")
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := encryptPlanTokens(deploy.DeployPlans); err != nil {
+		log.ErrorWithFields("Unable to encrypt cluster tokens", log.Fields{"error": err})
+		response.AddHeader("Content-Type", "text/plain")
+		response.WriteErrorString(http.StatusInternalServerError, "Unable to encrypt cluster tokens")
+		return
+	}
 
 	var setResponse api.DeploySetResponse
 	userID := request.PathParameter("user_id")
@@ -210,3 +235,71 @@ func setDeploy(request *restful.Request, response *restful.Response) {
 	setResponse.DeployID = deployID
 	response.WriteHeaderAndEntity(http.StatusAccepted, setResponse)
 }
+
+// checkDeployPlans checks the strategies of the deploy plans, the strategies
+// other than rolling are only supported by the kubernetes API. So are the
+// manifests, which are applied by the rolling strategy only.
+func checkDeployPlans(plans []api.DeployPlan) error {
+	for _, plan := range plans {
+		if err := checkPlanManifests(plan); err != nil {
+			return err
+		}
+		strategy := plan.Config.Strategy
+		switch strategy.Type {
+		case "", api.DeployStrategyRolling:
+			continue
+		case api.DeployStrategyCanary, api.DeployStrategyBlueGreen:
+		default:
+			return fmt.Errorf("unknown strategy %s of plan %s", strategy.Type, plan.PlanName)
+		}
+		if plan.Config.ClusterType != api.ClusterTypeKubernetes {
+			return fmt.Errorf("strategy %s of plan %s needs the cluster type %s",
+				strategy.Type, plan.PlanName, api.ClusterTypeKubernetes)
+		}
+		if strategy.Replicas < 0 || strategy.BakeTime < 0 {
+			return fmt.Errorf("replicas and bake time of plan %s can not be negative", plan.PlanName)
+		}
+		if strategy.Type == api.DeployStrategyBlueGreen && strategy.Service == "" {
+			return fmt.Errorf("blue-green strategy of plan %s needs the service", plan.PlanName)
+		}
+	}
+	return nil
+}
+
+// checkPlanManifests checks the manifests of the deploy plan are in the repository.
+func checkPlanManifests(plan api.DeployPlan) error {
+	manifests := plan.Config.Manifests
+	if manifests == "" {
+		return nil
+	}
+	if plan.Config.ClusterType != api.ClusterTypeKubernetes {
+		return fmt.Errorf("manifests of plan %s need the cluster type %s", plan.PlanName, api.ClusterTypeKubernetes)
+	}
+	clean := filepath.Clean(manifests)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("manifests of plan %s should be a relative path in the repository", plan.PlanName)
+	}
+	if strategy := plan.Config.Strategy.Type; strategy != "" && strategy != api.DeployStrategyRolling {
+		return fmt.Errorf("manifests of plan %s can not be deployed by strategy %s", plan.PlanName, strategy)
+	}
+	return nil
+}
+
+// checkEnvironments checks the names of the environments are unique, and their
+// deploy plans are valid.
+func checkEnvironments(environments []api.Environment) error {
+	names := make(map[string]bool)
+	for _, env := range environments {
+		if env.Name == "" {
+			return fmt.Errorf("the name of environment can not be empty")
+		}
+		if names[env.Name] {
+			return fmt.Errorf("duplicate environment %s", env.Name)
+		}
+		names[env.Name] = true
+		if err := checkDeployPlans(env.DeployPlans); err != nil {
+			return err
+		}
+	}
+	return nil
+}
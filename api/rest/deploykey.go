@@ -0,0 +1,201 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/event"
+	"github.com/caicloud/cyclone/pkg/log"
+	"github.com/caicloud/cyclone/pkg/sshkey"
+	"github.com/caicloud/cyclone/store"
+	"github.com/emicklei/go-restful"
+	"gopkg.in/mgo.v2"
+)
+
+// setDeployKey sets the SSH deploy key of a service to clone its repository,
+// a keypair is generated if the private key is not given. The private key is
+// stored encrypted, and the public key is registered as a read-only deploy key
+// if the repository is hosted in GitHub or GitLab.
+//
+// PUT: /api/v0.1/:uid/services/:service_id/deploykey
+//
+// PAYLOAD (DeployKeySetRequest):
+//   {
+//     "private_key": (string) PEM encoded private key, generated if empty
+//     "public_key": (string) public key in the authorized_keys format, required if
+//        it can't be derived from the private key
+//     "known_hosts": (string) trusted host keys of the repository host in the
+//        known_hosts format, the host keys are scanned on clone if empty
+//   }
+//
+// RESPONSE: (DeployKeySetResponse)
+//  {
+//    "public_key": (string) the public key to add to the repository.
+//    "registered": (bool) whether the key is registered in GitHub or GitLab.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func setDeployKey(request *restful.Request, response *restful.Response) {
+	userID := request.PathParameter("user_id")
+	serviceID := request.PathParameter("service_id")
+
+	var keyRequest api.DeployKeySetRequest
+	if err := request.ReadEntity(&keyRequest); err != nil {
+		response.AddHeader("Content-Type", "text/plain")
+		response.WriteErrorString(http.StatusBadRequest, "Unable to parse request body")
+		return
+	}
+
+	var setResponse api.DeployKeySetResponse
+	ds := store.NewStore()
+	defer ds.Close()
+
+	service, err := ds.FindServiceByID(serviceID)
+	if err != nil {
+		message := fmt.Sprintf("Unable to find service %s", serviceID)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		setResponse.ErrorMessage = message
+		status := http.StatusInternalServerError
+		if err == mgo.ErrNotFound {
+			status = http.StatusNotFound
+		}
+		response.WriteHeaderAndEntity(status, setResponse)
+		return
+	}
+	if service.Repository.Vcs != api.Git {
+		setResponse.ErrorMessage = "Deploy keys are only supported for git repositories"
+		response.WriteHeaderAndEntity(http.StatusBadRequest, setResponse)
+		return
+	}
+
+	privateKey, publicKey := keyRequest.PrivateKey, keyRequest.PublicKey
+	if privateKey == "" {
+		privateKey, publicKey, err = sshkey.Generate()
+		if err != nil {
+			message := "Unable to generate deploy key"
+			log.ErrorWithFields(message, log.Fields{"service_id": serviceID, "error": err})
+			setResponse.ErrorMessage = message
+			response.WriteHeaderAndEntity(http.StatusInternalServerError, setResponse)
+			return
+		}
+	} else if publicKey == "" {
+		publicKey, err = sshkey.PublicKey(privateKey)
+		if err != nil {
+			setResponse.ErrorMessage = fmt.Sprintf("Public key is required: %v", err)
+			response.WriteHeaderAndEntity(http.StatusBadRequest, setResponse)
+			return
+		}
+	}
+
+	encrypted, err := event.EncryptDeployKey(privateKey)
+	if err != nil {
+		message := "Unable to encrypt deploy key"
+		log.ErrorWithFields(message, log.Fields{"service_id": serviceID, "error": err})
+		setResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, setResponse)
+		return
+	}
+
+	// Replace the deploy key registered before.
+	deleteRemoteDeployKey(service)
+	service.Repository.DeployKey = api.DeployKey{
+		PublicKey:  publicKey,
+		PrivateKey: encrypted,
+		KnownHosts: keyRequest.KnownHosts,
+	}
+	if remote, err := remoteManager.FindRemote(service.Repository.SubVcs); err == nil {
+		id, err := remote.CreateDeployKey(service)
+		if err != nil {
+			log.WarnWithFields("Unable to register deploy key", log.Fields{"service_id": serviceID, "error": err})
+		} else {
+			service.Repository.DeployKey.RemoteID = id
+			setResponse.Registered = true
+		}
+	}
+
+	if err := ds.SetServiceDeployKey(serviceID, service.Repository.DeployKey); err != nil {
+		message := fmt.Sprintf("Unable to set deploy key of service %s", serviceID)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		setResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, setResponse)
+		return
+	}
+
+	setResponse.PublicKey = publicKey
+	response.WriteEntity(setResponse)
+}
+
+// deleteDeployKey removes the deploy key of a service, it's also removed from
+// GitHub or GitLab if registered.
+//
+// DELETE: /api/v0.1/:uid/services/:service_id/deploykey
+//
+// RESPONSE: (DeployKeyDelResponse)
+//  {
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func deleteDeployKey(request *restful.Request, response *restful.Response) {
+	userID := request.PathParameter("user_id")
+	serviceID := request.PathParameter("service_id")
+
+	var delResponse api.DeployKeyDelResponse
+	ds := store.NewStore()
+	defer ds.Close()
+
+	service, err := ds.FindServiceByID(serviceID)
+	if err != nil {
+		message := fmt.Sprintf("Unable to find service %s", serviceID)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		delResponse.ErrorMessage = message
+		status := http.StatusInternalServerError
+		if err == mgo.ErrNotFound {
+			status = http.StatusNotFound
+		}
+		response.WriteHeaderAndEntity(status, delResponse)
+		return
+	}
+
+	deleteRemoteDeployKey(service)
+	if err := ds.SetServiceDeployKey(serviceID, api.DeployKey{}); err != nil {
+		message := fmt.Sprintf("Unable to delete deploy key of service %s", serviceID)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		delResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, delResponse)
+		return
+	}
+
+	response.WriteEntity(delResponse)
+}
+
+// deleteRemoteDeployKey removes the deploy key of the service from GitHub or
+// GitLab if it is registered, errors are only logged.
+func deleteRemoteDeployKey(service *api.Service) {
+	if service.Repository.DeployKey.RemoteID == 0 {
+		return
+	}
+
+	remote, err := remoteManager.FindRemote(service.Repository.SubVcs)
+	if err != nil {
+		log.ErrorWithFields("Unable to get remote according coderepository", log.Fields{"service_id": service.ServiceID})
+		return
+	}
+	if err := remote.DeleteDeployKey(service); err != nil {
+		log.WarnWithFields("Unable to delete deploy key", log.Fields{"service_id": service.ServiceID, "error": err})
+	}
+}
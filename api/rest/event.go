@@ -18,16 +18,20 @@ package rest
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"github.com/caicloud/cyclone/api"
 	"github.com/caicloud/cyclone/etcd"
+	"github.com/caicloud/cyclone/event"
 	"github.com/caicloud/cyclone/pkg/log"
 	"github.com/caicloud/cyclone/store"
+	"github.com/coreos/etcd/client"
 	"github.com/emicklei/go-restful"
 )
 
-// getEvent finds a event from EventID.
+// getEvent finds a event from EventID. The finished event is not in etcd, its
+// attempts are found from the version of the event.
 //
 // GET: /api/v0.1/:uid/events/{event_id}
 //
@@ -51,6 +55,13 @@ func getEvent(request *restful.Request, response *restful.Response) {
 
 	etcdClient := etcd.GetClient()
 	sEvent, err := etcdClient.Get(EventsUnfinished + eventID)
+	if client.IsKeyNotFound(err) {
+		if event, err := finishedEvent(eventID); err == nil {
+			getResponse.Event = *event
+			response.WriteHeaderAndEntity(http.StatusAccepted, getResponse)
+			return
+		}
+	}
 	if err != nil {
 		message := "Unable to get event from etcd"
 		log.ErrorWithFields(message, log.Fields{"event_id": eventID, "error": err})
@@ -73,6 +84,30 @@ func getEvent(request *restful.Request, response *restful.Response) {
 	response.WriteHeaderAndEntity(http.StatusAccepted, getResponse)
 }
 
+// finishedEvent restores the finished event from its version, with the status
+// of its last attempt.
+func finishedEvent(eventID string) (*api.Event, error) {
+	ds := store.NewStore()
+	defer ds.Close()
+
+	version, err := ds.FindVersionByID(eventID)
+	if err != nil {
+		return nil, err
+	}
+	event := &api.Event{
+		EventID:  api.EventID(eventID),
+		Version:  *version,
+		Attempts: version.Attempts,
+	}
+	if n := len(version.Attempts); n > 0 {
+		last := version.Attempts[n-1]
+		event.Status = last.Status
+		event.ErrorClass = last.ErrorClass
+		event.ErrorMessage = last.ErrorMessage
+	}
+	return event, nil
+}
+
 // setEvent set a event, validates and saves it..
 //
 // PUT: /api/v0.1/:uid/events/{event_id}
@@ -123,18 +158,34 @@ func setEvent(request *restful.Request, response *restful.Response) {
 		return
 	}
 
+	// The event may have been cancelled or timed out, the worker can not change
+	// its result any more.
+	if event.Status != api.EventStatusPending && event.Status != api.EventStatusRunning {
+		message := fmt.Sprintf("Event %s has finished with status %s", eventID, event.Status)
+		log.ErrorWithFields(message, log.Fields{"event_id": eventID})
+		setResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusConflict, setResponse)
+		return
+	}
+
 	event.Service = setEvent.Event.Service
 	event.Version = setEvent.Event.Version
 	event.Project = setEvent.Event.Project
 	event.ProjectVersion = setEvent.Event.ProjectVersion
 	event.Status = setEvent.Event.Status
 	event.ErrorMessage = setEvent.Event.ErrorMessage
+	event.ErrorClass = setEvent.Event.ErrorClass
 
 	// Write service/version to mongo.
 	ds := store.NewStore()
 	defer ds.Close()
 
 	if "" != event.Service.ServiceID && "" == event.Version.VersionID {
+		// The private key of the deploy key is never sent to the worker, keep
+		// the stored one.
+		if service, err := ds.FindServiceByID(event.Service.ServiceID); err == nil {
+			event.Service.Repository.DeployKey = service.Repository.DeployKey
+		}
 		ds.UpsertServiceDocument(&event.Service)
 	} else if "" != event.Version.VersionID {
 		ds.UpdateVersionDocument(event.Version.VersionID, setEvent.Event.Version)
@@ -163,9 +214,149 @@ func setEvent(request *restful.Request, response *restful.Response) {
 	response.WriteHeaderAndEntity(http.StatusAccepted, setResponse)
 }
 
+// notLeaderMessage returns the message of the error, and tells who is the
+// leader if this server is not.
+func notLeaderMessage(err error) string {
+	if err != event.ErrNotLeader {
+		return err.Error()
+	}
+	leader, errLeader := event.Leader()
+	if errLeader != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("%v, the leader is %s", err, leader)
+}
+
 // checkToken check the validity of a token.
 func checkToken(token string) bool {
 	// TODO Check the token.
 	log.Infof("check token: %s", token)
 	return true
 }
+
+// listPendingEvents lists the pending events in the order they will be handled.
+//
+// GET: /api/v0.1/pendingevents
+//
+// RESPONSE: (QueuedEventListResponse)
+//  {
+//    "events": (array) a list of api.QueuedEvent objects.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func listPendingEvents(request *restful.Request, response *restful.Response) {
+	var listResponse api.QueuedEventListResponse
+	token := request.HeaderParameter("token")
+	if !checkToken(token) {
+		message := "Invalid token"
+		log.Error(message)
+		listResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, listResponse)
+		return
+	}
+
+	events, err := event.ListPendingEvents()
+	if err != nil {
+		message := fmt.Sprintf("Unable to list the pending events: %v", err)
+		log.Error(message)
+		listResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, listResponse)
+		return
+	}
+
+	listResponse.Events = events
+	response.WriteEntity(listResponse)
+}
+
+// setPendingEvent changes the priority or position of a pending event. The
+// change is passed to the leader by etcd if this server is not the leader.
+//
+// PUT: /api/v0.1/pendingevents/{event_id}
+//
+// PAYLOAD (QueuedEventSetting):
+//   {
+//     "priority": (int) new priority of the event, the higher one is handled earlier.
+//     "top": (bool) move the event ahead of all the other pending events.
+//   }
+//
+// RESPONSE: (QueuedEventSetResponse)
+//  {
+//    "event": (object) api.QueuedEvent object.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func setPendingEvent(request *restful.Request, response *restful.Response) {
+	setting := api.QueuedEventSetting{}
+	err := request.ReadEntity(&setting)
+	if err != nil {
+		response.AddHeader("Content-Type", "text/plain")
+		response.WriteErrorString(http.StatusBadRequest, "Unable to parse request body")
+		return
+	}
+
+	var setResponse api.QueuedEventSetResponse
+	token := request.HeaderParameter("token")
+	eventID := request.PathParameter("event_id")
+	if !checkToken(token) {
+		message := "Invalid token"
+		log.ErrorWithFields(message, log.Fields{"event_id": eventID})
+		setResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, setResponse)
+		return
+	}
+
+	queuedEvent, err := event.ReorderPendingEvent(api.EventID(eventID), setting)
+	if err != nil {
+		message := fmt.Sprintf("Unable to reorder the pending event: %v", err)
+		log.ErrorWithFields(message, log.Fields{"event_id": eventID})
+		setResponse.ErrorMessage = message
+		status := http.StatusInternalServerError
+		if err == event.ErrEventNotQueued {
+			status = http.StatusNotFound
+		}
+		response.WriteHeaderAndEntity(status, setResponse)
+		return
+	}
+
+	setResponse.Event = queuedEvent
+	response.WriteEntity(setResponse)
+}
+
+// deletePendingEvent removes a pending event from the queue and cancels it. The
+// change is passed to the leader by etcd if this server is not the leader.
+//
+// DELETE: /api/v0.1/pendingevents/{event_id}
+//
+// RESPONSE: (QueuedEventDelResponse)
+//  {
+//    "result": (string) set IFF the event is removed.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func deletePendingEvent(request *restful.Request, response *restful.Response) {
+	var delResponse api.QueuedEventDelResponse
+	token := request.HeaderParameter("token")
+	eventID := request.PathParameter("event_id")
+	if !checkToken(token) {
+		message := "Invalid token"
+		log.ErrorWithFields(message, log.Fields{"event_id": eventID})
+		delResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, delResponse)
+		return
+	}
+
+	err := event.RemovePendingEvent(api.EventID(eventID))
+	if err != nil {
+		message := fmt.Sprintf("Unable to remove the pending event: %v", err)
+		log.ErrorWithFields(message, log.Fields{"event_id": eventID})
+		delResponse.ErrorMessage = message
+		status := http.StatusInternalServerError
+		if err == event.ErrEventNotQueued {
+			status = http.StatusNotFound
+		} else if err == event.ErrEventDispatching {
+			status = http.StatusConflict
+		}
+		response.WriteHeaderAndEntity(status, delResponse)
+		return
+	}
+
+	delResponse.Result = "success"
+	response.WriteEntity(delResponse)
+}
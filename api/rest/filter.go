@@ -24,6 +24,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/caicloud/cyclone/event"
 	"github.com/caicloud/cyclone/pkg/log"
 	"github.com/caicloud/cyclone/pkg/osutil"
 	"github.com/caicloud/cyclone/store"
@@ -157,3 +158,77 @@ func checkACLForVersion(request *restful.Request, response *restful.Response, ch
 	// Validation passed and pass on to specific api operation.
 	chain.ProcessFilter(request, response)
 }
+
+// checkApproverForVersion checks whether the user can approve or reject the
+// deploy of a specific version, the approvers may not own the service.
+func checkApproverForVersion(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	userID := request.PathParameter("user_id")
+	versionID := request.PathParameter("version_id")
+
+	service, _, err := findServiceAndVersion(versionID)
+	if err != nil {
+		message := fmt.Sprintf("Unable to find version %v", versionID)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		response.WriteHeaderAndEntity(http.StatusNotFound, message)
+		return
+	} else if !event.CanApprove(service, userID) {
+		message := fmt.Sprintf("have no access to approve version %v", versionID)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID})
+		response.WriteHeaderAndEntity(http.StatusUnauthorized, message)
+		return
+	}
+
+	// Validation passed and pass on to specific api operation.
+	chain.ProcessFilter(request, response)
+}
+
+// checkACLForProject checks whether the user has access to a specific project.
+func checkACLForProject(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	userID := request.PathParameter("user_id")
+	projectID := request.PathParameter("project_id")
+
+	ds := store.NewStore()
+	defer ds.Close()
+
+	project, err := ds.FindProjectByID(projectID)
+	if err != nil {
+		message := fmt.Sprintf("Unable to find project %v", projectID)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		response.WriteHeaderAndEntity(http.StatusNotFound, message)
+		return
+	} else if project.UserID != userID {
+		message := fmt.Sprintf("have no access to project %v", projectID)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID})
+		response.WriteHeaderAndEntity(http.StatusUnauthorized, message)
+		return
+	}
+
+	// Validation passed and pass on to specific api operation.
+	chain.ProcessFilter(request, response)
+}
+
+// checkACLForProjectVersion checks whether the user has access to a specific
+// project version.
+func checkACLForProjectVersion(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	userID := request.PathParameter("user_id")
+	versionID := request.PathParameter("projectversion_id")
+
+	ds := store.NewStore()
+	defer ds.Close()
+
+	version, err := ds.FindProjectVersionByID(versionID)
+	if err != nil {
+		message := fmt.Sprintf("Unable to find project version %v", versionID)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		response.WriteHeaderAndEntity(http.StatusNotFound, message)
+		return
+	} else if version.UserID != userID {
+		message := fmt.Sprintf("have no access to project version %v", versionID)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID})
+		response.WriteHeaderAndEntity(http.StatusUnauthorized, message)
+		return
+	}
+
+	// Validation passed and pass on to specific api operation.
+	chain.ProcessFilter(request, response)
+}
@@ -41,6 +41,7 @@ func healthCheck(request *restful.Request, response *restful.Response) {
 
 	// Check mongo.
 	if nil != ds.Ping() {
+		setErrorStatus(request, http.StatusServiceUnavailable)
 		healthCheckResponse.ErrorMessage = "mongo disconnect"
 		response.WriteHeaderAndEntity(http.StatusNotAcceptable, healthCheckResponse)
 		return
@@ -48,6 +49,7 @@ func healthCheck(request *restful.Request, response *restful.Response) {
 
 	// Check kafka.
 	if false == kafka.IsConnected() {
+		setErrorStatus(request, http.StatusServiceUnavailable)
 		healthCheckResponse.ErrorMessage = "kafka disconnect"
 		response.WriteHeaderAndEntity(http.StatusNotAcceptable, healthCheckResponse)
 		return
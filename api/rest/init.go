@@ -38,28 +38,32 @@ func Initialize(enableCaicloudAuth string) {
 	// Register all rest endpoints.
 	ws := &restful.WebService{}
 	ws.Path(fmt.Sprintf("/api/%s", api.APIVersion)).
+		ApiVersion(api.APIVersion).
 		Consumes(restful.MIME_JSON, "text/plain", "text/event-stream").
 		Produces(restful.MIME_JSON, "text/plain", "text/event-stream")
 
 	if enableCaicloudAuth == "true" {
 		ws.Filter(checkUserAuth)
 	}
+	registerAPIs(ws)
+	restful.Add(ws)
 
-	// Register APIs to the web service.
-	registerWebhookAPIs(ws)
-	registerHealthCheckAPIs(ws)
-	registerServiceAPIs(ws)
-	registerEventAPIs(ws)
-	registerVersionAPIs(ws)
-	// TODO Register project API.
-	//registerProjectAPIs(ws)
-	registerRemoteAPIs(ws)
-	registerVersionLogAPIs(ws)
-	registerResourceAPIs(ws)
-	registerWorkerNodeAPIs(ws)
-	registerDeployAPIs(ws)
+	// API v1 shares the endpoints with API v0.1, the responses are converted
+	// by the adapter. It's the first filter to convert the responses written
+	// by the other filters too.
+	wsV1 := &restful.WebService{}
+	wsV1.Path(fmt.Sprintf("/api/%s", api.APIVersionV1)).
+		ApiVersion(api.APIVersionV1).
+		Doc("Failures are responded with 4xx or 5xx status codes and the api.Error").
+		Consumes(restful.MIME_JSON, "text/plain", "text/event-stream").
+		Produces(restful.MIME_JSON, "text/plain", "text/event-stream").
+		Filter(v1Adapter)
 
-	restful.Add(ws)
+	if enableCaicloudAuth == "true" {
+		wsV1.Filter(checkUserAuth)
+	}
+	registerAPIs(wsV1)
+	restful.Add(wsV1)
 
 	// Add container filter to enable CORS and respond to OPTIONS.
 	cors := restful.CrossOriginResourceSharing{
@@ -77,6 +81,21 @@ func Initialize(enableCaicloudAuth string) {
 	resourceManager = resource.NewManager()
 }
 
+// registerAPIs registers all the endpoints to the web service.
+func registerAPIs(ws *restful.WebService) {
+	registerWebhookAPIs(ws)
+	registerHealthCheckAPIs(ws)
+	registerServiceAPIs(ws)
+	registerEventAPIs(ws)
+	registerVersionAPIs(ws)
+	registerProjectAPIs(ws)
+	registerRemoteAPIs(ws)
+	registerVersionLogAPIs(ws)
+	registerResourceAPIs(ws)
+	registerWorkerNodeAPIs(ws)
+	registerDeployAPIs(ws)
+}
+
 // GetManager gets a remote manager.
 func GetManager() *remote.Manager {
 	if nil == remoteManager {
@@ -112,10 +131,14 @@ func registerServiceAPIs(ws *restful.WebService) {
 		Param(ws.PathParameter("service_id", "identifier of the service").DataType("string")).
 		Writes(api.ServiceGetResponse{}))
 
-	ws.Route(ws.GET("/{user_id}/services").
+	ws.Route(listParameters(ws, ws.GET("/{user_id}/services")).
 		To(listServices).
-		Doc("list all services of a given user").
+		Doc("list services of a given user").
 		Param(ws.PathParameter("user_id", "identifier of the user").DataType("string")).
+		Param(ws.QueryParameter("name_prefix", "prefix of the service names").DataType("string")).
+		Param(ws.QueryParameter("status", "repository status of the services").DataType("string")).
+		Param(ws.QueryParameter("updated_after", "the last versions are created after the time in RFC 3339").DataType("string")).
+		Param(ws.QueryParameter("updated_before", "the last versions are created before the time in RFC 3339").DataType("string")).
 		Writes([]api.ServiceListResponse{}))
 
 	ws.Route(ws.DELETE("/{user_id}/services/{service_id}").
@@ -126,6 +149,31 @@ func registerServiceAPIs(ws *restful.WebService) {
 		Param(ws.PathParameter("service_id", "identifier of the service").DataType("string")).
 		Writes(api.ServiceDelResponse{}))
 
+	ws.Route(ws.DELETE("/{user_id}/services/{service_id}/cache").
+		Filter(checkACLForService).
+		To(purgeServiceCache).
+		Doc("purge the build cache of a service").
+		Param(ws.PathParameter("user_id", "identifier of the user").DataType("string")).
+		Param(ws.PathParameter("service_id", "identifier of the service").DataType("string")).
+		Writes(api.ServiceCacheDelResponse{}))
+
+	ws.Route(ws.PUT("/{user_id}/services/{service_id}/deploykey").
+		Filter(checkACLForService).
+		To(setDeployKey).
+		Doc("set the SSH deploy key of a service").
+		Param(ws.PathParameter("user_id", "identifier of the user").DataType("string")).
+		Param(ws.PathParameter("service_id", "identifier of the service").DataType("string")).
+		Reads(api.DeployKeySetRequest{}).
+		Writes(api.DeployKeySetResponse{}))
+
+	ws.Route(ws.DELETE("/{user_id}/services/{service_id}/deploykey").
+		Filter(checkACLForService).
+		To(deleteDeployKey).
+		Doc("delete the SSH deploy key of a service").
+		Param(ws.PathParameter("user_id", "identifier of the user").DataType("string")).
+		Param(ws.PathParameter("service_id", "identifier of the service").DataType("string")).
+		Writes(api.DeployKeyDelResponse{}))
+
 	// Filter the unauthorized operation.
 	ws.Route(ws.PUT("/{user_id}/services/{service_id}").
 		Filter(checkACLForService).
@@ -154,12 +202,18 @@ func registerVersionAPIs(ws *restful.WebService) {
 		Param(ws.PathParameter("version_id", "identifier of the version").DataType("string")).
 		Writes(api.Version{}))
 
-	ws.Route(ws.GET("/{user_id}/services/{service_id}/versions").
+	ws.Route(listParameters(ws, ws.GET("/{user_id}/services/{service_id}/versions")).
 		Filter(checkACLForService).
 		To(listVersions).
-		Doc("list all versions of a given user and service").
+		Doc("list versions of a given user and service").
 		Param(ws.PathParameter("user_id", "identifier of the user").DataType("string")).
 		Param(ws.PathParameter("service_id", "identifier of the service").DataType("string")).
+		Param(ws.QueryParameter("name_prefix", "prefix of the version names").DataType("string")).
+		Param(ws.QueryParameter("status", "status of the versions").DataType("string")).
+		Param(ws.QueryParameter("operation", "one of the operations of the versions").DataType("string")).
+		Param(ws.QueryParameter("operator", "operator of the versions, webhook, api or upstream").DataType("string")).
+		Param(ws.QueryParameter("created_after", "the versions are created after the time in RFC 3339").DataType("string")).
+		Param(ws.QueryParameter("created_before", "the versions are created before the time in RFC 3339").DataType("string")).
 		Writes([]api.VersionListResponse{}))
 
 	ws.Route(ws.POST("/{user_id}/versions/{version_id}/cancelbuild").
@@ -170,6 +224,104 @@ func registerVersionAPIs(ws *restful.WebService) {
 		Param(ws.PathParameter("version_id", "identifier of the version").DataType("string")).
 		Writes(api.VersionConcelResponse{}))
 
+	ws.Route(ws.POST("/{user_id}/versions/{version_id}/rollback").
+		Filter(checkACLForVersion).
+		To(rollbackVersion).
+		Doc("roll the deploy plans of a version back to the previous images").
+		Param(ws.PathParameter("user_id", "identifier of the user").DataType("string")).
+		Param(ws.PathParameter("version_id", "identifier of the version").DataType("string")).
+		Writes(api.VersionRollbackResponse{}))
+
+	ws.Route(ws.POST("/{user_id}/versions/{version_id}/approve").
+		Filter(checkApproverForVersion).
+		To(approveVersion).
+		Doc("approve the deploy of a version awaiting approval").
+		Param(ws.PathParameter("user_id", "identifier of the user").DataType("string")).
+		Param(ws.PathParameter("version_id", "identifier of the version").DataType("string")).
+		Reads(api.VersionApprovalRequest{}).
+		Writes(api.VersionApprovalResponse{}))
+
+	ws.Route(ws.POST("/{user_id}/versions/{version_id}/reject").
+		Filter(checkApproverForVersion).
+		To(rejectVersion).
+		Doc("reject the deploy of a version awaiting approval").
+		Param(ws.PathParameter("user_id", "identifier of the user").DataType("string")).
+		Param(ws.PathParameter("version_id", "identifier of the version").DataType("string")).
+		Reads(api.VersionApprovalRequest{}).
+		Writes(api.VersionApprovalResponse{}))
+
+	ws.Route(ws.POST("/{user_id}/versions/{version_id}/promote").
+		Filter(checkACLForVersion).
+		To(promoteVersion).
+		Doc("promote a version to the next environment of the service").
+		Param(ws.PathParameter("user_id", "identifier of the user").DataType("string")).
+		Param(ws.PathParameter("version_id", "identifier of the version").DataType("string")).
+		Writes(api.VersionPromotionResponse{}))
+}
+
+// registerProjectAPIs registers project related endpoints.
+func registerProjectAPIs(ws *restful.WebService) {
+	ws.Route(ws.POST("/{user_id}/projects").
+		To(createProject).
+		Doc("create a project for given user").
+		Param(ws.PathParameter("user_id", "identifier of the user").DataType("string")).
+		Reads(api.Project{}).
+		Writes(api.ProjectCreationResponse{}))
+
+	// Filter the unauthorized operation.
+	ws.Route(ws.GET("/{user_id}/projects/{project_id}").
+		Filter(checkACLForProject).
+		To(getProject).
+		Doc("find a project by id for given user").
+		Param(ws.PathParameter("user_id", "identifier of the user").DataType("string")).
+		Param(ws.PathParameter("project_id", "identifier of the project").DataType("string")).
+		Writes(api.ProjectGetResponse{}))
+
+	ws.Route(ws.GET("/{user_id}/projects").
+		To(listProjects).
+		Doc("list all projects of a given user").
+		Param(ws.PathParameter("user_id", "identifier of the user").DataType("string")).
+		Writes(api.ProjectListResponse{}))
+
+	ws.Route(ws.PUT("/{user_id}/projects/{project_id}").
+		Filter(checkACLForProject).
+		To(setProject).
+		Doc("set a project by id for given user").
+		Param(ws.PathParameter("user_id", "identifier of the user").DataType("string")).
+		Param(ws.PathParameter("project_id", "identifier of the project").DataType("string")).
+		Reads(api.Project{}).
+		Writes(api.ProjectSetResponse{}))
+
+	ws.Route(ws.DELETE("/{user_id}/projects/{project_id}").
+		Filter(checkACLForProject).
+		To(deleteProject).
+		Doc("delete a project by id for given user").
+		Param(ws.PathParameter("user_id", "identifier of the user").DataType("string")).
+		Param(ws.PathParameter("project_id", "identifier of the project").DataType("string")).
+		Writes(api.ProjectDelResponse{}))
+
+	ws.Route(ws.POST("/{user_id}/versions_project").
+		To(createProjectVersion).
+		Doc("create a version for given user of a specific project").
+		Param(ws.PathParameter("user_id", "identifier of the user").DataType("string")).
+		Reads(api.ProjectVersion{}).
+		Writes(api.ProjectVersionCreationResponse{}))
+
+	ws.Route(ws.GET("/{user_id}/projectversions/{projectversion_id}").
+		Filter(checkACLForProjectVersion).
+		To(getProjectVersion).
+		Doc("find a project version by id for given user").
+		Param(ws.PathParameter("user_id", "identifier of the user").DataType("string")).
+		Param(ws.PathParameter("projectversion_id", "identifier of the project version").DataType("string")).
+		Writes(api.ProjectVersionGetResponse{}))
+
+	ws.Route(ws.GET("/{user_id}/projects/{project_id}/versions").
+		Filter(checkACLForProject).
+		To(listProjectVersions).
+		Doc("list versions of a given user and project").
+		Param(ws.PathParameter("user_id", "identifier of the user").DataType("string")).
+		Param(ws.PathParameter("project_id", "identifier of the project").DataType("string")).
+		Writes(api.ProjectVersionListResponse{}))
 }
 
 // registerEventAPIs registers event related endpoints.
@@ -186,6 +338,24 @@ func registerEventAPIs(ws *restful.WebService) {
 		Param(ws.PathParameter("event_id", "identifier of the event").DataType("string")).
 		Reads(api.SetEvent{}).
 		Writes(api.SetEventResponse{}))
+
+	ws.Route(ws.GET("/pendingevents").
+		To(listPendingEvents).
+		Doc("list the pending events in the order they will be handled").
+		Writes(api.QueuedEventListResponse{}))
+
+	ws.Route(ws.PUT("/pendingevents/{event_id}").
+		To(setPendingEvent).
+		Doc("change the priority or position of a pending event").
+		Param(ws.PathParameter("event_id", "identifier of the event").DataType("string")).
+		Reads(api.QueuedEventSetting{}).
+		Writes(api.QueuedEventSetResponse{}))
+
+	ws.Route(ws.DELETE("/pendingevents/{event_id}").
+		To(deletePendingEvent).
+		Doc("remove a pending event from the queue").
+		Param(ws.PathParameter("event_id", "identifier of the event").DataType("string")).
+		Writes(api.QueuedEventDelResponse{}))
 }
 
 // registerResourceAPIs registers resource related endpoints.
@@ -236,6 +406,13 @@ func registerWebhookAPIs(ws *restful.WebService) {
 		Param(ws.PathParameter("service_id", "identifier of the service").DataType("string")).
 		Reads(api.WebhookSVN{}).
 		Writes(api.WebhookResponse{}))
+
+	ws.Route(ws.POST("/{service_id}/webhook_hg").
+		To(webhookHg).
+		Doc("webhook from mercurial").
+		Param(ws.PathParameter("service_id", "identifier of the service").DataType("string")).
+		Reads(api.WebhookHg{}).
+		Writes(api.WebhookResponse{}))
 }
 
 // registerResourceAPIs registers resource related endpoints.
@@ -279,6 +456,24 @@ func registerWorkerNodeAPIs(ws *restful.WebService) {
 		Doc("delete a system worker node by id").
 		Param(ws.PathParameter("node_id", "identifier of the node").DataType("string")).
 		Writes(api.WorkerNodeDelResponse{}))
+
+	ws.Route(ws.PUT("/system_worker_nodes/{node_id}/cordon").
+		To(cordonSystemWorkerNode).
+		Doc("mark a system worker node as unschedulable").
+		Param(ws.PathParameter("node_id", "identifier of the node").DataType("string")).
+		Writes(api.WorkerNodeSetResponse{}))
+
+	ws.Route(ws.PUT("/system_worker_nodes/{node_id}/drain").
+		To(drainSystemWorkerNode).
+		Doc("cordon a system worker node and requeue the events running on it").
+		Param(ws.PathParameter("node_id", "identifier of the node").DataType("string")).
+		Writes(api.WorkerNodeSetResponse{}))
+
+	ws.Route(ws.PUT("/system_worker_nodes/{node_id}/uncordon").
+		To(uncordonSystemWorkerNode).
+		Doc("mark a system worker node as schedulable").
+		Param(ws.PathParameter("node_id", "identifier of the node").DataType("string")).
+		Writes(api.WorkerNodeSetResponse{}))
 }
 
 // registerVersionLogAPIs registers log related endpoints.
@@ -0,0 +1,72 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/caicloud/cyclone/store"
+	"github.com/emicklei/go-restful"
+)
+
+// maxListLimit is the max number of items in a page of the list endpoints.
+const maxListLimit = 1000
+
+// listOptions parses the pagination parameters of the list endpoints, all the
+// items are listed if limit is not given.
+func listOptions(request *restful.Request) (store.ListOptions, error) {
+	opts := store.ListOptions{
+		Continue: request.QueryParameter("continue"),
+		Sort:     request.QueryParameter("sort"),
+	}
+	if limit := request.QueryParameter("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("invalid limit %s", limit)
+		}
+		if n > maxListLimit {
+			n = maxListLimit
+		}
+		opts.Limit = n
+	}
+	return opts, nil
+}
+
+// timeParameter parses the query parameter in RFC 3339, the zero time is
+// returned if it is not given.
+func timeParameter(request *restful.Request, name string) (time.Time, error) {
+	value := request.QueryParameter(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %s, expect RFC 3339 time", name, value)
+	}
+	return t, nil
+}
+
+// listParameters adds the documentation of the pagination parameters to the
+// route of a list endpoint.
+func listParameters(ws *restful.WebService, builder *restful.RouteBuilder) *restful.RouteBuilder {
+	return builder.
+		Param(ws.QueryParameter("limit", fmt.Sprintf("max number of items in a page, up to %d, all the items if not given", maxListLimit)).DataType("integer")).
+		Param(ws.QueryParameter("continue", "the continue token of the previous page").DataType("string")).
+		Param(ws.QueryParameter("sort", "field to sort by, prefixed by - for the descending order").DataType("string"))
+}
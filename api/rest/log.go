@@ -21,6 +21,7 @@ import (
 	"github.com/caicloud/cyclone/pkg/log"
 	"github.com/caicloud/cyclone/store"
 	"github.com/emicklei/go-restful"
+	"gopkg.in/mgo.v2"
 )
 
 // getVersionLog finds an version log from versionID.
@@ -44,6 +45,11 @@ func getVersionLog(request *restful.Request, response *restful.Response) {
 	if err != nil {
 		message := fmt.Sprintf("Unable to find version log by versionID %v", versionID)
 		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		if err == mgo.ErrNotFound {
+			setErrorStatus(request, http.StatusNotFound)
+		} else {
+			setErrorStatus(request, http.StatusInternalServerError)
+		}
 		getResponse.ErrorMessage = message
 	} else {
 		getResponse.Logs = result.Logs
@@ -80,6 +86,7 @@ func createVersionLog(request *restful.Request, response *restful.Response) {
 	if err != nil {
 		message := fmt.Sprintf("Unable to create version log by versionID %v", versionID)
 		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		setErrorStatus(request, http.StatusInternalServerError)
 		createResponse.ErrorMessage = message
 	} else {
 		createResponse.LogID = result
@@ -66,44 +66,31 @@ func sendCreateServiceEvent(service *api.Service) error {
 	return nil
 }
 
-// sendCreateVersionEvent is a helper method which sends a create version event
-// to etcd and wait for the event to be acked.
-func sendCreateVersionEvent(service *api.Service, version *api.Version) error {
-	username := service.Username
-	serviceName := service.Name
-	versionName := version.Name
+// sendCreateProjectVersionEvent is a helper method which sends a create project
+// version event to etcd. The versions of the services are created when the event
+// is handled.
+func sendCreateProjectVersionEvent(version *api.ProjectVersion) error {
 	eventID := api.EventID(version.VersionID)
 
-	ds := store.NewStore()
-	defer ds.Close()
-	tok, _ := ds.FindtokenByUserID(service.UserID, service.Repository.SubVcs)
-
 	event := api.Event{
-		EventID:   eventID,
-		Service:   *service,
-		Version:   *version,
-		Operation: event.CreateVersionOps,
-		Data: map[string]interface{}{
-			"service-name": serviceName,
-			"version-name": versionName,
-			"username":     username,
-			"Token":        tok.Vsctoken.AccessToken,
-		},
-		Status: api.EventStatusPending,
+		EventID:        eventID,
+		ProjectVersion: *version,
+		Operation:      event.CreateProjectVersionOps,
+		Status:         api.EventStatusPending,
 	}
 
-	log.Infof("send create version event: %v", event)
+	log.Infof("send create project version event: %v", event)
 
 	etcdClient := etcd.GetClient()
 	jsEvent, err := json.Marshal(&event)
 	if err != nil {
-		log.Errorf("create version event marshal err: %v", err)
+		log.Errorf("create project version event marshal err: %v", err)
 		return err
 	}
 
 	err = etcdClient.Set(EventsUnfinished+string(eventID), string(jsEvent))
 	if err != nil {
-		log.Errorf("send create version event err: %v", err)
+		log.Errorf("send create project version event err: %v", err)
 		return err
 	}
 
@@ -0,0 +1,523 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/event"
+	"github.com/caicloud/cyclone/pkg/log"
+	"github.com/caicloud/cyclone/store"
+	"github.com/emicklei/go-restful"
+	"gopkg.in/mgo.v2"
+)
+
+// createProject creates a project for the user, the name of the project must
+// be unique for the user.
+//
+// POST: /api/v0.1/:uid/projects
+//
+// PAYLOAD (api.Project):
+//   {
+//     "name": (string) the project name to create with
+//     "description": (string) a short description of the project
+//     "services": (array) the services of the project with their dependencies
+//   }
+//
+// RESPONSE: (ProjectCreationResponse)
+//  {
+//    "project_id": (string) set IFF creation is accepted.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func createProject(request *restful.Request, response *restful.Response) {
+	project := api.Project{}
+	err := request.ReadEntity(&project)
+	if err != nil {
+		response.AddHeader("Content-Type", "text/plain")
+		response.WriteErrorString(http.StatusBadRequest, "Unable to parse request body")
+		return
+	}
+
+	userID := request.PathParameter("user_id")
+	log.InfoWithFields("Cyclone receives creating project request", log.Fields{"user_id": userID, "project": project})
+
+	var createResponse api.ProjectCreationResponse
+	ds := store.NewStore()
+	defer ds.Close()
+
+	if status, err := checkProjectName(ds, userID, "", project.Name); err != nil {
+		createResponse.ErrorMessage = err.Error()
+		response.WriteHeaderAndEntity(status, createResponse)
+		return
+	}
+	if status, err := resolveProjectServices(ds, userID, &project); err != nil {
+		createResponse.ErrorMessage = err.Error()
+		response.WriteHeaderAndEntity(status, createResponse)
+		return
+	}
+
+	project.UserID = userID
+	project.CreateTime = time.Now()
+	project.Versions = nil
+	projectID, err := ds.NewProjectDocument(&project)
+	if err != nil {
+		message := "Unable to create project document in database"
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		createResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, createResponse)
+		return
+	}
+
+	createResponse.ProjectID = projectID
+	response.WriteEntity(createResponse)
+}
+
+// checkProjectName checks the name of the project is not empty, and not used by
+// the other projects of the user.
+func checkProjectName(ds *store.DataStore, userID, projectID, name string) (int, error) {
+	if name == "" {
+		return http.StatusBadRequest, fmt.Errorf("Name of project is empty")
+	}
+	projects, err := ds.FindProjectsByCondition(userID, name)
+	if err != nil {
+		log.ErrorWithFields("Unable to find projects", log.Fields{"user_id": userID, "error": err})
+		return http.StatusInternalServerError, fmt.Errorf("Unable to find projects")
+	}
+	for _, project := range projects {
+		if project.ProjectID != projectID {
+			return http.StatusConflict, fmt.Errorf("Name of project %s is existed", name)
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// resolveProjectServices checks the services of the project belong to the user
+// and do not depend on each other circularly, then fills up the service names
+// and the work flow, i.e. the service IDs in the build order.
+func resolveProjectServices(ds *store.DataStore, userID string, project *api.Project) (int, error) {
+	levels, err := event.ResolveBuildOrder(project.Services)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	names := make(map[string]string)
+	var workFlow []string
+	for _, level := range levels {
+		for _, serviceID := range level {
+			if serviceID == "" {
+				return http.StatusBadRequest, fmt.Errorf("Service ID of project is empty")
+			}
+			service, err := ds.FindServiceByID(serviceID)
+			if err == mgo.ErrNotFound {
+				return http.StatusBadRequest, fmt.Errorf("Unable to find service %s", serviceID)
+			}
+			if err != nil {
+				log.ErrorWithFields("Unable to find service", log.Fields{"user_id": userID, "service_id": serviceID, "error": err})
+				return http.StatusInternalServerError, fmt.Errorf("Unable to find service %s", serviceID)
+			}
+			if service.UserID != userID {
+				return http.StatusBadRequest, fmt.Errorf("have no access to service %s", serviceID)
+			}
+			names[serviceID] = service.Name
+			workFlow = append(workFlow, serviceID)
+		}
+	}
+
+	setServiceNames(project.Services, names)
+	project.WorkFlow = workFlow
+	return http.StatusOK, nil
+}
+
+// setServiceNames sets the names of the services and their dependencies.
+func setServiceNames(services []api.ServiceDependency, names map[string]string) {
+	for i := range services {
+		services[i].ServiceName = names[services[i].ServiceID]
+		setServiceNames(services[i].Depend, names)
+	}
+}
+
+// getProject finds a project from ID.
+//
+// GET: /api/v0.1/:uid/projects/:project_id
+//
+// RESPONSE: (ProjectGetResponse)
+//  {
+//    "project": (object) api.Project object.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func getProject(request *restful.Request, response *restful.Response) {
+	projectID := request.PathParameter("project_id")
+	userID := request.PathParameter("user_id")
+
+	var getResponse api.ProjectGetResponse
+	ds := store.NewStore()
+	defer ds.Close()
+
+	result, err := ds.FindProjectByID(projectID)
+	if err != nil {
+		message := fmt.Sprintf("Unable to find project %v", projectID)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		if err == mgo.ErrNotFound {
+			setErrorStatus(request, http.StatusNotFound)
+		} else {
+			setErrorStatus(request, http.StatusInternalServerError)
+		}
+		getResponse.ErrorMessage = message
+	} else {
+		getResponse.Project = *result
+	}
+
+	response.WriteEntity(getResponse)
+}
+
+// listProjects returns the projects belong to a user.
+//
+// GET: /api/v0.1/:uid/projects
+//
+// RESPONSE: (ProjectListResponse)
+//  {
+//    "projects": (array) a list of api.Project object.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func listProjects(request *restful.Request, response *restful.Response) {
+	userID := request.PathParameter("user_id")
+
+	var listResponse api.ProjectListResponse
+	ds := store.NewStore()
+	defer ds.Close()
+
+	result, err := ds.FindProjectsByUserID(userID)
+	if err != nil {
+		message := "Unable to list project"
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		setErrorStatus(request, http.StatusInternalServerError)
+		listResponse.ErrorMessage = message
+	} else {
+		listResponse.Projects = result
+	}
+
+	response.WriteEntity(listResponse)
+}
+
+// setProject sets the name, description and services of a project. The versions
+// being built are not affected.
+//
+// PUT: /api/v0.1/:uid/projects/:project_id
+//
+// PAYLOAD (api.Project):
+//   {
+//     "name": (string) the new name of the project, kept if empty
+//     "description": (string) a short description of the project, kept if empty
+//     "services": (array) the services of the project with their dependencies, e.g.
+//        [{"service_id": "b", "depend": [{"service_id": "a"}]}] builds a before b
+//   }
+//
+// RESPONSE: (ProjectSetResponse)
+//  {
+//    "project_id": (string) set IFF the request succeeds.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func setProject(request *restful.Request, response *restful.Response) {
+	newProject := api.Project{}
+	err := request.ReadEntity(&newProject)
+	if err != nil {
+		response.AddHeader("Content-Type", "text/plain")
+		response.WriteErrorString(http.StatusBadRequest, "Unable to parse request body")
+		return
+	}
+
+	projectID := request.PathParameter("project_id")
+	userID := request.PathParameter("user_id")
+	log.InfoWithFields("Cyclone receives setting project request", log.Fields{"user_id": userID, "project": newProject})
+
+	var setResponse api.ProjectSetResponse
+	ds := store.NewStore()
+	defer ds.Close()
+
+	project, err := ds.FindProjectByID(projectID)
+	if err != nil {
+		message := fmt.Sprintf("Unable to find project %v", projectID)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		setResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusNotFound, setResponse)
+		return
+	}
+
+	if newProject.Name != "" && newProject.Name != project.Name {
+		if status, err := checkProjectName(ds, userID, projectID, newProject.Name); err != nil {
+			setResponse.ErrorMessage = err.Error()
+			response.WriteHeaderAndEntity(status, setResponse)
+			return
+		}
+		project.Name = newProject.Name
+	}
+	if newProject.Description != "" {
+		project.Description = newProject.Description
+	}
+	project.Services = newProject.Services
+	if status, err := resolveProjectServices(ds, userID, project); err != nil {
+		setResponse.ErrorMessage = err.Error()
+		response.WriteHeaderAndEntity(status, setResponse)
+		return
+	}
+
+	if _, err := ds.UpsertProjectDocument(project); err != nil {
+		message := "Unable to update project document in database"
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		setResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, setResponse)
+		return
+	}
+
+	setResponse.ProjectID = projectID
+	response.WriteEntity(setResponse)
+}
+
+// deleteProject deletes a project with its versions, the versions of the services
+// are kept. A project can not be deleted while any of its versions is building.
+//
+// DELETE: /api/v0.1/:uid/projects/:project_id
+//
+// RESPONSE: (ProjectDelResponse)
+//  {
+//    "result": (string) the result of deleting project
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func deleteProject(request *restful.Request, response *restful.Response) {
+	projectID := request.PathParameter("project_id")
+	userID := request.PathParameter("user_id")
+
+	var deleteResponse api.ProjectDelResponse
+	ds := store.NewStore()
+	defer ds.Close()
+
+	versions, err := ds.FindProjectVersionsByProjectID(projectID)
+	if err != nil {
+		message := "Unable to find project version"
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "project_id": projectID, "error": err})
+		deleteResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, deleteResponse)
+		return
+	}
+	for _, version := range versions {
+		if version.Status == api.VersionPending || version.Status == api.VersionRunning {
+			deleteResponse.ErrorMessage = fmt.Sprintf("Version %s of project is building", version.Name)
+			response.WriteHeaderAndEntity(http.StatusConflict, deleteResponse)
+			return
+		}
+	}
+
+	if err := ds.DeleteProjectVersionsByProjectID(projectID); err != nil {
+		message := "Unable to delete project version"
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "project_id": projectID, "error": err})
+		deleteResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, deleteResponse)
+		return
+	}
+	if err := ds.DeleteProjectByID(projectID); err != nil {
+		message := "Unable to delete project"
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "project_id": projectID, "error": err})
+		deleteResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, deleteResponse)
+		return
+	}
+
+	deleteResponse.Result = "success"
+	response.WriteEntity(deleteResponse)
+}
+
+// createProjectVersion creates a version of a project, which builds a version
+// with the same name for every service of the project. The services are built
+// in the order of their dependencies: a service starts building after all the
+// services it depends on are built successfully, and the project version fails
+// once any of them fails. To query the progress, use getProjectVersion API below.
+//
+// POST: /api/v0.1/:uid/versions_project
+//
+// PAYLOAD (api.ProjectVersion):
+//   {
+//     "project_id": (string) project associated with the version
+//     "name": (string) the version name to create with, e.g. v0.1.0
+//     "description": (string) a short description of the version
+//     "policy": (string) policy of the publish, e.g. manual
+//   }
+//
+// RESPONSE: (ProjectVersionCreationResponse)
+//  {
+//    "project_version_id": (string) set IFF creation is accepted.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func createProjectVersion(request *restful.Request, response *restful.Response) {
+	version := api.ProjectVersion{}
+	err := request.ReadEntity(&version)
+	if err != nil {
+		response.AddHeader("Content-Type", "text/plain")
+		response.WriteErrorString(http.StatusBadRequest, "Unable to parse request body")
+		return
+	}
+
+	userID := request.PathParameter("user_id")
+	log.InfoWithFields("Cyclone receives creating project version request", log.Fields{"user_id": userID, "version": version})
+
+	var createResponse api.ProjectVersionCreationResponse
+	ds := store.NewStore()
+	defer ds.Close()
+
+	project, err := ds.FindProjectByID(version.ProjectID)
+	if err != nil {
+		message := fmt.Sprintf("Unable to find project %v", version.ProjectID)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		createResponse.ErrorMessage = message
+		status := http.StatusInternalServerError
+		if err == mgo.ErrNotFound {
+			status = http.StatusNotFound
+		}
+		response.WriteHeaderAndEntity(status, createResponse)
+		return
+	}
+	if project.UserID != userID {
+		createResponse.ErrorMessage = fmt.Sprintf("have no access to project %v", version.ProjectID)
+		response.WriteHeaderAndEntity(http.StatusUnauthorized, createResponse)
+		return
+	}
+	if version.Name == "" {
+		createResponse.ErrorMessage = "Name of version is empty"
+		response.WriteHeaderAndEntity(http.StatusBadRequest, createResponse)
+		return
+	}
+
+	levels, err := event.ResolveBuildOrder(project.Services)
+	if err == nil && len(levels) == 0 {
+		err = fmt.Errorf("Project %s has no services", project.Name)
+	}
+	if err != nil {
+		createResponse.ErrorMessage = err.Error()
+		response.WriteHeaderAndEntity(http.StatusBadRequest, createResponse)
+		return
+	}
+
+	// The version name must be unused by the project and all its services.
+	versions, err := ds.FindProjectVersionsByCondition(version.ProjectID, version.Name)
+	if err == nil && len(versions) > 0 {
+		createResponse.ErrorMessage = fmt.Sprintf("Name of version %s is existed", version.Name)
+		response.WriteHeaderAndEntity(http.StatusConflict, createResponse)
+		return
+	}
+	for _, level := range levels {
+		for _, serviceID := range level {
+			versions, err := ds.FindVersionsByCondition(serviceID, version.Name)
+			if err == nil && len(versions) > 0 {
+				createResponse.ErrorMessage = fmt.Sprintf("Name of version %s is existed in service %s", version.Name, serviceID)
+				response.WriteHeaderAndEntity(http.StatusConflict, createResponse)
+				return
+			}
+		}
+	}
+
+	version.UserID = userID
+	version.CreateTime = time.Now()
+	version.Status = api.VersionPending
+	version.Services = project.Services
+	version.Tasks = nil
+	version.ErrorMessage = ""
+	versionID, err := ds.NewProjectVersionDocument(&version)
+	if err != nil {
+		message := "Unable to create project version document in database"
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		createResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, createResponse)
+		return
+	}
+
+	if err := sendCreateProjectVersionEvent(&version); err != nil {
+		message := "Unable to create build project version job"
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "version": version, "error": err})
+		createResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, createResponse)
+		return
+	}
+
+	createResponse.ProjectVersionID = versionID
+	response.WriteEntity(createResponse)
+}
+
+// getProjectVersion finds a project version from ID, the versions of its services
+// are in the tasks.
+//
+// GET: /api/v0.1/:uid/projectversions/:projectversion_id
+//
+// RESPONSE: (ProjectVersionGetResponse)
+//  {
+//    "projectversion": (object) api.ProjectVersion object.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func getProjectVersion(request *restful.Request, response *restful.Response) {
+	versionID := request.PathParameter("projectversion_id")
+	userID := request.PathParameter("user_id")
+
+	var getResponse api.ProjectVersionGetResponse
+	ds := store.NewStore()
+	defer ds.Close()
+
+	result, err := ds.FindProjectVersionByID(versionID)
+	if err != nil {
+		message := fmt.Sprintf("Unable to find project version %v", versionID)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		if err == mgo.ErrNotFound {
+			setErrorStatus(request, http.StatusNotFound)
+		} else {
+			setErrorStatus(request, http.StatusInternalServerError)
+		}
+		getResponse.ErrorMessage = message
+	} else {
+		getResponse.ProjectVersion = *result
+	}
+
+	response.WriteEntity(getResponse)
+}
+
+// listProjectVersions returns the versions of a project, the latest first.
+//
+// GET: /api/v0.1/:uid/projects/:project_id/versions
+//
+// RESPONSE: (ProjectVersionListResponse)
+//  {
+//    "project_versions": (array) a list of api.ProjectVersion object.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func listProjectVersions(request *restful.Request, response *restful.Response) {
+	projectID := request.PathParameter("project_id")
+	userID := request.PathParameter("user_id")
+
+	var listResponse api.ProjectVersionListResponse
+	ds := store.NewStore()
+	defer ds.Close()
+
+	result, err := ds.FindProjectVersionsByProjectID(projectID)
+	if err != nil {
+		message := "Unable to list project version"
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		setErrorStatus(request, http.StatusInternalServerError)
+		listResponse.ErrorMessage = message
+	} else {
+		listResponse.ProjectVersions = result
+	}
+
+	response.WriteEntity(listResponse)
+}
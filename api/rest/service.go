@@ -19,12 +19,15 @@ package rest
 import (
 	"fmt"
 	"net/http"
+	"regexp"
 	"time"
 
 	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/event"
 	"github.com/caicloud/cyclone/pkg/log"
 	"github.com/caicloud/cyclone/store"
 	"github.com/emicklei/go-restful"
+	"gopkg.in/mgo.v2"
 )
 
 // createService creates a service, validates and saves it. The operation is asynchronous,
@@ -44,10 +47,19 @@ import (
 //       "url": (string) url path of the service repository
 //       "vcs": (string) version control tool used to host the repository, options:
 //          git, fake (for testing)
+//       "clone_options": {
+//         "depth": (int) number of commits to fetch, 0 means the full history
+//         "submodules": (bool) fetch the submodules recursively
+//         "sparse_paths": ([]string) paths to check out, all the files if empty
+//         "lfs": (bool) fetch the files tracked by Git LFS
+//       }
 //     }
 //     "build_path": (string) Path of the file used to create service version. By default,
 //        Cyclone will create service version using "docker build", assming there is a
 //        Dockerfile at top of the repository.
+//     "downstreams": (array) services built after a release version is published, e.g.
+//        [{"service_id": "b", "variable": "BASE_IMAGE"}] builds service b with the
+//        image of the release in build variable BASE_IMAGE, UPSTREAM_IMAGE by default
 //     ...
 //   }
 //
@@ -98,6 +110,25 @@ func createService(request *restful.Request, response *restful.Response) {
 		}
 	}
 
+	if status, err := checkDownstreams(ds, userID, "", service.Downstreams); err != nil {
+		createResponse.ErrorMessage = err.Error()
+		response.WriteHeaderAndEntity(status, createResponse)
+		return
+	}
+
+	if err := checkEnvironments(service.Environments); err != nil {
+		createResponse.ErrorMessage = err.Error()
+		response.WriteHeaderAndEntity(http.StatusBadRequest, createResponse)
+		return
+	}
+	if err := encryptServiceTokens(&service); err != nil {
+		message := "Unable to encrypt cluster tokens"
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		createResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, createResponse)
+		return
+	}
+
 	log.InfoWithFields("Cyclone receives creating service request",
 		log.Fields{"user_id": userID, "service_name": service.Name})
 
@@ -153,28 +184,65 @@ func getService(request *restful.Request, response *restful.Response) {
 		response.WriteHeaderAndEntity(http.StatusNotFound, message)
 		return
 	}
+	hideServiceTokens(result)
 	getResponse.Service = *result
 
 	response.WriteEntity(getResponse)
 }
 
-// listServices returns all services belong to a user.
+// listServices returns the services belong to a user, page by page if limit
+// is given.
+//
+// GET: /api/v0.1/:uid/services?limit=&continue=&sort=&name_prefix=&status=&updated_after=&updated_before=
 //
-// GET: /api/v0.1/:uid/services
+// QUERY:
+//   limit: (int) max number of services in a page, all the services if not given
+//   continue: (string) the continue token of the previous page
+//   sort: (string) name or last_createtime, prefixed by - for the descending order,
+//      default is name
+//   name_prefix: (string) prefix of the service names
+//   status: (string) repository status of the services
+//   updated_after, updated_before: (string) range of the time the last versions are
+//      created, in RFC 3339
 //
 // RESPONSE: (ServiceListResponse)
 //  {
 //    "services": (array) a list of api.Service object.
+//    "continue": (string) the continue token of the next page, empty for the last page.
 //    "error_msg": (string) set IFF the request fails.
 //  }
 func listServices(request *restful.Request, response *restful.Response) {
 	userID := request.PathParameter("user_id")
-	ds := store.NewStore()
-	defer ds.Close()
 
 	var listResponse api.ServiceListResponse
-	result, err := ds.FindServicesByUserID(userID)
+	opts, err := listOptions(request)
+	if err != nil {
+		listResponse.ErrorMessage = err.Error()
+		response.WriteHeaderAndEntity(http.StatusBadRequest, listResponse)
+		return
+	}
+	filter := store.ServiceFilter{
+		NamePrefix: request.QueryParameter("name_prefix"),
+		Status:     api.RepositoryStatus(request.QueryParameter("status")),
+	}
+	if filter.UpdatedAfter, err = timeParameter(request, "updated_after"); err == nil {
+		filter.UpdatedBefore, err = timeParameter(request, "updated_before")
+	}
+	if err != nil {
+		listResponse.ErrorMessage = err.Error()
+		response.WriteHeaderAndEntity(http.StatusBadRequest, listResponse)
+		return
+	}
+
+	ds := store.NewStore()
+	defer ds.Close()
 
+	result, next, err := ds.ListServices(userID, filter, opts)
+	if err == store.ErrInvalidSort || err == store.ErrInvalidContinue {
+		listResponse.ErrorMessage = err.Error()
+		response.WriteHeaderAndEntity(http.StatusBadRequest, listResponse)
+		return
+	}
 	if err != nil {
 		message := "Unable to list service"
 		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
@@ -182,7 +250,11 @@ func listServices(request *restful.Request, response *restful.Response) {
 		response.WriteHeaderAndEntity(http.StatusNotFound, message)
 		return
 	}
+	for i := range result {
+		hideServiceTokens(&result[i])
+	}
 	listResponse.Services = result
+	listResponse.Continue = next
 
 	response.WriteEntity(listResponse)
 }
@@ -212,22 +284,22 @@ func deleteService(request *restful.Request, response *restful.Response) {
 		return
 	}
 
-	// // Check if this service has referenced in project.
-	// projects, err := ds.FindProjectsRelateService(service_id)
-	// if err != nil {
-	// 	message := "Unable to find relate projects"
-	// 	log.ErrorWithFields(message, log.Fields{"service_id": serviceID})
-	// 	deleteResponse.ErrorMessage = message
-	// 	response.WriteHeaderAndEntity(http.StatusInternalServerError, message)
-	// 	return
-	// }
-	// if len(projects) != 0 {
-	// 	message := "Has relate projects"
-	// 	log.ErrorWithFields(message, log.Fields{"service_id": serviceID})
-	// 	deleteResponse.ErrorMessage = message
-	// 	response.WriteHeaderAndEntity(http.StatusInternalServerError, message)
-	// 	return
-	// }
+	// Check if this service has referenced in project.
+	projects, err := ds.FindProjectsRelateService(serviceID)
+	if err != nil {
+		message := "Unable to find relate projects"
+		log.ErrorWithFields(message, log.Fields{"service_id": serviceID, "error": err})
+		deleteResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, message)
+		return
+	}
+	if len(projects) != 0 {
+		message := fmt.Sprintf("Service is used by project %s", projects[0].Name)
+		log.ErrorWithFields(message, log.Fields{"service_id": serviceID})
+		deleteResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusConflict, message)
+		return
+	}
 
 	remote, err := remoteManager.FindRemote(service.Repository.Webhook)
 	if err != nil {
@@ -237,6 +309,7 @@ func deleteService(request *restful.Request, response *restful.Response) {
 			log.ErrorWithFields("delete hook fail", log.Fields{"service_id": serviceID, "error": err})
 		}
 	}
+	deleteRemoteDeployKey(service)
 
 	versions, err := ds.FindVersionsByServiceID(serviceID)
 	if err != nil {
@@ -285,10 +358,19 @@ func deleteService(request *restful.Request, response *restful.Response) {
 //       "url": (string) url path of the service repository
 //       "vcs": (string) version control tool used to host the repository, options:
 //          git, fake (for testing)
+//       "clone_options": {
+//         "depth": (int) number of commits to fetch, 0 means the full history
+//         "submodules": (bool) fetch the submodules recursively
+//         "sparse_paths": ([]string) paths to check out, all the files if empty
+//         "lfs": (bool) fetch the files tracked by Git LFS
+//       }
 //     }
 //     "build_path": (string) Path of the file used to create service version. By default,
 //        Cyclone will create service version using "docker build", assming there is a
 //        Dockerfile at top of the repository.
+//     "downstreams": (array) services built after a release version is published, e.g.
+//        [{"service_id": "b", "variable": "BASE_IMAGE"}] builds service b with the
+//        image of the release in build variable BASE_IMAGE, UPSTREAM_IMAGE by default
 //   }
 //
 // RESPONSE: (ServiceSetResponse)
@@ -365,7 +447,34 @@ func setService(request *restful.Request, response *restful.Response) {
 	// 	}
 	// }
 
+	if status, err := checkDownstreams(ds, userID, serviceID, service.Downstreams); err != nil {
+		setResponse.ErrorMessage = err.Error()
+		response.WriteHeaderAndEntity(status, setResponse)
+		return
+	}
+	servicePre.Downstreams = service.Downstreams
+
+	if err := checkEnvironments(service.Environments); err != nil {
+		setResponse.ErrorMessage = err.Error()
+		response.WriteHeaderAndEntity(http.StatusBadRequest, setResponse)
+		return
+	}
+	if err := encryptServiceTokens(&service); err != nil {
+		message := "Unable to encrypt cluster tokens"
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		setResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, setResponse)
+		return
+	}
+	keepServiceTokens(&service, servicePre)
+	servicePre.Environments = service.Environments
+
 	servicePre.DeployPlans = service.DeployPlans
+	servicePre.Approval = service.Approval
+	servicePre.NodeSelector = service.NodeSelector
+	servicePre.Timeout = service.Timeout
+	servicePre.RetryPolicy = service.RetryPolicy
+	servicePre.Repository.CloneOptions = service.Repository.CloneOptions
 	_, err = ds.UpsertServiceDocument(servicePre)
 	if nil != err {
 		message := fmt.Sprintf("Set service %s err: %v", serviceID, err)
@@ -379,3 +488,95 @@ func setService(request *restful.Request, response *restful.Response) {
 	setResponse.ServiceID = serviceID
 	response.WriteHeaderAndEntity(http.StatusAccepted, setResponse)
 }
+
+// variablePattern matches the valid names of build variables.
+var variablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// checkDownstreams checks the downstream services belong to the user, and do not
+// trigger the service again. The service ID is empty for a new service, which
+// can not be a downstream of the others yet.
+func checkDownstreams(ds *store.DataStore, userID, serviceID string, downstreams []api.DownstreamTrigger) (int, error) {
+	if len(downstreams) == 0 {
+		return http.StatusOK, nil
+	}
+
+	services, err := ds.FindServicesByUserID(userID)
+	if err != nil {
+		log.ErrorWithFields("Unable to find services", log.Fields{"user_id": userID, "error": err})
+		return http.StatusInternalServerError, fmt.Errorf("Unable to find services")
+	}
+	graph := make(map[string][]string)
+	for _, service := range services {
+		for _, trigger := range service.Downstreams {
+			graph[service.ServiceID] = append(graph[service.ServiceID], trigger.ServiceID)
+		}
+	}
+
+	graph[serviceID] = nil
+	for _, trigger := range downstreams {
+		if !hasService(services, trigger.ServiceID) {
+			return http.StatusBadRequest, fmt.Errorf("Unable to find downstream service %s", trigger.ServiceID)
+		}
+		if trigger.ServiceID == serviceID {
+			return http.StatusBadRequest, event.ErrDownstreamCycle
+		}
+		if trigger.Variable != "" && !variablePattern.MatchString(trigger.Variable) {
+			return http.StatusBadRequest, fmt.Errorf("Invalid build variable name %s", trigger.Variable)
+		}
+		graph[serviceID] = append(graph[serviceID], trigger.ServiceID)
+	}
+
+	if serviceID == "" {
+		return http.StatusOK, nil
+	}
+	if err := event.CheckDownstreamCycle(serviceID, graph); err != nil {
+		return http.StatusBadRequest, err
+	}
+	return http.StatusOK, nil
+}
+
+// hasService returns whether the service is in the list.
+func hasService(services []api.Service, serviceID string) bool {
+	for _, service := range services {
+		if service.ServiceID == serviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// purgeServiceCache purges the build cache of the service. The cache is not used
+// by the following builds, and is removed from the worker nodes when the
+// service is built on them next time.
+//
+// DELETE: /api/v0.1/:uid/services/:service_id/cache
+//
+// RESPONSE: (ServiceCacheDelResponse)
+//  {
+//    "cache_generation": (int) the new cache generation of the service.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func purgeServiceCache(request *restful.Request, response *restful.Response) {
+	userID := request.PathParameter("user_id")
+	serviceID := request.PathParameter("service_id")
+
+	var delResponse api.ServiceCacheDelResponse
+	ds := store.NewStore()
+	defer ds.Close()
+
+	service, err := ds.IncServiceCacheGeneration(serviceID)
+	if err != nil {
+		message := fmt.Sprintf("Unable to purge cache of service %s", serviceID)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		delResponse.ErrorMessage = message
+		status := http.StatusInternalServerError
+		if err == mgo.ErrNotFound {
+			status = http.StatusNotFound
+		}
+		response.WriteHeaderAndEntity(status, delResponse)
+		return
+	}
+
+	delResponse.CacheGeneration = service.CacheGeneration
+	response.WriteEntity(delResponse)
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/emicklei/go-restful"
+)
+
+// errorStatusAttribute is the request attribute with the status code of the
+// failed request in API v1.
+const errorStatusAttribute = "cyclone.error.status"
+
+// setErrorStatus sets the status code in API v1 of the failed request, for the
+// handlers which respond failures with another status code, like 200, to keep
+// API v0.1 compatible.
+func setErrorStatus(request *restful.Request, status int) {
+	request.SetAttribute(errorStatusAttribute, status)
+}
+
+// bufferedWriter buffers the response of the handlers to convert it for API v1.
+type bufferedWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// Header implements http.ResponseWriter.
+func (w *bufferedWriter) Header() http.Header {
+	return w.header
+}
+
+// Write implements http.ResponseWriter.
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *bufferedWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// v1Adapter is the filter of API v1 to share the handlers of API v0.1. The
+// failures are responded with a 4xx or 5xx status code and api.Error, and the
+// error_msg is removed from the successful responses.
+func v1Adapter(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	writer := response.ResponseWriter
+	buffered := &bufferedWriter{header: http.Header{}}
+	response.ResponseWriter = buffered
+	chain.ProcessFilter(request, response)
+	response.ResponseWriter = writer
+
+	status := buffered.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if errorStatus, ok := request.Attribute(errorStatusAttribute).(int); ok {
+		status = errorStatus
+	}
+
+	if status >= http.StatusBadRequest {
+		response.WriteHeaderAndJson(status, toAPIError(status, buffered.body.Bytes()), restful.MIME_JSON)
+		return
+	}
+
+	for key, values := range buffered.header {
+		writer.Header()[key] = values
+	}
+	body := buffered.body.Bytes()
+	if fields, ok := decodeObject(body); ok {
+		if _, ok := fields["error_msg"]; ok {
+			delete(fields, "error_msg")
+			if encoded, err := json.Marshal(fields); err == nil {
+				body = encoded
+			}
+		}
+	}
+	writer.Header().Del("Content-Length")
+	response.WriteHeader(status)
+	response.Write(body)
+}
+
+// toAPIError converts the response body of a failed request to api.Error. The
+// message is the error_msg of a JSON object, a JSON string, or the plain text.
+func toAPIError(status int, body []byte) api.Error {
+	apiError := api.Error{Code: strings.Replace(http.StatusText(status), " ", "", -1)}
+
+	var message string
+	if fields, ok := decodeObject(body); ok {
+		message, _ = fields["error_msg"].(string)
+		delete(fields, "error_msg")
+		if len(fields) > 0 {
+			apiError.Details = fields
+		}
+	} else if err := json.Unmarshal(body, &message); err != nil {
+		message = strings.TrimSpace(string(body))
+	}
+
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	apiError.Message = message
+	return apiError
+}
+
+// decodeObject decodes the body if it is a JSON object, numbers are kept as
+// they are.
+func decodeObject(body []byte) (map[string]interface{}, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil || fields == nil {
+		return nil, false
+	}
+	return fields, true
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/emicklei/go-restful"
+)
+
+// newV1Container creates a container serving the handler under /api/v1 with
+// the v1 adapter, like the handlers of API v0.1 are served.
+func newV1Container(handler restful.RouteFunction) *restful.Container {
+	ws := new(restful.WebService)
+	ws.Path("/api/v1").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON).
+		Filter(v1Adapter)
+	ws.Route(ws.GET("/test").To(handler))
+
+	container := restful.NewContainer()
+	container.Add(ws)
+	return container
+}
+
+// serveV1 serves a GET request by the handler with the v1 adapter.
+func serveV1(handler restful.RouteFunction) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest("GET", "/api/v1/test", nil)
+	request.Header.Set("Accept", restful.MIME_JSON)
+	recorder := httptest.NewRecorder()
+	newV1Container(handler).ServeHTTP(recorder, request)
+	return recorder
+}
+
+// TestV1AdapterErrors tests the failures are responded with the status code
+// and the error envelope.
+func TestV1AdapterErrors(t *testing.T) {
+	testCases := map[string]struct {
+		handler restful.RouteFunction
+		status  int
+		err     api.Error
+	}{
+		"error status": {
+			handler: func(request *restful.Request, response *restful.Response) {
+				response.WriteHeaderAndEntity(http.StatusNotFound, api.WebhookResponse{ErrorMessage: "Unable to find service s1"})
+			},
+			status: http.StatusNotFound,
+			err:    api.Error{Code: "NotFound", Message: "Unable to find service s1"},
+		},
+		"error status set for v0.1 status 200": {
+			handler: func(request *restful.Request, response *restful.Response) {
+				setErrorStatus(request, http.StatusBadRequest)
+				response.WriteHeaderAndEntity(http.StatusOK, api.WebhookResponse{ErrorMessage: "vcs is not git"})
+			},
+			status: http.StatusBadRequest,
+			err:    api.Error{Code: "BadRequest", Message: "vcs is not git"},
+		},
+		"other fields as details": {
+			handler: func(request *restful.Request, response *restful.Response) {
+				response.WriteHeaderAndEntity(http.StatusConflict, map[string]interface{}{
+					"error_msg": "deploy plan web is in progress",
+					"version":   "v1",
+					"attempt":   2,
+				})
+			},
+			status: http.StatusConflict,
+			err: api.Error{Code: "Conflict", Message: "deploy plan web is in progress",
+				Details: map[string]interface{}{"version": "v1", "attempt": json.Number("2")}},
+		},
+		"json string": {
+			handler: func(request *restful.Request, response *restful.Response) {
+				response.WriteHeaderAndEntity(http.StatusNotFound, "Unable to find worker node n1")
+			},
+			status: http.StatusNotFound,
+			err:    api.Error{Code: "NotFound", Message: "Unable to find worker node n1"},
+		},
+		"plain text": {
+			handler: func(request *restful.Request, response *restful.Response) {
+				response.AddHeader("Content-Type", "text/plain")
+				response.WriteErrorString(http.StatusBadRequest, "Unable to parse request body\n")
+			},
+			status: http.StatusBadRequest,
+			err:    api.Error{Code: "BadRequest", Message: "Unable to parse request body"},
+		},
+		"no message": {
+			handler: func(request *restful.Request, response *restful.Response) {
+				response.WriteHeader(http.StatusServiceUnavailable)
+			},
+			status: http.StatusServiceUnavailable,
+			err:    api.Error{Code: "ServiceUnavailable", Message: "Service Unavailable"},
+		},
+	}
+
+	for d, tc := range testCases {
+		recorder := serveV1(tc.handler)
+		if recorder.Code != tc.status {
+			t.Errorf("%s: Expect status %d, got %d", d, tc.status, recorder.Code)
+		}
+
+		decoder := json.NewDecoder(recorder.Body)
+		decoder.UseNumber()
+		var apiError api.Error
+		if err := decoder.Decode(&apiError); err != nil {
+			t.Errorf("%s: Expect the error envelope, got %v", d, err)
+			continue
+		}
+		if !reflect.DeepEqual(apiError, tc.err) {
+			t.Errorf("%s: Expect error %+v, got %+v", d, tc.err, apiError)
+		}
+	}
+}
+
+// TestV1AdapterSuccess tests the successful responses are passed through with
+// their status code and headers, and the error_msg is removed.
+func TestV1AdapterSuccess(t *testing.T) {
+	testCases := map[string]struct {
+		handler restful.RouteFunction
+		status  int
+		body    string
+	}{
+		"error_msg removed": {
+			handler: func(request *restful.Request, response *restful.Response) {
+				response.AddHeader("X-Cyclone-Test", "yes")
+				response.WriteHeaderAndEntity(http.StatusAccepted, map[string]interface{}{
+					"service_id": "s1",
+					"error_msg":  "",
+					"size":       12345678901234567,
+				})
+			},
+			status: http.StatusAccepted,
+			body:   `{"service_id":"s1","size":12345678901234567}`,
+		},
+		"no error_msg": {
+			handler: func(request *restful.Request, response *restful.Response) {
+				response.WriteEntity(map[string]string{"result": "success"})
+			},
+			status: http.StatusOK,
+			body:   `{"result":"success"}`,
+		},
+		"not an object": {
+			handler: func(request *restful.Request, response *restful.Response) {
+				response.WriteEntity([]string{"a", "b"})
+			},
+			status: http.StatusOK,
+			body:   `["a","b"]`,
+		},
+	}
+
+	for d, tc := range testCases {
+		recorder := serveV1(tc.handler)
+		if recorder.Code != tc.status {
+			t.Errorf("%s: Expect status %d, got %d", d, tc.status, recorder.Code)
+		}
+		if !jsonEqual(recorder.Body.Bytes(), []byte(tc.body)) {
+			t.Errorf("%s: Expect body %s, got %s", d, tc.body, recorder.Body.String())
+		}
+	}
+
+	recorder := serveV1(testCases["error_msg removed"].handler)
+	if recorder.Header().Get("X-Cyclone-Test") != "yes" {
+		t.Errorf("Expect the headers of the handler to be kept, got %v", recorder.Header())
+	}
+}
+
+// TestBufferedWriter tests the first status code is kept, and writing the body
+// implies status 200.
+func TestBufferedWriter(t *testing.T) {
+	writer := &bufferedWriter{header: http.Header{}}
+	writer.Write([]byte("ok"))
+	writer.WriteHeader(http.StatusNotFound)
+	if writer.status != http.StatusOK || writer.body.String() != "ok" {
+		t.Errorf("Expect status 200 and body ok, got %d and %s", writer.status, writer.body.String())
+	}
+
+	writer = &bufferedWriter{header: http.Header{}}
+	writer.WriteHeader(http.StatusCreated)
+	writer.WriteHeader(http.StatusInternalServerError)
+	if writer.status != http.StatusCreated {
+		t.Errorf("Expect status 201, got %d", writer.status)
+	}
+}
+
+// jsonEqual gets whether the JSON documents are equal, numbers are compared
+// as they are.
+func jsonEqual(a, b []byte) bool {
+	if fields, ok := decodeObject(a); ok {
+		expected, ok := decodeObject(b)
+		return ok && reflect.DeepEqual(fields, expected)
+	}
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
@@ -18,14 +18,17 @@ package rest
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
 	"github.com/caicloud/cyclone/api"
 	"github.com/caicloud/cyclone/event"
 	"github.com/caicloud/cyclone/pkg/log"
+	"github.com/caicloud/cyclone/pkg/rollout"
 	"github.com/caicloud/cyclone/store"
 	"github.com/emicklei/go-restful"
+	"gopkg.in/mgo.v2"
 )
 
 // createVersion creates a new version from service codebase master branch/trunk,
@@ -44,6 +47,8 @@ import (
 //     "name": (string) the version name to create with, e.g. v0.1.0
 //     "description": (string) a short description of the version
 //     "service_id": (string) service associated with the version
+//     "build_variables": (object) variables passed to the steps as environment variables,
+//        and to the docker build as the build args declared in the Dockerfile
 //   }
 //
 // RESPONSE: (VersionCreationResponse)
@@ -97,6 +102,14 @@ func createVersion(request *restful.Request, response *restful.Response) {
 		return
 	}
 
+	if err := encryptVersionTokens(&version); err != nil {
+		message := "Unable to encrypt cluster tokens"
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		createResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, createResponse)
+		return
+	}
+
 	// Request looks good, now fill up initial version status.
 	version.CreateTime = time.Now()
 	version.Status = api.VersionPending
@@ -110,6 +123,8 @@ func createVersion(request *restful.Request, response *restful.Response) {
 	}
 
 	version.SecurityCheck = false
+	event.DeployFirstEnvironment(service, &version)
+	event.HoldDeploy(service, &version)
 
 	// Create a new version in database. Note the version is NOT the final version:
 	// there can be error when running tests or building docker image. The version
@@ -127,7 +142,7 @@ func createVersion(request *restful.Request, response *restful.Response) {
 
 	// Start building the version asynchronously, and make sure event is successfully
 	// created before return.
-	err = sendCreateVersionEvent(service, &version)
+	err = event.SendCreateVersionEvent(service, &version)
 	if err != nil {
 		message := "Unable to create build version job"
 		log.ErrorWithFields(message, log.Fields{"user_id": userID, "service": service, "version": version, "error": err})
@@ -177,21 +192,40 @@ func getVersion(request *restful.Request, response *restful.Response) {
 	if err != nil {
 		message := fmt.Sprintf("Unable to find version %v", versionID)
 		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		if err == mgo.ErrNotFound {
+			setErrorStatus(request, http.StatusNotFound)
+		} else {
+			setErrorStatus(request, http.StatusInternalServerError)
+		}
 		getResponse.ErrorMessage = message
 	} else {
+		hideVersionTokens(result)
 		getResponse.Version = *result
 	}
 
 	response.WriteEntity(getResponse)
 }
 
-// listVersions returns all versions belong to a user and service.
+// listVersions returns the versions belong to a user and service, page by page
+// if limit is given.
+//
+// GET: /api/v0.1/:uid/services/:service_id/versions?limit=&continue=&sort=&name_prefix=&status=&operation=&operator=&created_after=&created_before=
 //
-// GET: /api/v0.1/:uid/services/:service_id/versions
+// QUERY:
+//   limit: (int) max number of versions in a page, all the versions if not given
+//   continue: (string) the continue token of the previous page
+//   sort: (string) create_time or name, prefixed by - for the descending order,
+//      default is -create_time
+//   name_prefix: (string) prefix of the version names
+//   status: (string) status of the versions
+//   operation: (string) one of the operations of the versions
+//   operator: (string) operator of the versions, webhook, api or upstream
+//   created_after, created_before: (string) range of the create time, in RFC 3339
 //
 // RESPONSE: (VersionListResponse)
 //  {
 //    "versions": (array) a list of api.Version object.
+//    "continue": (string) the continue token of the next page, empty for the last page.
 //    "error_msg": (string) set IFF the request fails.
 //  }
 func listVersions(request *restful.Request, response *restful.Response) {
@@ -199,17 +233,47 @@ func listVersions(request *restful.Request, response *restful.Response) {
 	userID := request.PathParameter("user_id")
 
 	var listResponse api.VersionListResponse
+	opts, err := listOptions(request)
+	if err != nil {
+		listResponse.ErrorMessage = err.Error()
+		response.WriteHeaderAndEntity(http.StatusBadRequest, listResponse)
+		return
+	}
+	filter := store.VersionFilter{
+		NamePrefix: request.QueryParameter("name_prefix"),
+		Status:     api.VersionStatus(request.QueryParameter("status")),
+		Operation:  api.VersionOperation(request.QueryParameter("operation")),
+		Operator:   api.VersionOperator(request.QueryParameter("operator")),
+	}
+	if filter.CreatedAfter, err = timeParameter(request, "created_after"); err == nil {
+		filter.CreatedBefore, err = timeParameter(request, "created_before")
+	}
+	if err != nil {
+		listResponse.ErrorMessage = err.Error()
+		response.WriteHeaderAndEntity(http.StatusBadRequest, listResponse)
+		return
+	}
+
 	ds := store.NewStore()
 	defer ds.Close()
 
-	result, err := ds.FindVersionsByServiceID(serviceID)
-
+	result, next, err := ds.ListVersions(serviceID, filter, opts)
+	if err == store.ErrInvalidSort || err == store.ErrInvalidContinue {
+		listResponse.ErrorMessage = err.Error()
+		response.WriteHeaderAndEntity(http.StatusBadRequest, listResponse)
+		return
+	}
 	if err != nil {
 		message := "Unable to list version"
 		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		setErrorStatus(request, http.StatusInternalServerError)
 		listResponse.ErrorMessage = message
 	} else {
+		for i := range result {
+			hideVersionTokens(&result[i])
+		}
 		listResponse.Versions = result
+		listResponse.Continue = next
 	}
 
 	response.WriteEntity(listResponse)
@@ -218,6 +282,9 @@ func listVersions(request *restful.Request, response *restful.Response) {
 //
 // POST: /api/v0.1/:uid/versions/:versionID/cancelbuild
 //
+// A pending version is removed from the queue, and the worker of a running
+// version is killed. The version is marked as cancelled by the post hook.
+//
 // RESPONSE: (VersionConcelResponse)
 //  {
 //    "result": (string) success.
@@ -229,32 +296,306 @@ func cancelVersion(request *restful.Request, response *restful.Response) {
 
 	var cancelresponse api.VersionConcelResponse
 	log.Infof("user(%s) cance build version %s", userID, versionID)
-	e, err := event.LoadEventFromEtcd(api.EventID(versionID))
+	err := event.CancelEvent(api.EventID(versionID))
 	if err != nil {
-		message := fmt.Sprintf("Unable to find event by versonID %v", versionID)
-		log.ErrorWithFields(message, log.Fields{"user_id": userID})
+		message := fmt.Sprintf("Unable to cancel version %v: %v", versionID, err)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
 		cancelresponse.ErrorMessage = message
-		response.WriteHeaderAndEntity(http.StatusInternalServerError, cancelresponse)
+		status := http.StatusInternalServerError
+		if err == event.ErrEventFinished || err == event.ErrEventDispatching {
+			status = http.StatusConflict
+		}
+		response.WriteHeaderAndEntity(status, cancelresponse)
 		return
 	}
-	w, err := event.LoadWorker(e)
+
+	cancelresponse.Result = "success"
+	response.WriteEntity(cancelresponse)
+}
+
+// rollbackVersion rolls the deploy plans of a version back to the images the
+// containers ran before the version was deployed, the service of a blue-green
+// plan is switched back to the previous deployment. Only the plans deployed by
+// the kubernetes API can be rolled back, and the containers must still run the
+// images of the version.
+//
+// POST: /api/v0.1/:uid/versions/:versionID/rollback
+//
+// RESPONSE: (VersionRollbackResponse)
+//  {
+//    "plan_names": (array) names of the deploy plans rolled back.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func rollbackVersion(request *restful.Request, response *restful.Response) {
+	versionID := request.PathParameter("version_id")
+	userID := request.PathParameter("user_id")
+
+	var rollbackResponse api.VersionRollbackResponse
+	ds := store.NewStore()
+	defer ds.Close()
+
+	version, err := ds.FindVersionByID(versionID)
 	if err != nil {
-		message := fmt.Sprintf("Unable to load worker by event")
+		message := fmt.Sprintf("Unable to find version %v", versionID)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		rollbackResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, rollbackResponse)
+		return
+	}
+
+	plans, err := rollbackPlans(version)
+	if err != nil {
+		message := fmt.Sprintf("Unable to roll back version %v: %v", versionID, err)
 		log.ErrorWithFields(message, log.Fields{"user_id": userID})
-		cancelresponse.ErrorMessage = message
-		response.WriteHeaderAndEntity(http.StatusInternalServerError, cancelresponse)
+		rollbackResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusConflict, rollbackResponse)
 		return
 	}
 
-	err = w.Fire()
+	// The plans rolled back before an error are saved.
+	for _, plan := range plans {
+		if err = rollbackPlan(version, plan); err != nil {
+			break
+		}
+		rollbackResponse.PlanNames = append(rollbackResponse.PlanNames, plan.PlanName)
+	}
+	if errUpdate := ds.UpdateVersionDocument(versionID, *version); errUpdate != nil {
+		log.ErrorWithFields("Unable to update version after rollback", log.Fields{"version_id": versionID, "error": errUpdate})
+		if err == nil {
+			err = errUpdate
+		}
+	}
+	// The version is not live in the environments rolled back.
+	for _, plan := range plans {
+		if plan.Status != api.DeployRolledBack || plan.Environment == "" {
+			continue
+		}
+		if errLive := ds.RemoveLiveInfo(versionID, plan.Environment); errLive != nil {
+			log.ErrorWithFields("Unable to remove live info after rollback", log.Fields{"version_id": versionID, "error": errLive})
+		}
+	}
+
 	if err != nil {
-		message := fmt.Sprintf("Unable to cancel event")
+		message := fmt.Sprintf("Unable to roll back version %v: %v", versionID, err)
 		log.ErrorWithFields(message, log.Fields{"user_id": userID})
-		cancelresponse.ErrorMessage = message
-		response.WriteHeaderAndEntity(http.StatusInternalServerError, cancelresponse)
+		rollbackResponse.ErrorMessage = message
+		status := http.StatusInternalServerError
+		if err == rollout.ErrImageChanged || err == rollout.ErrServiceChanged {
+			status = http.StatusConflict
+		}
+		response.WriteHeaderAndEntity(status, rollbackResponse)
 		return
 	}
 
-	cancelresponse.Result = "success"
-	response.WriteEntity(cancelresponse)
+	response.WriteEntity(rollbackResponse)
+}
+
+// promoteVersion promotes a version to the next environment of its service, and
+// deploys it by the deploy plans of the environment. The deploy to the current
+// environment of the version must be healthy.
+//
+// POST: /api/v0.1/:uid/versions/:versionID/promote
+//
+// RESPONSE: (VersionPromotionResponse)
+//  {
+//    "environment": (string) environment the version is promoted to.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func promoteVersion(request *restful.Request, response *restful.Response) {
+	versionID := request.PathParameter("version_id")
+	userID := request.PathParameter("user_id")
+
+	var promotionResponse api.VersionPromotionResponse
+	service, _, err := findServiceAndVersion(versionID)
+	if err != nil {
+		message := fmt.Sprintf("Unable to find version %v", versionID)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		promotionResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusNotFound, promotionResponse)
+		return
+	}
+
+	version, err := event.PromoteVersion(service, versionID)
+	if err != nil {
+		message := fmt.Sprintf("Unable to promote version %v: %v", versionID, err)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID})
+		promotionResponse.ErrorMessage = message
+		status := http.StatusInternalServerError
+		switch err {
+		case event.ErrVersionNotHealthy, event.ErrUnknownEnvironment,
+			event.ErrEnvironmentNotHealthy, event.ErrNoNextEnvironment:
+			status = http.StatusConflict
+		}
+		response.WriteHeaderAndEntity(status, promotionResponse)
+		return
+	}
+
+	promotionResponse.Environment = version.Environment
+	response.WriteEntity(promotionResponse)
+}
+
+// approveVersion approves the deploy of a version awaiting approval, the version
+// starts deploying to its deploy plans and by the yaml information.
+//
+// POST: /api/v0.1/:uid/versions/:versionID/approve
+//
+// PAYLOAD (VersionApprovalRequest):
+//   {
+//     "comment": (string) comment of the approver, optional
+//   }
+//
+// RESPONSE: (VersionApprovalResponse)
+//  {
+//    "status": (string) status of the approval, approved.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func approveVersion(request *restful.Request, response *restful.Response) {
+	decideVersion(request, response, event.ApproveVersion)
+}
+
+// rejectVersion rejects the deploy of a version awaiting approval, the version
+// is kept without deploying.
+//
+// POST: /api/v0.1/:uid/versions/:versionID/reject
+//
+// PAYLOAD (VersionApprovalRequest):
+//   {
+//     "comment": (string) comment of the approver, optional
+//   }
+//
+// RESPONSE: (VersionApprovalResponse)
+//  {
+//    "status": (string) status of the approval, rejected.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func rejectVersion(request *restful.Request, response *restful.Response) {
+	decideVersion(request, response, event.RejectVersion)
+}
+
+// decideVersion records the decision of the user on the deploy of the version.
+func decideVersion(request *restful.Request, response *restful.Response,
+	decide func(service *api.Service, versionID, userID, comment string) (*api.Version, error)) {
+	versionID := request.PathParameter("version_id")
+	userID := request.PathParameter("user_id")
+
+	var approvalResponse api.VersionApprovalResponse
+	approvalRequest := api.VersionApprovalRequest{}
+	if err := request.ReadEntity(&approvalRequest); err != nil && err != io.EOF {
+		approvalResponse.ErrorMessage = "Unable to parse request body"
+		response.WriteHeaderAndEntity(http.StatusBadRequest, approvalResponse)
+		return
+	}
+
+	service, _, err := findServiceAndVersion(versionID)
+	if err != nil {
+		message := fmt.Sprintf("Unable to find version %v", versionID)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID, "error": err})
+		approvalResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusNotFound, approvalResponse)
+		return
+	}
+
+	version, err := decide(service, versionID, userID, approvalRequest.Comment)
+	if version != nil && version.Approval != nil {
+		approvalResponse.Status = version.Approval.Status
+	}
+	if err != nil {
+		message := fmt.Sprintf("Unable to decide the deploy of version %v: %v", versionID, err)
+		log.ErrorWithFields(message, log.Fields{"user_id": userID})
+		approvalResponse.ErrorMessage = message
+		status := http.StatusInternalServerError
+		if err == event.ErrNotAwaitingApproval || err == event.ErrApprovalExpired {
+			status = http.StatusConflict
+		} else if err == event.ErrNotApprover {
+			status = http.StatusUnauthorized
+		}
+		response.WriteHeaderAndEntity(status, approvalResponse)
+		return
+	}
+
+	response.WriteEntity(approvalResponse)
+}
+
+// rollbackPlans finds the deploy plans of the version to roll back, that is the
+// finished ones deployed by the kubernetes API and not rolled back yet.
+func rollbackPlans(version *api.Version) ([]*api.DeployPlanStatus, error) {
+	plans := []*api.DeployPlanStatus{}
+	for i := range version.DeployPlansStatuses {
+		plan := &version.DeployPlansStatuses[i]
+		if plan.Status == api.DeployPending {
+			return nil, fmt.Errorf("deploy plan %s is in progress", plan.PlanName)
+		}
+		if plan.Config.ClusterType != api.ClusterTypeKubernetes {
+			continue
+		}
+		if len(plan.PreviousImages) == 0 && switchedDeployment(plan) == "" {
+			continue
+		}
+		if plan.Status == api.DeploySuccess || plan.Status == api.DeployFailed {
+			plans = append(plans, plan)
+		}
+	}
+	if len(plans) == 0 {
+		return nil, fmt.Errorf("no deploy plan to roll back")
+	}
+	return plans, nil
+}
+
+// switchedDeployment returns the deployment the service of the blue-green plan
+// is switched to, empty if the service is not switched.
+func switchedDeployment(plan *api.DeployPlanStatus) string {
+	if plan.Config.Strategy.Type != api.DeployStrategyBlueGreen {
+		return ""
+	}
+	for _, subStatus := range plan.SubStatuses {
+		if subStatus.Phase == api.DeployPhaseSwitch && subStatus.Status == api.DeploySuccess {
+			return subStatus.Deployment
+		}
+	}
+	return ""
+}
+
+// rollbackPlan restores the previous images of the deploy plan, or switches
+// the service of the blue-green plan back, and records the rollback in the plan.
+func rollbackPlan(version *api.Version, plan *api.DeployPlanStatus) error {
+	token, err := event.DecryptClusterToken(plan.Config.ClusterToken)
+	if err != nil {
+		return err
+	}
+	client, err := rollout.NewClient(plan.Config.ClusterHost, token)
+	if err != nil {
+		return err
+	}
+	config := plan.Config
+	images := rollout.Images(config.Containers, plan.Image)
+	if switched := switchedDeployment(plan); switched != "" {
+		err = rollout.SwitchBack(client, config.Namespace, config.Deployment, config.Strategy.Service, switched, images)
+	} else {
+		err = rollout.Rollback(client, config.Namespace, config.Deployment, images, plan.PreviousImages)
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	plan.Status = api.DeployRolledBack
+	plan.SubStatuses = append(plan.SubStatuses, api.DeploySubStatus{
+		Deployment: plan.Config.Deployment,
+		Phase:      api.DeployPhaseRollback,
+		Status:     api.DeploySuccess,
+		StartTime:  now,
+		EndTime:    now,
+	})
+	plan.Deployments = append(plan.Deployments, api.VersionDeployment{
+		VersionID:      version.VersionID,
+		DeploymentKind: api.DeploymentKindRollack,
+		VersionLiveInfo: api.VersionLiveInfo{
+			Environment: plan.Environment,
+			Cluster:     plan.Config.ClusterName,
+			Project:     plan.Config.Namespace,
+			DeployTime:  now,
+		},
+		Reason: "rolled back by request",
+	})
+	return nil
 }
@@ -18,12 +18,15 @@ package rest
 
 import (
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 	"time"
 
 	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/event"
 	"github.com/caicloud/cyclone/pkg/executil"
 	"github.com/caicloud/cyclone/pkg/log"
 	"github.com/caicloud/cyclone/store"
@@ -54,6 +57,7 @@ func webhookGithub(request *restful.Request, response *restful.Response) {
 
 	// Check vcs of service is github.
 	if api.Git != getServiceVcs(serviceID) {
+		setErrorStatus(request, http.StatusBadRequest)
 		webhookResponse.ErrorMessage = "vcs is not github"
 		response.WriteHeaderAndEntity(http.StatusOK, webhookResponse)
 		return
@@ -85,6 +89,7 @@ func webhookGithub(request *restful.Request, response *restful.Response) {
 	// Create version config.
 	version := createVersionGithubConfig(serviceID, eventType, payload, operation)
 	if nil == version {
+		setErrorStatus(request, http.StatusBadRequest)
 		webhookResponse.ErrorMessage = "unknow"
 		response.WriteHeaderAndEntity(http.StatusOK, webhookResponse)
 		return
@@ -93,6 +98,7 @@ func webhookGithub(request *restful.Request, response *restful.Response) {
 	// Create a version.
 	errCreateVersion := webhookCreateVersion(serviceID, version)
 	if nil != errCreateVersion {
+		setErrorStatus(request, http.StatusInternalServerError)
 		webhookResponse.ErrorMessage = errCreateVersion.Error()
 		response.WriteHeaderAndEntity(http.StatusOK, webhookResponse)
 		return
@@ -175,10 +181,22 @@ func createVersionGithubConfig(serviceID string, eventType string, payload api.W
 	case api.GithubWebhookPush:
 		log.Info("webhook event: push")
 		version.Name, version.Description, version.Commit = generateVersionFromPushData(payload)
+		version.Branch = branchFromRef(payload[api.GithubWebhookFlagRef])
+		version.ChangedFiles = changedFilesFromCommits(payload["commits"])
+		version.Trigger = api.TriggerPush
+		if isReleaseTag(eventType, payload) {
+			version.Trigger = api.TriggerTag
+		}
 
 	case api.GithubWebhookPullRequest:
 		log.Info("webhook event: pull_request")
 		version.Name, version.Description, version.URL, version.Commit = generateVersionFromPRData(payload)
+		version.Trigger = api.TriggerPullRequest
+		if pullRequest, ok := payload[api.GithubWebhookFlagPR].(map[string]interface{}); ok {
+			if base, ok := pullRequest["base"].(map[string]interface{}); ok {
+				version.Branch, _ = base["ref"].(string)
+			}
+		}
 
 	default:
 		log.Info("receive undefine webhook event")
@@ -188,6 +206,46 @@ func createVersionGithubConfig(serviceID string, eventType string, payload api.W
 	return version
 }
 
+// branchFromRef gets the branch name from the ref of a push, e.g. refs/heads/master,
+// it returns empty string if the ref is not a branch.
+func branchFromRef(ref interface{}) string {
+	refStr, ok := ref.(string)
+	if !ok || !strings.HasPrefix(refStr, "refs/heads/") {
+		return ""
+	}
+	return strings.TrimPrefix(refStr, "refs/heads/")
+}
+
+// changedFilesFromCommits gets the files added, modified or removed by the
+// commits of a push, GitHub and GitLab share the format of the commits.
+func changedFilesFromCommits(commits interface{}) []string {
+	commitList, ok := commits.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var files []string
+	seen := make(map[string]bool)
+	for _, c := range commitList {
+		commit, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, key := range []string{"added", "modified", "removed"} {
+			changed, _ := commit[key].([]interface{})
+			for _, f := range changed {
+				file, ok := f.(string)
+				if !ok || seen[file] {
+					continue
+				}
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	return files
+}
+
 // generateVersionFromPushData generates version config from payload data.
 // name:
 //   tag: tag_commitId
@@ -340,12 +398,14 @@ func webhookSVN(request *restful.Request, response *restful.Response) {
 	defer ds.Close()
 	service, err := ds.FindServiceByID(serviceID)
 	if err != nil {
+		setErrorStatus(request, http.StatusNotFound)
 		webhookResponse.ErrorMessage = "unknow serviceID"
 		response.WriteHeaderAndEntity(http.StatusOK, webhookResponse)
 		return
 	}
 
 	if api.Svn != service.Repository.Vcs {
+		setErrorStatus(request, http.StatusBadRequest)
 		webhookResponse.ErrorMessage = "vcs is not svn"
 		response.WriteHeaderAndEntity(http.StatusOK, webhookResponse)
 		return
@@ -359,7 +419,13 @@ func webhookSVN(request *restful.Request, response *restful.Response) {
 		// Check if is a commit to special url
 		// In same repo of SVN, commit in other dir will also trap webhook.
 		needCreateVerion, commitLog, err := isCommitToSpecialURL(payload.CommitID, service)
-		if err != nil || false == needCreateVerion {
+		if err != nil {
+			setErrorStatus(request, http.StatusInternalServerError)
+			webhookResponse.ErrorMessage = err.Error()
+			response.WriteHeaderAndEntity(http.StatusOK, webhookResponse)
+			return
+		}
+		if false == needCreateVerion {
 			webhookResponse.ErrorMessage = "ignore"
 			response.WriteHeaderAndEntity(http.StatusOK, webhookResponse)
 			return
@@ -378,7 +444,12 @@ func webhookSVN(request *restful.Request, response *restful.Response) {
 		}
 
 		// Create version.
-		webhookCreateVersion(serviceID, version)
+		if err := webhookCreateVersion(serviceID, version); err != nil {
+			setErrorStatus(request, http.StatusInternalServerError)
+			webhookResponse.ErrorMessage = err.Error()
+			response.WriteHeaderAndEntity(http.StatusOK, webhookResponse)
+			return
+		}
 
 	default:
 		log.Info("svn webhook receive unknow event")
@@ -388,6 +459,153 @@ func webhookSVN(request *restful.Request, response *restful.Response) {
 	response.WriteHeaderAndEntity(http.StatusOK, webhookResponse)
 }
 
+// webhookHg handles webhook data from mercurial.
+//
+// POST: /api/v0.1/{service_id}/webhook_hg
+//
+// PAYLOAD (WebhookHg):
+//   {
+//     "url": (string) mercurial url, must be the repository of the service
+//     "event": (string) event type of webhook, commit or tag
+//     "commit_id": (string) id of commit
+//     "branch": (string) branch of the commit
+//     "tag": (string) name of the tag, only for tag event
+//     "description": (string) description of the commit
+//   }
+//
+// RESPONSE: (WebhookResponse)
+//  {
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func webhookHg(request *restful.Request, response *restful.Response) {
+	// read payload
+	payload := api.WebhookHg{}
+	err := request.ReadEntity(&payload)
+	if err != nil {
+		response.AddHeader("Content-Type", "text/plain")
+		response.WriteErrorString(http.StatusBadRequest, "Unable to parse request body")
+		return
+	}
+
+	var webhookResponse api.WebhookResponse
+	serviceID := request.PathParameter("service_id")
+
+	ds := store.NewStore()
+	defer ds.Close()
+	service, err := ds.FindServiceByID(serviceID)
+
+	// Make sure that the vcs of service is mercurial.
+	if err != nil || api.Mercurial != service.Repository.Vcs {
+		setErrorStatus(request, http.StatusBadRequest)
+		webhookResponse.ErrorMessage = "vcs is not mercurial"
+		response.WriteHeaderAndEntity(http.StatusOK, webhookResponse)
+		return
+	}
+
+	// Create version config.
+	version, err := createVersionHgConfig(service, payload)
+	if err != nil {
+		setErrorStatus(request, http.StatusBadRequest)
+		webhookResponse.ErrorMessage = err.Error()
+		response.WriteHeaderAndEntity(http.StatusOK, webhookResponse)
+		return
+	}
+	if nil == version {
+		webhookResponse.ErrorMessage = "ignore"
+		response.WriteHeaderAndEntity(http.StatusOK, webhookResponse)
+		return
+	}
+
+	// Create version.
+	if err := webhookCreateVersion(serviceID, version); err != nil {
+		setErrorStatus(request, http.StatusInternalServerError)
+		webhookResponse.ErrorMessage = err.Error()
+		response.WriteHeaderAndEntity(http.StatusOK, webhookResponse)
+		return
+	}
+
+	webhookResponse.ErrorMessage = "ok"
+	response.WriteHeaderAndEntity(http.StatusOK, webhookResponse)
+}
+
+// createVersionHgConfig creates a version config from mercurial webhook data, it
+// returns nil if the event should be ignored, or an error if the webhook is not
+// sent from the repository of the service.
+// name:
+//   tag: tag_tagName or tag_commitId
+//   commit: ci_commitId
+func createVersionHgConfig(service *api.Service, payload api.WebhookHg) (*api.Version, error) {
+	if !isSameRepository(payload.URL, service.Repository.URL) {
+		return nil, fmt.Errorf("url %s is not the repository of the service", payload.URL)
+	}
+	if "" == payload.CommitID {
+		log.Info("mercurial webhook receive event without commit")
+		return nil, nil
+	}
+
+	version := &api.Version{
+		ServiceID:        service.ServiceID,
+		Commit:           payload.CommitID,
+		Branch:           payload.Branch,
+		Description:      payload.Description + "\r\n" + payload.CommitID,
+		YamlDeploy:       api.DeployWithYaml,
+		YamlDeployStatus: api.DeployNoRun,
+		SecurityCheck:    false,
+	}
+
+	switch payload.Event {
+	case api.HgWebhookCommit:
+		log.Info("mercurial webhook receive commit event")
+		version.Name = "ci_" + payload.CommitID
+		version.Operation = api.IntegrationOperation
+		version.Trigger = api.TriggerPush
+
+	case api.HgWebhookTag:
+		log.Info("mercurial webhook receive tag event")
+		// Create a version in UI, it will create a tag in repo automatically.
+		// Need to ignore it.
+		if strings.HasSuffix(payload.Tag, api.AutoCreateTagFlag) {
+			return nil, nil
+		}
+		if "" != payload.Tag && canBeUsedInImageTag(payload.Tag) {
+			version.Name = "tag_" + payload.Tag
+		} else {
+			version.Name = "tag_" + payload.CommitID
+		}
+		version.Operation = api.IntegrationOperation + api.PublishOperation + api.DeployOperation
+		version.Trigger = api.TriggerTag
+
+	default:
+		log.Info("mercurial webhook receive unknow event")
+		return nil, nil
+	}
+
+	return version, nil
+}
+
+// isSameRepository gets whether the urls are of the same repository, the
+// scheme, user, port and trailing slash of the urls are ignored.
+func isSameRepository(url1, url2 string) bool {
+	u1, err := url.Parse(url1)
+	if err != nil || u1.Host == "" {
+		return false
+	}
+	u2, err := url.Parse(url2)
+	if err != nil || u2.Host == "" {
+		return false
+	}
+	return strings.EqualFold(hostOf(u1), hostOf(u2)) &&
+		strings.Trim(u1.Path, "/") == strings.Trim(u2.Path, "/")
+}
+
+// hostOf gets the host of the url without the port.
+func hostOf(u *url.URL) string {
+	if host, _, err := net.SplitHostPort(u.Host); err == nil {
+		return host
+	}
+	return u.Host
+}
+
 // isCommitToSpecialURL gets if the commit is to a specific url.
 func isCommitToSpecialURL(commitID string, service *api.Service) (bool, string, error) {
 	args := []string{"log", service.Repository.URL, "-r", commitID, "--username", service.Repository.Username,
@@ -432,6 +650,8 @@ func webhookCreateVersion(serviceID string, version *api.Version) error {
 	// Request looks good, now fill up initial version status.
 	version.CreateTime = time.Now()
 	version.Status = api.VersionPending
+	event.DeployFirstEnvironment(service, version)
+	event.HoldDeploy(service, version)
 
 	// Create a new version in database. Note the version is NOT the final version:
 	// there can be error when running tests or building docker image. The version
@@ -447,7 +667,7 @@ func webhookCreateVersion(serviceID string, version *api.Version) error {
 
 	// Start building the version asynchronously, and make sure event is successfully
 	// created before return.
-	err = sendCreateVersionEvent(service, version)
+	err = event.SendCreateVersionEvent(service, version)
 	if err != nil {
 		message := "Unable to create build version job"
 		log.ErrorWithFields(message, log.Fields{"user_id": service.UserID, "service": service, "version": version, "error": err})
@@ -480,6 +700,7 @@ func webhookGitLab(request *restful.Request, response *restful.Response) {
 
 	// Check vcs of service is gitlab.
 	if api.Git != getServiceVcs(serviceID) {
+		setErrorStatus(request, http.StatusBadRequest)
 		webhookResponse.ErrorMessage = "vcs is not git"
 		response.WriteHeaderAndEntity(http.StatusOK, webhookResponse)
 		return
@@ -511,6 +732,7 @@ func webhookGitLab(request *restful.Request, response *restful.Response) {
 	// Create version config.
 	version := createVersionGitlabConfig(serviceID, eventType, payload, operation)
 	if nil == version {
+		setErrorStatus(request, http.StatusBadRequest)
 		webhookResponse.ErrorMessage = "unknow"
 		response.WriteHeaderAndEntity(http.StatusOK, webhookResponse)
 		return
@@ -519,6 +741,7 @@ func webhookGitLab(request *restful.Request, response *restful.Response) {
 	// Create version.
 	errCreateVersion := webhookCreateVersion(serviceID, version)
 	if nil != errCreateVersion {
+		setErrorStatus(request, http.StatusInternalServerError)
 		webhookResponse.ErrorMessage = errCreateVersion.Error()
 		response.WriteHeaderAndEntity(http.StatusOK, webhookResponse)
 		return
@@ -584,14 +807,22 @@ func createVersionGitlabConfig(serviceID string, eventType string, payload api.W
 	switch eventType {
 	case api.GitlabWebhookPush:
 		version.Name, version.Description, version.Commit = generateVersionFromGitlabPushData(payload)
+		version.Branch = branchFromRef(payload[api.GitlabWebhookFlagRef])
+		version.ChangedFiles = changedFilesFromCommits(payload["commits"])
+		version.Trigger = api.TriggerPush
 		log.Info("webhook event: push")
 
 	case api.GitlabWebhookPullRequest:
 		version.Name, version.Description, version.URL, version.Commit = generateVersionFromGitlabPRData(payload)
+		version.Trigger = api.TriggerPullRequest
+		if attributes, ok := payload["object_attributes"].(map[string]interface{}); ok {
+			version.Branch, _ = attributes["target_branch"].(string)
+		}
 		log.Info("webhook event: merge_request")
 
 	case api.GitlabWebhookRelease:
 		version.Name, version.Description, version.Commit = generateVersionFromGitlabRelData(payload)
+		version.Trigger = api.TriggerTag
 		log.Infof("webhook event: release")
 
 	default:
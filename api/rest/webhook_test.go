@@ -0,0 +1,111 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"testing"
+
+	"github.com/caicloud/cyclone/api"
+)
+
+// TestCreateVersionHgConfig tests the versions created from the mercurial
+// webhooks, and the webhooks of other repositories are rejected.
+func TestCreateVersionHgConfig(t *testing.T) {
+	service := &api.Service{
+		ServiceID:  "s1",
+		Repository: api.ServiceRepository{URL: "https://hg.example.com/sample_repo", Vcs: api.Mercurial},
+	}
+
+	testCases := map[string]struct {
+		payload   api.WebhookHg
+		name      string
+		operation api.VersionOperation
+		trigger   api.VersionTrigger
+		ignored   bool
+		err       bool
+	}{
+		"commit": {
+			payload:   api.WebhookHg{URL: "https://hg.example.com/sample_repo/", Event: api.HgWebhookCommit, CommitID: "abc123", Branch: "default"},
+			name:      "ci_abc123",
+			operation: api.IntegrationOperation,
+			trigger:   api.TriggerPush,
+		},
+		"tag": {
+			payload:   api.WebhookHg{URL: "ssh://hg@HG.example.com:22/sample_repo", Event: api.HgWebhookTag, CommitID: "abc123", Tag: "v1.0"},
+			name:      "tag_v1.0",
+			operation: api.IntegrationOperation + api.PublishOperation + api.DeployOperation,
+			trigger:   api.TriggerTag,
+		},
+		"auto created tag": {
+			payload: api.WebhookHg{URL: "https://hg.example.com/sample_repo", Event: api.HgWebhookTag, CommitID: "abc123", Tag: "v1.0" + api.AutoCreateTagFlag},
+			ignored: true,
+		},
+		"no commit": {
+			payload: api.WebhookHg{URL: "https://hg.example.com/sample_repo", Event: api.HgWebhookCommit},
+			ignored: true,
+		},
+		"unknown event": {
+			payload: api.WebhookHg{URL: "https://hg.example.com/sample_repo", Event: "bookmark", CommitID: "abc123"},
+			ignored: true,
+		},
+		"other repository": {
+			payload: api.WebhookHg{URL: "https://hg.example.com/other_repo", Event: api.HgWebhookCommit, CommitID: "abc123"},
+			err:     true,
+		},
+		"other host": {
+			payload: api.WebhookHg{URL: "https://hg.evil.com/sample_repo", Event: api.HgWebhookCommit, CommitID: "abc123"},
+			err:     true,
+		},
+		"no url": {
+			payload: api.WebhookHg{Event: api.HgWebhookCommit, CommitID: "abc123"},
+			err:     true,
+		},
+	}
+
+	for d, tc := range testCases {
+		version, err := createVersionHgConfig(service, tc.payload)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: Expect error, got version %v", d, version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Expect error to be nil, got %v", d, err)
+			continue
+		}
+		if tc.ignored {
+			if version != nil {
+				t.Errorf("%s: Expect the webhook to be ignored, got version %v", d, version)
+			}
+			continue
+		}
+		if version == nil {
+			t.Errorf("%s: Expect a version, got nil", d)
+			continue
+		}
+		if version.ServiceID != service.ServiceID || version.Name != tc.name ||
+			version.Operation != tc.operation || version.Trigger != tc.trigger {
+			t.Errorf("%s: Expect version %s with operation %s and trigger %s, got %s, %s, %s",
+				d, tc.name, tc.operation, tc.trigger, version.Name, version.Operation, version.Trigger)
+		}
+		if version.Commit != tc.payload.CommitID || version.Branch != tc.payload.Branch {
+			t.Errorf("%s: Expect commit %s on branch %s, got %s, %s",
+				d, tc.payload.CommitID, tc.payload.Branch, version.Commit, version.Branch)
+		}
+	}
+}
@@ -21,8 +21,10 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/event"
 	"github.com/caicloud/cyclone/pkg/log"
 	"github.com/caicloud/cyclone/store"
 	"github.com/emicklei/go-restful"
@@ -46,6 +48,7 @@ const WORKER_NODE_DOCKER_MIN_VERSION = "1.10.0"
 //       "memory": (float64) The memory config
 //       "cpu": (float64) The CPU config
 //     }
+//     "labels": (map) Labels of the worker node, e.g. {"arch": "arm64"}
 //   }
 //
 // RESPONSE: (WorkerNodeCreateResponse)
@@ -95,6 +98,8 @@ func createSystemWorkerNode(request *restful.Request, response *restful.Response
 	}
 
 	workerNode.LeftResource = workerNode.TotalResource
+	workerNode.Status = api.WorkerNodeReady
+	workerNode.LastSeen = time.Now()
 	nodeID, err := ds.NewSystemWorkerNodeDocument(&workerNode)
 	if err != nil {
 		message := fmt.Sprintf("Create new worker node err: %v", err)
@@ -268,3 +273,79 @@ func deleteSystemWorkerNode(request *restful.Request, response *restful.Response
 	deleteResponse.Result = "success"
 	response.WriteEntity(deleteResponse)
 }
+
+// cordonSystemWorkerNode marks the system worker node as unschedulable, the
+// running workers on it are not affected.
+//
+// PUT: /api/v0.1/system_worker_nodes/:node_id/cordon
+//
+// RESPONSE: (WorkerNodeSetResponse)
+//  {
+//    "worker_node": (object) api.WorkerNode object.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func cordonSystemWorkerNode(request *restful.Request, response *restful.Response) {
+	setWorkerNodeUnschedulable(request, response, true)
+}
+
+// uncordonSystemWorkerNode marks the system worker node as schedulable.
+//
+// PUT: /api/v0.1/system_worker_nodes/:node_id/uncordon
+//
+// RESPONSE: (WorkerNodeSetResponse)
+//  {
+//    "worker_node": (object) api.WorkerNode object.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func uncordonSystemWorkerNode(request *restful.Request, response *restful.Response) {
+	setWorkerNodeUnschedulable(request, response, false)
+}
+
+// setWorkerNodeUnschedulable is a helper to cordon or uncordon a system worker node.
+func setWorkerNodeUnschedulable(request *restful.Request, response *restful.Response, unschedulable bool) {
+	nodeID := request.PathParameter("node_id")
+
+	var setResponse api.WorkerNodeSetResponse
+	node, err := event.CordonWorkerNode(nodeID, unschedulable)
+	if err != nil {
+		message := fmt.Sprintf("Unable to set worker node %v unschedulable to %v", nodeID, unschedulable)
+		log.ErrorWithFields(message, log.Fields{"error": err})
+		setResponse.ErrorMessage = message
+		response.WriteHeaderAndEntity(http.StatusNotFound, setResponse)
+		return
+	}
+
+	setResponse.WorkerNode = *node
+	response.WriteEntity(setResponse)
+}
+
+// drainSystemWorkerNode cordons the system worker node, and requeues the events
+// running on it.
+//
+// PUT: /api/v0.1/system_worker_nodes/:node_id/drain
+//
+// RESPONSE: (WorkerNodeSetResponse)
+//  {
+//    "worker_node": (object) api.WorkerNode object.
+//    "error_msg": (string) set IFF the request fails.
+//  }
+func drainSystemWorkerNode(request *restful.Request, response *restful.Response) {
+	nodeID := request.PathParameter("node_id")
+
+	var setResponse api.WorkerNodeSetResponse
+	node, err := event.DrainWorkerNode(nodeID)
+	if err != nil {
+		message := fmt.Sprintf("Unable to drain worker node %v: %s", nodeID, notLeaderMessage(err))
+		log.ErrorWithFields(message, log.Fields{"error": err})
+		setResponse.ErrorMessage = message
+		status := http.StatusNotFound
+		if err == event.ErrNotLeader {
+			status = http.StatusServiceUnavailable
+		}
+		response.WriteHeaderAndEntity(status, setResponse)
+		return
+	}
+
+	setResponse.WorkerNode = *node
+	response.WriteEntity(setResponse)
+}
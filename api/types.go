@@ -27,8 +27,23 @@ const (
 	// TODO: Make this package versioned. Right now, this is only used for indetifying
 	// the endpoints, i.e. /api/v0.1/; we can't really do version control with it.
 	APIVersion string = "v0.1"
+
+	// APIVersionV1 is the version of API with the structured errors, it shares
+	// the endpoints with APIVersion under /api/v1/.
+	APIVersionV1 string = "v1"
 )
 
+// Error is the error responded by API v1 with a 4xx or 5xx status code.
+type Error struct {
+	// Code is the status text of the status code without spaces, e.g.
+	// NotFound, InternalServerError.
+	Code string `json:"code"`
+	// Message is the user-facing error message.
+	Message string `json:"message"`
+	// Details are the other fields in the response of the request, if any.
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
 // HealthCheckResponse is the response type for health check request.
 type HealthCheckResponse struct {
 	// Return the error message. This is used to provide user-facing errors.
@@ -76,6 +91,58 @@ type Service struct {
 	DeployPlans []DeployPlan `bson:"deploy_plans,omitempty" json:"deploy_plans,omitempty"`
 	// Repository information of the service.
 	YAMLConfigName string `bson:"yaml_config_name,omitempty" json:"yaml_config_name,omitempty"`
+	// NodeSelector is the labels the worker node must have to build the service.
+	NodeSelector map[string]string `bson:"node_selector,omitempty" json:"node_selector,omitempty"`
+	// Timeout is the max time in seconds to build the service, the default timeout
+	// of the server is used if not set.
+	Timeout int `bson:"timeout,omitempty" json:"timeout,omitempty"`
+	// RetryPolicy decides how the failed builds of the service are retried, the
+	// default policy of the server is used for the fields not set.
+	RetryPolicy *RetryPolicy `bson:"retry_policy,omitempty" json:"retry_policy,omitempty"`
+	// CacheGeneration is increased when the build cache of the service is purged,
+	// the cache of the previous generations is not used any more.
+	CacheGeneration int `bson:"cache_generation,omitempty" json:"cache_generation,omitempty"`
+	// Downstreams are the services built after a release version of the service
+	// is built and published successfully.
+	Downstreams []DownstreamTrigger `bson:"downstreams,omitempty" json:"downstreams,omitempty"`
+	// Approval holds the deploys of the versions until they are approved.
+	Approval ApprovalPolicy `bson:"approval,omitempty" json:"approval,omitempty"`
+	// Environments are the environments the versions are promoted through in
+	// order, e.g. dev, staging and prod. A version deploys to the first one.
+	Environments []Environment `bson:"environments,omitempty" json:"environments,omitempty"`
+}
+
+// Environment is a stage the versions of a service are deployed to.
+type Environment struct {
+	// Name of the environment, unique in the service
+	Name string `bson:"name,omitempty" json:"name,omitempty"`
+	// Deploy plans of the environment
+	DeployPlans []DeployPlan `bson:"deploy_plans,omitempty" json:"deploy_plans,omitempty"`
+	// Variables of the environment, substituted into the manifests deployed to it
+	// or set to the deployed containers as environment variables
+	Variables map[string]string `bson:"variables,omitempty" json:"variables,omitempty"`
+}
+
+// ApprovalPolicy is the policy to approve the deploys of the versions.
+type ApprovalPolicy struct {
+	// Required pauses the versions after publish, they deploy only if approved.
+	Required bool `bson:"required,omitempty" json:"required,omitempty"`
+	// Approvers are the IDs of the users who can approve or reject the deploys,
+	// only the owner of the service can if not set.
+	Approvers []string `bson:"approvers,omitempty" json:"approvers,omitempty"`
+	// Timeout is the time in seconds to wait for the approval, the default of the
+	// server is used if not set. The version is not deployed if it times out.
+	Timeout int `bson:"timeout,omitempty" json:"timeout,omitempty"`
+}
+
+// DownstreamTrigger triggers the build of a downstream service with the image
+// of the upstream version.
+type DownstreamTrigger struct {
+	// ServiceID is the ID of the downstream service, which belongs to the same user.
+	ServiceID string `bson:"service_id,omitempty" json:"service_id,omitempty"`
+	// Variable is the name of the build variable holding the upstream image with
+	// its tag, UPSTREAM_IMAGE if not set.
+	Variable string `bson:"variable,omitempty" json:"variable,omitempty"`
 }
 
 // DeployPlan is the type for deployment plan.
@@ -90,10 +157,21 @@ type DeployPlan struct {
 type DeployPlanStatus struct {
 	// Plan name
 	PlanName string `bson:"plan_name,omitempty" json:"plan_name,omitempty"`
+	// Environment of the plan if it belongs to one
+	Environment string `bson:"environment,omitempty" json:"environment,omitempty"`
 	// Deploy config
 	Config DeployConfig `bson:"config,omitempty" json:"config,omitempty"`
 	// Deploy status
 	Status VersionDeployStatus `bson:"status,omitempty" json:"status,omitempty"`
+	// Image deployed to the containers
+	Image string `bson:"image,omitempty" json:"image,omitempty"`
+	// Images of the containers before the deploy, they are restored when the
+	// deploy is rolled back.
+	PreviousImages map[string]string `bson:"previous_images,omitempty" json:"previous_images,omitempty"`
+	// Deployments made by the plan, the deploy of the version and the rollback if any.
+	Deployments []VersionDeployment `bson:"deployments,omitempty" json:"deployments,omitempty"`
+	// Progress of the deploy by its strategy, one sub status per phase.
+	SubStatuses []DeploySubStatus `bson:"sub_statuses,omitempty" json:"sub_statuses,omitempty"`
 }
 
 // DeployConfig is the type for deplyment config.
@@ -108,6 +186,96 @@ type DeployConfig struct {
 	Deployment string `bson:"deployment,omitempty" json:"deployment,omitempty"`
 	// container names
 	Containers []string `bson:"containers,omitempty" json:"containers,omitempty"`
+	// Cluster type, the deployment is updated by the kubernetes API directly
+	// if it is kubernetes, otherwise by the console web.
+	ClusterType string `bson:"cluster_type,omitempty" json:"cluster_type,omitempty"`
+	// Kubernetes API server address
+	ClusterHost string `bson:"cluster_host,omitempty" json:"cluster_host,omitempty"`
+	// Bearer token to access the kubernetes API server, it is stored encrypted,
+	// not returned by the API, and kept if a plan is updated without it
+	ClusterToken string `bson:"cluster_token,omitempty" json:"cluster_token,omitempty"`
+	// Strategy to deploy by the kubernetes API, rolling by default
+	Strategy DeployStrategy `bson:"strategy,omitempty" json:"strategy,omitempty"`
+	// Path of the kubernetes manifests in the repository, they are applied by
+	// the kubernetes API instead of updating the images of the deployment
+	Manifests string `bson:"manifests,omitempty" json:"manifests,omitempty"`
+}
+
+// ClusterTypeKubernetes is the type of the clusters deployed by the kubernetes API.
+const ClusterTypeKubernetes = "kubernetes"
+
+const (
+	// WorkerSecretsDir is the directory in the worker with the secret files
+	// put there by the worker backend.
+	WorkerSecretsDir = "/etc/cyclone/secrets"
+	// ClusterTokensFile is the file in the worker with the cluster tokens of the
+	// deploy plans decrypted by the server, in JSON mapping the stored tokens to
+	// the decrypted ones.
+	ClusterTokensFile = WorkerSecretsDir + "/cluster-tokens"
+)
+
+// DeployStrategyType is the type for the strategies to deploy.
+type DeployStrategyType string
+
+const (
+	// DeployStrategyRolling updates the images of the deployment by its rolling update.
+	DeployStrategyRolling DeployStrategyType = "rolling"
+	// DeployStrategyCanary rolls the images out to a canary deployment first, and
+	// promotes them to the deployment if the canary keeps healthy for the bake time.
+	DeployStrategyCanary DeployStrategyType = "canary"
+	// DeployStrategyBlueGreen rolls the images out to a new deployment, and switches
+	// the selector of the service to it.
+	DeployStrategyBlueGreen DeployStrategyType = "blue-green"
+)
+
+// DeployStrategy is the type for the strategy to deploy.
+type DeployStrategy struct {
+	// Strategy type, rolling, canary or blue-green
+	Type DeployStrategyType `bson:"type,omitempty" json:"type,omitempty"`
+	// Replicas of the canary deployment, 1 by default
+	Replicas int32 `bson:"replicas,omitempty" json:"replicas,omitempty"`
+	// Seconds the canary keeps healthy before it is promoted
+	BakeTime int `bson:"bake_time,omitempty" json:"bake_time,omitempty"`
+	// Service switched to the new deployment by blue-green
+	Service string `bson:"service,omitempty" json:"service,omitempty"`
+}
+
+// DeployPhase is the type for the phases of a deploy.
+type DeployPhase string
+
+const (
+	// DeployPhaseApply applies the manifests in the repository to the cluster.
+	DeployPhaseApply DeployPhase = "apply"
+	// DeployPhaseRollout rolls the images out to the deployment.
+	DeployPhaseRollout DeployPhase = "rollout"
+	// DeployPhaseCanary rolls the images out to the canary deployment.
+	DeployPhaseCanary DeployPhase = "canary"
+	// DeployPhaseBake watches the canary for the bake time.
+	DeployPhaseBake DeployPhase = "bake"
+	// DeployPhasePromote rolls the images of the canary out to the deployment.
+	DeployPhasePromote DeployPhase = "promote"
+	// DeployPhaseSwitch switches the service to the new deployment.
+	DeployPhaseSwitch DeployPhase = "switch"
+	// DeployPhaseAbort removes the canary or new deployment after a failure.
+	DeployPhaseAbort DeployPhase = "abort"
+	// DeployPhaseRollback restores the previous images of the deployment.
+	DeployPhaseRollback DeployPhase = "rollback"
+)
+
+// DeploySubStatus is the type for the status of a phase of a deploy.
+type DeploySubStatus struct {
+	// The kubernetes deployment the phase works on, empty for applying the manifests
+	Deployment string `bson:"deployment,omitempty" json:"deployment,omitempty"`
+	// Phase of the deploy
+	Phase DeployPhase `bson:"phase,omitempty" json:"phase,omitempty"`
+	// Status of the phase, pending, success or failed
+	Status VersionDeployStatus `bson:"status,omitempty" json:"status,omitempty"`
+	// Error message if the phase failed
+	ErrorMessage string `bson:"error_msg,omitempty" json:"error_msg,omitempty"`
+	// When the phase starts
+	StartTime time.Time `bson:"start_time,omitempty" json:"start_time,omitempty"`
+	// When the phase ends
+	EndTime time.Time `bson:"end_time,omitempty" json:"end_time,omitempty"`
 }
 
 // JenkinsConfig is the type for jenkins config.
@@ -199,6 +367,39 @@ type ServiceRepository struct {
 	Password string `bson:"password,omitempty" json:"password,omitempty"`
 	// Webhook type, such as "github" "bitbuckect"
 	Webhook string `bson:"webhook,omitempty" json:"webhook,omitempty"`
+	// CloneOptions is how the repository is cloned, only for git.
+	CloneOptions CloneOptions `bson:"clone_options,omitempty" json:"clone_options,omitempty"`
+	// DeployKey is the SSH key to clone the repository, only for git. The
+	// URL of the repository should be an SSH URL if it is set.
+	DeployKey DeployKey `bson:"deploy_key,omitempty" json:"deploy_key,omitempty"`
+}
+
+// DeployKey is the SSH key of a service to access its repository.
+type DeployKey struct {
+	// PublicKey is in the authorized_keys format.
+	PublicKey string `bson:"public_key,omitempty" json:"public_key,omitempty"`
+	// PrivateKey is encrypted, and is never returned by the API.
+	PrivateKey string `bson:"private_key,omitempty" json:"-"`
+	// KnownHosts are the trusted host keys of the repository host in the
+	// known_hosts format, the host keys are scanned on clone if empty.
+	KnownHosts string `bson:"known_hosts,omitempty" json:"known_hosts,omitempty"`
+	// RemoteID is the ID of the deploy key registered in GitHub or GitLab.
+	RemoteID int `bson:"remote_id,omitempty" json:"remote_id,omitempty"`
+}
+
+// CloneOptions is the options to clone a git repository.
+type CloneOptions struct {
+	// Depth is the number of commits to fetch, 0 means the full history.
+	Depth int `bson:"depth,omitempty" json:"depth,omitempty"`
+	// Submodules fetches the submodules recursively with the credentials of
+	// the repository.
+	Submodules bool `bson:"submodules,omitempty" json:"submodules,omitempty"`
+	// SparsePaths are the paths relative to the repository to check out, all
+	// the files are checked out if empty.
+	SparsePaths []string `bson:"sparse_paths,omitempty" json:"sparse_paths,omitempty"`
+	// LFS fetches the files tracked by Git LFS, git-lfs must be installed in
+	// the worker.
+	LFS bool `bson:"lfs,omitempty" json:"lfs,omitempty"`
 }
 
 // ServiceCreationResponse is the response type for service creation request.
@@ -218,6 +419,44 @@ type ServiceGetResponse struct {
 // ServiceListResponse is the response type for service list request.
 type ServiceListResponse struct {
 	Services []Service `json:"services,omitempty"`
+	// Continue is the token to list the next page, empty for the last page.
+	Continue string `json:"continue,omitempty"`
+	// Return the error message IFF not successful. This is used to provide user-facing errors.
+	ErrorMessage string `json:"error_msg,omitempty"`
+}
+
+// ServiceCacheDelResponse is the response type for purge service cache request.
+type ServiceCacheDelResponse struct {
+	// CacheGeneration is the new cache generation of the service.
+	CacheGeneration int `json:"cache_generation,omitempty"`
+	// Return the error message IFF not successful. This is used to provide user-facing errors.
+	ErrorMessage string `json:"error_msg,omitempty"`
+}
+
+// DeployKeySetRequest is the request type for set deploy key request.
+type DeployKeySetRequest struct {
+	// PrivateKey is the PEM encoded private key, a keypair is generated if
+	// empty.
+	PrivateKey string `json:"private_key,omitempty"`
+	// PublicKey is required if it can't be derived from the private key.
+	PublicKey string `json:"public_key,omitempty"`
+	// KnownHosts are the trusted host keys of the repository host.
+	KnownHosts string `json:"known_hosts,omitempty"`
+}
+
+// DeployKeySetResponse is the response type for set deploy key request.
+type DeployKeySetResponse struct {
+	// PublicKey is the public key to add to the repository.
+	PublicKey string `json:"public_key,omitempty"`
+	// Registered is true if the key is registered as a deploy key in GitHub
+	// or GitLab.
+	Registered bool `json:"registered,omitempty"`
+	// Return the error message IFF not successful. This is used to provide user-facing errors.
+	ErrorMessage string `json:"error_msg,omitempty"`
+}
+
+// DeployKeyDelResponse is the response type for delete deploy key request.
+type DeployKeyDelResponse struct {
 	// Return the error message IFF not successful. This is used to provide user-facing errors.
 	ErrorMessage string `json:"error_msg,omitempty"`
 }
@@ -311,9 +550,13 @@ type Version struct {
 	Name string `bson:"name,omitempty" json:"name,omitempty"`
 	// A short, human-readable description of the version.
 	Description string `bson:"description,omitempty" json:"description,omitempty"`
-	// A list of dependencies, reference to other versions.
+	// A list of dependencies, reference to other versions. For the version triggered
+	// by an upstream service, it is the upstream version.
 	// TODO: This is only used as a FYI. It's hard to manage composite application.
 	DependentVersionIDs []string `bson:"dependent_version_ids,omitempty" json:"dependent_version_ids,omitempty"`
+	// BuildVariables are passed to the steps in caicloud.yml as environment variables,
+	// and to the docker build as the build args declared in the Dockerfile.
+	BuildVariables map[string]string `bson:"build_variables,omitempty" json:"build_variables,omitempty"`
 	// Tags is a list of version tags. A version can have multiple tags, including
 	// system tags and custom tags. System tags is added by Cyclone and custom tags
 	// are added by a user. A version can have multiple tags, e.g. latest version
@@ -326,6 +569,13 @@ type Version struct {
 	LiveInfo []VersionLiveInfo `bson:"live_info,omitempty" json:"live_info,omitempty"`
 	// Commit of the version (also known as revision, etc).
 	Commit string `bson:"commit,omitempty" json:"commit,omitempty"`
+	// Branch of the commit, the build cache is shared by versions of the same branch.
+	// For pull requests, it is the branch to merge into.
+	Branch string `bson:"branch,omitempty" json:"branch,omitempty"`
+	// Trigger is the kind of the change creating the version by webhook.
+	Trigger VersionTrigger `bson:"trigger,omitempty" json:"trigger,omitempty"`
+	// ChangedFiles is the files changed by the commits of a push.
+	ChangedFiles []string `bson:"changed_files,omitempty" json:"changed_files,omitempty"`
 	// Time when the version is created.
 	CreateTime time.Time `bson:"create_time,omitempty" json:"create_time,omitempty"`
 	// Release version URL. This is used to find the release hosted on remote machine,
@@ -335,6 +585,8 @@ type Version struct {
 	Status VersionStatus `bson:"status,omitempty" json:"status,omitempty"`
 	// Yaml deploy status is the current status of the version's deployment information.
 	YamlDeployStatus VersionDeployStatus `bson:"yaml_deploy_status,omitempty" json:"yaml_deploy_status,omitempty"`
+	// Progress of the deploys by the yaml information, one sub status per phase.
+	YamlDeploySubStatuses []DeploySubStatus `bson:"yaml_deploy_sub_statuses,omitempty" json:"yaml_deploy_sub_statuses,omitempty"`
 	// Operation is the version's operation to execute.
 	Operation VersionOperation `bson:"operation,omitempty" json:"operation,omitempty"`
 	// Operator is the version's operator.
@@ -355,6 +607,74 @@ type Version struct {
 	SecurityInfo []Security `bson:"security_info,omitempty" json:"security_info,omitempty"`
 	// BuildResource resoure for building image
 	BuildResource BuildResource `bson:"build_resource,omitempty" json:"build_resource,omitempty"`
+	// NodeSelector is the labels the worker node must have to build the version,
+	// it overrides the same labels of the service's.
+	NodeSelector map[string]string `bson:"node_selector,omitempty" json:"node_selector,omitempty"`
+	// Timeout is the max time in seconds to build the version, it overrides the
+	// timeout of the service.
+	Timeout int `bson:"timeout,omitempty" json:"timeout,omitempty"`
+	// RetryPolicy decides how the failed build of the version is retried, it
+	// overrides the retry policy of the service.
+	RetryPolicy *RetryPolicy `bson:"retry_policy,omitempty" json:"retry_policy,omitempty"`
+	// Attempts is the history of the attempts to handle the events of the version,
+	// kept after the events finish.
+	Attempts []EventAttempt `bson:"attempts,omitempty" json:"attempts,omitempty"`
+	// MatrixStatuses is the integration status of each cell in the build matrix.
+	MatrixStatuses []MatrixCellStatus `bson:"matrix_statuses,omitempty" json:"matrix_statuses,omitempty"`
+	// Approval is the approval of the deploy if the service requires it.
+	Approval *VersionApproval `bson:"approval,omitempty" json:"approval,omitempty"`
+	// Environment is the environment of the service the version is promoted to
+	// last, its deploy plans are the ones deployed.
+	Environment string `bson:"environment,omitempty" json:"environment,omitempty"`
+}
+
+// ApprovalStatus is the type for the status of a deploy approval.
+type ApprovalStatus string
+
+const (
+	// ApprovalPending shows that the deploy waits for the approval.
+	ApprovalPending ApprovalStatus = "pending"
+	// ApprovalApproved shows that the deploy is approved and runs.
+	ApprovalApproved ApprovalStatus = "approved"
+	// ApprovalRejected shows that the deploy is rejected.
+	ApprovalRejected ApprovalStatus = "rejected"
+	// ApprovalExpired shows that the deploy is not approved in time.
+	ApprovalExpired ApprovalStatus = "expired"
+	// ApprovalCancelled shows that the version fails or is cancelled before it
+	// waits for the approval.
+	ApprovalCancelled ApprovalStatus = "cancelled"
+)
+
+// VersionApproval is the approval of the deploy of a version.
+type VersionApproval struct {
+	// Status of the approval
+	Status ApprovalStatus `bson:"status,omitempty" json:"status,omitempty"`
+	// Names of the deploy plans waiting for the approval
+	PlanNames []string `bson:"plan_names,omitempty" json:"plan_names,omitempty"`
+	// Environment the version is promoted from, if the approval is for a promotion
+	PromotedFrom string `bson:"promoted_from,omitempty" json:"promoted_from,omitempty"`
+	// When the version starts waiting for the approval
+	RequestTime time.Time `bson:"request_time,omitempty" json:"request_time,omitempty"`
+	// When the approval times out
+	Deadline time.Time `bson:"deadline,omitempty" json:"deadline,omitempty"`
+	// ID of the user who approved or rejected the deploy
+	Approver string `bson:"approver,omitempty" json:"approver,omitempty"`
+	// Comment of the approver
+	Comment string `bson:"comment,omitempty" json:"comment,omitempty"`
+	// When the deploy is approved, rejected or expired
+	DecisionTime time.Time `bson:"decision_time,omitempty" json:"decision_time,omitempty"`
+}
+
+// MatrixCellStatus is the integration status of a cell in the build matrix.
+type MatrixCellStatus struct {
+	// Name identifies the cell, e.g. GO_VERSION=1.7 DB=mysql.
+	Name string `bson:"name,omitempty" json:"name,omitempty"`
+	// Environment is the environment variables of the cell.
+	Environment []string `bson:"environment,omitempty" json:"environment,omitempty"`
+	// Status is healthy if the integration of the cell passes, otherwise failed.
+	Status VersionStatus `bson:"status,omitempty" json:"status,omitempty"`
+	// ErrorMessage is why the integration of the cell fails.
+	ErrorMessage string `bson:"error_message,omitempty" json:"error_message,omitempty"`
 }
 
 // BuildResource is config of resource for building image
@@ -415,6 +735,9 @@ const (
 	VersionPending VersionStatus = "pending"
 	VersionCancel  VersionStatus = "cancelled"
 	VersionRunning VersionStatus = "running"
+	// VersionAwaitingApproval is the version published and waiting for the
+	// approval to deploy.
+	VersionAwaitingApproval VersionStatus = "awaiting_approval"
 )
 
 // CIStatus defines the status of a ci
@@ -422,6 +745,7 @@ const (
 	CISuccess string = "success"
 	CIFailure string = "failure"
 	CIPending string = "pending"
+	CIError   string = "error"
 )
 
 // VersionDeployStatus is the type for version status.
@@ -436,6 +760,13 @@ const (
 	DeploySuccess VersionDeployStatus = "success"
 	// DeployFailed shows that the version's deployment is failed.
 	DeployFailed VersionDeployStatus = "failed"
+	// DeployCancel shows that the version's deployment is cancelled before finished.
+	DeployCancel VersionDeployStatus = "cancelled"
+	// DeployRolledBack shows that the version's deployment is rolled back to the
+	// previous images, after it failed or by request.
+	DeployRolledBack VersionDeployStatus = "rolledback"
+	// DeployAwaitingApproval shows that the version's deployment waits for the approval.
+	DeployAwaitingApproval VersionDeployStatus = "awaiting_approval"
 )
 
 // VersionOperation defines the operations of a version
@@ -451,6 +782,18 @@ const (
 	DeployOperation VersionOperation = "deploy"
 )
 
+// VersionTrigger defines the kind of the change creating a version.
+type VersionTrigger string
+
+const (
+	// TriggerPush is a push to a branch.
+	TriggerPush VersionTrigger = "push"
+	// TriggerPullRequest is a pull request, or a merge request of GitLab.
+	TriggerPullRequest VersionTrigger = "pull_request"
+	// TriggerTag is a release tag.
+	TriggerTag VersionTrigger = "tag"
+)
+
 // VersionOperator defines the operator of a version
 type VersionOperator string
 
@@ -459,6 +802,8 @@ const (
 	WebhookOperator VersionOperator = "webhook"
 	// APIOperator is api operator.
 	APIOperator VersionOperator = "api"
+	// UpstreamOperator is the operator of the versions triggered by upstream services.
+	UpstreamOperator VersionOperator = "upstream"
 )
 
 // AutoCreateTagFlag is the default tag postfix.
@@ -466,6 +811,8 @@ const AutoCreateTagFlag = "_Cyclone"
 
 // VersionLiveInfo contains information about how a version is deployed.
 type VersionLiveInfo struct {
+	// Which environment of the service was the version deployed.
+	Environment string `bson:"environment,omitempty" json:"environment,omitempty"`
 	// Which cluster and project was the version deployed.
 	Cluster string `bson:"cluster,omitempty" json:"cluster,omitempty"`
 	Project string `bson:"project,omitempty" json:"project,omitempty"`
@@ -503,12 +850,14 @@ const (
 
 // VersionDeployment is the type for deployment.
 type VersionDeployment struct {
-	// The version to deploy.
-	VersionID string
+	// The version to deploy, or to roll back from.
+	VersionID string `bson:"version_id,omitempty" json:"version_id,omitempty"`
 	// Kind of the deployment, e.g. deploy a new version, rolling upgrade from an old version.
-	DeploymentKind DeploymentKind
+	DeploymentKind DeploymentKind `bson:"kind,omitempty" json:"kind,omitempty"`
 	// Information needed to deploy the version.
-	VersionLiveInfo VersionLiveInfo
+	VersionLiveInfo VersionLiveInfo `bson:"live_info,omitempty" json:"live_info,omitempty"`
+	// Why the deployment is made, e.g. the rollout check failed.
+	Reason string `bson:"reason,omitempty" json:"reason,omitempty"`
 }
 
 // VersionCreationResponse is the response type for version creation request.
@@ -543,6 +892,8 @@ type VersionLogGetResponse struct {
 // VersionListResponse is the response type for service list request.
 type VersionListResponse struct {
 	Versions []Version `json:"versions,omitempty"`
+	// Continue is the token to list the next page, empty for the last page.
+	Continue string `json:"continue,omitempty"`
 	// Return the error message IFF not successful. This is used to provide user-facing errors.
 	ErrorMessage string `json:"error_msg,omitempty"`
 }
@@ -566,6 +917,36 @@ type VersionConcelResponse struct {
 	ErrorMessage string `json:"error_msg,omitempty"`
 }
 
+// VersionRollbackResponse is the response type for version rollback request.
+type VersionRollbackResponse struct {
+	// Names of the deploy plans rolled back.
+	PlanNames []string `json:"plan_names,omitempty"`
+	// Return the error message IFF not successful. This is used to provide user-facing errors.
+	ErrorMessage string `json:"error_msg,omitempty"`
+}
+
+// VersionPromotionResponse is the response type for version promotion request.
+type VersionPromotionResponse struct {
+	// Environment the version is promoted to.
+	Environment string `json:"environment,omitempty"`
+	// Return the error message IFF not successful. This is used to provide user-facing errors.
+	ErrorMessage string `json:"error_msg,omitempty"`
+}
+
+// VersionApprovalRequest is the request type for approving or rejecting the deploy of a version.
+type VersionApprovalRequest struct {
+	// Comment of the approver.
+	Comment string `json:"comment,omitempty"`
+}
+
+// VersionApprovalResponse is the response type for version approval request.
+type VersionApprovalResponse struct {
+	// Status of the approval after the request.
+	Status ApprovalStatus `json:"status,omitempty"`
+	// Return the error message IFF not successful. This is used to provide user-facing errors.
+	ErrorMessage string `json:"error_msg,omitempty"`
+}
+
 // SMTPServerConfig deifnes the config of SMTP Server.
 type SMTPServerConfig struct {
 	SMTPServer   string
@@ -642,6 +1023,29 @@ const (
 	SVNWebhookCommit string = "commit"
 )
 
+// WebhookHg contains datas send from mercurial webhook, e.g. by the
+// changegroup or txnclose hook of the repository.
+type WebhookHg struct {
+	// mercurial url, ie. https://hg.example.com/sample_repo
+	URL string `json:"url,omitempty"`
+	// event type of webhook, commit or tag
+	Event string `json:"event,omitempty"`
+	// id of commit, the changeset hash
+	CommitID string `json:"commit_id,omitempty"`
+	// branch of the commit
+	Branch string `json:"branch,omitempty"`
+	// name of the tag, only for tag event
+	Tag string `json:"tag,omitempty"`
+	// description of the commit
+	Description string `json:"description,omitempty"`
+}
+
+// Mercurial webhook event type
+const (
+	HgWebhookCommit string = "commit"
+	HgWebhookTag    string = "tag"
+)
+
 // WebhookGitlab is type for gitlab webhook request.
 type WebhookGitlab map[string]interface{}
 
@@ -818,6 +1222,63 @@ type Event struct {
 	Status EventStatus `bson:"status,omitempty" json:"status,omitempty"`
 	// In case of error, ErrorMessage holds the messge for end user.
 	ErrorMessage string `bson:"error_msg,omitempty" json:"error_msg,omitempty"`
+	// ErrorClass classifies the error, to decide whether to retry the event.
+	ErrorClass ErrorClass `bson:"error_class,omitempty" json:"error_class,omitempty"`
+	// RetryPolicy decides how the failed event is retried, the default policy
+	// of the server is used if not set.
+	RetryPolicy *RetryPolicy `bson:"retry_policy,omitempty" json:"retry_policy,omitempty"`
+	// Attempts is the history of the finished attempts to handle the event.
+	Attempts []EventAttempt `bson:"attempts,omitempty" json:"attempts,omitempty"`
+	// RetryTime is the time before which the retried event is not handled.
+	RetryTime time.Time `bson:"retry_time,omitempty" json:"retry_time,omitempty"`
+}
+
+// ErrorClass is the class of the errors failing events.
+type ErrorClass string
+
+// error class type
+const (
+	// ErrorClassInfrastructure is the error when the worker can not be started or is lost.
+	ErrorClassInfrastructure ErrorClass = "infrastructure"
+	// ErrorClassClone is the error when the code repository can not be cloned,
+	// like network errors.
+	ErrorClassClone ErrorClass = "clone"
+	// ErrorClassRepository is the error when the code repository does not exist
+	// or the credentials are refused, cloning again does not help.
+	ErrorClassRepository ErrorClass = "repository"
+	// ErrorClassPush is the error when the image can not be pushed to registry.
+	ErrorClassPush ErrorClass = "push"
+	// ErrorClassTimeout is the error when the worker runs out of time.
+	ErrorClassTimeout ErrorClass = "timeout"
+	// ErrorClassBuild is the error of the user build, like compiling or testing failures.
+	ErrorClassBuild ErrorClass = "build"
+)
+
+// RetryPolicy is the policy to retry failed events.
+type RetryPolicy struct {
+	// MaxAttempts is the max times to handle the event, including the first one.
+	MaxAttempts int `bson:"max_attempts,omitempty" json:"max_attempts,omitempty"`
+	// Backoff is the delay in seconds before the first retry, it doubles for
+	// each following retry.
+	Backoff int `bson:"backoff,omitempty" json:"backoff,omitempty"`
+	// MaxBackoff is the max delay in seconds before a retry.
+	MaxBackoff int `bson:"max_backoff,omitempty" json:"max_backoff,omitempty"`
+	// RetryOn is the classes of errors to retry.
+	RetryOn []ErrorClass `bson:"retry_on,omitempty" json:"retry_on,omitempty"`
+}
+
+// EventAttempt is a finished attempt to handle a event.
+type EventAttempt struct {
+	// Attempt is the sequence of the attempt, starts from 1.
+	Attempt    int       `bson:"attempt,omitempty" json:"attempt,omitempty"`
+	StartTime  time.Time `bson:"start_time,omitempty" json:"start_time,omitempty"`
+	EndTime    time.Time `bson:"end_time,omitempty" json:"end_time,omitempty"`
+	DockerHost string    `bson:"docker_host,omitempty" json:"docker_host,omitempty"`
+	// ID of the worker, the container id or pod name.
+	WorkerID     string      `bson:"worker_id,omitempty" json:"worker_id,omitempty"`
+	Status       EventStatus `bson:"status,omitempty" json:"status,omitempty"`
+	ErrorClass   ErrorClass  `bson:"error_class,omitempty" json:"error_class,omitempty"`
+	ErrorMessage string      `bson:"error_msg,omitempty" json:"error_msg,omitempty"`
 }
 
 // EventStatus contains the status of an event.
@@ -852,16 +1313,70 @@ type SetEventResponse struct {
 	ErrorMessage string `json:"error_msg,omitempty"`
 }
 
+// QueuedEvent is the scheduling information of a pending event in the queue.
+type QueuedEvent struct {
+	// ID of the event, uniquely identifies the event.
+	EventID EventID `bson:"event_id,omitempty" json:"event_id,omitempty"`
+	// The user who owns the event, events are shared fairly among users.
+	UserID string `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	// Name of the service the event belongs to.
+	ServiceName string `bson:"service_name,omitempty" json:"service_name,omitempty"`
+	// Name of the version the event belongs to.
+	VersionName string `bson:"version_name,omitempty" json:"version_name,omitempty"`
+	// Priority of the event, the event with higher priority is handled earlier.
+	Priority int `bson:"priority" json:"priority"`
+	// Sequence of the event, the smaller one is handled earlier in the same priority.
+	Sequence int64 `bson:"sequence" json:"sequence"`
+	// EnqueueTime is the time when the event is put into the queue.
+	EnqueueTime time.Time `bson:"enqueue_time,omitempty" json:"enqueue_time,omitempty"`
+}
+
+// QueuedEventListResponse is the response type for list queued events request.
+type QueuedEventListResponse struct {
+	// Events are listed in the order they will be handled.
+	Events []QueuedEvent `json:"events,omitempty"`
+	// Return the error message IFF not successful. This is used to provide user-facing errors.
+	ErrorMessage string `json:"error_msg,omitempty"`
+}
+
+// QueuedEventSetting is the request body for reorder queued event request.
+type QueuedEventSetting struct {
+	// Priority is the new priority of the event, keep unchanged if not set.
+	Priority *int `json:"priority,omitempty"`
+	// Top moves the event ahead of all the other queued events.
+	Top bool `json:"top,omitempty"`
+}
+
+// QueuedEventSetResponse is the response type for reorder queued event request.
+type QueuedEventSetResponse struct {
+	Event QueuedEvent `json:"event,omitempty"`
+	// Return the error message IFF not successful. This is used to provide user-facing errors.
+	ErrorMessage string `json:"error_msg,omitempty"`
+}
+
+// QueuedEventDelResponse is the response type for remove queued event request.
+type QueuedEventDelResponse struct {
+	Result string `json:"result,omitempty"`
+	// Return the error message IFF not successful. This is used to provide user-facing errors.
+	ErrorMessage string `json:"error_msg,omitempty"`
+}
+
 // WorkerInfo save the woker info
 type WorkerInfo struct {
+	// Backend is the backend running the worker, docker or kubernetes.
+	Backend string `json:"backend,omitempty"`
 	// worker docker host
 	DockerHost string `json:"docker_host,omitempty"`
 	// ID of the container, uniquely identifies the container.
 	ContainerID string `json:"container_id,omitempty"`
+	// StartTime is the time when the worker starts.
+	StartTime time.Time `json:"start_time,omitempty"`
 	// DueTime due time of the event
 	DueTime time.Time `json:"due_time,omitempty"`
 	// The resource need to used by this event
 	UsedResource BuildResource `json:"used_resource,omitempty"`
+	// Requeues is the times the event is requeued because its worker node is unreachable.
+	Requeues int `json:"requeues,omitempty"`
 }
 
 // Resource is the management for user
@@ -912,8 +1427,25 @@ type WorkerNode struct {
 	TotalResource NodeResource `bson:"total_resource,omitempty" json:"total_resource,omitempty"`
 	// Left resouce of the worker node
 	LeftResource NodeResource `bson:"left_resource,omitempty" json:"left_resource,omitempty"`
+	// Labels of the worker node, such as arch=arm64, ssd=true.
+	Labels map[string]string `bson:"labels,omitempty" json:"labels,omitempty"`
+	// Status of the worker node, probed by the leader periodically.
+	Status WorkerNodeStatus `bson:"status,omitempty" json:"status,omitempty"`
+	// LastSeen is the last time the docker daemon of the node responded.
+	LastSeen time.Time `bson:"last_seen,omitempty" json:"last_seen,omitempty"`
+	// Unschedulable is true if the node is cordoned, no more workers are placed on it.
+	Unschedulable bool `bson:"unschedulable,omitempty" json:"unschedulable,omitempty"`
 }
 
+// WorkerNodeStatus is the type for node's status.
+type WorkerNodeStatus string
+
+// Worker Node Status
+const (
+	WorkerNodeReady       WorkerNodeStatus = "ready"
+	WorkerNodeUnreachable WorkerNodeStatus = "unreachable"
+)
+
 // WorkerNodeType is the type for node's type, such as "system".
 type WorkerNodeType string
 
@@ -952,6 +1484,13 @@ type WorkerNodesListResponse struct {
 	ErrorMessage string `json:"error_msg,omitempty"`
 }
 
+// WorkerNodeSetResponse is the response type for cordon, drain and uncordon worker node request.
+type WorkerNodeSetResponse struct {
+	WorkerNode WorkerNode `json:"worker_node,omitempty"`
+	// Return the error message IFF not successful. This is used to provide user-facing errors.
+	ErrorMessage string `json:"error_msg,omitempty"`
+}
+
 // WorkerNodeDelResponse is the response type for delete worker node request.
 type WorkerNodeDelResponse struct {
 	Result string `json:"result,omitempty"`
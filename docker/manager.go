@@ -17,9 +17,13 @@ limitations under the License.
 package docker
 
 import (
+	"archive/tar"
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/caicloud/cyclone/api"
 	"github.com/caicloud/cyclone/pkg/filebuffer"
@@ -28,6 +32,7 @@ import (
 	"github.com/docker/docker/builder/dockerfile/command"
 	docker_parse "github.com/docker/docker/builder/dockerfile/parser"
 	docker_client "github.com/fsouza/go-dockerclient"
+	"golang.org/x/net/context"
 )
 
 // Manager manages all docker operations, like build, push, etc.
@@ -135,6 +140,7 @@ func (dm *Manager) BuildImage(event *api.Event, output filebuffer.FileBuffer) er
 		RmTmpContainer: true,
 		Memswap:        -1,
 		OutputStream:   output,
+		BuildArgs:      BuildArgs(event.Version.BuildVariables, contextDir+"/Dockerfile"),
 	}
 	err := dm.Client.BuildImage(opt)
 	if err == nil {
@@ -175,6 +181,14 @@ func (dm *Manager) PushImage(event *api.Event, output filebuffer.FileBuffer) err
 
 // RunContainer runs a container according to special config
 func (dm *Manager) RunContainer(cco *docker_client.CreateContainerOptions) (string, error) {
+	return dm.RunContainerWithFiles(cco, nil)
+}
+
+// RunContainerWithFiles runs a container like RunContainer, the files are
+// written into the container before it starts, keyed by their absolute paths.
+// They are readable only by the owner, so that secrets can be passed by files
+// instead of envs shown by docker inspect.
+func (dm *Manager) RunContainerWithFiles(cco *docker_client.CreateContainerOptions, files map[string][]byte) (string, error) {
 	isImageExisted, err := dm.IsImagePresent(cco.Config.Image)
 	if err != nil {
 		return "", err
@@ -196,6 +210,20 @@ func (dm *Manager) RunContainer(cco *docker_client.CreateContainerOptions) (stri
 		return "", err
 	}
 
+	if len(files) > 0 {
+		archive, err := tarFiles(files)
+		if err == nil {
+			err = client.UploadToContainer(container.ID, docker_client.UploadToContainerOptions{
+				InputStream: archive,
+				Path:        "/",
+			})
+		}
+		if err != nil {
+			dm.RemoveContainer(container.ID)
+			return "", err
+		}
+	}
+
 	err = client.StartContainer(container.ID, cco.HostConfig)
 	if err != nil {
 		client.RemoveContainer(docker_client.RemoveContainerOptions{
@@ -208,6 +236,31 @@ func (dm *Manager) RunContainer(cco *docker_client.CreateContainerOptions) (stri
 	return container.ID, nil
 }
 
+// tarFiles archives the files with the mode 0600, the leading slash of their
+// paths is removed.
+func tarFiles(files map[string][]byte) (io.Reader, error) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for path, content := range files {
+		header := &tar.Header{
+			Name:    strings.TrimPrefix(path, "/"),
+			Mode:    0600,
+			Size:    int64(len(content)),
+			ModTime: time.Now(),
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
 // StopContainer stops a container by given ID.
 func (dm *Manager) StopContainer(ID string) error {
 	return dm.Client.StopContainer(ID, 0)
@@ -230,6 +283,31 @@ func (dm *Manager) StopAndRemoveContainer(ID string) error {
 	return dm.RemoveContainer(ID)
 }
 
+// ListContainersByLabel lists the running containers with the given label, and
+// inspects them to get the details.
+func (dm *Manager) ListContainersByLabel(label string) ([]*docker_client.Container, error) {
+	opts := docker_client.ListContainersOptions{
+		Filters: map[string][]string{"label": {label}},
+	}
+	apiContainers, err := dm.Client.ListContainers(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	containers := make([]*docker_client.Container, 0, len(apiContainers))
+	for _, apiContainer := range apiContainers {
+		container, err := dm.Client.InspectContainer(apiContainer.ID)
+		if err != nil {
+			if _, ok := err.(*docker_client.NoSuchContainer); ok {
+				continue
+			}
+			return nil, err
+		}
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+
 // GetAuthOpts gets Auth options.
 func (dm *Manager) GetAuthOpts() (authOpts docker_client.AuthConfigurations) {
 	authOpt := docker_client.AuthConfiguration{
@@ -246,9 +324,10 @@ func (dm *Manager) GetAuthOpts() (authOpts docker_client.AuthConfigurations) {
 }
 
 // BuildImageSpecifyDockerfile builds docker image with params from event with
-// specify Dockerfile. Build output will be sent to event status output.
-func (dm *Manager) BuildImageSpecifyDockerfile(event *api.Event,
-	dockerfilePath string, dockerfileName string, output filebuffer.FileBuffer) error {
+// specify Dockerfile. Build output will be sent to event status output. The
+// build is cancelled if it runs longer than the timeout, 0 means no limit.
+func (dm *Manager) BuildImageSpecifyDockerfile(event *api.Event, dockerfilePath string,
+	dockerfileName string, output filebuffer.FileBuffer, timeout time.Duration) error {
 	imagename, ok := event.Data["image-name"]
 	tagname, ok2 := event.Data["tag-name"]
 	contextdir, ok3 := event.Data["context-dir"]
@@ -287,6 +366,12 @@ func (dm *Manager) BuildImageSpecifyDockerfile(event *api.Event,
 		AuthConfigs:    authOpts,
 		RmTmpContainer: true,
 		Memswap:        -1,
+		BuildArgs:      BuildArgs(event.Version.BuildVariables, contextDir+"/"+dockerfileName),
+	}
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		opt.Context = ctx
 	}
 	steplog.InsertStepLog(event, steplog.BuildImage, steplog.Start, nil)
 	err := dm.Client.BuildImage(opt)
@@ -355,6 +440,38 @@ func parse(despath string) ([]string, error) {
 	return str, nil
 }
 
+// BuildArgs returns the build variables declared by ARG instructions in the
+// Dockerfile as build args, the others are left out since docker refuses to
+// build with unconsumed build args.
+func BuildArgs(variables map[string]string, dockerfile string) []docker_client.BuildArg {
+	if len(variables) == 0 {
+		return nil
+	}
+	f, err := os.Open(dockerfile)
+	if err != nil {
+		log.ErrorWithFields("open dockerfile fail", log.Fields{"error": err})
+		return nil
+	}
+	defer f.Close()
+
+	nodes, err := docker_parse.Parse(f)
+	if err != nil {
+		log.ErrorWithFields("parse dockerfile fail", log.Fields{"error": err})
+		return nil
+	}
+	var args []docker_client.BuildArg
+	for _, node := range nodes.Children {
+		if node.Value != command.Arg || node.Next == nil {
+			continue
+		}
+		name := strings.SplitN(node.Next.Value, "=", 2)[0]
+		if value, ok := variables[name]; ok {
+			args = append(args, docker_client.BuildArg{Name: name, Value: value})
+		}
+	}
+	return args
+}
+
 // IsImagePresent checks if given image exists.
 func (dm *Manager) IsImagePresent(image string) (bool, error) {
 	_, err := dm.Client.InspectImage(image)
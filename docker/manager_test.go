@@ -0,0 +1,87 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package docker
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	docker_client "github.com/fsouza/go-dockerclient"
+)
+
+// TestBuildArgs tests only the variables declared by ARG are passed to the build.
+func TestBuildArgs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dockerfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dockerfile := filepath.Join(dir, "Dockerfile")
+	content := "ARG BASE_IMAGE=busybox\nFROM ${BASE_IMAGE}\narg VERSION\nENV UPSTREAM_IMAGE=none\n"
+	if err := ioutil.WriteFile(dockerfile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	variables := map[string]string{
+		"BASE_IMAGE":     "cargo.caicloud.io/alice/base:v1",
+		"VERSION":        "v1",
+		"UPSTREAM_IMAGE": "cargo.caicloud.io/alice/base:v1",
+	}
+	expected := []docker_client.BuildArg{
+		{Name: "BASE_IMAGE", Value: "cargo.caicloud.io/alice/base:v1"},
+		{Name: "VERSION", Value: "v1"},
+	}
+	if args := BuildArgs(variables, dockerfile); !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expect build args %v, got %v", expected, args)
+	}
+
+	if args := BuildArgs(variables, filepath.Join(dir, "Missing")); args != nil {
+		t.Errorf("Expect no build args without Dockerfile, got %v", args)
+	}
+	if args := BuildArgs(nil, dockerfile); args != nil {
+		t.Errorf("Expect no build args without variables, got %v", args)
+	}
+}
+
+// TestTarFiles tests the files written into containers are archived with
+// relative paths, and readable only by the owner.
+func TestTarFiles(t *testing.T) {
+	archive, err := tarFiles(map[string][]byte{"/etc/cyclone/id_deploy": []byte("private key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(archive)
+	header, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Name != "etc/cyclone/id_deploy" || header.Mode != 0600 {
+		t.Errorf("Expect etc/cyclone/id_deploy with mode 0600, got %s, %o", header.Name, header.Mode)
+	}
+	if content, err := ioutil.ReadAll(tr); err != nil || string(content) != "private key" {
+		t.Errorf("Expect the content of the file, got %s, %v", content, err)
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("Expect only one file, got %v", err)
+	}
+}
@@ -88,6 +88,28 @@ func (ec *Client) Set(key, value string) error {
 	return err
 }
 
+// CreateWithTTL creates a key with ttl in etcd server, fails if the key exists.
+func (ec *Client) CreateWithTTL(key, value string, ttl time.Duration) error {
+	kapi := client.NewKeysAPI(ec.client)
+	_, err := kapi.Set(ctx, key, value, &client.SetOptions{PrevExist: client.PrevNoExist, TTL: ttl})
+	return err
+}
+
+// CompareAndSwapWithTTL sets value and ttl to key in etcd server IFF the
+// current value of the key equals to prevValue.
+func (ec *Client) CompareAndSwapWithTTL(key, value, prevValue string, ttl time.Duration) error {
+	kapi := client.NewKeysAPI(ec.client)
+	_, err := kapi.Set(ctx, key, value, &client.SetOptions{PrevValue: prevValue, TTL: ttl})
+	return err
+}
+
+// CompareAndDelete deletes a key IFF the current value of the key equals to prevValue.
+func (ec *Client) CompareAndDelete(key, prevValue string) error {
+	kapi := client.NewKeysAPI(ec.client)
+	_, err := kapi.Delete(ctx, key, &client.DeleteOptions{PrevValue: prevValue})
+	return err
+}
+
 // Get gets value from key in etcd server.
 func (ec *Client) Get(key string) (value string, err error) {
 	kapi := client.NewKeysAPI(ec.client)
@@ -127,6 +149,21 @@ func (ec *Client) List(dir string) ([]string, error) {
 	return values, nil
 }
 
+// ListNodes lists the keys in a dir with their values.
+func (ec *Client) ListNodes(dir string) (map[string]string, error) {
+	nodes := make(map[string]string)
+	kapi := client.NewKeysAPI(ec.client)
+	resp, err := kapi.Get(ctx, dir, nil)
+	if err != nil {
+		return nodes, err
+	}
+
+	for _, node := range resp.Node.Nodes {
+		nodes[node.Key] = node.Value
+	}
+	return nodes, nil
+}
+
 // CreateWatcher creates a watcher to watch a dir.
 func (ec *Client) CreateWatcher(dir string) (client.Watcher, error) {
 	kapi := client.NewKeysAPI(ec.client)
@@ -0,0 +1,271 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/pkg/log"
+	"github.com/caicloud/cyclone/pkg/osutil"
+	"github.com/caicloud/cyclone/store"
+	"golang.org/x/net/context"
+	"gopkg.in/mgo.v2"
+)
+
+const (
+	// APPROVAL_TIMEOUT is the default time in seconds to wait for the approval
+	// of a deploy, if the service does not set it.
+	APPROVAL_TIMEOUT = "APPROVAL_TIMEOUT"
+
+	// APPROVAL_CHECK_INTERVAL is the interval in seconds to expire the approvals
+	// timed out.
+	APPROVAL_CHECK_INTERVAL = "APPROVAL_CHECK_INTERVAL"
+)
+
+var (
+	// ErrNotApprover is the error when the user can not approve the deploys of the service.
+	ErrNotApprover = errors.New("user is not an approver of the service")
+	// ErrNotAwaitingApproval is the error when the version is not waiting for the approval.
+	ErrNotAwaitingApproval = errors.New("version is not awaiting approval")
+	// ErrApprovalExpired is the error when the approval of the version has timed out.
+	ErrApprovalExpired = errors.New("approval of the version has expired")
+)
+
+// HoldDeploy holds the deploy of the version for the approval if the service
+// requires it. The version publishes the image without deploying, and waits for
+// the approval after that.
+func HoldDeploy(service *api.Service, version *api.Version) {
+	operation := string(version.Operation)
+	if !service.Approval.Required || !strings.Contains(operation, string(api.PublishOperation)) ||
+		!strings.Contains(operation, string(api.DeployOperation)) {
+		return
+	}
+
+	version.Operation = api.VersionOperation(strings.Replace(operation, string(api.DeployOperation), "", 1))
+	planNames := []string{}
+	for _, plan := range version.DeployPlansStatuses {
+		planNames = append(planNames, plan.PlanName)
+	}
+	version.Approval = &api.VersionApproval{
+		Status:    api.ApprovalPending,
+		PlanNames: planNames,
+	}
+}
+
+// holdPromotion holds the deploy of the version promoted from the environment
+// for the approval of the plans of the next environment.
+func holdPromotion(version *api.Version, from string) {
+	planNames := []string{}
+	for _, plan := range version.DeployPlansStatuses {
+		if plan.Environment == version.Environment {
+			planNames = append(planNames, plan.PlanName)
+		}
+	}
+	version.Approval = &api.VersionApproval{
+		Status:       api.ApprovalPending,
+		PlanNames:    planNames,
+		PromotedFrom: from,
+	}
+}
+
+// CanApprove returns whether the user can approve or reject the deploys of the
+// service, the owner can if the approvers are not set.
+func CanApprove(service *api.Service, userID string) bool {
+	if len(service.Approval.Approvers) == 0 {
+		return userID == service.UserID
+	}
+	for _, approver := range service.Approval.Approvers {
+		if approver == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// ApproveVersion approves the deploy of the version, which starts deploying by
+// a event of the deploy operation.
+func ApproveVersion(service *api.Service, versionID, userID, comment string) (*api.Version, error) {
+	return decideVersion(service, versionID, api.ApprovalApproved, userID, comment)
+}
+
+// RejectVersion rejects the deploy of the version, which is never deployed.
+func RejectVersion(service *api.Service, versionID, userID, comment string) (*api.Version, error) {
+	return decideVersion(service, versionID, api.ApprovalRejected, userID, comment)
+}
+
+// decideVersion records the decision of the user on the deploy of the version,
+// the version is updated only if it is still waiting for the approval.
+func decideVersion(service *api.Service, versionID string, status api.ApprovalStatus,
+	userID, comment string) (*api.Version, error) {
+	if !CanApprove(service, userID) {
+		return nil, ErrNotApprover
+	}
+
+	ds := store.NewStore()
+	defer ds.Close()
+
+	version, err := ds.FindVersionByID(versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = decide(version, status, userID, comment, time.Now())
+	if err == ErrApprovalExpired {
+		expireVersion(ds, version)
+		return version, err
+	}
+	if err != nil {
+		return version, err
+	}
+
+	if err := ds.CompareAndSetVersion(versionID, api.VersionAwaitingApproval, *version); err != nil {
+		if err == mgo.ErrNotFound {
+			err = ErrNotAwaitingApproval
+		}
+		return version, err
+	}
+	log.InfoWithFields("Deploy of version is decided", log.Fields{"version_id": versionID, "status": status, "user_id": userID})
+
+	if status == api.ApprovalApproved {
+		if err := SendCreateVersionEvent(service, version); err != nil {
+			return version, err
+		}
+	}
+	return version, nil
+}
+
+// awaitApproval makes the built version wait for the approval of its deploy
+// until the timeout of the service.
+func awaitApproval(service *api.Service, version *api.Version, now time.Time) {
+	timeout := service.Approval.Timeout
+	if timeout <= 0 {
+		timeout = osutil.GetIntEnv(APPROVAL_TIMEOUT, 86400)
+	}
+
+	version.Status = api.VersionAwaitingApproval
+	version.Approval.RequestTime = now
+	version.Approval.Deadline = now.Add(time.Duration(timeout) * time.Second)
+	setDeployStatuses(version, api.DeployAwaitingApproval)
+}
+
+// decide records the decision on the deploy of the version waiting for the
+// approval. The approved version deploys only, the others are kept healthy
+// without deploying, and the promoted ones stay in the environment they are
+// promoted from. It can not be approved or rejected once it times out.
+func decide(version *api.Version, status api.ApprovalStatus, userID, comment string, now time.Time) error {
+	if version.Status != api.VersionAwaitingApproval || version.Approval == nil {
+		return ErrNotAwaitingApproval
+	}
+	if status != api.ApprovalExpired && now.After(version.Approval.Deadline) {
+		return ErrApprovalExpired
+	}
+
+	version.Approval.Status = status
+	version.Approval.Approver = userID
+	version.Approval.Comment = comment
+	version.Approval.DecisionTime = now
+	if status == api.ApprovalApproved {
+		version.Status = api.VersionPending
+		version.Operation = api.DeployOperation
+		setDeployStatuses(version, api.DeployNoRun)
+		return nil
+	}
+	version.Status = api.VersionHealthy
+	if from := version.Approval.PromotedFrom; from != "" {
+		plans := []api.DeployPlanStatus{}
+		for _, plan := range version.DeployPlansStatuses {
+			if plan.Environment != version.Environment {
+				plans = append(plans, plan)
+			}
+		}
+		version.DeployPlansStatuses = plans
+		version.Environment = from
+		return nil
+	}
+	setDeployStatuses(version, api.DeployCancel)
+	return nil
+}
+
+// setDeployStatuses sets the statuses of the deploys by the deploy plans of the
+// environment of the version and by the yaml information. The deploys done to
+// the previous environments before the promotion are kept.
+func setDeployStatuses(version *api.Version, status api.VersionDeployStatus) {
+	if version.YamlDeploy != api.NotDeployWithYaml {
+		switch version.YamlDeployStatus {
+		case "", api.DeployNoRun, api.DeployAwaitingApproval:
+			version.YamlDeployStatus = status
+		}
+	}
+	for i := range version.DeployPlansStatuses {
+		if version.DeployPlansStatuses[i].Environment == version.Environment {
+			version.DeployPlansStatuses[i].Status = status
+		}
+	}
+}
+
+// expireVersion marks the version not approved in time as expired.
+func expireVersion(ds *store.DataStore, version *api.Version) {
+	if err := decide(version, api.ApprovalExpired, "", "", time.Now()); err != nil {
+		return
+	}
+	err := ds.CompareAndSetVersion(version.VersionID, api.VersionAwaitingApproval, *version)
+	if err != nil && err != mgo.ErrNotFound {
+		log.Errorf("Unable to expire the approval of version %s: %v", version.VersionID, err)
+		return
+	}
+	log.Infof("approval of version %s expired", version.VersionID)
+}
+
+// expireApprovals expires the approvals timed out periodically, until the
+// context is cancelled.
+func expireApprovals(ctx context.Context) {
+	interval := time.Duration(osutil.GetIntEnv(APPROVAL_CHECK_INTERVAL, 60)) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("stop expiring approvals")
+			return
+		case <-ticker.C:
+			expireApprovalsOnce()
+		}
+	}
+}
+
+// expireApprovalsOnce expires the approvals of the versions past their deadlines.
+func expireApprovalsOnce() {
+	ds := store.NewStore()
+	defer ds.Close()
+
+	versions, err := ds.FindVersionsByStatus(api.VersionAwaitingApproval)
+	if err != nil {
+		log.Errorf("find versions awaiting approval err: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for i := range versions {
+		if versions[i].Approval != nil && now.After(versions[i].Approval.Deadline) {
+			expireVersion(ds, &versions[i])
+		}
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caicloud/cyclone/api"
+)
+
+// newApprovalVersion creates a release version of the service requiring the
+// approval, with the deploy plans prod and staging.
+func newApprovalVersion() (*api.Service, *api.Version) {
+	service := &api.Service{
+		UserID:   "owner",
+		Approval: api.ApprovalPolicy{Required: true, Timeout: 3600},
+	}
+	version := &api.Version{
+		Operation: api.IntegrationOperation + api.PublishOperation + api.DeployOperation,
+		DeployPlansStatuses: []api.DeployPlanStatus{
+			{PlanName: "prod", Status: api.DeployNoRun},
+			{PlanName: "staging", Status: api.DeployNoRun},
+		},
+	}
+	return service, version
+}
+
+// TestHoldDeploy tests the deploy is held only if the service requires the approval.
+func TestHoldDeploy(t *testing.T) {
+	service, version := newApprovalVersion()
+	HoldDeploy(service, version)
+	if version.Operation != api.IntegrationOperation+api.PublishOperation {
+		t.Errorf("Expect the deploy operation removed, got %s", version.Operation)
+	}
+	if version.Approval == nil || version.Approval.Status != api.ApprovalPending || len(version.Approval.PlanNames) != 2 {
+		t.Fatalf("Expect pending approval of 2 plans, got %+v", version.Approval)
+	}
+
+	service, version = newApprovalVersion()
+	service.Approval.Required = false
+	HoldDeploy(service, version)
+	if version.Approval != nil || version.Operation != api.IntegrationOperation+api.PublishOperation+api.DeployOperation {
+		t.Errorf("Expect the deploy not held, got %+v", version)
+	}
+
+	service, version = newApprovalVersion()
+	version.Operation = api.IntegrationOperation
+	HoldDeploy(service, version)
+	if version.Approval != nil {
+		t.Errorf("Expect the integration not held, got %+v", version.Approval)
+	}
+}
+
+// TestCanApprove tests the owner approves if the approvers are not set.
+func TestCanApprove(t *testing.T) {
+	service, _ := newApprovalVersion()
+	if !CanApprove(service, "owner") || CanApprove(service, "alice") {
+		t.Errorf("Expect only the owner to approve")
+	}
+
+	service.Approval.Approvers = []string{"alice", "bob"}
+	if !CanApprove(service, "bob") || CanApprove(service, "owner") {
+		t.Errorf("Expect only the approvers to approve")
+	}
+}
+
+// TestDecide tests the approval, rejection and expiration of the deploy.
+func TestDecide(t *testing.T) {
+	now := time.Now()
+	service, version := newApprovalVersion()
+	if err := decide(version, api.ApprovalApproved, "owner", "", now); err != ErrNotAwaitingApproval {
+		t.Errorf("Expect error %v, got %v", ErrNotAwaitingApproval, err)
+	}
+
+	HoldDeploy(service, version)
+	awaitApproval(service, version, now)
+	if version.Status != api.VersionAwaitingApproval || !version.Approval.Deadline.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expect awaiting approval for an hour, got %+v", version.Approval)
+	}
+	if version.YamlDeployStatus != api.DeployAwaitingApproval || version.DeployPlansStatuses[1].Status != api.DeployAwaitingApproval {
+		t.Errorf("Expect the deploys awaiting approval, got %+v", version)
+	}
+
+	if err := decide(version, api.ApprovalApproved, "owner", "ship it", now.Add(time.Minute)); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	approval := version.Approval
+	if approval.Status != api.ApprovalApproved || approval.Approver != "owner" || approval.Comment != "ship it" ||
+		!approval.DecisionTime.Equal(now.Add(time.Minute)) {
+		t.Errorf("Expect approved by owner, got %+v", approval)
+	}
+	if version.Status != api.VersionPending || version.Operation != api.DeployOperation ||
+		version.DeployPlansStatuses[0].Status != api.DeployNoRun {
+		t.Errorf("Expect the version to deploy only, got %+v", version)
+	}
+	if err := decide(version, api.ApprovalRejected, "owner", "", now); err != ErrNotAwaitingApproval {
+		t.Errorf("Expect error %v for the decided version, got %v", ErrNotAwaitingApproval, err)
+	}
+
+	_, version = newApprovalVersion()
+	HoldDeploy(service, version)
+	awaitApproval(service, version, now)
+	if err := decide(version, api.ApprovalRejected, "owner", "", now.Add(2*time.Hour)); err != ErrApprovalExpired {
+		t.Errorf("Expect error %v, got %v", ErrApprovalExpired, err)
+	}
+	if err := decide(version, api.ApprovalExpired, "", "", now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if version.Status != api.VersionHealthy || version.Approval.Status != api.ApprovalExpired ||
+		version.DeployPlansStatuses[0].Status != api.DeployCancel {
+		t.Errorf("Expect the version kept without deploying, got %+v", version)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/docker"
+	"github.com/caicloud/cyclone/pkg/log"
+	"github.com/caicloud/cyclone/pkg/osutil"
+	"github.com/caicloud/cyclone/worker/vcs"
+)
+
+const (
+	// WORKER_BACKEND is the backend to run workers, docker or kubernetes.
+	WORKER_BACKEND = "WORKER_BACKEND"
+)
+
+// Names of the worker backends.
+const (
+	// DockerBackend runs workers as containers on the system worker nodes.
+	DockerBackend = "docker"
+	// KubernetesBackend runs workers as pods in a kubernetes cluster.
+	KubernetesBackend = "kubernetes"
+)
+
+// WorkerBackend runs the workers of events.
+type WorkerBackend interface {
+	// Run starts a worker for the event, and returns the ID of the worker.
+	Run(event *api.Event) (string, error)
+	// Stop stops and removes the worker.
+	Stop(workerID string) error
+}
+
+// workerBackendName gets the worker backend configured by env.
+func workerBackendName() string {
+	name := osutil.GetStringEnv(WORKER_BACKEND, DockerBackend)
+	if name != DockerBackend && name != KubernetesBackend {
+		log.Warnf("Unknown worker backend %s, use %s", name, DockerBackend)
+		return DockerBackend
+	}
+	return name
+}
+
+// dockerBackend runs workers as containers on a docker host. The containers of
+// the CI steps run in the docker daemon of the worker, they are removed with it.
+type dockerBackend struct {
+	dm *docker.Manager
+}
+
+// Run implements WorkerBackend.
+func (b *dockerBackend) Run(event *api.Event) (string, error) {
+	coo := toBuildContainerConfig(event.EventID, int64(event.WorkerInfo.UsedResource.CPU),
+		int64(event.WorkerInfo.UsedResource.Memory))
+	files, err := workerFiles(event)
+	if err != nil {
+		return "", err
+	}
+	containerID, err := b.dm.RunContainerWithFiles(coo, files)
+	if err != nil {
+		b.dm.StopContainer(containerID)
+		b.dm.RemoveContainer(containerID)
+		return "", err
+	}
+	return containerID, nil
+}
+
+// workerFiles gets the secret files given to the worker of the event by their
+// paths in api.WorkerSecretsDir, the private key of the deploy key and the
+// decrypted cluster tokens.
+func workerFiles(event *api.Event) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	privateKey, err := loadDeployKey(event)
+	if err != nil {
+		return nil, err
+	}
+	if privateKey != nil {
+		files[vcs.DEPLOY_KEY_FILE] = privateKey
+	}
+
+	tokens, err := clusterTokens(event)
+	if err != nil {
+		return nil, err
+	}
+	if tokens != nil {
+		files[api.ClusterTokensFile] = tokens
+	}
+	return files, nil
+}
+
+// Stop implements WorkerBackend.
+func (b *dockerBackend) Stop(workerID string) error {
+	// stop worker container
+	err := b.dm.StopContainer(workerID)
+	if err != nil {
+		log.Errorf("stop err: %v", err)
+	}
+
+	// remove worker container
+	err = b.dm.RemoveContainer(workerID)
+	if err != nil {
+		log.Errorf("remove err: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/pkg/log"
+	"github.com/caicloud/cyclone/pkg/osutil"
+	"github.com/caicloud/cyclone/worker/cache"
+	k8s_core_api "k8s.io/kubernetes/pkg/api"
+	k8s_errors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/resource"
+	clientset "k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset"
+	internalversioncore "k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/typed/core/internalversion"
+	restclient "k8s.io/kubernetes/pkg/client/restclient"
+)
+
+const (
+	// WORKER_K8S_HOST is the address of the kubernetes cluster running workers.
+	WORKER_K8S_HOST = "WORKER_K8S_HOST"
+	// WORKER_K8S_TOKEN is the bearer token to access the kubernetes cluster.
+	WORKER_K8S_TOKEN = "WORKER_K8S_TOKEN"
+	// WORKER_K8S_NAMESPACE is the namespace of the worker pods.
+	WORKER_K8S_NAMESPACE = "WORKER_K8S_NAMESPACE"
+
+	// workerPodPrefix is the prefix of the worker pod names.
+	workerPodPrefix = "cyclone-worker-"
+)
+
+// kubernetesClient is the part of the kubernetes client used by the backend.
+type kubernetesClient interface {
+	internalversioncore.PodsGetter
+	internalversioncore.SecretsGetter
+}
+
+// kubernetesBackend runs workers as pods in a kubernetes cluster, the pods are
+// scheduled by kubernetes according to their resource requests. The private
+// key of the deploy key is mounted to the pod from a secret of the same name.
+type kubernetesBackend struct {
+	client    kubernetesClient
+	namespace string
+}
+
+// newKubernetesBackend creates a kubernetes backend configured by env.
+func newKubernetesBackend() (*kubernetesBackend, error) {
+	config := &restclient.Config{
+		Host:        osutil.GetStringEnv(WORKER_K8S_HOST, ""),
+		BearerToken: osutil.GetStringEnv(WORKER_K8S_TOKEN, ""),
+		Insecure:    true,
+	}
+	client, err := clientset.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &kubernetesBackend{
+		client:    client.Core(),
+		namespace: osutil.GetStringEnv(WORKER_K8S_NAMESPACE, "default"),
+	}, nil
+}
+
+// Run implements WorkerBackend.
+func (b *kubernetesBackend) Run(event *api.Event) (string, error) {
+	files, err := workerFiles(event)
+	if err != nil {
+		return "", err
+	}
+	pod := toWorkerPod(event)
+	if len(files) > 0 {
+		secret := &k8s_core_api.Secret{
+			ObjectMeta: k8s_core_api.ObjectMeta{Name: pod.Name, Labels: pod.Labels},
+			Data:       make(map[string][]byte),
+		}
+		for path, data := range files {
+			secret.Data[filepath.Base(path)] = data
+		}
+		if _, err := b.client.Secrets(b.namespace).Create(secret); err != nil {
+			return "", err
+		}
+		mountSecrets(pod, secret.Name)
+	}
+
+	created, err := b.client.Pods(b.namespace).Create(pod)
+	if err != nil {
+		if len(files) > 0 {
+			b.deleteSecret(pod.Name)
+		}
+		return "", err
+	}
+	return created.Name, nil
+}
+
+// Stop implements WorkerBackend, the worker is stopped by deleting its pod and
+// the secret of its files.
+func (b *kubernetesBackend) Stop(workerID string) error {
+	err := b.client.Pods(b.namespace).Delete(workerID, nil)
+	if err != nil && !k8s_errors.IsNotFound(err) {
+		log.Errorf("delete worker pod err: %v", err)
+		return err
+	}
+	return b.deleteSecret(workerID)
+}
+
+// deleteSecret deletes the secret of the files of the worker, if any.
+func (b *kubernetesBackend) deleteSecret(workerID string) error {
+	err := b.client.Secrets(b.namespace).Delete(workerID, nil)
+	if err != nil && !k8s_errors.IsNotFound(err) {
+		log.Errorf("delete worker secret err: %v", err)
+		return err
+	}
+	return nil
+}
+
+// mountSecrets mounts the secret with the files of the worker to the directory
+// api.WorkerSecretsDir, readable only by the owner.
+func mountSecrets(pod *k8s_core_api.Pod, secretName string) {
+	mode := int32(0400)
+	pod.Spec.Volumes = append(pod.Spec.Volumes, k8s_core_api.Volume{
+		Name: "secrets",
+		VolumeSource: k8s_core_api.VolumeSource{
+			Secret: &k8s_core_api.SecretVolumeSource{SecretName: secretName, DefaultMode: &mode},
+		},
+	})
+	container := &pod.Spec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, k8s_core_api.VolumeMount{
+		Name:      "secrets",
+		MountPath: api.WorkerSecretsDir,
+		ReadOnly:  true,
+	})
+}
+
+// toWorkerPod creates the worker pod of the event, the resource used by the
+// worker is set as both the requests and limits of the container.
+func toWorkerPod(event *api.Event) *k8s_core_api.Pod {
+	var envs []k8s_core_api.EnvVar
+	for _, env := range workerEnvs(event.EventID) {
+		kv := strings.SplitN(env, "=", 2)
+		envs = append(envs, k8s_core_api.EnvVar{Name: kv[0], Value: kv[1]})
+	}
+
+	// CPU of the worker is in docker cpu shares, 1024 shares for a core.
+	used := event.WorkerInfo.UsedResource
+	resources := k8s_core_api.ResourceList{
+		k8s_core_api.ResourceCPU:    *resource.NewMilliQuantity(int64(used.CPU*1000/1024), resource.DecimalSI),
+		k8s_core_api.ResourceMemory: *resource.NewQuantity(int64(used.Memory), resource.BinarySI),
+	}
+
+	privileged := true
+	cacheDir := osutil.GetStringEnv(cache.WORKER_CACHE_DIR, cache.DEFAULT_WORKER_CACHE_DIR)
+	return &k8s_core_api.Pod{
+		ObjectMeta: k8s_core_api.ObjectMeta{
+			Name:   workerPodName(event.EventID),
+			Labels: map[string]string{WORKER_LABEL_EVENTID: string(event.EventID)},
+		},
+		Spec: k8s_core_api.PodSpec{
+			RestartPolicy: k8s_core_api.RestartPolicyNever,
+			Volumes: []k8s_core_api.Volume{
+				{
+					Name: "cache",
+					VolumeSource: k8s_core_api.VolumeSource{
+						HostPath: &k8s_core_api.HostPathVolumeSource{Path: cacheDir},
+					},
+				},
+			},
+			Containers: []k8s_core_api.Container{
+				{
+					Name:  "worker",
+					Image: osutil.GetStringEnv(WORKER_IMAGE, DEFAULT_WORKER_IMAGE),
+					Env:   envs,
+					Resources: k8s_core_api.ResourceRequirements{
+						Requests: resources,
+						Limits:   resources,
+					},
+					SecurityContext: &k8s_core_api.SecurityContext{Privileged: &privileged},
+					VolumeMounts:    []k8s_core_api.VolumeMount{{Name: "cache", MountPath: cacheDir}},
+				},
+			},
+		},
+	}
+}
+
+// workerPodName gets the name of the worker pod of the event.
+func workerPodName(eventID api.EventID) string {
+	return fmt.Sprintf("%s%s", workerPodPrefix, strings.ToLower(string(eventID)))
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/caicloud/cyclone/api"
+	k8s_core_api "k8s.io/kubernetes/pkg/api"
+	k8s_errors "k8s.io/kubernetes/pkg/api/errors"
+	internalversioncore "k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/typed/core/internalversion"
+)
+
+// fakePods keeps pods in memory, methods not overridden panic.
+type fakePods struct {
+	internalversioncore.PodInterface
+	pods map[string]*k8s_core_api.Pod
+}
+
+func (f *fakePods) Pods(namespace string) internalversioncore.PodInterface {
+	return f
+}
+
+func (f *fakePods) Create(pod *k8s_core_api.Pod) (*k8s_core_api.Pod, error) {
+	if _, ok := f.pods[pod.Name]; ok {
+		return nil, k8s_errors.NewAlreadyExists(k8s_core_api.Resource("pods"), pod.Name)
+	}
+	f.pods[pod.Name] = pod
+	return pod, nil
+}
+
+func (f *fakePods) Delete(name string, options *k8s_core_api.DeleteOptions) error {
+	if _, ok := f.pods[name]; !ok {
+		return k8s_errors.NewNotFound(k8s_core_api.Resource("pods"), name)
+	}
+	delete(f.pods, name)
+	return nil
+}
+
+// fakeSecrets keeps secrets in memory, methods not overridden panic.
+type fakeSecrets struct {
+	internalversioncore.SecretInterface
+	secrets map[string]*k8s_core_api.Secret
+}
+
+func (f *fakeSecrets) Secrets(namespace string) internalversioncore.SecretInterface {
+	return f
+}
+
+func (f *fakeSecrets) Create(secret *k8s_core_api.Secret) (*k8s_core_api.Secret, error) {
+	if _, ok := f.secrets[secret.Name]; ok {
+		return nil, k8s_errors.NewAlreadyExists(k8s_core_api.Resource("secrets"), secret.Name)
+	}
+	f.secrets[secret.Name] = secret
+	return secret, nil
+}
+
+func (f *fakeSecrets) Delete(name string, options *k8s_core_api.DeleteOptions) error {
+	if _, ok := f.secrets[name]; !ok {
+		return k8s_errors.NewNotFound(k8s_core_api.Resource("secrets"), name)
+	}
+	delete(f.secrets, name)
+	return nil
+}
+
+// fakeKubernetesClient gets the fake pods and secrets.
+type fakeKubernetesClient struct {
+	*fakePods
+	*fakeSecrets
+}
+
+func newFakeKubernetesClient() *fakeKubernetesClient {
+	return &fakeKubernetesClient{
+		fakePods:    &fakePods{pods: make(map[string]*k8s_core_api.Pod)},
+		fakeSecrets: &fakeSecrets{secrets: make(map[string]*k8s_core_api.Secret)},
+	}
+}
+
+// TestKubernetesBackend tests running and stopping workers as pods.
+func TestKubernetesBackend(t *testing.T) {
+	client := newFakeKubernetesClient()
+	pods := client.fakePods
+	backend := &kubernetesBackend{client: client, namespace: "default"}
+
+	event := &api.Event{EventID: "Unit-Test-EventID"}
+	event.WorkerInfo.UsedResource = api.BuildResource{Memory: 536870912, CPU: 512}
+
+	workerID, err := backend.Run(event)
+	if err != nil {
+		t.Fatalf("Expect no error, but got %v", err)
+	}
+	if workerID != "cyclone-worker-unit-test-eventid" {
+		t.Errorf("Expect worker id cyclone-worker-unit-test-eventid, but got %s", workerID)
+	}
+
+	pod, ok := pods.pods[workerID]
+	if !ok {
+		t.Fatalf("Expect pod %s created", workerID)
+	}
+	if pod.Spec.RestartPolicy != k8s_core_api.RestartPolicyNever {
+		t.Errorf("Expect restart policy Never, but got %s", pod.Spec.RestartPolicy)
+	}
+	requests := pod.Spec.Containers[0].Resources.Requests
+	cpu, memory := requests[k8s_core_api.ResourceCPU], requests[k8s_core_api.ResourceMemory]
+	if cpu.MilliValue() != 500 {
+		t.Errorf("Expect cpu request 500m, but got %dm", cpu.MilliValue())
+	}
+	if memory.Value() != 536870912 {
+		t.Errorf("Expect memory request 536870912, but got %d", memory.Value())
+	}
+	if pod.Labels[WORKER_LABEL_EVENTID] != string(event.EventID) {
+		t.Errorf("Expect label %s of the pod", WORKER_LABEL_EVENTID)
+	}
+
+	if _, err := backend.Run(event); err == nil {
+		t.Errorf("Expect error when the pod exists")
+	}
+
+	if err := backend.Stop(workerID); err != nil {
+		t.Errorf("Expect no error, but got %v", err)
+	}
+	if _, ok := pods.pods[workerID]; ok {
+		t.Errorf("Expect pod %s deleted", workerID)
+	}
+	// Stopping a deleted worker is not an error.
+	if err := backend.Stop(workerID); err != nil {
+		t.Errorf("Expect no error when the pod is gone, but got %v", err)
+	}
+}
+
+// TestKubernetesBackendDeployKey tests the private key of the deploy key and the
+// decrypted cluster tokens are mounted to the worker from a secret, which is
+// deleted with the worker.
+func TestKubernetesBackendDeployKey(t *testing.T) {
+	defer func(f func(*api.Event) ([]byte, error)) { loadDeployKey = f }(loadDeployKey)
+	loadDeployKey = func(event *api.Event) ([]byte, error) {
+		return []byte("private key"), nil
+	}
+	defer os.Setenv(CLUSTER_TOKEN_SECRET, os.Getenv(CLUSTER_TOKEN_SECRET))
+	os.Setenv(CLUSTER_TOKEN_SECRET, "passphrase")
+	token, err := EncryptClusterToken("cluster token")
+	if err != nil {
+		t.Fatalf("Expect no error, but got %v", err)
+	}
+
+	client := newFakeKubernetesClient()
+	backend := &kubernetesBackend{client: client, namespace: "default"}
+	event := &api.Event{EventID: "Unit-Test-EventID"}
+	event.Version.DeployPlansStatuses = []api.DeployPlanStatus{
+		{PlanName: "web", Config: api.DeployConfig{ClusterToken: token}},
+	}
+
+	workerID, err := backend.Run(event)
+	if err != nil {
+		t.Fatalf("Expect no error, but got %v", err)
+	}
+	secret, ok := client.secrets[workerID]
+	if !ok {
+		t.Fatalf("Expect secret %s created", workerID)
+	}
+	if string(secret.Data["id_deploy"]) != "private key" {
+		t.Errorf("Expect the private key in the secret, but got %v", secret.Data)
+	}
+	tokens := map[string]string{}
+	if err := json.Unmarshal(secret.Data["cluster-tokens"], &tokens); err != nil || tokens[token] != "cluster token" {
+		t.Errorf("Expect the decrypted cluster token in the secret, but got %v, %v", tokens, err)
+	}
+
+	pod := client.pods[workerID]
+	for _, env := range pod.Spec.Containers[0].Env {
+		if env.Value == "private key" {
+			t.Errorf("Expect the private key not in the env %s", env.Name)
+		}
+	}
+	volumes := pod.Spec.Volumes
+	if volume := volumes[len(volumes)-1]; volume.Secret == nil || volume.Secret.SecretName != workerID ||
+		*volume.Secret.DefaultMode != 0400 {
+		t.Errorf("Expect the secret %s mounted with mode 0400, but got %v", workerID, volume)
+	}
+	mounts := pod.Spec.Containers[0].VolumeMounts
+	if mount := mounts[len(mounts)-1]; mount.MountPath != "/etc/cyclone/secrets" {
+		t.Errorf("Expect the secret mounted to /etc/cyclone/secrets, but got %s", mount.MountPath)
+	}
+
+	if err := backend.Stop(workerID); err != nil {
+		t.Errorf("Expect no error, but got %v", err)
+	}
+	if _, ok := client.secrets[workerID]; ok {
+		t.Errorf("Expect secret %s deleted", workerID)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"errors"
+
+	"github.com/caicloud/cyclone/api"
+)
+
+var (
+	// ErrEventFinished is the error when the event has finished.
+	ErrEventFinished = errors.New("event has finished")
+)
+
+// CancelEvent cancels a unfinished event. A pending event is removed from the
+// queue, a running event is marked as cancelled. Then the post hook is run by
+// the leader when the change is watched, which fires the worker with its CI
+// containers, and releases the resources.
+func CancelEvent(eventID api.EventID) error {
+	event, err := LoadEventFromEtcd(eventID)
+	if err != nil {
+		return err
+	}
+	if IsEventFinished(event) {
+		return ErrEventFinished
+	}
+
+	if event.Status == api.EventStatusPending {
+		err = cancelPendingEvent(eventID, "cancelled by user")
+		// The event may not have been enqueued yet, cancel it directly.
+		if err != ErrEventNotQueued {
+			return err
+		}
+	}
+
+	event.Status = api.EventStatusCancel
+	event.ErrorMessage = "cancelled by user"
+	return SaveEventToEtcd(event)
+}
+
+// cancelVersionDeploys marks the deployments of the version still being checked
+// as cancelled.
+func cancelVersionDeploys(version *api.Version) {
+	if version.YamlDeployStatus == api.DeployPending {
+		version.YamlDeployStatus = api.DeployCancel
+	}
+	for i := range version.DeployPlansStatuses {
+		if version.DeployPlansStatuses[i].Status == api.DeployPending {
+			version.DeployPlansStatuses[i].Status = api.DeployCancel
+		}
+	}
+}
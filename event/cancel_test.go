@@ -0,0 +1,45 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"testing"
+
+	"github.com/caicloud/cyclone/api"
+)
+
+// TestCancelVersionDeploys tests only the deployments being checked are cancelled.
+func TestCancelVersionDeploys(t *testing.T) {
+	version := api.Version{
+		YamlDeployStatus: api.DeployPending,
+		DeployPlansStatuses: []api.DeployPlanStatus{
+			{Status: api.DeploySuccess},
+			{Status: api.DeployPending},
+		},
+	}
+
+	cancelVersionDeploys(&version)
+	if version.YamlDeployStatus != api.DeployCancel {
+		t.Errorf("Expect yaml deploy cancelled, got %s", version.YamlDeployStatus)
+	}
+	if version.DeployPlansStatuses[0].Status != api.DeploySuccess {
+		t.Errorf("Expect finished deploy plan kept, got %s", version.DeployPlansStatuses[0].Status)
+	}
+	if version.DeployPlansStatuses[1].Status != api.DeployCancel {
+		t.Errorf("Expect pending deploy plan cancelled, got %s", version.DeployPlansStatuses[1].Status)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/pkg/secret"
+)
+
+const (
+	// CLUSTER_TOKEN_SECRET is the passphrase to encrypt the bearer tokens of the
+	// kubernetes clusters of the deploy plans in the store.
+	CLUSTER_TOKEN_SECRET = "CLUSTER_TOKEN_SECRET"
+)
+
+// EncryptClusterToken encrypts the cluster token of a deploy plan to store it,
+// the empty token is kept empty.
+func EncryptClusterToken(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+	return secret.Encrypt(os.Getenv(CLUSTER_TOKEN_SECRET), []byte(token))
+}
+
+// DecryptClusterToken decrypts the stored cluster token of a deploy plan.
+func DecryptClusterToken(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+	decrypted, err := secret.Decrypt(os.Getenv(CLUSTER_TOKEN_SECRET), token)
+	if err != nil {
+		return "", err
+	}
+	return string(decrypted), nil
+}
+
+// clusterTokens decrypts the cluster tokens of the deploy plans of the event's
+// version, or returns nil if the plans have none. They're given to the worker
+// by the file api.ClusterTokensFile, which is removed with the worker.
+func clusterTokens(event *api.Event) ([]byte, error) {
+	tokens := make(map[string]string)
+	for _, plan := range event.Version.DeployPlansStatuses {
+		token := plan.Config.ClusterToken
+		if token == "" || tokens[token] != "" {
+			continue
+		}
+		decrypted, err := DecryptClusterToken(token)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decrypt cluster token of plan %s: %v", plan.PlanName, err)
+		}
+		tokens[token] = decrypted
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(tokens)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/pkg/secret"
+	"github.com/caicloud/cyclone/store"
+)
+
+const (
+	// DEPLOY_KEY_SECRET is the passphrase to encrypt the private keys of the
+	// deploy keys in the store.
+	DEPLOY_KEY_SECRET = "DEPLOY_KEY_SECRET"
+)
+
+// loadDeployKey loads the private key of the deploy key, it is replaced in tests.
+var loadDeployKey = deployKey
+
+// EncryptDeployKey encrypts the private key of a deploy key to store it.
+func EncryptDeployKey(privateKey string) (string, error) {
+	return secret.Encrypt(os.Getenv(DEPLOY_KEY_SECRET), []byte(privateKey))
+}
+
+// deployKey gets the decrypted private key of the deploy key of the event's
+// service, or nil if the service has none. The private key is not in the
+// event, it's loaded from the store. It's given to the worker by the file
+// vcs.DEPLOY_KEY_FILE, which is removed with the worker.
+func deployKey(event *api.Event) ([]byte, error) {
+	if event.Service.Repository.DeployKey.PublicKey == "" {
+		return nil, nil
+	}
+
+	ds := store.NewStore()
+	defer ds.Close()
+	service, err := ds.FindServiceByID(event.Service.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+	if service.Repository.DeployKey.PrivateKey == "" {
+		return nil, nil
+	}
+
+	privateKey, err := secret.Decrypt(os.Getenv(DEPLOY_KEY_SECRET), service.Repository.DeployKey.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt deploy key of service %s: %v", service.ServiceID, err)
+	}
+	return privateKey, nil
+}
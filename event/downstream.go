@@ -0,0 +1,148 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package event
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/pkg/log"
+	"github.com/caicloud/cyclone/store"
+)
+
+const (
+	// DefaultUpstreamVariable is the build variable holding the upstream image if
+	// the downstream trigger does not name one.
+	DefaultUpstreamVariable = "UPSTREAM_IMAGE"
+)
+
+var (
+	// ErrDownstreamCycle is the error when the downstream triggers of services
+	// form a cycle.
+	ErrDownstreamCycle = errors.New("downstream services trigger each other circularly")
+)
+
+// CheckDownstreamCycle checks whether the service can be reached from itself
+// through the downstream triggers, the graph maps a service ID to the IDs of
+// its downstream services.
+func CheckDownstreamCycle(serviceID string, graph map[string][]string) error {
+	visited := make(map[string]bool)
+	var visit func(id string) bool
+	visit = func(id string) bool {
+		for _, downstream := range graph[id] {
+			if downstream == serviceID {
+				return true
+			}
+			if visited[downstream] {
+				continue
+			}
+			visited[downstream] = true
+			if visit(downstream) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if visit(serviceID) {
+		return ErrDownstreamCycle
+	}
+	return nil
+}
+
+// upstreamImage returns the image with tag published by the version, it is
+// named in the same way as the worker does.
+func upstreamImage(service *api.Service, version *api.Version) string {
+	return fmt.Sprintf("%s/%s/%s:%s", registryWorker.RegistryLocation, strings.ToLower(service.Username),
+		strings.ToLower(service.Name), version.Name)
+}
+
+// shouldTriggerDownstreams returns whether the version triggers the downstream
+// services, only the versions publishing the image successfully do, including
+// those waiting for the approval to deploy.
+func shouldTriggerDownstreams(service *api.Service, version *api.Version) bool {
+	built := version.Status == api.VersionHealthy || version.Status == api.VersionAwaitingApproval
+	return len(service.Downstreams) > 0 && built &&
+		strings.Contains(string(version.Operation), string(api.PublishOperation))
+}
+
+// newDownstreamVersion creates the version of the downstream service triggered
+// by the upstream version, which has the same name and the upstream image in
+// the build variable.
+func newDownstreamVersion(trigger api.DownstreamTrigger, service *api.Service, version *api.Version) api.Version {
+	variable := trigger.Variable
+	if variable == "" {
+		variable = DefaultUpstreamVariable
+	}
+	return api.Version{
+		ServiceID:           trigger.ServiceID,
+		Name:                version.Name,
+		Description:         fmt.Sprintf("Triggered by %s of service %s", version.Name, service.Name),
+		DependentVersionIDs: []string{version.VersionID},
+		BuildVariables:      map[string]string{variable: upstreamImage(service, version)},
+		Operation:           api.IntegrationOperation + api.PublishOperation,
+		Operator:            api.UpstreamOperator,
+		Status:              api.VersionPending,
+		YamlDeployStatus:    api.DeployNoRun,
+	}
+}
+
+// triggerDownstreams creates the versions of the downstream services after the
+// upstream version is built, the latest triggers of the service are used. A
+// downstream service is skipped if it already has a version of the same name,
+// which also stops the triggers going round.
+func triggerDownstreams(ds *store.DataStore, version *api.Version) {
+	service, err := ds.FindServiceByID(version.ServiceID)
+	if err != nil {
+		log.Errorf("Unable to find service %s: %v", version.ServiceID, err)
+		return
+	}
+	if !shouldTriggerDownstreams(service, version) {
+		return
+	}
+
+	for _, trigger := range service.Downstreams {
+		downstream, err := ds.FindServiceByID(trigger.ServiceID)
+		if err != nil {
+			log.Errorf("Unable to find downstream service %s of %s: %v", trigger.ServiceID, service.Name, err)
+			continue
+		}
+		if downstream.Repository.Status != api.RepositoryHealthy {
+			log.Warnf("Skip downstream service %s, repository status is %s", downstream.Name,
+				downstream.Repository.Status)
+			continue
+		}
+		versions, err := ds.FindVersionsByCondition(downstream.ServiceID, version.Name)
+		if err == nil && len(versions) > 0 {
+			log.Infof("Skip downstream service %s, version %s is existed", downstream.Name, version.Name)
+			continue
+		}
+
+		downstreamVersion := newDownstreamVersion(trigger, service, version)
+		downstreamVersion.URL = downstream.Repository.URL
+		downstreamVersion.CreateTime = time.Now()
+		if _, err := ds.NewVersionDocument(&downstreamVersion); err != nil {
+			log.Errorf("Unable to create version of downstream service %s: %v", downstream.Name, err)
+			continue
+		}
+		if err := SendCreateVersionEvent(downstream, &downstreamVersion); err != nil {
+			log.Errorf("Unable to create build job of downstream service %s: %v", downstream.Name, err)
+		}
+	}
+}
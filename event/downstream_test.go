@@ -0,0 +1,90 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package event
+
+import (
+	"testing"
+
+	"github.com/caicloud/cyclone/api"
+)
+
+// TestCheckDownstreamCycle tests the cycles through the downstream triggers are found.
+func TestCheckDownstreamCycle(t *testing.T) {
+	graph := map[string][]string{
+		"base":  {"api", "web"},
+		"api":   {"web"},
+		"web":   nil,
+		"tools": {"tools-ui"},
+	}
+	if err := CheckDownstreamCycle("base", graph); err != nil {
+		t.Errorf("Expect no cycle, got %v", err)
+	}
+
+	graph["web"] = []string{"base"}
+	if err := CheckDownstreamCycle("base", graph); err != ErrDownstreamCycle {
+		t.Errorf("Expect cycle through web, got %v", err)
+	}
+	if err := CheckDownstreamCycle("tools", graph); err != nil {
+		t.Errorf("Expect the cycle not reachable from tools ignored, got %v", err)
+	}
+}
+
+// TestNewDownstreamVersion tests the downstream version carries the upstream image.
+func TestNewDownstreamVersion(t *testing.T) {
+	registryWorker = api.RegistryCompose{RegistryLocation: "cargo.caicloud.io"}
+	defer func() { registryWorker = api.RegistryCompose{} }()
+
+	service := &api.Service{
+		Name:        "Base",
+		Username:    "Alice",
+		Downstreams: []api.DownstreamTrigger{{ServiceID: "web"}},
+	}
+	version := &api.Version{
+		VersionID: "base-v1",
+		Name:      "tag_v1",
+		Status:    api.VersionHealthy,
+		Operation: api.IntegrationOperation + api.PublishOperation,
+	}
+	if !shouldTriggerDownstreams(service, version) {
+		t.Errorf("Expect published release to trigger downstreams")
+	}
+	version.Status = api.VersionAwaitingApproval
+	if !shouldTriggerDownstreams(service, version) {
+		t.Errorf("Expect published release awaiting approval to trigger downstreams")
+	}
+	version.Status = api.VersionHealthy
+
+	downstream := newDownstreamVersion(api.DownstreamTrigger{ServiceID: "web"}, service, version)
+	if image := downstream.BuildVariables[DefaultUpstreamVariable]; image != "cargo.caicloud.io/alice/base:tag_v1" {
+		t.Errorf("Expect upstream image in %s, got %q", DefaultUpstreamVariable, image)
+	}
+	if downstream.ServiceID != "web" || downstream.Name != "tag_v1" || downstream.Operator != api.UpstreamOperator {
+		t.Errorf("Expect version tag_v1 of web by upstream, got %+v", downstream)
+	}
+	if len(downstream.DependentVersionIDs) != 1 || downstream.DependentVersionIDs[0] != "base-v1" {
+		t.Errorf("Expect dependent version base-v1, got %v", downstream.DependentVersionIDs)
+	}
+
+	downstream = newDownstreamVersion(api.DownstreamTrigger{ServiceID: "web", Variable: "BASE_IMAGE"}, service, version)
+	if _, ok := downstream.BuildVariables["BASE_IMAGE"]; !ok {
+		t.Errorf("Expect upstream image in BASE_IMAGE, got %v", downstream.BuildVariables)
+	}
+
+	version.Operation = api.IntegrationOperation
+	if shouldTriggerDownstreams(service, version) {
+		t.Errorf("Expect integration only version not to trigger downstreams")
+	}
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package event
 
 import (
+	"time"
+
 	"github.com/caicloud/cyclone/api"
 	"github.com/caicloud/cyclone/notify"
 	"github.com/caicloud/cyclone/pkg/log"
@@ -35,7 +37,9 @@ const (
 	// hook.
 	CreateVersionOps api.Operation = "create-version"
 
-	// CreateProjectVersionOps defines the operation to create a project version.
+	// CreateProjectVersionOps defines the operation to create a project version,
+	// it creates a version for every service of the project in the order of
+	// their dependencies.
 	CreateProjectVersionOps api.Operation = "create-projectversion"
 )
 
@@ -74,6 +78,12 @@ func initOperationMap() {
 		Handler:  createVersionHandler,
 		PostHook: createVersionPostHook,
 	}
+
+	// create project version ops
+	mapOperation[CreateProjectVersionOps] = Operation{
+		Handler:  createProjectVersionHandler,
+		PostHook: createProjectVersionPostHook,
+	}
 }
 
 // handleEvent is the event create handler.
@@ -84,35 +94,32 @@ func handleEvent(event *api.Event) error {
 // postHookEvent is the event finished post hook.
 func postHookEvent(event *api.Event) {
 	mapOperation[event.Operation].PostHook(event)
+	releaseWorker(event)
+}
 
+// releaseWorker fires the worker of the event, and releases its resources.
+func releaseWorker(event *api.Event) {
+	// Project versions build their services by other events, they have no
+	// worker of their own.
+	if event.Operation == CreateProjectVersionOps {
+		return
+	}
 	w, err := LoadWorker(event)
 	if err != nil {
 		log.Errorf("load worker err: %v", err)
 		return
 	}
-	err = w.Fire()
-	if err != nil {
+	// The worker container may have been removed automatically, release the
+	// resources anyway.
+	if err = w.Fire(); err != nil {
 		log.Errorf("fire worker err: %v", err)
-		return
 	}
 
 	if err := resourceManager.ReleaseResource(event); err != nil {
 		log.Errorf("Unable to release resource %v", err)
 	}
 	// Release resources of worker node.
-	ds := store.NewStore()
-	defer ds.Close()
-	nodes, err := ds.FindWorkerNodesByDockerHost(event.WorkerInfo.DockerHost)
-	if err != nil || len(nodes) != 1 {
-		log.Errorf("find worker node err: %v", err)
-	} else {
-		nodes[0].LeftResource.Memory += event.WorkerInfo.UsedResource.Memory
-		nodes[0].LeftResource.CPU += event.WorkerInfo.UsedResource.CPU
-		_, err := ds.UpsertWorkerNodeDocument(&(nodes[0]))
-		if err != nil {
-			log.Errorf("release worker node resource err: %v", err)
-		}
-	}
+	releaseWorkerNodeResource(event)
 }
 
 // createServiceHander is the create service handler.
@@ -188,12 +195,28 @@ func createVersionPostHook(event *api.Event) {
 		event.Version.Status = api.VersionHealthy
 	} else if event.Status == api.EventStatusCancel {
 		event.Version.Status = api.VersionCancel
+		event.Version.ErrorMessage = event.ErrorMessage
+		cancelVersionDeploys(&event.Version)
 	} else {
 		event.Version.Status = api.VersionFailed
 		event.Version.ErrorMessage = event.ErrorMessage
 	}
 
+	// The version holding its deploy waits for the approval once built.
+	if event.Version.Approval != nil && event.Version.Approval.Status == api.ApprovalPending {
+		if event.Status == api.EventStatusSuccess {
+			awaitApproval(&event.Service, &event.Version, time.Now())
+		} else {
+			event.Version.Approval.Status = api.ApprovalCancelled
+		}
+	}
+
 	operation := string(event.Version.Operation)
+	// The version deploying after the approval or the promotion was added to
+	// the service once built, so it is not added again.
+	redeploy := operation == string(api.DeployOperation) &&
+		((event.Version.Approval != nil && event.Version.Approval.Status == api.ApprovalApproved) ||
+			environmentIndex(&event.Service, event.Version.Environment) > 0)
 	// Record that whether this event is a deploy for project. According this flag, we will make some special operations.
 	DeployInProject := false
 	if (operation == string(api.DeployOperation)) && (event.Version.ProjectVersionID != "") {
@@ -203,9 +226,16 @@ func createVersionPostHook(event *api.Event) {
 	ds := store.NewStore()
 	defer ds.Close()
 
+	// The version deployed to its environment replaces the one live there.
+	live := refreshLiveInfo(ds, &event.Service, &event.Version)
 	if err := ds.UpdateVersionDocument(event.Version.VersionID, event.Version); err != nil {
 		log.Errorf("Unable to update version status post hook for %+v: %v", event.Version, err)
 	}
+	if live {
+		if err := ds.RemoveOtherLiveInfo(event.Version.ServiceID, event.Version.VersionID, event.Version.Environment); err != nil {
+			log.Errorf("Unable to remove live info of environment %s: %v", event.Version.Environment, err)
+		}
+	}
 
 	remote, err := remoteManager.FindRemote(event.Service.Repository.Webhook)
 	if err != nil {
@@ -216,7 +246,7 @@ func createVersionPostHook(event *api.Event) {
 		}
 	}
 
-	if DeployInProject == false {
+	if DeployInProject == false && !redeploy {
 		if event.Version.Status == api.VersionHealthy {
 			if err := ds.AddNewVersion(event.Version.ServiceID, event.Version.VersionID); err != nil {
 				log.Errorf("Unable to add new version in post hook for %+v: %v", event.Version, err)
@@ -232,6 +262,15 @@ func createVersionPostHook(event *api.Event) {
 		log.Errorf("Unable to update new version info in service %+v: %v", event.Version, err)
 	}
 
+	// Build the next services of the project version if any, the project
+	// version builds the services in order by itself, otherwise the downstream
+	// services are triggered.
+	if event.Version.ProjectVersionID != "" && DeployInProject == false {
+		advanceProjectVersion(ds, &event.Version)
+	} else if event.Version.ProjectVersionID == "" && event.Version.Status == api.VersionHealthy {
+		triggerDownstreams(ds, &event.Version)
+	}
+
 	// Use for checking project's version deploy status.
 	if DeployInProject == true {
 		event.Version.FinalStatus = "finished"
@@ -245,3 +284,36 @@ func createVersionPostHook(event *api.Event) {
 	}
 	notify.Notify(&event.Service, &event.Version, versionLog.Logs)
 }
+
+// SendCreateVersionEvent is a helper method which sends a create version event
+// of the service to etcd, the version is built when the event is handled.
+func SendCreateVersionEvent(service *api.Service, version *api.Version) error {
+	ds := store.NewStore()
+	defer ds.Close()
+	tok, _ := ds.FindtokenByUserID(service.UserID, service.Repository.SubVcs)
+
+	event := api.Event{
+		EventID:   api.EventID(version.VersionID),
+		Service:   *service,
+		Version:   *version,
+		Operation: CreateVersionOps,
+		Data: map[string]interface{}{
+			"service-name": service.Name,
+			"version-name": version.Name,
+			"username":     service.Username,
+			"Token":        tok.Vsctoken.AccessToken,
+		},
+		Status:      api.EventStatusPending,
+		RetryPolicy: version.RetryPolicy,
+	}
+	if event.RetryPolicy == nil {
+		event.RetryPolicy = service.RetryPolicy
+	}
+
+	log.Infof("send create version event: %v", event)
+	if err := SaveEventToEtcd(&event); err != nil {
+		log.Errorf("send create version event err: %v", err)
+		return err
+	}
+	return nil
+}
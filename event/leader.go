@@ -0,0 +1,187 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/caicloud/cyclone/etcd"
+	"github.com/caicloud/cyclone/pkg/log"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	// leader key path in etcd
+	Events_Leader = "/events/leader"
+
+	// LEADER_TTL is the ttl of the leader key, other servers take over the
+	// leadership if the leader does not renew the key in time.
+	LEADER_TTL = 15 * time.Second
+
+	// LEADER_RENEW_INTERVAL is the interval to renew or campaign for leadership.
+	LEADER_RENEW_INTERVAL = 5 * time.Second
+)
+
+var (
+	// ErrNotLeader is the error when the server is not the leader.
+	ErrNotLeader = errors.New("this cyclone server is not the leader")
+)
+
+// elector is the leader elector of this server.
+var elector *Elector
+
+// leaderLease is the store of the leader key, etcd.Client is used in production.
+type leaderLease interface {
+	CreateWithTTL(key, value string, ttl time.Duration) error
+	CompareAndSwapWithTTL(key, value, prevValue string, ttl time.Duration) error
+	CompareAndDelete(key, prevValue string) error
+}
+
+// Elector campaigns for the leadership of cyclone servers. Only the leader
+// watches unfinished events, schedules workers and runs the post hooks.
+type Elector struct {
+	sync.RWMutex
+	id       string
+	isLeader bool
+	lease    leaderLease
+	// lead is called when the server becomes the leader, the returned func
+	// is called when the server loses the leadership.
+	lead   func() (stop func())
+	stop   func()
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// NewElector creates a elector for this server.
+func NewElector(lease leaderLease, lead func() (stop func())) *Elector {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "cyclone"
+	}
+	return &Elector{
+		id:     fmt.Sprintf("%s-%s", hostname, uuid.NewV4().String()),
+		lease:  lease,
+		lead:   lead,
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+}
+
+// Run campaigns for and renews the leadership until Stop is called.
+func (e *Elector) Run() {
+	ticker := time.NewTicker(LEADER_RENEW_INTERVAL)
+	defer ticker.Stop()
+	defer close(e.doneCh)
+
+	for {
+		e.campaign()
+
+		select {
+		case <-ticker.C:
+		case <-e.stopCh:
+			e.resign()
+			return
+		}
+	}
+}
+
+// Stop stops the elector and waits until the leadership is given up.
+func (e *Elector) Stop() {
+	close(e.stopCh)
+	<-e.doneCh
+}
+
+// IsLeader returns whether this server is the leader.
+func (e *Elector) IsLeader() bool {
+	e.RLock()
+	defer e.RUnlock()
+
+	return e.isLeader
+}
+
+// ID returns the identity of this server.
+func (e *Elector) ID() string {
+	return e.id
+}
+
+// campaign renews the leadership if this server is the leader, otherwise
+// tries to become the leader.
+func (e *Elector) campaign() {
+	if e.IsLeader() {
+		err := e.lease.CompareAndSwapWithTTL(Events_Leader, e.id, e.id, LEADER_TTL)
+		if err != nil {
+			log.Errorf("renew leadership err, step down: %v", err)
+			e.stepDown()
+		}
+		return
+	}
+
+	if err := e.lease.CreateWithTTL(Events_Leader, e.id, LEADER_TTL); err != nil {
+		return
+	}
+	log.Infof("%s becomes the leader", e.id)
+
+	e.Lock()
+	e.isLeader = true
+	e.Unlock()
+	e.stop = e.lead()
+}
+
+// stepDown stops the leader's work.
+func (e *Elector) stepDown() {
+	e.Lock()
+	e.isLeader = false
+	e.Unlock()
+
+	if e.stop != nil {
+		e.stop()
+		e.stop = nil
+	}
+}
+
+// resign gives up the leadership, so that other servers can take over at once.
+func (e *Elector) resign() {
+	if !e.IsLeader() {
+		return
+	}
+	e.stepDown()
+	if err := e.lease.CompareAndDelete(Events_Leader, e.id); err != nil {
+		log.Errorf("resign leadership err: %v", err)
+	}
+}
+
+// IsLeader returns whether this server is the leader of cyclone servers.
+func IsLeader() bool {
+	return elector != nil && elector.IsLeader()
+}
+
+// Stop gives up the leadership of this server, so that other servers can take
+// over without waiting for the leader key to expire.
+func Stop() {
+	if elector != nil {
+		elector.Stop()
+	}
+}
+
+// Leader returns the identity of the current leader.
+func Leader() (string, error) {
+	return etcd.GetClient().Get(Events_Leader)
+}
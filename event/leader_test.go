@@ -0,0 +1,88 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeLease is a in memory leaderLease for testing.
+type fakeLease struct {
+	values map[string]string
+}
+
+func (l *fakeLease) CreateWithTTL(key, value string, ttl time.Duration) error {
+	if _, ok := l.values[key]; ok {
+		return errors.New("key exists")
+	}
+	l.values[key] = value
+	return nil
+}
+
+func (l *fakeLease) CompareAndSwapWithTTL(key, value, prevValue string, ttl time.Duration) error {
+	if l.values[key] != prevValue {
+		return errors.New("compare failed")
+	}
+	l.values[key] = value
+	return nil
+}
+
+func (l *fakeLease) CompareAndDelete(key, prevValue string) error {
+	if l.values[key] != prevValue {
+		return errors.New("compare failed")
+	}
+	delete(l.values, key)
+	return nil
+}
+
+// TestElectorTakeOver tests that only one elector leads, and the other one
+// takes over when the leader key expires.
+func TestElectorTakeOver(t *testing.T) {
+	lease := &fakeLease{values: make(map[string]string)}
+	running := map[string]bool{}
+	newTestElector := func(name string) *Elector {
+		return NewElector(lease, func() func() {
+			running[name] = true
+			return func() { running[name] = false }
+		})
+	}
+	e1, e2 := newTestElector("e1"), newTestElector("e2")
+
+	e1.campaign()
+	e2.campaign()
+	if !e1.IsLeader() || e2.IsLeader() || !running["e1"] || running["e2"] {
+		t.Fatalf("Expect e1 to be the only leader")
+	}
+
+	// The leader key expires, and e2 becomes the leader.
+	delete(lease.values, Events_Leader)
+	e2.campaign()
+	e1.campaign()
+	if e1.IsLeader() || !e2.IsLeader() || running["e1"] || !running["e2"] {
+		t.Fatalf("Expect e2 to take over the leadership")
+	}
+
+	e2.resign()
+	if e2.IsLeader() || running["e2"] {
+		t.Errorf("Expect e2 to give up the leadership")
+	}
+	if _, ok := lease.values[Events_Leader]; ok {
+		t.Errorf("Expect leader key to be deleted")
+	}
+}
@@ -17,7 +17,6 @@ limitations under the License.
 package event
 
 import (
-	"container/list"
 	"encoding/json"
 	"sync"
 	"time"
@@ -51,9 +50,9 @@ var (
 // Init init event manager
 // Step1: init event operation map
 // Step2: new a etcd client
-// Step3: load unfinished events from etcd
-// Step4: create a unfinished events watcher
-// Step5: new a remote api manager
+// Step3: new a remote api manager
+// Step4: campaign for leadership, the leader loads unfinished events and the
+// pending queue from etcd, and creates a unfinished events watcher
 func Init(certPath string, registry api.RegistryCompose) {
 	certPathWorker = certPath
 	registryWorker = registry
@@ -70,26 +69,68 @@ func Init(certPath string, registry api.RegistryCompose) {
 		}
 	}
 
-	GetList().loadListFromEtcd(etcdClient)
-	initPendingQueue()
+	if !etcdClient.IsDirExist(Events_Queue) {
+		err := etcdClient.CreateDir(Events_Queue)
+		if err != nil {
+			log.Errorf("init event manager create queue dir err: %v", err)
+			return
+		}
+	}
 
-	go watchEtcd(etcdClient)
-	go handlePendingEvents()
+	if !etcdClient.IsDirExist(Events_QueueRequests) {
+		err := etcdClient.CreateDir(Events_QueueRequests)
+		if err != nil {
+			log.Errorf("init event manager create queue requests dir err: %v", err)
+			return
+		}
+	}
 
 	remoteManager = remote.NewManager()
 	resourceManager = resource.NewManager()
+
+	elector = NewElector(etcdClient, lead)
+	go elector.Run()
+}
+
+// lead starts the leader's work: loads unfinished events and the pending
+// queue from etcd, watches the unfinished events and the queue changes
+// requested by the other servers, handles pending events,
+// reconciles the resource of worker nodes, probes them, tracks the timeout
+// of running events and expires the approvals timed out. The returned func stops the work when the leadership is lost.
+func lead() func() {
+	etcdClient := etcd.GetClient()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	GetList().clear()
+	timeouts.Reset()
+	GetList().loadListFromEtcd(etcdClient)
+	initPendingQueue(etcdClient)
+
+	go watchEtcd(ctx, etcdClient)
+	go watchQueueRequests(ctx, etcdClient)
+	go handlePendingEvents(ctx)
+	go reconcileWorkerNodes(ctx)
+	go probeWorkerNodes(ctx)
+	go timeouts.Run(ctx)
+	go expireApprovals(ctx)
+
+	return cancel
 }
 
 // watchEtcd watch unfinished events status change in etcd
-func watchEtcd(etcdClient *etcd.Client) {
+func watchEtcd(ctx context.Context, etcdClient *etcd.Client) {
 	watcherUnfinishedEvents, err := etcdClient.CreateWatcher(Events_Unfinished)
 	if err != nil {
 		log.Fatalf("watch unfinshed events err: %v", err)
 	}
 
 	for {
-		change, err := watcherUnfinishedEvents.Next(context.Background())
+		change, err := watcherUnfinishedEvents.Next(ctx)
 		if err != nil {
+			if ctx.Err() != nil {
+				log.Info("stop watching unfinished events")
+				return
+			}
 			log.Fatalf("watch unfinshed events next err: %v", err)
 		}
 
@@ -149,8 +190,22 @@ func eventCreateHandler(event *api.Event) {
 func eventChangeHandler(event *api.Event, preEvent *api.Event) {
 	GetList().addUnfinshedEvent(event)
 
+	// event is requeued
+	if preEvent.Status != api.EventStatusPending && event.Status == api.EventStatusPending {
+		pendingEvents.In(event)
+	}
+
 	// event handle finished
 	if !IsEventFinished(preEvent) && IsEventFinished(event) {
+		timeouts.Remove(event.EventID)
+		// The event may be cancelled before it is enqueued.
+		pendingEvents.Remove(event.EventID)
+		if shouldRetry(event) {
+			releaseWorker(event)
+			retryEvent(event)
+			return
+		}
+		recordAttempt(event)
 		postHookEvent(event)
 		etcdClient := etcd.GetClient()
 		err := etcdClient.Delete(Events_Unfinished + "/" + string(event.EventID))
@@ -224,8 +279,13 @@ func newList() *List {
 	}
 }
 
+// eventLister lists the events stored in etcd, etcd.Client is used in production.
+type eventLister interface {
+	List(dir string) ([]string, error)
+}
+
 // loadListFromEtcd load event list from etcd
-func (el *List) loadListFromEtcd(etcd *etcd.Client) {
+func (el *List) loadListFromEtcd(etcd eventLister) {
 	jsonEvents, err := etcd.List(Events_Unfinished)
 	if err != nil {
 		log.Errorf("load event list from etcd err: %v", err)
@@ -242,7 +302,7 @@ func (el *List) loadListFromEtcd(etcd *etcd.Client) {
 		if event.Status == api.EventStatusPending {
 			el.addUnfinshedEvent(&event)
 		} else {
-			go CheckWorkerTimeOut(event)
+			trackWorkerTimeOut(&event)
 		}
 	}
 }
@@ -259,6 +319,13 @@ func loadEventFromJSON(jsonEvent string) (api.Event, error) {
 	return event, nil
 }
 
+// clear removes all events from list.
+func (el *List) clear() {
+	el.Lock()
+	defer el.Unlock()
+	el.events = make(map[api.EventID]*api.Event)
+}
+
 // addUnfinshedEvent adds unfinished event to list.
 func (el *List) addUnfinshedEvent(event *api.Event) {
 	if event.EventID != "" {
@@ -289,56 +356,24 @@ func (el *List) GetEvent(eventID api.EventID) *api.Event {
 	return event
 }
 
-var pendingEvents Queue
-
-// Queue is the type for pending events.
-type Queue struct {
-	sync.RWMutex
-	queue *list.List
-}
-
-// Init initializes a queue.
-func (eq *Queue) Init() {
-	eq.queue = list.New()
-}
-
-// In enqueues a event.
-func (eq *Queue) In(event *api.Event) {
-	eq.Lock()
-	defer eq.Unlock()
-
-	eq.queue.PushBack(event)
-}
-
-// GetFront get the first event in the queue.
-func (eq *Queue) GetFront() *api.Event {
-	eq.RLock()
-	defer eq.RUnlock()
-
-	element := eq.queue.Front()
-	return element.Value.(*api.Event)
-}
-
-// Out dequeues a event.
-func (eq *Queue) Out() {
-	eq.Lock()
-	defer eq.Unlock()
-
-	element := eq.queue.Front()
-	eq.queue.Remove(element)
-}
-
-// IsEmpty checks if the queue is empty.
-func (eq *Queue) IsEmpty() bool {
-	eq.RLock()
-	defer eq.RUnlock()
+// eventsOnDockerHost gets the events which have been dispatched to the docker host.
+func (el *List) eventsOnDockerHost(dockerHost string) []*api.Event {
+	el.RLock()
+	defer el.RUnlock()
 
-	return eq.queue.Len() == 0
+	var events []*api.Event
+	for _, event := range el.events {
+		if event.WorkerInfo.DockerHost == dockerHost {
+			events = append(events, event)
+		}
+	}
+	return events
 }
 
-// initPendingQueue initializes the pending events queue.
-func initPendingQueue() {
-	pendingEvents.Init()
+// initPendingQueue initializes the pending events queue, and restores the
+// order of the events from etcd.
+func initPendingQueue(etcdClient *etcd.Client) {
+	pendingEvents.Init(etcdClient)
 
 	eventList.RLock()
 	defer eventList.RUnlock()
@@ -348,27 +383,39 @@ func initPendingQueue() {
 			pendingEvents.In(event)
 		}
 	}
+	pendingEvents.prune()
 }
 
-// handlePendingEvents polls event queues and handle events one by one.
-func handlePendingEvents() {
+// handlePendingEvents polls event queues and handle events one by one, until
+// the context is cancelled.
+func handlePendingEvents(ctx context.Context) {
 	for {
-		if pendingEvents.IsEmpty() {
+		if ctx.Err() != nil {
+			log.Info("stop handling pending events")
+			return
+		}
+
+		pendingEvent := pendingEvents.Dispatch()
+		if pendingEvent == nil {
 			time.Sleep(time.Second * 1)
 			continue
 		}
 
-		event := *pendingEvents.GetFront()
+		event := *pendingEvent
+		dispatchLock.Lock()
 		err := handleEvent(&event)
+		dispatchLock.Unlock()
 		if err != nil {
 			if err == resource.ErrUnableSupport {
 				log.Info("Waiting for resource to be relaesed...")
+				pendingEvents.Release()
 				time.Sleep(time.Second * 10)
 				continue
 			}
 			// worker busy
 			if err == ErrWorkerBusy {
 				log.Info("All system worker are busy, wait for 10 seconds")
+				pendingEvents.Release()
 				time.Sleep(time.Second * 10)
 				continue
 			}
@@ -378,7 +425,14 @@ func handlePendingEvents() {
 
 			event.Status = api.EventStatusFail
 			event.ErrorMessage = err.Error()
+			event.ErrorClass = api.ErrorClassInfrastructure
 			log.ErrorWithFields("handle event err", log.Fields{"error": err, "event": event})
+			// The resources have been released when the worker failed to start.
+			if retryEvent(&event) {
+				pendingEvents.In(&event)
+				continue
+			}
+			recordAttempt(&event)
 			postHookEvent(&event)
 			etcdClient := etcd.GetClient()
 			err := etcdClient.Delete(Events_Unfinished + "/" + string(event.EventID))
@@ -388,9 +442,11 @@ func handlePendingEvents() {
 			continue
 		}
 
-		// remove the event from queue which had run
-		pendingEvents.Out()
+		// Save the event as running before it leaves the queue, so that it can
+		// not be cancelled as a pending event in between.
 		event.Status = api.EventStatusRunning
 		SaveEventToEtcd(&event)
+		// remove the event from queue which had run
+		pendingEvents.Out()
 	}
 }
@@ -0,0 +1,248 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/pkg/log"
+	"github.com/caicloud/cyclone/pkg/osutil"
+	"gopkg.in/mgo.v2"
+)
+
+const (
+	// WORKER_PLACEMENT_STRATEGY is the strategy to place workers on nodes.
+	WORKER_PLACEMENT_STRATEGY = "WORKER_PLACEMENT_STRATEGY"
+)
+
+// Names of the placement strategies.
+const (
+	// LeastLoadedStrategy places a worker on the node with the most free resource in proportion.
+	LeastLoadedStrategy = "least-loaded"
+	// SpreadStrategy places workers on nodes in turn.
+	SpreadStrategy = "spread"
+	// BinPackingStrategy places a worker on the node with the least free resource,
+	// to leave room for large workers.
+	BinPackingStrategy = "bin-packing"
+	// AffinityStrategy places a worker on the node whose labels match the node
+	// selector best, to leave the special nodes for the workers require them.
+	AffinityStrategy = "affinity"
+)
+
+// placementStrategy is the strategy used by this server.
+var placementStrategy PlacementStrategy
+
+// nodeStore is the store of worker nodes, store.DataStore is used in production.
+type nodeStore interface {
+	FindSystemWorkerNodeByResource(resource *api.BuildResource, selector map[string]string) ([]api.WorkerNode, error)
+	ReserveWorkerNodeResource(nodeID string, resource *api.BuildResource) (*api.WorkerNode, error)
+}
+
+// PlacementStrategy decides which worker node a worker is placed on.
+type PlacementStrategy interface {
+	// Prioritize sorts the candidate nodes, the node in front is tried first. All
+	// the candidates have enough resource and all the labels in the selector.
+	Prioritize(nodes []api.WorkerNode, selector map[string]string)
+	// Placed is called when a worker is placed on the node.
+	Placed(node *api.WorkerNode)
+}
+
+// NewPlacementStrategy creates a placement strategy by name, the least loaded
+// strategy is used if the name is unknown.
+func NewPlacementStrategy(name string) PlacementStrategy {
+	switch name {
+	case LeastLoadedStrategy:
+		return &leastLoaded{}
+	case SpreadStrategy:
+		return &spread{}
+	case BinPackingStrategy:
+		return &binPacking{}
+	case AffinityStrategy:
+		return &affinity{}
+	default:
+		log.Warnf("Unknown placement strategy %s, use %s", name, LeastLoadedStrategy)
+		return &leastLoaded{}
+	}
+}
+
+// getPlacementStrategy gets the placement strategy configured by env.
+func getPlacementStrategy() PlacementStrategy {
+	if placementStrategy == nil {
+		placementStrategy = NewPlacementStrategy(osutil.GetStringEnv(WORKER_PLACEMENT_STRATEGY, LeastLoadedStrategy))
+	}
+	return placementStrategy
+}
+
+// placeWorker finds the worker nodes which can afford the resource, and reserves
+// the resource on the first node prioritized by the strategy. It returns
+// ErrWorkerBusy if no node can afford the resource.
+func placeWorker(ns nodeStore, strategy PlacementStrategy, resource *api.BuildResource,
+	selector map[string]string) (*api.WorkerNode, error) {
+	nodes, err := ns.FindSystemWorkerNodeByResource(resource, selector)
+	if err != nil {
+		return nil, err
+	}
+	strategy.Prioritize(nodes, selector)
+
+	// The nodes may be taken by others after found, try them one by one until
+	// the resource is reserved.
+	for i := range nodes {
+		_, err = ns.ReserveWorkerNodeResource(nodes[i].NodeID, resource)
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		strategy.Placed(&nodes[i])
+		return &nodes[i], nil
+	}
+	return nil, ErrWorkerBusy
+}
+
+// nodeSelector gets the labels the worker node must have for the event, labels
+// of the version override the service's.
+func nodeSelector(event *api.Event) map[string]string {
+	selector := make(map[string]string)
+	for key, value := range event.Service.NodeSelector {
+		selector[key] = value
+	}
+	for key, value := range event.Version.NodeSelector {
+		selector[key] = value
+	}
+	return selector
+}
+
+// freeRatio returns the proportion of free resource of a node, the smaller one
+// of memory and cpu.
+func freeRatio(node *api.WorkerNode) float64 {
+	ratio := func(left, total float64) float64 {
+		if total <= 0 {
+			return 0
+		}
+		return left / total
+	}
+	memory := ratio(node.LeftResource.Memory, node.TotalResource.Memory)
+	cpu := ratio(node.LeftResource.CPU, node.TotalResource.CPU)
+	if memory < cpu {
+		return memory
+	}
+	return cpu
+}
+
+// nodeSorter sorts worker nodes by the less func.
+type nodeSorter struct {
+	nodes []api.WorkerNode
+	less  func(a, b *api.WorkerNode) bool
+}
+
+func (s *nodeSorter) Len() int           { return len(s.nodes) }
+func (s *nodeSorter) Swap(i, j int)      { s.nodes[i], s.nodes[j] = s.nodes[j], s.nodes[i] }
+func (s *nodeSorter) Less(i, j int) bool { return s.less(&s.nodes[i], &s.nodes[j]) }
+
+// sortNodes sorts worker nodes stably by the less func.
+func sortNodes(nodes []api.WorkerNode, less func(a, b *api.WorkerNode) bool) {
+	sort.Stable(&nodeSorter{nodes: nodes, less: less})
+}
+
+// leastLoaded prefers the node with the most free resource in proportion.
+type leastLoaded struct{}
+
+// Prioritize implements PlacementStrategy.
+func (s *leastLoaded) Prioritize(nodes []api.WorkerNode, selector map[string]string) {
+	sortNodes(nodes, func(a, b *api.WorkerNode) bool {
+		return freeRatio(a) > freeRatio(b)
+	})
+}
+
+// Placed implements PlacementStrategy.
+func (s *leastLoaded) Placed(node *api.WorkerNode) {}
+
+// spread places workers on nodes in turn, ordered by node ID.
+type spread struct {
+	sync.Mutex
+	// last is the ID of the node placed on last time.
+	last string
+}
+
+// Prioritize implements PlacementStrategy.
+func (s *spread) Prioritize(nodes []api.WorkerNode, selector map[string]string) {
+	s.Lock()
+	last := s.last
+	s.Unlock()
+
+	// Nodes after the last one go first, then the others.
+	sortNodes(nodes, func(a, b *api.WorkerNode) bool {
+		aAfter, bAfter := a.NodeID > last, b.NodeID > last
+		if aAfter != bAfter {
+			return aAfter
+		}
+		return a.NodeID < b.NodeID
+	})
+}
+
+// Placed implements PlacementStrategy.
+func (s *spread) Placed(node *api.WorkerNode) {
+	s.Lock()
+	defer s.Unlock()
+	s.last = node.NodeID
+}
+
+// binPacking prefers the node with the least free resource.
+type binPacking struct{}
+
+// Prioritize implements PlacementStrategy.
+func (s *binPacking) Prioritize(nodes []api.WorkerNode, selector map[string]string) {
+	sortNodes(nodes, func(a, b *api.WorkerNode) bool {
+		if a.LeftResource.Memory != b.LeftResource.Memory {
+			return a.LeftResource.Memory < b.LeftResource.Memory
+		}
+		return a.LeftResource.CPU < b.LeftResource.CPU
+	})
+}
+
+// Placed implements PlacementStrategy.
+func (s *binPacking) Placed(node *api.WorkerNode) {}
+
+// affinity prefers the node with the fewest labels not required by the selector,
+// then the least loaded one.
+type affinity struct{}
+
+// Prioritize implements PlacementStrategy.
+func (s *affinity) Prioritize(nodes []api.WorkerNode, selector map[string]string) {
+	extraLabels := func(node *api.WorkerNode) int {
+		count := 0
+		for key := range node.Labels {
+			if _, ok := selector[key]; !ok {
+				count++
+			}
+		}
+		return count
+	}
+	sortNodes(nodes, func(a, b *api.WorkerNode) bool {
+		aExtra, bExtra := extraLabels(a), extraLabels(b)
+		if aExtra != bExtra {
+			return aExtra < bExtra
+		}
+		return freeRatio(a) > freeRatio(b)
+	})
+}
+
+// Placed implements PlacementStrategy.
+func (s *affinity) Placed(node *api.WorkerNode) {}
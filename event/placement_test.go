@@ -0,0 +1,155 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"testing"
+
+	"github.com/caicloud/cyclone/api"
+	"gopkg.in/mgo.v2"
+)
+
+// fakeNodeStore is a in memory nodeStore for testing.
+type fakeNodeStore struct {
+	nodes []api.WorkerNode
+}
+
+func (s *fakeNodeStore) FindSystemWorkerNodeByResource(resource *api.BuildResource,
+	selector map[string]string) ([]api.WorkerNode, error) {
+	var nodes []api.WorkerNode
+	for _, node := range s.nodes {
+		if node.LeftResource.Memory < resource.Memory || node.LeftResource.CPU < resource.CPU {
+			continue
+		}
+		matched := true
+		for key, value := range selector {
+			if node.Labels[key] != value {
+				matched = false
+			}
+		}
+		if matched {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil
+}
+
+func (s *fakeNodeStore) ReserveWorkerNodeResource(nodeID string, resource *api.BuildResource) (*api.WorkerNode, error) {
+	for i := range s.nodes {
+		node := &s.nodes[i]
+		if node.NodeID != nodeID {
+			continue
+		}
+		if node.LeftResource.Memory < resource.Memory || node.LeftResource.CPU < resource.CPU {
+			return nil, mgo.ErrNotFound
+		}
+		node.LeftResource.Memory -= resource.Memory
+		node.LeftResource.CPU -= resource.CPU
+		return node, nil
+	}
+	return nil, mgo.ErrNotFound
+}
+
+// newFakeNodeStore creates a fake store with three nodes:
+//   n1: 4G memory, 1G left
+//   n2: 2G memory, 2G left, labeled ssd=true
+//   n3: 8G memory, 3G left, labeled ssd=true and arch=arm64
+func newFakeNodeStore() *fakeNodeStore {
+	newNode := func(id string, total, left float64, labels map[string]string) api.WorkerNode {
+		return api.WorkerNode{
+			NodeID:        id,
+			TotalResource: api.NodeResource{Memory: total, CPU: total},
+			LeftResource:  api.NodeResource{Memory: left, CPU: left},
+			Labels:        labels,
+		}
+	}
+	return &fakeNodeStore{nodes: []api.WorkerNode{
+		newNode("n1", 4096, 1024, nil),
+		newNode("n2", 2048, 2048, map[string]string{"ssd": "true"}),
+		newNode("n3", 8192, 3072, map[string]string{"ssd": "true", "arch": "arm64"}),
+	}}
+}
+
+// expectPlaced places a worker and checks the node it is placed on.
+func expectPlaced(t *testing.T, ns nodeStore, strategy PlacementStrategy, memory float64,
+	selector map[string]string, expect string) {
+	resource := &api.BuildResource{Memory: memory, CPU: memory}
+	node, err := placeWorker(ns, strategy, resource, selector)
+	if expect == "" {
+		if err != ErrWorkerBusy {
+			t.Errorf("Expect error %v, got node %v and error %v", ErrWorkerBusy, node, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Expect worker to be placed on %s, got error %v", expect, err)
+	}
+	if node.NodeID != expect {
+		t.Errorf("Expect worker to be placed on %s, got %s", expect, node.NodeID)
+	}
+}
+
+// TestLeastLoadedStrategy tests the least loaded strategy.
+func TestLeastLoadedStrategy(t *testing.T) {
+	ns := newFakeNodeStore()
+	strategy := NewPlacementStrategy(LeastLoadedStrategy)
+	expectPlaced(t, ns, strategy, 1024, nil, "n2")
+	expectPlaced(t, ns, strategy, 1024, nil, "n2")
+	expectPlaced(t, ns, strategy, 1024, nil, "n3")
+}
+
+// TestSpreadStrategy tests the spread strategy.
+func TestSpreadStrategy(t *testing.T) {
+	ns := newFakeNodeStore()
+	strategy := NewPlacementStrategy(SpreadStrategy)
+	expectPlaced(t, ns, strategy, 256, nil, "n1")
+	expectPlaced(t, ns, strategy, 256, nil, "n2")
+	expectPlaced(t, ns, strategy, 256, nil, "n3")
+	expectPlaced(t, ns, strategy, 256, nil, "n1")
+}
+
+// TestBinPackingStrategy tests the bin packing strategy.
+func TestBinPackingStrategy(t *testing.T) {
+	ns := newFakeNodeStore()
+	strategy := NewPlacementStrategy(BinPackingStrategy)
+	expectPlaced(t, ns, strategy, 1024, nil, "n1")
+	expectPlaced(t, ns, strategy, 2048, nil, "n2")
+	expectPlaced(t, ns, strategy, 2048, nil, "n3")
+	expectPlaced(t, ns, strategy, 2048, nil, "")
+}
+
+// TestAffinityStrategy tests the affinity strategy and the node selector.
+func TestAffinityStrategy(t *testing.T) {
+	ns := newFakeNodeStore()
+	strategy := NewPlacementStrategy(AffinityStrategy)
+	expectPlaced(t, ns, strategy, 512, nil, "n1")
+	expectPlaced(t, ns, strategy, 512, map[string]string{"ssd": "true"}, "n2")
+	expectPlaced(t, ns, strategy, 512, map[string]string{"arch": "arm64"}, "n3")
+	expectPlaced(t, ns, strategy, 512, map[string]string{"arch": "amd64"}, "")
+}
+
+// TestNodeSelector tests that labels of the version override the service's.
+func TestNodeSelector(t *testing.T) {
+	event := &api.Event{
+		Service: api.Service{NodeSelector: map[string]string{"arch": "amd64", "ssd": "true"}},
+		Version: api.Version{NodeSelector: map[string]string{"arch": "arm64"}},
+	}
+	selector := nodeSelector(event)
+	if len(selector) != 2 || selector["arch"] != "arm64" || selector["ssd"] != "true" {
+		t.Errorf("Expect selector arch=arm64 and ssd=true, got %v", selector)
+	}
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"time"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/docker"
+	"github.com/caicloud/cyclone/etcd"
+	"github.com/caicloud/cyclone/pkg/log"
+	"github.com/caicloud/cyclone/pkg/osutil"
+	"github.com/caicloud/cyclone/store"
+	"golang.org/x/net/context"
+)
+
+const (
+	// PROBE_INTERVAL is the interval in seconds to probe worker nodes.
+	PROBE_INTERVAL = "PROBE_INTERVAL"
+
+	// NODE_UNREACHABLE_TIMEOUT is the time in seconds after which a worker node
+	// not responding is marked as unreachable.
+	NODE_UNREACHABLE_TIMEOUT = "NODE_UNREACHABLE_TIMEOUT"
+
+	// MAX_NODE_REQUEUES is the max times a event is requeued because its worker
+	// node is unreachable, the event fails after that.
+	MAX_NODE_REQUEUES = "MAX_NODE_REQUEUES"
+)
+
+// probeWorkerNodes pings the docker daemon of worker nodes periodically, until
+// the context is cancelled.
+func probeWorkerNodes(ctx context.Context) {
+	interval := time.Duration(osutil.GetIntEnv(PROBE_INTERVAL, 30)) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("stop probing worker nodes")
+			return
+		case <-ticker.C:
+			probeOnce()
+		}
+	}
+}
+
+// workerNodeStore is the store of the worker nodes probed, cordoned and drained,
+// store.DataStore is used in production.
+type workerNodeStore interface {
+	UpdateWorkerNodeStatus(nodeID string, status api.WorkerNodeStatus, lastSeen time.Time) error
+	SetWorkerNodeUnschedulable(nodeID string, unschedulable bool) (*api.WorkerNode, error)
+}
+
+// eventMover moves the running events off the worker nodes, etcdEventMover is
+// used in production.
+type eventMover interface {
+	// RunningEvents returns the events running on the workers.
+	RunningEvents() ([]api.Event, error)
+	// Requeue puts the event back to pending, its worker is fired if fire is
+	// true, and the requeue is counted if count is true.
+	Requeue(event *api.Event, fire bool, count bool)
+	// Fail fails the event with the message.
+	Fail(event *api.Event, message string)
+}
+
+// probeOnce probes all system worker nodes.
+func probeOnce() {
+	ds := store.NewStore()
+	defer ds.Close()
+
+	nodes, err := ds.FindSystemWorkerNode()
+	if err != nil {
+		log.Errorf("find system worker nodes err: %v", err)
+		return
+	}
+
+	timeout := time.Duration(osutil.GetIntEnv(NODE_UNREACHABLE_TIMEOUT, 90)) * time.Second
+	for _, node := range nodes {
+		probeWorkerNode(ds, etcdEventMover{}, &node, pingWorkerNode(&node), time.Now(), timeout)
+	}
+}
+
+// pingWorkerNode pings the docker daemon of the worker node.
+func pingWorkerNode(node *api.WorkerNode) error {
+	dm, err := docker.NewManager(node.DockerHost, "", registryWorker)
+	if err != nil {
+		return err
+	}
+	return dm.Client.Ping()
+}
+
+// probeWorkerNode records the status of the worker node by the result of the
+// ping. The events running on the node are requeued or failed if the node has
+// not responded for the timeout.
+func probeWorkerNode(ns workerNodeStore, mover eventMover, node *api.WorkerNode, pingErr error,
+	now time.Time, timeout time.Duration) {
+	if pingErr == nil {
+		if err := ns.UpdateWorkerNodeStatus(node.NodeID, api.WorkerNodeReady, now); err != nil {
+			log.Errorf("update worker node %s status err: %v", node.Name, err)
+		}
+		if node.Status == api.WorkerNodeUnreachable {
+			log.Infof("worker node %s is ready again", node.Name)
+		}
+		return
+	}
+
+	log.ErrorWithFields("probe worker node err", log.Fields{"node": node.Name, "error": pingErr})
+	if now.Sub(node.LastSeen) < timeout {
+		return
+	}
+
+	if node.Status != api.WorkerNodeUnreachable {
+		log.Warnf("worker node %s is unreachable since %v", node.Name, node.LastSeen)
+		if err := ns.UpdateWorkerNodeStatus(node.NodeID, api.WorkerNodeUnreachable, time.Time{}); err != nil {
+			log.Errorf("update worker node %s status err: %v", node.Name, err)
+		}
+	}
+	evacuateWorkerNode(mover, node, false)
+}
+
+// evacuateWorkerNode moves the running events off the worker node. When the node
+// is drained, the events are requeued and their workers are fired. When the node
+// is unreachable, the events are requeued until MAX_NODE_REQUEUES, then failed.
+// The running events are read from etcd, as the leader only lists the pending
+// ones in memory after it is elected.
+func evacuateWorkerNode(mover eventMover, node *api.WorkerNode, drain bool) {
+	events, err := mover.RunningEvents()
+	if err != nil {
+		log.Errorf("find running events on worker node %s err: %v", node.Name, err)
+		return
+	}
+
+	maxRequeues := osutil.GetIntEnv(MAX_NODE_REQUEUES, 1)
+	for i := range events {
+		event := &events[i]
+		if event.WorkerInfo.DockerHost != node.DockerHost {
+			continue
+		}
+
+		if drain {
+			log.Infof("requeue event %s as worker node %s is drained", event.EventID, node.Name)
+			mover.Requeue(event, true, false)
+		} else if event.WorkerInfo.Requeues < maxRequeues {
+			log.Infof("requeue event %s as worker node %s is unreachable", event.EventID, node.Name)
+			mover.Requeue(event, false, true)
+		} else {
+			log.Infof("fail event %s as worker node %s is unreachable", event.EventID, node.Name)
+			mover.Fail(event, "worker node "+node.Name+" is unreachable")
+		}
+	}
+}
+
+// etcdEventMover moves the events saved in etcd.
+type etcdEventMover struct{}
+
+// RunningEvents lists the unfinished events in etcd, and returns the running ones.
+func (etcdEventMover) RunningEvents() ([]api.Event, error) {
+	jsonEvents, err := etcd.GetClient().List(Events_Unfinished)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []api.Event{}
+	for _, jsonEvent := range jsonEvents {
+		event, err := loadEventFromJSON(jsonEvent)
+		if err != nil {
+			continue
+		}
+		if event.Status == api.EventStatusRunning {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// Requeue releases the resource of the event and puts it back to pending, the
+// event is enqueued again when the change is watched.
+func (etcdEventMover) Requeue(event *api.Event, fire bool, count bool) {
+	if fire {
+		if w, err := LoadWorker(event); err == nil {
+			w.Fire()
+		}
+	}
+	if err := resourceManager.ReleaseResource(event); err != nil {
+		log.Errorf("Unable to release resource %v", err)
+	}
+	releaseWorkerNodeResource(event)
+
+	requeues := event.WorkerInfo.Requeues
+	if count {
+		requeues++
+	}
+	event.WorkerInfo = api.WorkerInfo{Requeues: requeues}
+	event.Status = api.EventStatusPending
+	if err := SaveEventToEtcd(event); err != nil {
+		log.Errorf("save event %s err: %v", event.EventID, err)
+	}
+}
+
+// Fail saves the event failed by the infrastructure, its post hook runs when the
+// change is watched.
+func (etcdEventMover) Fail(event *api.Event, message string) {
+	event.Status = api.EventStatusFail
+	event.ErrorMessage = message
+	event.ErrorClass = api.ErrorClassInfrastructure
+	if err := SaveEventToEtcd(event); err != nil {
+		log.Errorf("save event %s err: %v", event.EventID, err)
+	}
+}
+
+// CordonWorkerNode cordons or uncordons the worker node, the running workers on
+// it are not affected.
+func CordonWorkerNode(nodeID string, unschedulable bool) (*api.WorkerNode, error) {
+	ds := store.NewStore()
+	defer ds.Close()
+	return ds.SetWorkerNodeUnschedulable(nodeID, unschedulable)
+}
+
+// DrainWorkerNode cordons the worker node, and requeues the events running on it.
+func DrainWorkerNode(nodeID string) (*api.WorkerNode, error) {
+	if !IsLeader() {
+		return nil, ErrNotLeader
+	}
+
+	ds := store.NewStore()
+	defer ds.Close()
+	return drainWorkerNode(ds, etcdEventMover{}, nodeID)
+}
+
+// drainWorkerNode cordons the worker node, and requeues the events running on it.
+func drainWorkerNode(ns workerNodeStore, mover eventMover, nodeID string) (*api.WorkerNode, error) {
+	node, err := ns.SetWorkerNodeUnschedulable(nodeID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	evacuateWorkerNode(mover, node, true)
+	return node, nil
+}
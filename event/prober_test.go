@@ -0,0 +1,143 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/caicloud/cyclone/api"
+)
+
+// fakeProbedStore keeps the worker nodes probed in memory.
+type fakeProbedStore struct {
+	nodes map[string]*api.WorkerNode
+}
+
+func (s *fakeProbedStore) UpdateWorkerNodeStatus(nodeID string, status api.WorkerNodeStatus, lastSeen time.Time) error {
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return errors.New("not found")
+	}
+	node.Status = status
+	if !lastSeen.IsZero() {
+		node.LastSeen = lastSeen
+	}
+	return nil
+}
+
+func (s *fakeProbedStore) SetWorkerNodeUnschedulable(nodeID string, unschedulable bool) (*api.WorkerNode, error) {
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	node.Unschedulable = unschedulable
+	copied := *node
+	return &copied, nil
+}
+
+// fakeMover records the events moved off the worker nodes.
+type fakeMover struct {
+	events   []api.Event
+	requeued []string
+	failed   []string
+}
+
+func (m *fakeMover) RunningEvents() ([]api.Event, error) {
+	return m.events, nil
+}
+
+func (m *fakeMover) Requeue(event *api.Event, fire bool, count bool) {
+	m.requeued = append(m.requeued, string(event.EventID))
+	if count {
+		m.requeued[len(m.requeued)-1] += "+1"
+	}
+}
+
+func (m *fakeMover) Fail(event *api.Event, message string) {
+	m.failed = append(m.failed, string(event.EventID))
+}
+
+// newProbedNode creates the store of the node n1 on docker host h1, and the
+// events e1 and e2 running on it and e3 running on h2.
+func newProbedNode(lastSeen time.Time) (*fakeProbedStore, *fakeMover) {
+	ns := &fakeProbedStore{nodes: map[string]*api.WorkerNode{
+		"n1": {NodeID: "n1", Name: "n1", DockerHost: "h1", Status: api.WorkerNodeReady, LastSeen: lastSeen},
+	}}
+	mover := &fakeMover{events: []api.Event{
+		{EventID: "e1", WorkerInfo: api.WorkerInfo{DockerHost: "h1"}},
+		{EventID: "e2", WorkerInfo: api.WorkerInfo{DockerHost: "h1", Requeues: 1}},
+		{EventID: "e3", WorkerInfo: api.WorkerInfo{DockerHost: "h2"}},
+	}}
+	return ns, mover
+}
+
+// TestProbeWorkerNode tests the node is unreachable after the timeout, and its
+// events are requeued until the max requeues, then failed.
+func TestProbeWorkerNode(t *testing.T) {
+	os.Setenv(MAX_NODE_REQUEUES, "1")
+	defer os.Unsetenv(MAX_NODE_REQUEUES)
+
+	now := time.Now()
+	ns, mover := newProbedNode(now.Add(-time.Minute))
+	node := *ns.nodes["n1"]
+	probeWorkerNode(ns, mover, &node, errors.New("refused"), now, 90*time.Second)
+	if ns.nodes["n1"].Status != api.WorkerNodeReady || len(mover.requeued)+len(mover.failed) != 0 {
+		t.Errorf("Expect the node ready before the timeout, got %s", ns.nodes["n1"].Status)
+	}
+
+	probeWorkerNode(ns, mover, &node, errors.New("refused"), now.Add(time.Minute), 90*time.Second)
+	if ns.nodes["n1"].Status != api.WorkerNodeUnreachable {
+		t.Errorf("Expect the node unreachable, got %s", ns.nodes["n1"].Status)
+	}
+	if !reflect.DeepEqual(mover.requeued, []string{"e1+1"}) || !reflect.DeepEqual(mover.failed, []string{"e2"}) {
+		t.Errorf("Expect e1 requeued and e2 failed, got %v and %v", mover.requeued, mover.failed)
+	}
+
+	node = *ns.nodes["n1"]
+	later := now.Add(2 * time.Minute)
+	probeWorkerNode(ns, &fakeMover{}, &node, nil, later, 90*time.Second)
+	if ns.nodes["n1"].Status != api.WorkerNodeReady || !ns.nodes["n1"].LastSeen.Equal(later) {
+		t.Errorf("Expect the node ready again, got %+v", ns.nodes["n1"])
+	}
+}
+
+// TestCordonDrainWorkerNode tests the drained node is cordoned and all its running
+// events are requeued without counting, and it is schedulable after uncordoned.
+func TestCordonDrainWorkerNode(t *testing.T) {
+	ns, mover := newProbedNode(time.Now())
+	node, err := drainWorkerNode(ns, mover, "n1")
+	if err != nil {
+		t.Fatalf("Expect err %v to be nil", err)
+	}
+	if !node.Unschedulable || !ns.nodes["n1"].Unschedulable {
+		t.Errorf("Expect the node cordoned, got %+v", node)
+	}
+	if !reflect.DeepEqual(mover.requeued, []string{"e1", "e2"}) || len(mover.failed) != 0 {
+		t.Errorf("Expect e1 and e2 requeued, got %v and failed %v", mover.requeued, mover.failed)
+	}
+
+	if _, err := ns.SetWorkerNodeUnschedulable("n1", false); err != nil || ns.nodes["n1"].Unschedulable {
+		t.Errorf("Expect the node uncordoned, got %+v and %v", ns.nodes["n1"], err)
+	}
+	if _, err := drainWorkerNode(ns, mover, "n2"); err == nil {
+		t.Errorf("Expect error draining the unknown node")
+	}
+}
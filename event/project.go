@@ -0,0 +1,309 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package event
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/pkg/log"
+	"github.com/caicloud/cyclone/store"
+)
+
+var (
+	// ErrDependencyCycle is the error when the services of a project depend on
+	// each other circularly.
+	ErrDependencyCycle = errors.New("dependency cycle in project services")
+)
+
+// ResolveBuildOrder groups the services of a project into levels by their
+// dependencies: a service only depends on the services in the previous levels,
+// so the services in a level can be built at the same time. Services which
+// only appear as dependencies are members of the project as well.
+func ResolveBuildOrder(services []api.ServiceDependency) ([][]string, error) {
+	var ids []string
+	deps := make(map[string][]string)
+	var add func(service api.ServiceDependency)
+	add = func(service api.ServiceDependency) {
+		if _, ok := deps[service.ServiceID]; !ok {
+			ids = append(ids, service.ServiceID)
+			deps[service.ServiceID] = nil
+		}
+		for _, depend := range service.Depend {
+			add(depend)
+			deps[service.ServiceID] = append(deps[service.ServiceID], depend.ServiceID)
+		}
+	}
+	for _, service := range services {
+		add(service)
+	}
+
+	var levels [][]string
+	built := make(map[string]bool)
+	for len(built) < len(ids) {
+		var level []string
+		for _, id := range ids {
+			if built[id] {
+				continue
+			}
+			ready := true
+			for _, depend := range deps[id] {
+				if !built[depend] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				level = append(level, id)
+			}
+		}
+		if len(level) == 0 {
+			return nil, ErrDependencyCycle
+		}
+		for _, id := range level {
+			built[id] = true
+		}
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
+
+// newProjectTasks creates a pending task for every service of the project
+// version, in the build order.
+func newProjectTasks(projectVersion *api.ProjectVersion, levels [][]string) []api.Version {
+	var tasks []api.Version
+	for _, level := range levels {
+		for _, serviceID := range level {
+			tasks = append(tasks, api.Version{
+				ServiceID:        serviceID,
+				Name:             projectVersion.Name,
+				Description:      projectVersion.Description,
+				Status:           api.VersionPending,
+				ProjectVersionID: projectVersion.VersionID,
+			})
+		}
+	}
+	return tasks
+}
+
+// projectProgress checks the tasks of the project version level by level. It
+// returns the indexes of the tasks to start when the previous levels are all
+// built, and the status of the project version. The error describes the
+// failed task if any.
+func projectProgress(projectVersion *api.ProjectVersion, levels [][]string) ([]int, api.VersionStatus, error) {
+	tasks := make(map[string]int)
+	for i, task := range projectVersion.Tasks {
+		tasks[task.ServiceID] = i
+	}
+
+	for _, level := range levels {
+		var start []int
+		healthy := true
+		for _, serviceID := range level {
+			i, ok := tasks[serviceID]
+			if !ok {
+				return nil, api.VersionFailed, fmt.Errorf("no task for service %s", serviceID)
+			}
+			task := projectVersion.Tasks[i]
+			switch task.Status {
+			case api.VersionHealthy:
+				continue
+			case api.VersionFailed, api.VersionCancel:
+				return nil, api.VersionFailed, fmt.Errorf("version of service %s is %s: %s",
+					serviceID, task.Status, task.ErrorMessage)
+			}
+			healthy = false
+			if task.VersionID == "" {
+				start = append(start, i)
+			}
+		}
+		if !healthy {
+			return start, api.VersionRunning, nil
+		}
+	}
+	return nil, api.VersionHealthy, nil
+}
+
+// scheduleProjectTasks starts the tasks of the project version which are ready
+// to build, and returns the status of the project version.
+func scheduleProjectTasks(ds *store.DataStore, projectVersion *api.ProjectVersion) (api.VersionStatus, error) {
+	levels, err := ResolveBuildOrder(projectVersion.Services)
+	if err != nil {
+		return api.VersionFailed, err
+	}
+	if len(projectVersion.Tasks) == 0 {
+		projectVersion.Tasks = newProjectTasks(projectVersion, levels)
+	}
+
+	start, status, err := projectProgress(projectVersion, levels)
+	for _, i := range start {
+		if err := startProjectTask(ds, &projectVersion.Tasks[i]); err != nil {
+			return api.VersionFailed, err
+		}
+	}
+	return status, err
+}
+
+// startProjectTask creates the version of a task and sends the create version
+// event for it.
+func startProjectTask(ds *store.DataStore, task *api.Version) error {
+	service, err := ds.FindServiceByID(task.ServiceID)
+	if err != nil {
+		return fmt.Errorf("unable to find service %s: %v", task.ServiceID, err)
+	}
+
+	version := *task
+	version.Operator = api.APIOperator
+	version.URL = service.Repository.URL
+	version.CreateTime = time.Now()
+	version.YamlDeployStatus = api.DeployNoRun
+	if _, err := ds.NewVersionDocument(&version); err != nil {
+		return fmt.Errorf("unable to create version of service %s: %v", service.Name, err)
+	}
+
+	if err := SendCreateVersionEvent(service, &version); err != nil {
+		return fmt.Errorf("unable to create build job of service %s: %v", service.Name, err)
+	}
+
+	*task = version
+	return nil
+}
+
+// advanceProjectVersion records the finished version in the tasks of its project
+// version, then starts the next level of tasks, or finishes the project version
+// event when all the tasks are built or any of them fails, the running tasks are
+// cancelled if any fails.
+func advanceProjectVersion(ds *store.DataStore, version *api.Version) {
+	projectVersion, err := ds.FindProjectVersionByID(version.ProjectVersionID)
+	if err != nil {
+		log.Errorf("Unable to find project version %s: %v", version.ProjectVersionID, err)
+		return
+	}
+	for i := range projectVersion.Tasks {
+		if projectVersion.Tasks[i].VersionID == version.VersionID {
+			projectVersion.Tasks[i] = *version
+		}
+	}
+
+	// The tasks of a finished project version are only recorded.
+	running := projectVersion.Status == api.VersionRunning
+	var status api.VersionStatus
+	if running {
+		status, err = scheduleProjectTasks(ds, projectVersion)
+	}
+	if err := ds.UpdateProjectVersionDocument(projectVersion); err != nil {
+		log.Errorf("Unable to update project version %s: %v", projectVersion.VersionID, err)
+	}
+	if !running {
+		return
+	}
+
+	switch status {
+	case api.VersionHealthy:
+		finishProjectVersion(projectVersion.VersionID, api.EventStatusSuccess, "")
+	case api.VersionFailed:
+		cancelProjectTasks(projectVersion)
+		finishProjectVersion(projectVersion.VersionID, api.EventStatusFail, err.Error())
+	}
+}
+
+// finishProjectVersion sets the status of the project version event, the post
+// hook is run when the change is watched.
+func finishProjectVersion(versionID string, status api.EventStatus, message string) {
+	event, err := LoadEventFromEtcd(api.EventID(versionID))
+	if err != nil {
+		log.Errorf("load project version event %s err: %v", versionID, err)
+		return
+	}
+	if IsEventFinished(event) {
+		return
+	}
+
+	event.Status = status
+	event.ErrorMessage = message
+	if err := SaveEventToEtcd(event); err != nil {
+		log.Errorf("save project version event %s err: %v", versionID, err)
+	}
+}
+
+// createProjectVersionHandler is the create project version handler, it starts
+// the services without dependencies. It does not run a worker, the other
+// services are started by the post hooks of their dependencies.
+func createProjectVersionHandler(event *api.Event) error {
+	log.Infof("create project version handler")
+	ds := store.NewStore()
+	defer ds.Close()
+
+	projectVersion, err := ds.FindProjectVersionByID(event.ProjectVersion.VersionID)
+	if err != nil {
+		return err
+	}
+
+	projectVersion.Status = api.VersionRunning
+	_, err = scheduleProjectTasks(ds, projectVersion)
+	if errUpdate := ds.UpdateProjectVersionDocument(projectVersion); errUpdate != nil {
+		log.Errorf("Unable to update project version %s: %v", projectVersion.VersionID, errUpdate)
+	}
+	return err
+}
+
+// createProjectVersionPostHook is the create project version post hook.
+func createProjectVersionPostHook(event *api.Event) {
+	log.Infof("create project version post hook")
+	ds := store.NewStore()
+	defer ds.Close()
+
+	projectVersion, err := ds.FindProjectVersionByID(event.ProjectVersion.VersionID)
+	if err != nil {
+		log.Errorf("Unable to find project version %s: %v", event.ProjectVersion.VersionID, err)
+		return
+	}
+
+	if event.Status == api.EventStatusSuccess {
+		projectVersion.Status = api.VersionHealthy
+	} else if event.Status == api.EventStatusCancel {
+		projectVersion.Status = api.VersionCancel
+		cancelProjectTasks(projectVersion)
+	} else {
+		projectVersion.Status = api.VersionFailed
+	}
+	projectVersion.ErrorMessage = event.ErrorMessage
+
+	if err := ds.UpdateProjectVersionDocument(projectVersion); err != nil {
+		log.Errorf("Unable to update project version post hook for %+v: %v", projectVersion, err)
+	}
+	if projectVersion.Status == api.VersionHealthy {
+		if err := ds.AddNewProjectVersion(projectVersion.ProjectID, projectVersion.VersionID); err != nil {
+			log.Errorf("Unable to add new version to project %s: %v", projectVersion.ProjectID, err)
+		}
+	}
+}
+
+// cancelProjectTasks cancels the started tasks which have not finished yet.
+func cancelProjectTasks(projectVersion *api.ProjectVersion) {
+	for _, task := range projectVersion.Tasks {
+		if task.VersionID == "" || task.Status == api.VersionHealthy ||
+			task.Status == api.VersionFailed || task.Status == api.VersionCancel {
+			continue
+		}
+		if err := CancelEvent(api.EventID(task.VersionID)); err != nil && err != ErrEventFinished {
+			log.Errorf("Unable to cancel version %s of project version %s: %v", task.VersionID,
+				projectVersion.VersionID, err)
+		}
+	}
+}
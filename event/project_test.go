@@ -0,0 +1,118 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package event
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/caicloud/cyclone/api"
+)
+
+// dependency returns a service depending on the given services.
+func dependency(serviceID string, depends ...string) api.ServiceDependency {
+	service := api.ServiceDependency{ServiceID: serviceID}
+	for _, depend := range depends {
+		service.Depend = append(service.Depend, api.ServiceDependency{ServiceID: depend})
+	}
+	return service
+}
+
+// TestResolveBuildOrder tests services are grouped into levels by dependencies.
+func TestResolveBuildOrder(t *testing.T) {
+	testCases := map[string]struct {
+		services []api.ServiceDependency
+		levels   [][]string
+		err      error
+	}{
+		"dependency only": {
+			services: []api.ServiceDependency{dependency("client", "server")},
+			levels:   [][]string{{"server"}, {"client"}},
+		},
+		"diamond": {
+			services: []api.ServiceDependency{
+				dependency("web", "order", "user"),
+				dependency("order", "db"),
+				dependency("user", "db"),
+				dependency("db"),
+			},
+			levels: [][]string{{"db"}, {"order", "user"}, {"web"}},
+		},
+		"independent": {
+			services: []api.ServiceDependency{dependency("a"), dependency("b")},
+			levels:   [][]string{{"a", "b"}},
+		},
+		"cycle": {
+			services: []api.ServiceDependency{dependency("a", "b"), dependency("b", "a")},
+			err:      ErrDependencyCycle,
+		},
+		"self": {
+			services: []api.ServiceDependency{dependency("a", "a")},
+			err:      ErrDependencyCycle,
+		},
+	}
+
+	for name, tc := range testCases {
+		levels, err := ResolveBuildOrder(tc.services)
+		if err != tc.err {
+			t.Errorf("%s: Expect error %v, got %v", name, tc.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(levels, tc.levels) {
+			t.Errorf("%s: Expect levels %v, got %v", name, tc.levels, levels)
+		}
+	}
+}
+
+// TestProjectProgress tests the next level of tasks starts only after the
+// previous levels are built.
+func TestProjectProgress(t *testing.T) {
+	levels := [][]string{{"db"}, {"order", "user"}}
+	projectVersion := &api.ProjectVersion{Name: "v1"}
+	projectVersion.Tasks = newProjectTasks(projectVersion, levels)
+
+	start, status, err := projectProgress(projectVersion, levels)
+	if err != nil || status != api.VersionRunning || !reflect.DeepEqual(start, []int{0}) {
+		t.Errorf("Expect db to start, got %v %s %v", start, status, err)
+	}
+
+	projectVersion.Tasks[0].VersionID = "db-v1"
+	start, status, err = projectProgress(projectVersion, levels)
+	if err != nil || status != api.VersionRunning || len(start) != 0 {
+		t.Errorf("Expect waiting for db, got %v %s %v", start, status, err)
+	}
+
+	projectVersion.Tasks[0].Status = api.VersionHealthy
+	start, status, err = projectProgress(projectVersion, levels)
+	if err != nil || status != api.VersionRunning || !reflect.DeepEqual(start, []int{1, 2}) {
+		t.Errorf("Expect order and user to start, got %v %s %v", start, status, err)
+	}
+
+	projectVersion.Tasks[1].VersionID = "order-v1"
+	projectVersion.Tasks[1].Status = api.VersionFailed
+	projectVersion.Tasks[2].VersionID = "user-v1"
+	_, status, err = projectProgress(projectVersion, levels)
+	if err == nil || status != api.VersionFailed {
+		t.Errorf("Expect project version failed, got %s %v", status, err)
+	}
+
+	projectVersion.Tasks[1].Status = api.VersionHealthy
+	projectVersion.Tasks[2].Status = api.VersionHealthy
+	_, status, err = projectProgress(projectVersion, levels)
+	if err != nil || status != api.VersionHealthy {
+		t.Errorf("Expect project version healthy, got %s %v", status, err)
+	}
+}
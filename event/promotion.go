@@ -0,0 +1,227 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/pkg/log"
+	"github.com/caicloud/cyclone/store"
+	"gopkg.in/mgo.v2"
+)
+
+var (
+	// ErrVersionNotHealthy is the error when the version to promote is not healthy.
+	ErrVersionNotHealthy = errors.New("version is not healthy")
+	// ErrUnknownEnvironment is the error when the environment of the version is not in the service.
+	ErrUnknownEnvironment = errors.New("environment of the version is not in the service")
+	// ErrEnvironmentNotHealthy is the error when the deploy to the environment of the version is not healthy.
+	ErrEnvironmentNotHealthy = errors.New("deploy to the environment of the version is not healthy")
+	// ErrNoNextEnvironment is the error when the version is in the last environment already.
+	ErrNoNextEnvironment = errors.New("version is in the last environment")
+)
+
+// DeployFirstEnvironment makes the version deploy to the first environment of
+// the service if it has environments, the deploy plans of the version without
+// an environment belong to the first one.
+func DeployFirstEnvironment(service *api.Service, version *api.Version) {
+	if len(service.Environments) == 0 || !strings.Contains(string(version.Operation), string(api.DeployOperation)) {
+		return
+	}
+
+	first := service.Environments[0]
+	version.Environment = first.Name
+	for i := range version.DeployPlansStatuses {
+		if version.DeployPlansStatuses[i].Environment == "" {
+			version.DeployPlansStatuses[i].Environment = first.Name
+		}
+	}
+	addEnvironmentPlans(version, first)
+}
+
+// PromoteVersion promotes the version to the next environment of the service,
+// which starts deploying by a event of the deploy operation. The deploy waits for
+// the approval first if the service requires it.
+func PromoteVersion(service *api.Service, versionID string) (*api.Version, error) {
+	ds := store.NewStore()
+	defer ds.Close()
+
+	version, err := ds.FindVersionByID(versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	from := version.Environment
+	if err := promote(service, version); err != nil {
+		return version, err
+	}
+	if service.Approval.Required {
+		holdPromotion(version, from)
+		awaitApproval(service, version, time.Now())
+	}
+
+	if err := ds.CompareAndSetVersion(versionID, api.VersionHealthy, *version); err != nil {
+		if err == mgo.ErrNotFound {
+			err = ErrVersionNotHealthy
+		}
+		return version, err
+	}
+	log.InfoWithFields("Version is promoted", log.Fields{"version_id": versionID, "environment": version.Environment})
+
+	if version.Status == api.VersionAwaitingApproval {
+		return version, nil
+	}
+	return version, SendCreateVersionEvent(service, version)
+}
+
+// promote moves the healthy version to the next environment, only if the deploy
+// to its current environment is healthy.
+func promote(service *api.Service, version *api.Version) error {
+	if version.Status != api.VersionHealthy {
+		return ErrVersionNotHealthy
+	}
+	index := environmentIndex(service, version.Environment)
+	if index < 0 {
+		return ErrUnknownEnvironment
+	}
+	if !environmentHealthy(service, version) {
+		return ErrEnvironmentNotHealthy
+	}
+	if index+1 >= len(service.Environments) {
+		return ErrNoNextEnvironment
+	}
+
+	next := service.Environments[index+1]
+	version.Environment = next.Name
+	version.Status = api.VersionPending
+	version.Operation = api.DeployOperation
+	addEnvironmentPlans(version, next)
+	return nil
+}
+
+// addEnvironmentPlans adds the deploy plans of the environment to the version.
+func addEnvironmentPlans(version *api.Version, env api.Environment) {
+	for _, plan := range env.DeployPlans {
+		version.DeployPlansStatuses = append(version.DeployPlansStatuses, api.DeployPlanStatus{
+			PlanName:    plan.PlanName,
+			Environment: env.Name,
+			Config:      plan.Config,
+			Status:      api.DeployNoRun,
+		})
+	}
+}
+
+// environmentIndex returns the index of the environment in the service, -1 if
+// it is not found.
+func environmentIndex(service *api.Service, name string) int {
+	if name == "" {
+		return -1
+	}
+	for i, env := range service.Environments {
+		if env.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// environmentHealthy returns whether the healthy version is deployed to its
+// environment successfully, by all the deploy plans of the environment and by
+// the yaml information for the first environment.
+func environmentHealthy(service *api.Service, version *api.Version) bool {
+	index := environmentIndex(service, version.Environment)
+	if version.Status != api.VersionHealthy || index < 0 {
+		return false
+	}
+	if index == 0 && version.YamlDeploy != api.NotDeployWithYaml {
+		switch version.YamlDeployStatus {
+		case "", api.DeployNoRun, api.DeploySuccess:
+		default:
+			return false
+		}
+	}
+	for _, plan := range version.DeployPlansStatuses {
+		if plan.Environment == version.Environment && plan.Status != api.DeploySuccess {
+			return false
+		}
+	}
+	return true
+}
+
+// markLive records the version is live in its environment after deploying to it
+// successfully, which replaces the previous live information of the environment.
+// It returns whether the version is marked.
+func markLive(service *api.Service, version *api.Version, now time.Time) bool {
+	if !strings.Contains(string(version.Operation), string(api.DeployOperation)) ||
+		!environmentHealthy(service, version) {
+		return false
+	}
+
+	current := []api.VersionLiveInfo{}
+	for _, plan := range version.DeployPlansStatuses {
+		if plan.Environment == version.Environment {
+			current = append(current, api.VersionLiveInfo{
+				Environment: version.Environment,
+				Cluster:     plan.Config.ClusterName,
+				Project:     plan.Config.Namespace,
+				DeployTime:  now,
+			})
+		}
+	}
+	// The first environment may be deployed by the yaml information only.
+	if len(current) == 0 && version.YamlDeployStatus == api.DeploySuccess &&
+		environmentIndex(service, version.Environment) == 0 {
+		current = append(current, api.VersionLiveInfo{Environment: version.Environment, DeployTime: now})
+	}
+	if len(current) == 0 {
+		return false
+	}
+
+	for _, info := range version.LiveInfo {
+		if info.Environment != version.Environment {
+			current = append(current, info)
+		}
+	}
+	version.LiveInfo = current
+	for _, tag := range version.Tags {
+		if tag == api.LiveVersion {
+			return true
+		}
+	}
+	version.Tags = append(version.Tags, api.LiveVersion)
+	return true
+}
+
+// refreshLiveInfo reads the live information of the version deployed to its
+// environment again, as the other versions may replace it during the deploy,
+// and marks the version live if the deploy is healthy. It returns whether the
+// version is marked.
+func refreshLiveInfo(ds *store.DataStore, service *api.Service, version *api.Version) bool {
+	if version.Environment == "" {
+		return false
+	}
+	if stored, err := ds.FindVersionByID(version.VersionID); err == nil {
+		version.LiveInfo = stored.LiveInfo
+		version.Tags = stored.Tags
+	} else {
+		log.Errorf("Unable to find version %s to refresh live info: %v", version.VersionID, err)
+	}
+	return markLive(service, version, time.Now())
+}
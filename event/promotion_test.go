@@ -0,0 +1,168 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caicloud/cyclone/api"
+)
+
+// newPromotionService creates the service with the environments dev and prod,
+// each deployed by a plan.
+func newPromotionService() *api.Service {
+	return &api.Service{
+		Environments: []api.Environment{
+			{Name: "dev", DeployPlans: []api.DeployPlan{{PlanName: "dev-web"}}},
+			{Name: "prod", DeployPlans: []api.DeployPlan{{PlanName: "prod-web"}, {PlanName: "prod-api"}}},
+		},
+	}
+}
+
+// TestDeployFirstEnvironment tests the version deploys to the first environment.
+func TestDeployFirstEnvironment(t *testing.T) {
+	service := newPromotionService()
+	version := &api.Version{
+		Operation:           api.PublishOperation + api.DeployOperation,
+		DeployPlansStatuses: []api.DeployPlanStatus{{PlanName: "extra"}},
+	}
+	DeployFirstEnvironment(service, version)
+	if version.Environment != "dev" || len(version.DeployPlansStatuses) != 2 {
+		t.Fatalf("Expect the version in dev with 2 plans, got %+v", version)
+	}
+	for _, plan := range version.DeployPlansStatuses {
+		if plan.Environment != "dev" {
+			t.Errorf("Expect plan %s in dev, got %s", plan.PlanName, plan.Environment)
+		}
+	}
+
+	version = &api.Version{Operation: api.PublishOperation}
+	DeployFirstEnvironment(service, version)
+	if version.Environment != "" || len(version.DeployPlansStatuses) != 0 {
+		t.Errorf("Expect the version not deploying without environment, got %+v", version)
+	}
+}
+
+// TestPromote tests the version is promoted only if its environment is healthy.
+func TestPromote(t *testing.T) {
+	service := newPromotionService()
+	version := &api.Version{Operation: api.PublishOperation + api.DeployOperation, YamlDeploy: api.NotDeployWithYaml}
+	DeployFirstEnvironment(service, version)
+
+	version.Status = api.VersionHealthy
+	version.DeployPlansStatuses[0].Status = api.DeployFailed
+	if err := promote(service, version); err != ErrEnvironmentNotHealthy {
+		t.Errorf("Expect err %v, got %v", ErrEnvironmentNotHealthy, err)
+	}
+
+	version.DeployPlansStatuses[0].Status = api.DeploySuccess
+	if err := promote(service, version); err != nil {
+		t.Fatalf("Expect err %v to be nil", err)
+	}
+	if version.Environment != "prod" || version.Status != api.VersionPending || version.Operation != api.DeployOperation {
+		t.Errorf("Expect the version deploying to prod, got %+v", version)
+	}
+	if len(version.DeployPlansStatuses) != 3 || version.DeployPlansStatuses[2].Environment != "prod" ||
+		version.DeployPlansStatuses[2].Status != api.DeployNoRun {
+		t.Errorf("Expect the plans of prod added, got %+v", version.DeployPlansStatuses)
+	}
+	if err := promote(service, version); err != ErrVersionNotHealthy {
+		t.Errorf("Expect err %v, got %v", ErrVersionNotHealthy, err)
+	}
+
+	version.Status = api.VersionHealthy
+	version.DeployPlansStatuses[1].Status = api.DeploySuccess
+	version.DeployPlansStatuses[2].Status = api.DeploySuccess
+	if err := promote(service, version); err != ErrNoNextEnvironment {
+		t.Errorf("Expect err %v, got %v", ErrNoNextEnvironment, err)
+	}
+
+	version.Environment = "qa"
+	if err := promote(service, version); err != ErrUnknownEnvironment {
+		t.Errorf("Expect err %v, got %v", ErrUnknownEnvironment, err)
+	}
+}
+
+// TestMarkLive tests the live information of the environment is replaced once
+// the version is deployed to it.
+func TestMarkLive(t *testing.T) {
+	service := newPromotionService()
+	version := &api.Version{Operation: api.PublishOperation + api.DeployOperation, YamlDeploy: api.NotDeployWithYaml}
+	DeployFirstEnvironment(service, version)
+	version.Status = api.VersionHealthy
+	version.DeployPlansStatuses[0].Status = api.DeployFailed
+	if markLive(service, version, time.Now()) {
+		t.Errorf("Expect the version failed to deploy not live, got %+v", version.LiveInfo)
+	}
+
+	version.DeployPlansStatuses[0].Status = api.DeploySuccess
+	if !markLive(service, version, time.Now()) {
+		t.Fatalf("Expect the version live in dev")
+	}
+	if len(version.LiveInfo) != 1 || version.LiveInfo[0].Environment != "dev" {
+		t.Errorf("Expect the version live in dev, got %+v", version.LiveInfo)
+	}
+
+	if err := promote(service, version); err != nil {
+		t.Fatalf("Expect err %v to be nil", err)
+	}
+	version.Status = api.VersionHealthy
+	version.DeployPlansStatuses[1].Status = api.DeploySuccess
+	version.DeployPlansStatuses[2].Status = api.DeploySuccess
+	if !markLive(service, version, time.Now()) || !markLive(service, version, time.Now()) {
+		t.Fatalf("Expect the version live in prod")
+	}
+	if len(version.LiveInfo) != 3 || len(version.Tags) != 1 || version.Tags[0] != api.LiveVersion {
+		t.Errorf("Expect the version live in dev and prod by 3 plans, got %+v %v", version.LiveInfo, version.Tags)
+	}
+}
+
+// TestHoldPromotion tests the promotion waits for the approval of the plans of
+// the next environment, and stays in the previous one if it is rejected.
+func TestHoldPromotion(t *testing.T) {
+	now := time.Now()
+	service := newPromotionService()
+	service.Approval = api.ApprovalPolicy{Required: true, Timeout: 3600}
+	version := &api.Version{Operation: api.PublishOperation + api.DeployOperation, YamlDeployStatus: api.DeploySuccess}
+	DeployFirstEnvironment(service, version)
+	version.Status = api.VersionHealthy
+	version.DeployPlansStatuses[0].Status = api.DeploySuccess
+	if err := promote(service, version); err != nil {
+		t.Fatalf("Expect err %v to be nil", err)
+	}
+
+	holdPromotion(version, "dev")
+	awaitApproval(service, version, now)
+	if version.Approval == nil || len(version.Approval.PlanNames) != 2 || version.Approval.PromotedFrom != "dev" {
+		t.Fatalf("Expect the plans of prod waiting for the approval, got %+v", version.Approval)
+	}
+	if version.YamlDeployStatus != api.DeploySuccess || version.DeployPlansStatuses[0].Status != api.DeploySuccess ||
+		version.DeployPlansStatuses[1].Status != api.DeployAwaitingApproval {
+		t.Errorf("Expect only the plans of prod awaiting approval, got %+v", version)
+	}
+
+	if err := decide(version, api.ApprovalRejected, "owner", "", now); err != nil {
+		t.Fatalf("Expect err %v to be nil", err)
+	}
+	if version.Status != api.VersionHealthy || version.Environment != "dev" || len(version.DeployPlansStatuses) != 1 {
+		t.Errorf("Expect the version kept in dev, got %+v", version)
+	}
+	if err := promote(service, version); err != nil {
+		t.Errorf("Expect the version promoted again, got %v", err)
+	}
+}
@@ -0,0 +1,566 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/etcd"
+	"github.com/caicloud/cyclone/pkg/log"
+	"github.com/coreos/etcd/client"
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
+)
+
+const (
+	// queued events dir path in etcd
+	Events_Queue = "/events/queue"
+
+	// dir path in etcd of the queue changes requested by the servers other
+	// than the leader
+	Events_QueueRequests = "/events/queuerequests"
+)
+
+// Priorities of the pending events, the event with higher priority is handled earlier.
+const (
+	// PriorityLow is the priority of commit and pull request builds.
+	PriorityLow = 0
+	// PriorityNormal is the priority of events created by api.
+	PriorityNormal = 5
+	// PriorityHigh is the priority of release builds.
+	PriorityHigh = 10
+)
+
+var (
+	// ErrEventNotQueued is the error when the event is not in the pending queue.
+	ErrEventNotQueued = errors.New("event is not in the pending queue")
+
+	// ErrEventDispatching is the error when the event is being handled.
+	ErrEventDispatching = errors.New("event is being handled")
+)
+
+var pendingEvents Queue
+
+// queueItem is a event and its scheduling information in the queue.
+type queueItem struct {
+	info  api.QueuedEvent
+	event *api.Event
+}
+
+// Queue is the type for pending events. Events are ordered by priority first,
+// then the events of the user who has been served least recently go ahead, so
+// that one user can not starve the others, and at last by their sequence.
+type Queue struct {
+	sync.RWMutex
+	items map[api.EventID]*queueItem
+	// sequence is the last sequence assigned to a event.
+	sequence int64
+	// served records the tick when the users' events were handled last time.
+	served map[string]int64
+	tick   int64
+	// dispatching is the event being handled.
+	dispatching api.EventID
+	// saved is the scheduling information loaded from etcd.
+	saved map[api.EventID]api.QueuedEvent
+	// etcdClient persists the queue, nil to keep the queue in memory only.
+	etcdClient *etcd.Client
+}
+
+// Init initializes a queue, and loads the persisted scheduling information
+// if a etcd client is given.
+func (eq *Queue) Init(etcdClient *etcd.Client) {
+	eq.Lock()
+	defer eq.Unlock()
+
+	eq.items = make(map[api.EventID]*queueItem)
+	eq.served = make(map[string]int64)
+	eq.saved = make(map[api.EventID]api.QueuedEvent)
+	eq.sequence = 0
+	eq.tick = 0
+	eq.dispatching = ""
+	eq.etcdClient = etcdClient
+
+	if etcdClient == nil {
+		return
+	}
+	jsonInfos, err := etcdClient.List(Events_Queue)
+	if err != nil {
+		log.Errorf("load queued events from etcd err: %v", err)
+		return
+	}
+	for _, jsonInfo := range jsonInfos {
+		var info api.QueuedEvent
+		if err := json.Unmarshal([]byte(jsonInfo), &info); err != nil {
+			log.Errorf("analysis queued event err: %v", err)
+			continue
+		}
+		eq.saved[info.EventID] = info
+		if info.Sequence > eq.sequence {
+			eq.sequence = info.Sequence
+		}
+	}
+}
+
+// In enqueues a event.
+func (eq *Queue) In(event *api.Event) {
+	eq.Lock()
+	defer eq.Unlock()
+
+	if _, ok := eq.items[event.EventID]; ok {
+		eq.items[event.EventID].event = event
+		return
+	}
+
+	info, ok := eq.saved[event.EventID]
+	if ok {
+		delete(eq.saved, event.EventID)
+	} else {
+		eq.sequence++
+		info = api.QueuedEvent{
+			EventID:     event.EventID,
+			UserID:      event.Service.UserID,
+			ServiceName: event.Service.Name,
+			VersionName: event.Version.Name,
+			Priority:    eventPriority(event),
+			Sequence:    eq.sequence,
+			EnqueueTime: time.Now(),
+		}
+	}
+
+	item := &queueItem{info: info, event: event}
+	eq.items[event.EventID] = item
+	eq.persist(item)
+}
+
+// Dispatch gets the event which should be handled next, and marks it as being
+// handled until Out or Release is called, returns nil if the queue is empty.
+func (eq *Queue) Dispatch() *api.Event {
+	eq.Lock()
+	defer eq.Unlock()
+
+	item := eq.front(eq.served)
+	if item == nil {
+		return nil
+	}
+	eq.dispatching = item.info.EventID
+	return item.event
+}
+
+// Release puts back the dispatching event, it will be dispatched again later.
+func (eq *Queue) Release() {
+	eq.Lock()
+	defer eq.Unlock()
+
+	eq.dispatching = ""
+}
+
+// Out dequeues the dispatching event which has been handled, and counts it to
+// its user.
+func (eq *Queue) Out() {
+	eq.Lock()
+	defer eq.Unlock()
+
+	item, ok := eq.items[eq.dispatching]
+	eq.dispatching = ""
+	if !ok {
+		return
+	}
+	eq.tick++
+	eq.served[item.info.UserID] = eq.tick
+	eq.remove(item.info.EventID)
+}
+
+// Remove removes a event from the queue without handling it.
+func (eq *Queue) Remove(eventID api.EventID) (*api.Event, error) {
+	eq.Lock()
+	defer eq.Unlock()
+
+	item, ok := eq.items[eventID]
+	if !ok {
+		return nil, ErrEventNotQueued
+	}
+	if eventID == eq.dispatching {
+		return nil, ErrEventDispatching
+	}
+	eq.remove(eventID)
+	return item.event, nil
+}
+
+// Reorder changes the priority of a event, top moves the event ahead of all
+// the other events.
+func (eq *Queue) Reorder(eventID api.EventID, priority *int, top bool) (api.QueuedEvent, error) {
+	eq.Lock()
+	defer eq.Unlock()
+
+	item, ok := eq.items[eventID]
+	if !ok {
+		return api.QueuedEvent{}, ErrEventNotQueued
+	}
+
+	if priority != nil {
+		item.info.Priority = *priority
+	}
+	if top {
+		for id, other := range eq.items {
+			if id != eventID && other.info.Priority >= item.info.Priority {
+				item.info.Priority = other.info.Priority + 1
+			}
+			if other.info.Sequence <= item.info.Sequence {
+				item.info.Sequence = other.info.Sequence - 1
+			}
+		}
+	}
+	eq.persist(item)
+	return item.info, nil
+}
+
+// List lists the scheduling information of events in the order they will be
+// handled, assuming no more events come.
+func (eq *Queue) List() []api.QueuedEvent {
+	eq.RLock()
+	defer eq.RUnlock()
+
+	served := make(map[string]int64, len(eq.served))
+	for user, tick := range eq.served {
+		served[user] = tick
+	}
+	picked := make(map[api.EventID]bool, len(eq.items))
+	tick := eq.tick
+
+	infos := make([]api.QueuedEvent, 0, len(eq.items))
+	for len(infos) < len(eq.items) {
+		var next *queueItem
+		for id, item := range eq.items {
+			if !picked[id] && (next == nil || item.before(next, served)) {
+				next = item
+			}
+		}
+		picked[next.info.EventID] = true
+		tick++
+		served[next.info.UserID] = tick
+		infos = append(infos, next.info)
+	}
+	return infos
+}
+
+// IsEmpty checks if the queue is empty.
+func (eq *Queue) IsEmpty() bool {
+	eq.RLock()
+	defer eq.RUnlock()
+
+	return len(eq.items) == 0
+}
+
+// Len returns the number of events in the queue.
+func (eq *Queue) Len() int {
+	eq.RLock()
+	defer eq.RUnlock()
+
+	return len(eq.items)
+}
+
+// prune deletes the persisted scheduling information of events which are
+// no longer pending.
+func (eq *Queue) prune() {
+	eq.Lock()
+	defer eq.Unlock()
+
+	for eventID := range eq.saved {
+		delete(eq.saved, eventID)
+		if eq.etcdClient != nil {
+			if err := eq.etcdClient.Delete(Events_Queue + "/" + string(eventID)); err != nil {
+				log.Errorf("delete queued event err: %v", err)
+			}
+		}
+	}
+}
+
+// front finds the event which should be handled next, the retried events are
+// skipped until their backoff ends.
+func (eq *Queue) front(served map[string]int64) *queueItem {
+	var front *queueItem
+	now := time.Now()
+	for _, item := range eq.items {
+		if item.event.RetryTime.After(now) {
+			continue
+		}
+		if front == nil || item.before(front, served) {
+			front = item
+		}
+	}
+	return front
+}
+
+// remove removes a event from the queue and etcd, the caller must hold the lock.
+func (eq *Queue) remove(eventID api.EventID) {
+	delete(eq.items, eventID)
+	if eq.etcdClient == nil {
+		return
+	}
+	if err := eq.etcdClient.Delete(Events_Queue + "/" + string(eventID)); err != nil {
+		log.Errorf("delete queued event err: %v", err)
+	}
+}
+
+// persist saves the scheduling information of a event to etcd, the caller
+// must hold the lock.
+func (eq *Queue) persist(item *queueItem) {
+	if eq.etcdClient == nil {
+		return
+	}
+	jsonInfo, err := json.Marshal(item.info)
+	if err != nil {
+		log.Errorf("queued event marshal err: %v", err)
+		return
+	}
+	if err := eq.etcdClient.Set(Events_Queue+"/"+string(item.info.EventID), string(jsonInfo)); err != nil {
+		log.Errorf("save queued event err: %v", err)
+	}
+}
+
+// before returns whether the item should be handled before the other one.
+func (item *queueItem) before(other *queueItem, served map[string]int64) bool {
+	if item.info.Priority != other.info.Priority {
+		return item.info.Priority > other.info.Priority
+	}
+	if item.info.UserID != other.info.UserID {
+		itemServed, otherServed := served[item.info.UserID], served[other.info.UserID]
+		if itemServed != otherServed {
+			return itemServed < otherServed
+		}
+	}
+	if item.info.Sequence != other.info.Sequence {
+		return item.info.Sequence < other.info.Sequence
+	}
+	return item.info.EventID < other.info.EventID
+}
+
+// eventPriority gets the default priority of a event, release builds go ahead of
+// commit and pull request builds.
+func eventPriority(event *api.Event) int {
+	if event.Operation != CreateVersionOps {
+		return PriorityNormal
+	}
+
+	if strings.Contains(string(event.Version.Operation), string(api.PublishOperation)) ||
+		strings.HasPrefix(event.Version.Name, "tag_") {
+		return PriorityHigh
+	}
+	if strings.HasPrefix(event.Version.Name, "ci_") ||
+		strings.HasPrefix(event.Version.Name, "pr_") {
+		return PriorityLow
+	}
+	return PriorityNormal
+}
+
+// ListPendingEvents lists the pending events in the order they will be handled.
+// The queue is kept by the leader, the other servers list it by the scheduling
+// information persisted in etcd.
+func ListPendingEvents() ([]api.QueuedEvent, error) {
+	if !IsLeader() {
+		queue, err := loadQueue(etcd.GetClient())
+		if err != nil {
+			return nil, err
+		}
+		return queue.List(), nil
+	}
+	return pendingEvents.List(), nil
+}
+
+// ReorderPendingEvent changes the position of a pending event in the queue. The
+// servers other than the leader request the leader to change it, and return the
+// scheduling information as it will be changed.
+func ReorderPendingEvent(eventID api.EventID, setting api.QueuedEventSetting) (api.QueuedEvent, error) {
+	if !IsLeader() {
+		return requestReorder(etcd.GetClient(), eventID, setting)
+	}
+	return pendingEvents.Reorder(eventID, setting.Priority, setting.Top)
+}
+
+// RemovePendingEvent removes a pending event from the queue, and marks it as
+// cancelled, the post hook is run when the change is watched.
+func RemovePendingEvent(eventID api.EventID) error {
+	return cancelPendingEvent(eventID, "removed from the pending queue")
+}
+
+// cancelPendingEvent removes a pending event from the queue, and marks it as
+// cancelled with the message.
+func cancelPendingEvent(eventID api.EventID, message string) error {
+	if !IsLeader() {
+		return requestCancel(etcd.GetClient(), eventID, message)
+	}
+	event, err := pendingEvents.Remove(eventID)
+	if err != nil {
+		return err
+	}
+
+	event.Status = api.EventStatusCancel
+	event.ErrorMessage = message
+	return SaveEventToEtcd(event)
+}
+
+// queueStore is the store of the queue in etcd, etcd.Client is used in production.
+type queueStore interface {
+	Get(key string) (string, error)
+	Set(key, value string) error
+	List(dir string) ([]string, error)
+}
+
+// queueRequest is a change of the pending queue requested by a server other
+// than the leader, the leader applies it once watched.
+type queueRequest struct {
+	EventID api.EventID `json:"event_id"`
+	// Setting changes the position of the event if set.
+	Setting *api.QueuedEventSetting `json:"setting,omitempty"`
+	// CancelMessage removes the event from the queue and cancels it with the
+	// message if set.
+	CancelMessage string `json:"cancel_message,omitempty"`
+}
+
+// loadQueue loads the scheduling information of the pending events persisted
+// in etcd into a queue without the events. The users served recently by the
+// leader are not known, so the fair share starts over.
+func loadQueue(store queueStore) (*Queue, error) {
+	jsonInfos, err := store.List(Events_Queue)
+	if err != nil {
+		return nil, err
+	}
+
+	queue := &Queue{
+		items:  make(map[api.EventID]*queueItem),
+		served: make(map[string]int64),
+	}
+	for _, jsonInfo := range jsonInfos {
+		var info api.QueuedEvent
+		if err := json.Unmarshal([]byte(jsonInfo), &info); err != nil {
+			log.Errorf("analysis queued event err: %v", err)
+			continue
+		}
+		queue.items[info.EventID] = &queueItem{info: info}
+	}
+	return queue, nil
+}
+
+// requestReorder requests the leader to change the position of the pending
+// event, and returns the scheduling information as it will be changed.
+func requestReorder(store queueStore, eventID api.EventID, setting api.QueuedEventSetting) (api.QueuedEvent, error) {
+	queue, err := loadQueue(store)
+	if err != nil {
+		return api.QueuedEvent{}, err
+	}
+	info, err := queue.Reorder(eventID, setting.Priority, setting.Top)
+	if err != nil {
+		return info, err
+	}
+	return info, sendQueueRequest(store, queueRequest{EventID: eventID, Setting: &setting})
+}
+
+// requestCancel requests the leader to remove the pending event from the queue
+// and cancel it with the message.
+func requestCancel(store queueStore, eventID api.EventID, message string) error {
+	if _, err := store.Get(Events_Queue + "/" + string(eventID)); err != nil {
+		if client.IsKeyNotFound(err) {
+			return ErrEventNotQueued
+		}
+		return err
+	}
+	return sendQueueRequest(store, queueRequest{EventID: eventID, CancelMessage: message})
+}
+
+// sendQueueRequest saves the request to etcd for the leader, each request has
+// its own key so that none is lost.
+func sendQueueRequest(store queueStore, request queueRequest) error {
+	jsonRequest, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s/%d-%s", Events_QueueRequests, time.Now().UnixNano(), uuid.NewV4().String())
+	return store.Set(key, string(jsonRequest))
+}
+
+// applyQueueRequest applies the change of the pending queue requested by
+// another server, it fails as the same change requested to the leader does.
+func applyQueueRequest(jsonRequest string) error {
+	var request queueRequest
+	if err := json.Unmarshal([]byte(jsonRequest), &request); err != nil {
+		return err
+	}
+	if request.Setting != nil {
+		_, err := pendingEvents.Reorder(request.EventID, request.Setting.Priority, request.Setting.Top)
+		return err
+	}
+	if request.CancelMessage != "" {
+		return cancelPendingEvent(request.EventID, request.CancelMessage)
+	}
+	return nil
+}
+
+// watchQueueRequests applies the queue changes requested by the other servers,
+// including the ones requested before this server leads, until the context is
+// cancelled.
+func watchQueueRequests(ctx context.Context, etcdClient *etcd.Client) {
+	watcher, err := etcdClient.CreateWatcher(Events_QueueRequests)
+	if err != nil {
+		log.Fatalf("watch queue requests err: %v", err)
+	}
+
+	// The request is deleted before applied, so that the one both listed and
+	// watched is applied once.
+	apply := func(key, jsonRequest string) {
+		if err := etcdClient.Delete(key); err != nil {
+			return
+		}
+		if err := applyQueueRequest(jsonRequest); err != nil {
+			log.Errorf("apply queue request %s err: %v", jsonRequest, err)
+		}
+	}
+
+	nodes, err := etcdClient.ListNodes(Events_QueueRequests)
+	if err != nil {
+		log.Errorf("load queue requests err: %v", err)
+	}
+	// The keys start with the time requested.
+	keys := make([]string, 0, len(nodes))
+	for key := range nodes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		apply(key, nodes[key])
+	}
+
+	for {
+		change, err := watcher.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Info("stop watching queue requests")
+				return
+			}
+			log.Fatalf("watch queue requests next err: %v", err)
+		}
+		if change.Action == etcd.Watch_Action_Create || change.Action == etcd.Watch_Action_Set {
+			apply(change.Node.Key, change.Node.Value)
+		}
+	}
+}
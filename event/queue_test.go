@@ -0,0 +1,253 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/coreos/etcd/client"
+)
+
+// newTestEvent creates a create version event for testing.
+func newTestEvent(eventID, userID, versionName string) *api.Event {
+	return &api.Event{
+		EventID:   api.EventID(eventID),
+		Operation: CreateVersionOps,
+		Service:   api.Service{UserID: userID},
+		Version:   api.Version{Name: versionName},
+		Status:    api.EventStatusPending,
+	}
+}
+
+// dispatchAll dispatches all events in the queue and returns their IDs in order.
+func dispatchAll(q *Queue) []api.EventID {
+	var ids []api.EventID
+	for {
+		event := q.Dispatch()
+		if event == nil {
+			return ids
+		}
+		ids = append(ids, event.EventID)
+		q.Out()
+	}
+}
+
+// expectOrder checks the events are dispatched in the expected order.
+func expectOrder(t *testing.T, got []api.EventID, expect ...string) {
+	if len(got) != len(expect) {
+		t.Fatalf("Expect %d events, got %v", len(expect), got)
+	}
+	for i := range expect {
+		if string(got[i]) != expect[i] {
+			t.Errorf("Expect event %s at position %d, got %v", expect[i], i, got)
+			return
+		}
+	}
+}
+
+// TestQueuePriority tests that release builds go ahead of commit builds.
+func TestQueuePriority(t *testing.T) {
+	var q Queue
+	q.Init(nil)
+
+	q.In(newTestEvent("e1", "u1", "ci_1"))
+	q.In(newTestEvent("e2", "u1", "pr_2"))
+	q.In(newTestEvent("e3", "u1", "tag_3"))
+	q.In(newTestEvent("e4", "u1", "v1.0.0"))
+
+	expectOrder(t, dispatchAll(&q), "e3", "e4", "e1", "e2")
+}
+
+// TestQueueFairShare tests that users are served in turn.
+func TestQueueFairShare(t *testing.T) {
+	var q Queue
+	q.Init(nil)
+
+	q.In(newTestEvent("a1", "alice", "ci_1"))
+	q.In(newTestEvent("a2", "alice", "ci_2"))
+	q.In(newTestEvent("a3", "alice", "ci_3"))
+	q.In(newTestEvent("b1", "bob", "ci_1"))
+	q.In(newTestEvent("b2", "bob", "ci_2"))
+	q.In(newTestEvent("c1", "carol", "ci_1"))
+
+	list := q.List()
+	expectOrder(t, dispatchAll(&q), "a1", "b1", "c1", "a2", "b2", "a3")
+	if len(list) != 6 || list[1].EventID != "b1" || list[5].EventID != "a3" {
+		t.Errorf("Expect list in dispatch order, got %v", list)
+	}
+}
+
+// TestQueueReorder tests reordering and removing events.
+func TestQueueReorder(t *testing.T) {
+	var q Queue
+	q.Init(nil)
+
+	q.In(newTestEvent("e1", "u1", "tag_1"))
+	q.In(newTestEvent("e2", "u2", "ci_2"))
+	q.In(newTestEvent("e3", "u3", "ci_3"))
+	q.In(newTestEvent("e4", "u4", "ci_4"))
+
+	if _, err := q.Reorder("e3", nil, true); err != nil {
+		t.Errorf("Expect reorder success, got %v", err)
+	}
+	priority := PriorityHigh
+	if _, err := q.Reorder("e4", &priority, false); err != nil {
+		t.Errorf("Expect reorder success, got %v", err)
+	}
+	if _, err := q.Reorder("e5", nil, true); err != ErrEventNotQueued {
+		t.Errorf("Expect error %v, got %v", ErrEventNotQueued, err)
+	}
+
+	if event := q.Dispatch(); event == nil || event.EventID != "e3" {
+		t.Fatalf("Expect e3 to be dispatched first, got %v", event)
+	}
+	if _, err := q.Remove("e3"); err != ErrEventDispatching {
+		t.Errorf("Expect error %v, got %v", ErrEventDispatching, err)
+	}
+	q.Out()
+
+	if _, err := q.Remove("e1"); err != nil {
+		t.Errorf("Expect remove success, got %v", err)
+	}
+	expectOrder(t, dispatchAll(&q), "e4", "e2")
+}
+
+// fakeQueueStore is a queueStore keeping the keys in memory.
+type fakeQueueStore map[string]string
+
+func (s fakeQueueStore) Get(key string) (string, error) {
+	value, ok := s[key]
+	if !ok {
+		return "", client.Error{Code: client.ErrorCodeKeyNotFound}
+	}
+	return value, nil
+}
+
+func (s fakeQueueStore) Set(key, value string) error {
+	s[key] = value
+	return nil
+}
+
+func (s fakeQueueStore) List(dir string) ([]string, error) {
+	var values []string
+	for key, value := range s {
+		if strings.HasPrefix(key, dir+"/") {
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
+
+// requests gets the queue requests saved in the store.
+func (s fakeQueueStore) requests(t *testing.T) []queueRequest {
+	jsonRequests, _ := s.List(Events_QueueRequests)
+	requests := make([]queueRequest, 0, len(jsonRequests))
+	for _, jsonRequest := range jsonRequests {
+		var request queueRequest
+		if err := json.Unmarshal([]byte(jsonRequest), &request); err != nil {
+			t.Fatalf("Expect a queue request, got %s", jsonRequest)
+		}
+		requests = append(requests, request)
+	}
+	return requests
+}
+
+// newTestStore creates a store with the scheduling information of the events.
+func newTestStore(infos ...api.QueuedEvent) fakeQueueStore {
+	store := fakeQueueStore{}
+	for _, info := range infos {
+		jsonInfo, _ := json.Marshal(info)
+		store[Events_Queue+"/"+string(info.EventID)] = string(jsonInfo)
+	}
+	return store
+}
+
+func TestLoadQueue(t *testing.T) {
+	store := newTestStore(
+		api.QueuedEvent{EventID: "e1", UserID: "u1", Priority: PriorityNormal, Sequence: 1},
+		api.QueuedEvent{EventID: "e2", UserID: "u1", Priority: PriorityHigh, Sequence: 2},
+		api.QueuedEvent{EventID: "e3", UserID: "u2", Priority: PriorityNormal, Sequence: 3},
+	)
+
+	queue, err := loadQueue(store)
+	if err != nil {
+		t.Fatalf("Expect the queue loaded, got %v", err)
+	}
+	var ids []api.EventID
+	for _, info := range queue.List() {
+		ids = append(ids, info.EventID)
+	}
+	// e3 goes ahead of e1 as the user of e2 has been served.
+	expectOrder(t, ids, "e2", "e3", "e1")
+}
+
+func TestRequestReorder(t *testing.T) {
+	store := newTestStore(
+		api.QueuedEvent{EventID: "e1", UserID: "u1", Priority: PriorityNormal, Sequence: 1},
+		api.QueuedEvent{EventID: "e2", UserID: "u2", Priority: PriorityNormal, Sequence: 2},
+	)
+
+	info, err := requestReorder(store, "e2", api.QueuedEventSetting{Top: true})
+	if err != nil {
+		t.Fatalf("Expect the reorder requested, got %v", err)
+	}
+	if info.Priority <= PriorityNormal {
+		t.Errorf("Expect e2 ahead of e1, got priority %d", info.Priority)
+	}
+	requests := store.requests(t)
+	if len(requests) != 1 || requests[0].EventID != "e2" || requests[0].Setting == nil || !requests[0].Setting.Top {
+		t.Errorf("Expect a request to move e2 to the top, got %v", requests)
+	}
+
+	if _, err := requestReorder(store, "e3", api.QueuedEventSetting{Top: true}); err != ErrEventNotQueued {
+		t.Errorf("Expect ErrEventNotQueued for e3, got %v", err)
+	}
+	if len(store.requests(t)) != 1 {
+		t.Errorf("Expect no request for e3")
+	}
+}
+
+func TestRequestCancel(t *testing.T) {
+	store := newTestStore(api.QueuedEvent{EventID: "e1", UserID: "u1", Priority: PriorityNormal, Sequence: 1})
+
+	if err := requestCancel(store, "e1", "cancelled by user"); err != nil {
+		t.Fatalf("Expect the cancel requested, got %v", err)
+	}
+	requests := store.requests(t)
+	if len(requests) != 1 || requests[0].EventID != "e1" || requests[0].CancelMessage != "cancelled by user" {
+		t.Errorf("Expect a request to cancel e1, got %v", requests)
+	}
+
+	if err := requestCancel(store, "e2", "cancelled by user"); err != ErrEventNotQueued {
+		t.Errorf("Expect ErrEventNotQueued for e2, got %v", err)
+	}
+}
+
+func TestApplyQueueRequest(t *testing.T) {
+	pendingEvents.Init(nil)
+	defer pendingEvents.Init(nil)
+	pendingEvents.In(newTestEvent("e1", "u1", "v1"))
+	pendingEvents.In(newTestEvent("e2", "u2", "v2"))
+
+	if err := applyQueueRequest(`{"event_id":"e2","setting":{"top":true}}`); err != nil {
+		t.Fatalf("Expect the request applied, got %v", err)
+	}
+	expectOrder(t, dispatchAll(&pendingEvents), "e2", "e1")
+}
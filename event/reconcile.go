@@ -0,0 +1,144 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"sync"
+	"time"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/docker"
+	"github.com/caicloud/cyclone/pkg/log"
+	"github.com/caicloud/cyclone/pkg/osutil"
+	"github.com/caicloud/cyclone/store"
+	docker_client "github.com/fsouza/go-dockerclient"
+	"golang.org/x/net/context"
+	"gopkg.in/mgo.v2"
+)
+
+const (
+	// RECONCILE_INTERVAL is the interval in seconds to reconcile the left
+	// resource of worker nodes.
+	RECONCILE_INTERVAL = "RECONCILE_INTERVAL"
+)
+
+// dispatchLock is held while dispatching a event, so that the reconciliation
+// does not see a reserved resource whose worker container is not running yet.
+var dispatchLock sync.Mutex
+
+// reconcileWorkerNodes recomputes the left resource of worker nodes from the
+// running worker containers periodically, until the context is cancelled.
+func reconcileWorkerNodes(ctx context.Context) {
+	interval := time.Duration(osutil.GetIntEnv(RECONCILE_INTERVAL, 300)) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("stop reconciling worker nodes")
+			return
+		case <-ticker.C:
+			reconcileOnce()
+		}
+	}
+}
+
+// reconcileOnce reconciles the left resource of all system worker nodes.
+func reconcileOnce() {
+	ds := store.NewStore()
+	defer ds.Close()
+
+	nodes, err := ds.FindSystemWorkerNode()
+	if err != nil {
+		log.Errorf("find system worker nodes err: %v", err)
+		return
+	}
+
+	for _, node := range nodes {
+		if node.Status == api.WorkerNodeUnreachable {
+			continue
+		}
+		if err := reconcileWorkerNode(ds, node.NodeID); err != nil {
+			log.ErrorWithFields("reconcile worker node err", log.Fields{"node": node.Name, "error": err})
+		}
+	}
+}
+
+// reconcileWorkerNode sets the left resource of a worker node to its total
+// resource minus the resource used by the running worker containers and the
+// unfinished events. The node is skipped if its resource changes during the
+// reconciliation.
+func reconcileWorkerNode(ds *store.DataStore, nodeID string) error {
+	dispatchLock.Lock()
+	defer dispatchLock.Unlock()
+
+	node, err := ds.FindWorkerNodeByID(nodeID)
+	if err != nil {
+		return err
+	}
+
+	dm, err := docker.NewManager(node.DockerHost, "", registryWorker)
+	if err != nil {
+		return err
+	}
+	containers, err := dm.ListContainersByLabel(WORKER_LABEL_EVENTID)
+	if err != nil {
+		return err
+	}
+
+	left := computeLeftResource(node.TotalResource, containers, GetList().eventsOnDockerHost(node.DockerHost))
+	if left == node.LeftResource {
+		return nil
+	}
+
+	log.InfoWithFields("reconcile worker node left resource",
+		log.Fields{"node": node.Name, "recorded": node.LeftResource, "actual": left})
+	err = ds.CompareAndSetWorkerNodeLeftResource(node.NodeID, node.LeftResource, left)
+	if err == mgo.ErrNotFound {
+		log.Infof("worker node %s changed during reconciliation, skip it", node.Name)
+		return nil
+	}
+	return err
+}
+
+// computeLeftResource computes the left resource from the total resource and the
+// resource used by worker containers. The unfinished events whose containers are
+// not running are counted too, as their resource is released in the post hook.
+func computeLeftResource(total api.NodeResource, containers []*docker_client.Container,
+	events []*api.Event) api.NodeResource {
+	used := make(map[string]api.BuildResource)
+	for _, event := range events {
+		used[string(event.EventID)] = event.WorkerInfo.UsedResource
+	}
+	for _, container := range containers {
+		if container.Config == nil || container.HostConfig == nil {
+			continue
+		}
+		used[container.Config.Labels[WORKER_LABEL_EVENTID]] = api.BuildResource{
+			Memory: float64(container.HostConfig.Memory),
+			CPU:    float64(container.HostConfig.CPUShares),
+		}
+	}
+
+	left := total
+	for _, resource := range used {
+		left.Memory -= resource.Memory
+		left.CPU -= resource.CPU
+	}
+	return left
+}
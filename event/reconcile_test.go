@@ -0,0 +1,50 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"testing"
+
+	"github.com/caicloud/cyclone/api"
+	docker_client "github.com/fsouza/go-dockerclient"
+)
+
+// TestComputeLeftResource tests the computeLeftResource function.
+func TestComputeLeftResource(t *testing.T) {
+	total := api.NodeResource{Memory: 4096, CPU: 4096}
+	containers := []*docker_client.Container{
+		{
+			Config:     &docker_client.Config{Labels: map[string]string{WORKER_LABEL_EVENTID: "e1"}},
+			HostConfig: &docker_client.HostConfig{Memory: 1024, CPUShares: 512},
+		},
+		{
+			Config:     &docker_client.Config{Labels: map[string]string{WORKER_LABEL_EVENTID: "e2"}},
+			HostConfig: &docker_client.HostConfig{Memory: 512, CPUShares: 512},
+		},
+	}
+	events := []*api.Event{
+		// e1 is running, it is counted by its container.
+		{EventID: "e1", WorkerInfo: api.WorkerInfo{UsedResource: api.BuildResource{Memory: 1024, CPU: 512}}},
+		// e3 has finished, but its resource has not been released.
+		{EventID: "e3", WorkerInfo: api.WorkerInfo{UsedResource: api.BuildResource{Memory: 256, CPU: 256}}},
+	}
+
+	left := computeLeftResource(total, containers, events)
+	if left.Memory != 2304 || left.CPU != 2816 {
+		t.Errorf("Expect left memory equals to 2304, cpu equals to 2816, got %v", left)
+	}
+}
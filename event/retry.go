@@ -0,0 +1,138 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"time"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/pkg/log"
+	"github.com/caicloud/cyclone/pkg/osutil"
+)
+
+const (
+	// EVENT_MAX_ATTEMPTS is the default max times to handle a event.
+	EVENT_MAX_ATTEMPTS = "EVENT_MAX_ATTEMPTS"
+
+	// EVENT_RETRY_BACKOFF is the default delay in seconds before the first retry.
+	EVENT_RETRY_BACKOFF = "EVENT_RETRY_BACKOFF"
+
+	// EVENT_RETRY_MAX_BACKOFF is the default max delay in seconds before a retry.
+	EVENT_RETRY_MAX_BACKOFF = "EVENT_RETRY_MAX_BACKOFF"
+)
+
+// defaultRetryOn is the classes of errors retried by default, user build
+// failures, unavailable repositories and timeouts are not retried.
+var defaultRetryOn = []api.ErrorClass{
+	api.ErrorClassInfrastructure,
+	api.ErrorClassClone,
+	api.ErrorClassPush,
+}
+
+// retryPolicy gets the retry policy of the event, the unset fields are filled
+// with the defaults configured by env.
+func retryPolicy(event *api.Event) api.RetryPolicy {
+	var policy api.RetryPolicy
+	if event.RetryPolicy != nil {
+		policy = *event.RetryPolicy
+	}
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = osutil.GetIntEnv(EVENT_MAX_ATTEMPTS, 3)
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = osutil.GetIntEnv(EVENT_RETRY_BACKOFF, 30)
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = osutil.GetIntEnv(EVENT_RETRY_MAX_BACKOFF, 600)
+	}
+	if policy.RetryOn == nil {
+		policy.RetryOn = defaultRetryOn
+	}
+	return policy
+}
+
+// shouldRetry returns whether the failed event should be retried.
+func shouldRetry(event *api.Event) bool {
+	if event.Status != api.EventStatusFail {
+		return false
+	}
+
+	policy := retryPolicy(event)
+	if len(event.Attempts)+1 >= policy.MaxAttempts {
+		return false
+	}
+	for _, class := range policy.RetryOn {
+		if class == event.ErrorClass {
+			return true
+		}
+	}
+	return false
+}
+
+// retryBackoff gets the delay before the retry after the failed attempts, the
+// delay doubles for each retry until the max backoff.
+func retryBackoff(policy api.RetryPolicy, failed int) time.Duration {
+	backoff := time.Duration(policy.Backoff) * time.Second
+	maxBackoff := time.Duration(policy.MaxBackoff) * time.Second
+	for i := 1; i < failed && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// recordAttempt appends the finished attempt to the history of the event, and
+// to the version to keep it after the event finishes.
+func recordAttempt(event *api.Event) {
+	event.Attempts = append(event.Attempts, api.EventAttempt{
+		Attempt:      len(event.Attempts) + 1,
+		StartTime:    event.WorkerInfo.StartTime,
+		EndTime:      time.Now(),
+		DockerHost:   event.WorkerInfo.DockerHost,
+		WorkerID:     event.WorkerInfo.ContainerID,
+		Status:       event.Status,
+		ErrorClass:   event.ErrorClass,
+		ErrorMessage: event.ErrorMessage,
+	})
+	event.Version.Attempts = append(event.Version.Attempts, event.Attempts[len(event.Attempts)-1])
+}
+
+// retryEvent records the failed attempt and puts the event back to pending
+// after the backoff if it should be retried. The resources of the worker
+// must have been released by the caller.
+func retryEvent(event *api.Event) bool {
+	if !shouldRetry(event) {
+		return false
+	}
+
+	recordAttempt(event)
+	backoff := retryBackoff(retryPolicy(event), len(event.Attempts))
+	log.Infof("retry event %s in %v, attempt %d failed: %s", event.EventID, backoff,
+		len(event.Attempts), event.ErrorMessage)
+
+	event.WorkerInfo = api.WorkerInfo{Requeues: event.WorkerInfo.Requeues}
+	event.Status = api.EventStatusPending
+	event.ErrorMessage = ""
+	event.ErrorClass = ""
+	event.RetryTime = time.Now().Add(backoff)
+	if err := SaveEventToEtcd(event); err != nil {
+		log.Errorf("save event %s err: %v", event.EventID, err)
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/caicloud/cyclone/api"
+)
+
+// TestShouldRetry tests deciding whether to retry failed events.
+func TestShouldRetry(t *testing.T) {
+	event := newTestEvent("e1", "u1", "v1")
+	event.Status = api.EventStatusFail
+	event.ErrorClass = api.ErrorClassPush
+	event.RetryPolicy = &api.RetryPolicy{MaxAttempts: 2}
+
+	if !shouldRetry(event) {
+		t.Errorf("Expect push failure retried")
+	}
+
+	recordAttempt(event)
+	if len(event.Attempts) != 1 || event.Attempts[0].Attempt != 1 ||
+		event.Attempts[0].ErrorClass != api.ErrorClassPush {
+		t.Errorf("Expect the attempt recorded, got %+v", event.Attempts)
+	}
+	if !reflect.DeepEqual(event.Version.Attempts, event.Attempts) {
+		t.Errorf("Expect the attempt kept in the version, got %+v", event.Version.Attempts)
+	}
+	if shouldRetry(event) {
+		t.Errorf("Expect no retry after max attempts")
+	}
+
+	event.Attempts = nil
+	event.ErrorClass = api.ErrorClassBuild
+	if shouldRetry(event) {
+		t.Errorf("Expect build failure not retried")
+	}
+
+	event.ErrorClass = api.ErrorClassRepository
+	if shouldRetry(event) {
+		t.Errorf("Expect unavailable repository not retried")
+	}
+
+	event.ErrorClass = api.ErrorClassBuild
+	event.RetryPolicy.RetryOn = []api.ErrorClass{api.ErrorClassBuild}
+	if !shouldRetry(event) {
+		t.Errorf("Expect build failure retried by the policy")
+	}
+
+	event.Status = api.EventStatusCancel
+	if shouldRetry(event) {
+		t.Errorf("Expect cancelled event not retried")
+	}
+}
+
+// TestRetryBackoff tests the exponential backoff of retries.
+func TestRetryBackoff(t *testing.T) {
+	policy := api.RetryPolicy{Backoff: 10, MaxBackoff: 60}
+	expects := []time.Duration{10, 20, 40, 60, 60}
+	for i, expect := range expects {
+		if backoff := retryBackoff(policy, i+1); backoff != expect*time.Second {
+			t.Errorf("Expect backoff %v after %d failures, got %v", expect*time.Second, i+1, backoff)
+		}
+	}
+}
+
+// TestQueueSkipsBackoff tests the retried events are not dispatched until
+// their backoff ends.
+func TestQueueSkipsBackoff(t *testing.T) {
+	var q Queue
+	q.Init(nil)
+
+	retried := newTestEvent("e1", "u1", "v1")
+	retried.RetryTime = time.Now().Add(time.Hour)
+	q.In(retried)
+	q.In(newTestEvent("e2", "u1", "v2"))
+
+	expectOrder(t, dispatchAll(&q), "e2")
+	if q.Len() != 1 {
+		t.Errorf("Expect the retried event kept in the queue")
+	}
+
+	retried.RetryTime = time.Now().Add(-time.Second)
+	expectOrder(t, dispatchAll(&q), "e1")
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/pkg/log"
+	"github.com/caicloud/cyclone/pkg/osutil"
+	"golang.org/x/net/context"
+)
+
+// timeouts tracks the due time of the running events.
+var timeouts = NewTimeoutTracker(expireEvent)
+
+// eventTimeout gets the max time to run the worker of the event, the timeout
+// of the version overrides the service's, and WORKER_TIMEOUT is the default.
+func eventTimeout(event *api.Event) time.Duration {
+	timeout := event.Version.Timeout
+	if timeout <= 0 || event.Operation != CreateVersionOps {
+		timeout = event.Service.Timeout
+	}
+	if timeout <= 0 {
+		timeout = osutil.GetIntEnv(WORKER_TIMEOUT, DEFAULT_WORKER_TIMEOUT)
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+// timeoutItem is a running event in the heap.
+type timeoutItem struct {
+	eventID  api.EventID
+	workerID string
+	due      time.Time
+	index    int
+}
+
+// timeoutHeap is a min heap of events ordered by their due time.
+type timeoutHeap []*timeoutItem
+
+func (h timeoutHeap) Len() int           { return len(h) }
+func (h timeoutHeap) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+func (h timeoutHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timeoutHeap) Push(x interface{}) {
+	item := x.(*timeoutItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *timeoutHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+// TimeoutTracker tracks the due time of running events in a heap, and expires
+// the events with one timer instead of a goroutine for each event.
+type TimeoutTracker struct {
+	sync.Mutex
+	heap  timeoutHeap
+	items map[api.EventID]*timeoutItem
+	// expire is called when a event is due, with the worker id when tracked.
+	expire func(eventID api.EventID, workerID string)
+	// wakeCh wakes up the tracker when the earliest due time changes.
+	wakeCh chan struct{}
+}
+
+// NewTimeoutTracker creates a timeout tracker.
+func NewTimeoutTracker(expire func(eventID api.EventID, workerID string)) *TimeoutTracker {
+	return &TimeoutTracker{
+		items:  make(map[api.EventID]*timeoutItem),
+		expire: expire,
+		wakeCh: make(chan struct{}, 1),
+	}
+}
+
+// Add tracks the worker of the event until its due time, the previous worker of
+// the event is not tracked any more.
+func (t *TimeoutTracker) Add(eventID api.EventID, workerID string, due time.Time) {
+	t.Lock()
+	defer t.Unlock()
+
+	if item, ok := t.items[eventID]; ok {
+		item.workerID = workerID
+		item.due = due
+		heap.Fix(&t.heap, item.index)
+	} else {
+		item = &timeoutItem{eventID: eventID, workerID: workerID, due: due}
+		heap.Push(&t.heap, item)
+		t.items[eventID] = item
+	}
+	t.wake()
+}
+
+// Remove stops tracking the event.
+func (t *TimeoutTracker) Remove(eventID api.EventID) {
+	t.Lock()
+	defer t.Unlock()
+
+	if item, ok := t.items[eventID]; ok {
+		heap.Remove(&t.heap, item.index)
+		delete(t.items, eventID)
+	}
+}
+
+// Reset stops tracking all events.
+func (t *TimeoutTracker) Reset() {
+	t.Lock()
+	defer t.Unlock()
+
+	t.heap = nil
+	t.items = make(map[api.EventID]*timeoutItem)
+	t.wake()
+}
+
+// Len returns the number of tracked events.
+func (t *TimeoutTracker) Len() int {
+	t.Lock()
+	defer t.Unlock()
+
+	return len(t.heap)
+}
+
+// Run expires the events when they are due, until the context is cancelled.
+func (t *TimeoutTracker) Run(ctx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		for _, item := range t.popDue(time.Now()) {
+			t.expire(item.eventID, item.workerID)
+		}
+
+		timer.Reset(t.nextWait(time.Now()))
+		select {
+		case <-ctx.Done():
+			log.Info("stop tracking event timeouts")
+			return
+		case <-timer.C:
+		case <-t.wakeCh:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+	}
+}
+
+// popDue removes and returns the events due before now.
+func (t *TimeoutTracker) popDue(now time.Time) []*timeoutItem {
+	t.Lock()
+	defer t.Unlock()
+
+	var items []*timeoutItem
+	for len(t.heap) > 0 && !t.heap[0].due.After(now) {
+		item := heap.Pop(&t.heap).(*timeoutItem)
+		delete(t.items, item.eventID)
+		items = append(items, item)
+	}
+	return items
+}
+
+// nextWait returns the time to wait until the earliest due time.
+func (t *TimeoutTracker) nextWait(now time.Time) time.Duration {
+	t.Lock()
+	defer t.Unlock()
+
+	if len(t.heap) == 0 {
+		return time.Hour
+	}
+	return t.heap[0].due.Sub(now)
+}
+
+// wake wakes up the tracker without blocking, the caller must hold the lock.
+func (t *TimeoutTracker) wake() {
+	select {
+	case t.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// trackWorkerTimeOut tracks the timeout of the running event. The project
+// versions run by the tasks of their services and the events without a worker
+// are not tracked.
+func trackWorkerTimeOut(event *api.Event) {
+	if IsEventFinished(event) || event.Operation == CreateProjectVersionOps || event.WorkerInfo.ContainerID == "" {
+		return
+	}
+	timeouts.Add(event.EventID, event.WorkerInfo.ContainerID, event.WorkerInfo.DueTime)
+}
+
+// expireEvent fails the event if it is still run by the worker, the worker is
+// fired by the post hook.
+func expireEvent(eventID api.EventID, workerID string) {
+	// Only the leader fails the timed out events.
+	if !IsLeader() {
+		return
+	}
+
+	event, err := LoadEventFromEtcd(eventID)
+	if err != nil {
+		log.Errorf("load timed out event %s err: %v", eventID, err)
+		return
+	}
+
+	// The event may have been requeued and run by another worker.
+	if IsEventFinished(event) || event.WorkerInfo.ContainerID != workerID {
+		return
+	}
+
+	log.Infof("event time out: %s", eventID)
+	event.Status = api.EventStatusFail
+	event.ErrorMessage = "worker time out"
+	event.ErrorClass = api.ErrorClassTimeout
+	if err := SaveEventToEtcd(event); err != nil {
+		log.Errorf("save event %s err: %v", eventID, err)
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/caicloud/cyclone/api"
+	"golang.org/x/net/context"
+)
+
+// TestEventTimeout tests the timeout of the version overrides the service's.
+func TestEventTimeout(t *testing.T) {
+	event := newTestEvent("e1", "u1", "v1")
+	if timeout := eventTimeout(event); timeout != DEFAULT_WORKER_TIMEOUT*time.Second {
+		t.Errorf("Expect the default timeout, got %v", timeout)
+	}
+
+	event.Service.Timeout = 600
+	if timeout := eventTimeout(event); timeout != 600*time.Second {
+		t.Errorf("Expect the service timeout, got %v", timeout)
+	}
+
+	event.Version.Timeout = 60
+	if timeout := eventTimeout(event); timeout != 60*time.Second {
+		t.Errorf("Expect the version timeout, got %v", timeout)
+	}
+}
+
+// TestTimeoutTracker tests expiring events in the order of their due time.
+func TestTimeoutTracker(t *testing.T) {
+	expired := make(chan api.EventID, 10)
+	tracker := NewTimeoutTracker(func(eventID api.EventID, workerID string) {
+		if workerID != "w-"+string(eventID) {
+			t.Errorf("Expect worker w-%s, got %s", eventID, workerID)
+		}
+		expired <- eventID
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tracker.Run(ctx)
+
+	now := time.Now()
+	tracker.Add("e1", "w-e1", now.Add(200*time.Millisecond))
+	tracker.Add("e2", "w-e2", now.Add(50*time.Millisecond))
+	tracker.Add("e3", "w-e3", now.Add(100*time.Millisecond))
+	// e3 finishes in time.
+	tracker.Remove("e3")
+	// e1 is requeued and run by another worker.
+	tracker.Add("e1", "w-e1", now.Add(150*time.Millisecond))
+
+	for _, expect := range []api.EventID{"e2", "e1"} {
+		select {
+		case got := <-expired:
+			if got != expect {
+				t.Errorf("Expect %s expired, got %s", expect, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expect %s expired in time", expect)
+		}
+	}
+
+	select {
+	case got := <-expired:
+		t.Errorf("Expect no more events expired, got %s", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if tracker.Len() != 0 {
+		t.Errorf("Expect no events tracked, got %d", tracker.Len())
+	}
+}
+
+// fakeEventLister lists the events in etcd.
+type fakeEventLister []*api.Event
+
+func (l fakeEventLister) List(dir string) ([]string, error) {
+	jsonEvents := []string{}
+	for _, event := range l {
+		jsonEvent, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		jsonEvents = append(jsonEvents, string(jsonEvent))
+	}
+	return jsonEvents, nil
+}
+
+// TestLoadRunningEvents tests only the running events with a worker are tracked
+// once loaded from etcd, the running project version has no worker.
+func TestLoadRunningEvents(t *testing.T) {
+	timeouts.Reset()
+	defer timeouts.Reset()
+
+	pending := newTestEvent("e1", "u1", "v1")
+	running := newTestEvent("e2", "u1", "v2")
+	running.Status = api.EventStatusRunning
+	running.WorkerInfo.ContainerID = "w-e2"
+	running.WorkerInfo.DueTime = time.Now().Add(time.Hour)
+	project := newTestEvent("e3", "u1", "v3")
+	project.Operation = CreateProjectVersionOps
+	project.Status = api.EventStatusRunning
+
+	el := &List{events: make(map[api.EventID]*api.Event)}
+	el.loadListFromEtcd(fakeEventLister{pending, running, project})
+
+	if _, ok := el.events["e1"]; !ok || len(el.events) != 1 {
+		t.Errorf("Expect the pending event in the list, got %v", el.events)
+	}
+	if timeouts.Len() != 1 {
+		t.Errorf("Expect only the running event with a worker tracked, got %d", timeouts.Len())
+	}
+}
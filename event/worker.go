@@ -28,13 +28,13 @@ import (
 	"github.com/caicloud/cyclone/pkg/log"
 	"github.com/caicloud/cyclone/pkg/osutil"
 	"github.com/caicloud/cyclone/store"
+	"github.com/caicloud/cyclone/worker/cache"
 	docker_client "github.com/fsouza/go-dockerclient"
 )
 
 // Worker is the type for Cyclone Worker.
 type Worker struct {
-	dockerHost  string
-	dm          *docker.Manager
+	backend     WorkerBackend
 	containerID string
 }
 
@@ -43,12 +43,20 @@ const (
 	WORKER_IMAGE        = "WORKER_IMAGE"
 	WORK_DOCKER_HOST    = "WORK_DOCKER_HOST"
 
+	// DEFAULT_WORKER_IMAGE is the worker image used if WORKER_IMAGE is not set.
+	DEFAULT_WORKER_IMAGE = "cargo.caicloud.io/caicloud/cyclone-worker"
+
 	// worker env setting
 	WORKER_EVENTID = "WORKER_EVENTID"
-	SERVER_HOST    = "SERVER_HOST"
 
-	// worker time out
-	WORKER_TIMEOUT = 7200 * time.Second
+	// WORKER_LABEL_EVENTID is the label of worker containers, its value is the event id.
+	WORKER_LABEL_EVENTID = "cyclone.worker.eventid"
+	SERVER_HOST          = "SERVER_HOST"
+
+	// WORKER_TIMEOUT is the default max time in seconds to run a worker.
+	WORKER_TIMEOUT = "WORKER_TIMEOUT"
+	// DEFAULT_WORKER_TIMEOUT is the default value of WORKER_TIMEOUT.
+	DEFAULT_WORKER_TIMEOUT = 7200
 
 	WORK_REGISTRY_LOCATION = "WORK_REGISTRY_LOCATION"
 	REGISTRY_USERNAME      = "REGISTRY_USERNAME"
@@ -77,6 +85,10 @@ type RegistryCompose struct {
 
 // NewWorker new a worker
 func NewWorker(event *api.Event) (*Worker, error) {
+	if workerBackendName() == KubernetesBackend {
+		return newKubernetesWorker(event)
+	}
+
 	dockerHostWorker, err := GetWorkerDockerHost(event)
 	if err != nil {
 		return nil, err
@@ -85,13 +97,42 @@ func NewWorker(event *api.Event) (*Worker, error) {
 	dockerManager, err := docker.NewManager(dockerHostWorker, "", registryWorker)
 	//dockerManager, err := docker.NewManager(dockerHostWorker, certPathWorker, registryWorker)
 	if err != nil {
+		releaseWorkerNodeResource(event)
+		if errRelease := resourceManager.ReleaseResource(event); errRelease != nil {
+			log.Errorf("Unable to release resource %v", errRelease)
+		}
 		return nil, err
 	}
 	w := &Worker{
-		dockerHost:  dockerHostWorker,
-		dm:          dockerManager,
+		backend:     &dockerBackend{dm: dockerManager},
 		containerID: event.WorkerInfo.ContainerID,
 	}
+	event.WorkerInfo.Backend = DockerBackend
+
+	return w, nil
+}
+
+// newKubernetesWorker new a worker running as a pod in kubernetes, the pod is
+// scheduled by kubernetes, so only the resource quota of the user is applied.
+func newKubernetesWorker(event *api.Event) (*Worker, error) {
+	setUsedResource(event)
+	if err := resourceManager.ApplyResource(event); err != nil {
+		log.Errorf("apply resource err %v", err)
+		return nil, err
+	}
+
+	backend, err := newKubernetesBackend()
+	if err != nil {
+		if errRelease := resourceManager.ReleaseResource(event); errRelease != nil {
+			log.Errorf("Unable to release resource %v", errRelease)
+		}
+		return nil, err
+	}
+	w := &Worker{
+		backend:     backend,
+		containerID: event.WorkerInfo.ContainerID,
+	}
+	event.WorkerInfo.Backend = KubernetesBackend
 
 	return w, nil
 }
@@ -102,148 +143,140 @@ func LoadWorker(event *api.Event) (*Worker, error) {
 		return nil, fmt.Errorf("event with empty workerinfo")
 	}
 
+	if event.WorkerInfo.Backend == KubernetesBackend {
+		backend, err := newKubernetesBackend()
+		if err != nil {
+			return nil, err
+		}
+		return &Worker{
+			backend:     backend,
+			containerID: event.WorkerInfo.ContainerID,
+		}, nil
+	}
+
 	dockerHostWorker := event.WorkerInfo.DockerHost
 	dockerManager, err := docker.NewManager(dockerHostWorker, certPathWorker, registryWorker)
 	if err != nil {
 		return nil, err
 	}
 	w := &Worker{
-		dockerHost:  dockerHostWorker,
-		dm:          dockerManager,
+		backend:     &dockerBackend{dm: dockerManager},
 		containerID: event.WorkerInfo.ContainerID,
 	}
 
 	return w, nil
 }
 
-// GetWorkerDockerHost get woker docker host LB according to node resource
-func GetWorkerDockerHost(event *api.Event) (string, error) {
+// setUsedResource sets the resource used by the worker of the event.
+func setUsedResource(event *api.Event) {
 	if event.Operation == CreateVersionOps {
 		event.WorkerInfo.UsedResource = event.Version.BuildResource
 	} else {
 		event.WorkerInfo.UsedResource.Memory = osutil.GetFloat64Env(MEMORY_FOR_CONTAINER, 536870912.0) //512M
 		event.WorkerInfo.UsedResource.CPU = osutil.GetFloat64Env(CPU_FOR_CONTAINER, 512.0)
 	}
+}
 
-	ds := store.NewStore()
-	defer ds.Close()
-	workerNodes, err := ds.FindSystemWorkerNodeByResource(&(event.WorkerInfo.UsedResource))
-	if err != nil {
-		log.Errorf("Get worker docker host err %v", err)
-		return "", err
-	}
-	if len(workerNodes) == 0 {
-		log.Errorf("Get worker docker host busy")
-		return "", ErrWorkerBusy
-	}
+// GetWorkerDockerHost get woker docker host LB according to node resource, the
+// resource is reserved on the node atomically.
+func GetWorkerDockerHost(event *api.Event) (string, error) {
+	setUsedResource(event)
 
-	err = resourceManager.ApplyResource(event)
+	err := resourceManager.ApplyResource(event)
 	if err != nil {
 		log.Errorf("apply resource err %v", err)
 		return "", err
 	}
 
-	workerDockerHost := workerNodes[0].DockerHost
-	log.Infof("Get worker docker host: %s", workerDockerHost)
-	workerNodes[0].LeftResource.CPU -= event.WorkerInfo.UsedResource.CPU
-	workerNodes[0].LeftResource.Memory -= event.WorkerInfo.UsedResource.Memory
-	_, err = ds.UpsertWorkerNodeDocument(&workerNodes[0])
+	ds := store.NewStore()
+	defer ds.Close()
+	workerNode, err := placeWorker(ds, getPlacementStrategy(), &(event.WorkerInfo.UsedResource), nodeSelector(event))
 	if err != nil {
-		log.Errorf("Update woker node err %v", err)
+		if errRelease := resourceManager.ReleaseResource(event); errRelease != nil {
+			log.Errorf("Unable to release resource %v", errRelease)
+		}
+		if err == ErrWorkerBusy {
+			log.Errorf("Get worker docker host busy")
+		} else {
+			log.Errorf("Get worker docker host err %v", err)
+		}
 		return "", err
 	}
 
+	workerDockerHost := workerNode.DockerHost
+	log.Infof("Get worker docker host: %s", workerDockerHost)
+	event.WorkerInfo.DockerHost = workerDockerHost
 	return workerDockerHost, nil
 }
 
+// releaseWorkerNodeResource gives back the resource reserved by the event to
+// its worker node.
+func releaseWorkerNodeResource(event *api.Event) {
+	if event.WorkerInfo.DockerHost == "" {
+		return
+	}
+
+	ds := store.NewStore()
+	defer ds.Close()
+	err := ds.ReleaseWorkerNodeResource(event.WorkerInfo.DockerHost, &(event.WorkerInfo.UsedResource))
+	if err != nil {
+		log.Errorf("release worker node resource err: %v", err)
+	}
+}
+
 // DoWork create a container start do work
 func (w *Worker) DoWork(event *api.Event) (err error) {
-	coo := toBuildContainerConfig(event.EventID, int64(event.WorkerInfo.UsedResource.CPU),
-		int64(event.WorkerInfo.UsedResource.Memory))
-	w.containerID, err = w.dm.RunContainer(coo)
+	w.containerID, err = w.backend.Run(event)
 	if err != nil {
-		w.dm.StopContainer(w.containerID)
-		w.dm.RemoveContainer(w.containerID) // release resource of worker node
-		ds := store.NewStore()
-		defer ds.Close()
-		nodes, errinfo := ds.FindWorkerNodesByDockerHost(event.WorkerInfo.DockerHost)
-		if errinfo != nil || len(nodes) != 1 {
-			log.Errorf("find worker node err: %v", err)
-		} else {
-			nodes[0].LeftResource.Memory += event.WorkerInfo.UsedResource.Memory
-			nodes[0].LeftResource.CPU += event.WorkerInfo.UsedResource.CPU
-			_, err := ds.UpsertWorkerNodeDocument(&(nodes[0]))
-			if err != nil {
-				log.Errorf("release worker node resource err: %v", err)
-			}
+		// release resource of worker node
+		releaseWorkerNodeResource(event)
+		if errRelease := resourceManager.ReleaseResource(event); errRelease != nil {
+			log.Errorf("Unable to release resource %v", errRelease)
 		}
 		return err
 	}
 
-	event.WorkerInfo.DockerHost = w.dockerHost
 	event.WorkerInfo.ContainerID = w.containerID
-	event.WorkerInfo.DueTime = time.Now().Add(time.Duration(WORKER_TIMEOUT))
+	event.WorkerInfo.StartTime = time.Now()
+	event.WorkerInfo.DueTime = event.WorkerInfo.StartTime.Add(eventTimeout(event))
 	err = SaveEventToEtcd(event)
 	log.Infof("save event worker info: %s, %v", w.containerID, err)
-	go CheckWorkerTimeOut(*event)
+	trackWorkerTimeOut(event)
 	return nil
 }
 
 // Fire fire a worker, stop and remove the worker container
 func (w *Worker) Fire() error {
-	// stop worker container
-	err := w.dm.StopContainer(w.containerID)
-	if err != nil {
-		log.Errorf("stop err: %v", err)
-	}
-
-	// remove worker container
-	err = w.dm.RemoveContainer(w.containerID)
-	if err != nil {
-		log.Errorf("remove err: %v", err)
-	}
-	return err
+	return w.backend.Stop(w.containerID)
 }
 
-// CheckWorkerTimeOut ensures that the events are not timed out.
-func CheckWorkerTimeOut(e api.Event) {
-	var eventCopy api.Event
-	eventCopy = e
-	event := &eventCopy
-	if IsEventFinished(event) {
-		return
-	}
-
-	now := time.Now()
-	dueTime := event.WorkerInfo.DueTime
-	var err error
-	// has time out
-	if !dueTime.After(now) {
-		log.Infof("event has time out: %v", event)
-
-		// save event to etcd
-		event.Status = api.EventStatusFail
-		SaveEventToEtcd(event)
-		return
+// toContainerConfig creates CreateContainerOptions from BuildNode.
+func toBuildContainerConfig(eventID api.EventID, cpu, memory int64) *docker_client.CreateContainerOptions {
+	config := &docker_client.Config{
+		Image:  osutil.GetStringEnv(WORKER_IMAGE, DEFAULT_WORKER_IMAGE),
+		Env:    workerEnvs(eventID),
+		Labels: map[string]string{WORKER_LABEL_EVENTID: string(eventID)},
 	}
-	remain := event.WorkerInfo.DueTime.Sub(now)
-	time.Sleep(remain)
 
-	event, err = LoadEventFromEtcd(event.EventID)
-	if err != nil {
-		return
+	cacheDir := osutil.GetStringEnv(cache.WORKER_CACHE_DIR, cache.DEFAULT_WORKER_CACHE_DIR)
+	hostConfig := &docker_client.HostConfig{
+		Privileged:  true,
+		NetworkMode: "host",
+		AutoRemove:  true,
+		CPUShares:   cpu,
+		Memory:      memory,
+		Binds:       []string{cacheDir + ":" + cacheDir},
 	}
 
-	if !IsEventFinished(event) {
-		log.Infof("event time out: %v", event)
-		event.Status = api.EventStatusFail
-		SaveEventToEtcd(event)
+	createContainerOptions := &docker_client.CreateContainerOptions{
+		Config:     config,
+		HostConfig: hostConfig,
 	}
+	return createContainerOptions
 }
 
-// toContainerConfig creates CreateContainerOptions from BuildNode.
-func toBuildContainerConfig(eventID api.EventID, cpu, memory int64) *docker_client.CreateContainerOptions {
-	workerImage := osutil.GetStringEnv(WORKER_IMAGE, "cargo.caicloud.io/caicloud/cyclone-worker")
+// workerEnvs gets the envs of the worker container, in the form of "key=value".
+func workerEnvs(eventID api.EventID) []string {
 	serverHost := osutil.GetStringEnv(CYCLONE_SERVER_HOST, "http://127.0.0.1:7099")
 	registryLocation := osutil.GetStringEnv(WORK_REGISTRY_LOCATION, "")
 	registryUsername := osutil.GetStringEnv(REGISTRY_USERNAME, "")
@@ -252,6 +285,7 @@ func toBuildContainerConfig(eventID api.EventID, cpu, memory int64) *docker_clie
 	clairServerIP := osutil.GetStringEnv(CLAIR_SERVER_IP, "http://127.0.0.1:6060")
 	gitlabServer := osutil.GetStringEnv("SERVER_GITLAB", "https://gitlab.com")
 	logServer := osutil.GetStringEnv(LOG_SERVER, "ws://127.0.0.1:8000/ws")
+	cacheDir := osutil.GetStringEnv(cache.WORKER_CACHE_DIR, cache.DEFAULT_WORKER_CACHE_DIR)
 
 	envEventID := fmt.Sprintf("%s=%s", WORKER_EVENTID, string(eventID))
 	envServerHost := fmt.Sprintf("%s=%s", SERVER_HOST, serverHost)
@@ -262,26 +296,10 @@ func toBuildContainerConfig(eventID api.EventID, cpu, memory int64) *docker_clie
 	envclairServerIP := fmt.Sprintf("%s=%s", CLAIR_SERVER_IP, clairServerIP)
 	envgitlabServer := fmt.Sprintf("%s=%s", SERVER_GITLAB, gitlabServer)
 	envLogServer := fmt.Sprintf("%s=%s", LOG_SERVER, logServer)
+	envCacheDir := fmt.Sprintf("%s=%s", cache.WORKER_CACHE_DIR, cacheDir)
 
-	config := &docker_client.Config{
-		Image: workerImage,
-		Env: []string{envEventID, envServerHost, envregistryLocation, envregistryUsername, envregistryPassword,
-			envconsoleWebEndpoint, envclairServerIP, envgitlabServer, envLogServer},
-	}
-
-	hostConfig := &docker_client.HostConfig{
-		Privileged:  true,
-		NetworkMode: "host",
-		AutoRemove:  true,
-		CPUShares:   cpu,
-		Memory:      memory,
-	}
-
-	createContainerOptions := &docker_client.CreateContainerOptions{
-		Config:     config,
-		HostConfig: hostConfig,
-	}
-	return createContainerOptions
+	return []string{envEventID, envServerHost, envregistryLocation, envregistryUsername, envregistryPassword,
+		envconsoleWebEndpoint, envclairServerIP, envgitlabServer, envLogServer, envCacheDir}
 }
 
 // traceScript is a helper script that is added
@@ -19,7 +19,10 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 	"time"
 
 	"github.com/caicloud/cyclone/api"
@@ -105,6 +108,7 @@ func main() {
 
 	// init event manager
 	initEventManger()
+	go handleSignals()
 
 	// init log server
 	go initLogServer()
@@ -153,6 +157,11 @@ func dailMongo(session *mgo.Session) {
 
 	// Init data store
 	store.Init(session)
+	ds := store.NewStore()
+	defer ds.Close()
+	if err := ds.EnsureIndexes(); err != nil {
+		log.Errorf("Unable to ensure indexes: %v", err)
+	}
 }
 
 // initAPIServer init restful api server.
@@ -193,6 +202,17 @@ func startAPIServer() {
 	log.Fatal(server.ListenAndServe())
 }
 
+// handleSignals gives up the leadership of event manager before exiting, so
+// that other cyclone servers can take over at once.
+func handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	log.Infof("cyclone server receives signal %v, exiting", sig)
+	event.Stop()
+	os.Exit(0)
+}
+
 // initLogServer init log server.
 func initLogServer() {
 	kafkaIP := osutil.GetStringEnv(KAFKA_SERVER_IP, "127.0.0.1:9092")
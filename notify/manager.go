@@ -84,7 +84,7 @@ func shouldSendNotifyEvent(service *api.Service, version *api.Version) bool {
 		return true
 	} else if setting == api.SendWhenFailed &&
 		(version.Status == api.VersionFailed ||
-			version.YamlDeployStatus == api.DeployFailed ||
+			deployFailed(version.YamlDeployStatus) ||
 			deployPlansFailed(version)) {
 		return true
 	}
@@ -95,9 +95,15 @@ func shouldSendNotifyEvent(service *api.Service, version *api.Version) bool {
 // deployPlansFailed return true if version's deploy plans are all successful.
 func deployPlansFailed(version *api.Version) bool {
 	for _, plan := range version.DeployPlansStatuses {
-		if plan.Status == api.DeployFailed {
+		if deployFailed(plan.Status) {
 			return true
 		}
 	}
 	return false
 }
+
+// deployFailed returns true if the deploy failed, including the ones rolled
+// back after failing.
+func deployFailed(status api.VersionDeployStatus) bool {
+	return status == api.DeployFailed || status == api.DeployRolledBack
+}
@@ -52,6 +52,16 @@ func TestEmailSendLogic(t *testing.T) {
 	if shouldSend := shouldSendNotifyEvent(&service, &version); shouldSend != true {
 		t.Error("Expected send the mail but not.")
 	}
+
+	// the yaml deploy failed and is rolled back.
+	version = api.Version{
+		Status:           api.VersionHealthy,
+		YamlDeployStatus: api.DeployRolledBack,
+	}
+
+	if shouldSend := shouldSendNotifyEvent(&service, &version); shouldSend != true {
+		t.Error("Expected send the mail for the rolled back deploy but not.")
+	}
 }
 
 // TestNewManagerWithoutTemplate tests the manager with wrong template path.
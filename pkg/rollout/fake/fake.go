@@ -0,0 +1,253 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fake implements rollout.Client in memory for the tests. The rollouts
+// complete as soon as the deployments are created or updated, unless they run
+// the broken images.
+package fake
+
+import (
+	k8s_core_api "k8s.io/kubernetes/pkg/api"
+	k8s_errors "k8s.io/kubernetes/pkg/api/errors"
+	k8s_ext_api "k8s.io/kubernetes/pkg/apis/extensions"
+	internalversioncore "k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/typed/core/internalversion"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/typed/extensions/internalversion"
+)
+
+// Client keeps the deployments, services, config maps and ingresses of all the
+// namespaces by name.
+type Client struct {
+	// BrokenImages are the images whose pods never become available.
+	BrokenImages map[string]bool
+
+	deployments map[string]*k8s_ext_api.Deployment
+	services    map[string]*k8s_core_api.Service
+	configMaps  map[string]*k8s_core_api.ConfigMap
+	ingresses   map[string]*k8s_ext_api.Ingress
+}
+
+// NewClient creates a fake client with the deployments and services.
+func NewClient(deployments []*k8s_ext_api.Deployment, services []*k8s_core_api.Service) *Client {
+	c := &Client{
+		BrokenImages: make(map[string]bool),
+		deployments:  make(map[string]*k8s_ext_api.Deployment),
+		services:     make(map[string]*k8s_core_api.Service),
+		configMaps:   make(map[string]*k8s_core_api.ConfigMap),
+		ingresses:    make(map[string]*k8s_ext_api.Ingress),
+	}
+	for _, d := range deployments {
+		c.deployments[d.Name] = d
+		c.rollout(d)
+	}
+	for _, s := range services {
+		c.services[s.Name] = s
+	}
+	return c
+}
+
+// Deployment gets the deployment, nil if it does not exist.
+func (c *Client) Deployment(name string) *k8s_ext_api.Deployment {
+	return c.deployments[name]
+}
+
+// Service gets the service, nil if it does not exist.
+func (c *Client) Service(name string) *k8s_core_api.Service {
+	return c.services[name]
+}
+
+// ConfigMap gets the config map, nil if it does not exist.
+func (c *Client) ConfigMap(name string) *k8s_core_api.ConfigMap {
+	return c.configMaps[name]
+}
+
+// Ingress gets the ingress, nil if it does not exist.
+func (c *Client) Ingress(name string) *k8s_ext_api.Ingress {
+	return c.ingresses[name]
+}
+
+// Deployments implements internalversion.DeploymentsGetter.
+func (c *Client) Deployments(namespace string) internalversion.DeploymentInterface {
+	return &deployments{client: c}
+}
+
+// Services implements internalversioncore.ServicesGetter.
+func (c *Client) Services(namespace string) internalversioncore.ServiceInterface {
+	return &services{client: c}
+}
+
+// ConfigMaps implements internalversioncore.ConfigMapsGetter.
+func (c *Client) ConfigMaps(namespace string) internalversioncore.ConfigMapInterface {
+	return &configMaps{client: c}
+}
+
+// Ingresses implements internalversion.IngressesGetter.
+func (c *Client) Ingresses(namespace string) internalversion.IngressInterface {
+	return &ingresses{client: c}
+}
+
+// rollout updates the status of the deployment as the controller does, all the
+// replicas are updated, and available unless any container runs a broken image.
+func (c *Client) rollout(d *k8s_ext_api.Deployment) {
+	d.Generation++
+	d.Status.ObservedGeneration = d.Generation
+	d.Status.Replicas = d.Spec.Replicas
+	d.Status.UpdatedReplicas = d.Spec.Replicas
+	d.Status.AvailableReplicas = d.Spec.Replicas
+	for _, container := range d.Spec.Template.Spec.Containers {
+		if c.BrokenImages[container.Image] {
+			d.Status.AvailableReplicas = 0
+		}
+	}
+}
+
+// deployments implements internalversion.DeploymentInterface, methods not
+// overridden panic.
+type deployments struct {
+	internalversion.DeploymentInterface
+	client *Client
+}
+
+func (f *deployments) Get(name string) (*k8s_ext_api.Deployment, error) {
+	d, ok := f.client.deployments[name]
+	if !ok {
+		return nil, k8s_errors.NewNotFound(k8s_ext_api.Resource("deployments"), name)
+	}
+	copied := *d
+	copied.Spec.Template.Spec.Containers = append([]k8s_core_api.Container(nil), d.Spec.Template.Spec.Containers...)
+	return &copied, nil
+}
+
+func (f *deployments) Create(d *k8s_ext_api.Deployment) (*k8s_ext_api.Deployment, error) {
+	if _, ok := f.client.deployments[d.Name]; ok {
+		return nil, k8s_errors.NewAlreadyExists(k8s_ext_api.Resource("deployments"), d.Name)
+	}
+	f.client.deployments[d.Name] = d
+	f.client.rollout(d)
+	return d, nil
+}
+
+func (f *deployments) Update(d *k8s_ext_api.Deployment) (*k8s_ext_api.Deployment, error) {
+	if _, ok := f.client.deployments[d.Name]; !ok {
+		return nil, k8s_errors.NewNotFound(k8s_ext_api.Resource("deployments"), d.Name)
+	}
+	f.client.deployments[d.Name] = d
+	f.client.rollout(d)
+	return d, nil
+}
+
+func (f *deployments) Delete(name string, options *k8s_core_api.DeleteOptions) error {
+	if _, ok := f.client.deployments[name]; !ok {
+		return k8s_errors.NewNotFound(k8s_ext_api.Resource("deployments"), name)
+	}
+	delete(f.client.deployments, name)
+	return nil
+}
+
+// services implements internalversioncore.ServiceInterface, methods not
+// overridden panic.
+type services struct {
+	internalversioncore.ServiceInterface
+	client *Client
+}
+
+func (f *services) Get(name string) (*k8s_core_api.Service, error) {
+	s, ok := f.client.services[name]
+	if !ok {
+		return nil, k8s_errors.NewNotFound(k8s_core_api.Resource("services"), name)
+	}
+	copied := *s
+	return &copied, nil
+}
+
+func (f *services) Create(s *k8s_core_api.Service) (*k8s_core_api.Service, error) {
+	if _, ok := f.client.services[s.Name]; ok {
+		return nil, k8s_errors.NewAlreadyExists(k8s_core_api.Resource("services"), s.Name)
+	}
+	f.client.services[s.Name] = s
+	return s, nil
+}
+
+func (f *services) Update(s *k8s_core_api.Service) (*k8s_core_api.Service, error) {
+	if _, ok := f.client.services[s.Name]; !ok {
+		return nil, k8s_errors.NewNotFound(k8s_core_api.Resource("services"), s.Name)
+	}
+	f.client.services[s.Name] = s
+	return s, nil
+}
+
+// configMaps implements internalversioncore.ConfigMapInterface, methods not
+// overridden panic.
+type configMaps struct {
+	internalversioncore.ConfigMapInterface
+	client *Client
+}
+
+func (f *configMaps) Get(name string) (*k8s_core_api.ConfigMap, error) {
+	m, ok := f.client.configMaps[name]
+	if !ok {
+		return nil, k8s_errors.NewNotFound(k8s_core_api.Resource("configmaps"), name)
+	}
+	copied := *m
+	return &copied, nil
+}
+
+func (f *configMaps) Create(m *k8s_core_api.ConfigMap) (*k8s_core_api.ConfigMap, error) {
+	if _, ok := f.client.configMaps[m.Name]; ok {
+		return nil, k8s_errors.NewAlreadyExists(k8s_core_api.Resource("configmaps"), m.Name)
+	}
+	f.client.configMaps[m.Name] = m
+	return m, nil
+}
+
+func (f *configMaps) Update(m *k8s_core_api.ConfigMap) (*k8s_core_api.ConfigMap, error) {
+	if _, ok := f.client.configMaps[m.Name]; !ok {
+		return nil, k8s_errors.NewNotFound(k8s_core_api.Resource("configmaps"), m.Name)
+	}
+	f.client.configMaps[m.Name] = m
+	return m, nil
+}
+
+// ingresses implements internalversion.IngressInterface, methods not
+// overridden panic.
+type ingresses struct {
+	internalversion.IngressInterface
+	client *Client
+}
+
+func (f *ingresses) Get(name string) (*k8s_ext_api.Ingress, error) {
+	i, ok := f.client.ingresses[name]
+	if !ok {
+		return nil, k8s_errors.NewNotFound(k8s_ext_api.Resource("ingresses"), name)
+	}
+	copied := *i
+	return &copied, nil
+}
+
+func (f *ingresses) Create(i *k8s_ext_api.Ingress) (*k8s_ext_api.Ingress, error) {
+	if _, ok := f.client.ingresses[i.Name]; ok {
+		return nil, k8s_errors.NewAlreadyExists(k8s_ext_api.Resource("ingresses"), i.Name)
+	}
+	f.client.ingresses[i.Name] = i
+	return i, nil
+}
+
+func (f *ingresses) Update(i *k8s_ext_api.Ingress) (*k8s_ext_api.Ingress, error) {
+	if _, ok := f.client.ingresses[i.Name]; !ok {
+		return nil, k8s_errors.NewNotFound(k8s_ext_api.Resource("ingresses"), i.Name)
+	}
+	f.client.ingresses[i.Name] = i
+	return i, nil
+}
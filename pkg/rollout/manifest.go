@@ -0,0 +1,263 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rollout
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	k8s_core_api "k8s.io/kubernetes/pkg/api"
+	k8s_errors "k8s.io/kubernetes/pkg/api/errors"
+	k8s_ext_api "k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/runtime"
+	k8s_yaml "k8s.io/kubernetes/pkg/util/yaml"
+)
+
+// manifestExts are the extensions of the manifest files read from a directory.
+var manifestExts = map[string]bool{
+	".yml":  true,
+	".yaml": true,
+	".json": true,
+}
+
+// Values are the values substituted into the manifest templates, e.g.
+// {{ .Image }} is replaced with the built image.
+type Values struct {
+	// Image is the built image with its tag.
+	Image string
+	// Version is the name of the version.
+	Version string
+	// Namespace is the namespace the manifests are applied to.
+	Namespace string
+	// Environment is the environment of the service deployed to.
+	Environment string
+	// Variables are the variables of the environment, e.g.
+	// {{ .Variables.REPLICAS }} is replaced with the variable REPLICAS.
+	Variables map[string]string
+}
+
+// ReadManifests reads the manifests from the file, or the files in the directory
+// by name, renders them with the values and decodes the objects in them.
+func ReadManifests(path string, values Values) ([]runtime.Object, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		infos, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = nil
+		for _, info := range infos {
+			if !info.IsDir() && manifestExts[filepath.Ext(info.Name())] {
+				files = append(files, filepath.Join(path, info.Name()))
+			}
+		}
+	}
+
+	objects := []runtime.Object{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = Render(filepath.Base(file), data, values); err != nil {
+			return nil, err
+		}
+		decoded, err := Decode(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode manifests %s: %v", filepath.Base(file), err)
+		}
+		objects = append(objects, decoded...)
+	}
+	return objects, nil
+}
+
+// Render renders the manifest template with the values, referring to a
+// missing value is an error.
+func Render(name string, data []byte, values Values) ([]byte, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, values); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes the objects in the yaml documents or json of the manifests,
+// the empty documents are skipped.
+func Decode(data []byte) ([]runtime.Object, error) {
+	objects := []runtime.Object{}
+	reader := k8s_yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		json, err := k8s_yaml.ToJSON(doc)
+		if err != nil {
+			return nil, err
+		}
+		if json = bytes.TrimSpace(json); len(json) == 0 || string(json) == "null" {
+			continue
+		}
+
+		object, _, err := k8s_core_api.Codecs.UniversalDecoder().Decode(json, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+}
+
+// Deployments returns the deployments in the objects, the ones without a namespace
+// are in the namespace.
+func Deployments(namespace string, objects []runtime.Object) []*k8s_ext_api.Deployment {
+	deployments := []*k8s_ext_api.Deployment{}
+	for _, object := range objects {
+		if deployment, ok := object.(*k8s_ext_api.Deployment); ok {
+			defaultNamespace(&deployment.ObjectMeta, namespace)
+			deployments = append(deployments, deployment)
+		}
+	}
+	return deployments
+}
+
+// ApplyManifests creates the objects, or updates them if they exist. Only
+// deployments, services, config maps and ingresses are supported, the objects
+// without a namespace are applied to the namespace. The applied deployments are
+// returned to wait for their rollouts.
+func ApplyManifests(client Client, namespace string, objects []runtime.Object) ([]*k8s_ext_api.Deployment, error) {
+	deployments := []*k8s_ext_api.Deployment{}
+	for _, object := range objects {
+		var err error
+		switch o := object.(type) {
+		case *k8s_ext_api.Deployment:
+			if err = applyDeployment(client, namespace, o); err == nil {
+				deployments = append(deployments, o)
+			}
+		case *k8s_core_api.Service:
+			err = applyService(client, namespace, o)
+		case *k8s_core_api.ConfigMap:
+			err = applyConfigMap(client, namespace, o)
+		case *k8s_ext_api.Ingress:
+			err = applyIngress(client, namespace, o)
+		default:
+			// The decoded objects are internal, which do not keep their kinds.
+			kind, _, _ := k8s_core_api.Scheme.ObjectKind(object)
+			err = fmt.Errorf("unsupported kind %s, should be Deployment, Service, ConfigMap or Ingress", kind.Kind)
+		}
+		if err != nil {
+			return deployments, err
+		}
+	}
+	return deployments, nil
+}
+
+// defaultNamespace sets the namespace of the object if it has none, and returns
+// the namespace of the object.
+func defaultNamespace(meta *k8s_core_api.ObjectMeta, namespace string) string {
+	if meta.Namespace == "" {
+		meta.Namespace = namespace
+	}
+	return meta.Namespace
+}
+
+func applyDeployment(client Client, namespace string, deployment *k8s_ext_api.Deployment) error {
+	namespace = defaultNamespace(&deployment.ObjectMeta, namespace)
+	existing, err := client.Deployments(namespace).Get(deployment.Name)
+	if k8s_errors.IsNotFound(err) {
+		_, err = client.Deployments(namespace).Create(deployment)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	deployment.ResourceVersion = existing.ResourceVersion
+	_, err = client.Deployments(namespace).Update(deployment)
+	return err
+}
+
+func applyService(client Client, namespace string, service *k8s_core_api.Service) error {
+	namespace = defaultNamespace(&service.ObjectMeta, namespace)
+	existing, err := client.Services(namespace).Get(service.Name)
+	if k8s_errors.IsNotFound(err) {
+		_, err = client.Services(namespace).Create(service)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	// The cluster IP can not be changed once allocated.
+	service.ResourceVersion = existing.ResourceVersion
+	if service.Spec.ClusterIP == "" {
+		service.Spec.ClusterIP = existing.Spec.ClusterIP
+	}
+	_, err = client.Services(namespace).Update(service)
+	return err
+}
+
+func applyConfigMap(client Client, namespace string, configMap *k8s_core_api.ConfigMap) error {
+	namespace = defaultNamespace(&configMap.ObjectMeta, namespace)
+	existing, err := client.ConfigMaps(namespace).Get(configMap.Name)
+	if k8s_errors.IsNotFound(err) {
+		_, err = client.ConfigMaps(namespace).Create(configMap)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	configMap.ResourceVersion = existing.ResourceVersion
+	_, err = client.ConfigMaps(namespace).Update(configMap)
+	return err
+}
+
+func applyIngress(client Client, namespace string, ingress *k8s_ext_api.Ingress) error {
+	namespace = defaultNamespace(&ingress.ObjectMeta, namespace)
+	existing, err := client.Ingresses(namespace).Get(ingress.Name)
+	if k8s_errors.IsNotFound(err) {
+		_, err = client.Ingresses(namespace).Create(ingress)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	ingress.ResourceVersion = existing.ResourceVersion
+	_, err = client.Ingresses(namespace).Update(ingress)
+	return err
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rollout
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caicloud/cyclone/pkg/rollout/fake"
+	k8s_core_api "k8s.io/kubernetes/pkg/api"
+	k8s_ext_api "k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+const testManifests = `# the web application
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 2
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: web
+        image: {{ .Image }}
+        env:
+        - name: VERSION
+          value: {{ .Version }}
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  selector:
+    app: web
+  ports:
+  - port: 80
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+  namespace: {{ .Namespace }}
+data:
+  version: {{ .Version }}
+---
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  backend:
+    serviceName: web
+    servicePort: 80
+`
+
+// TestReadManifests tests the manifests are rendered and decoded.
+func TestReadManifests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "web.yml"), []byte(testManifests), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("# not a manifest"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	values := Values{Image: "web:v2", Version: "v2", Namespace: "prod"}
+	objects, err := ReadManifests(dir, values)
+	if err != nil {
+		t.Fatalf("Expect no error, but got %v", err)
+	}
+	if len(objects) != 4 {
+		t.Fatalf("Expect 4 objects, but got %d", len(objects))
+	}
+	deployment, ok := objects[0].(*k8s_ext_api.Deployment)
+	if !ok {
+		t.Fatalf("Expect a deployment, but got %T", objects[0])
+	}
+	container := deployment.Spec.Template.Spec.Containers[0]
+	if container.Image != "web:v2" || container.Env[0].Value != "v2" {
+		t.Errorf("Expect image web:v2 and version v2, but got %s and %s", container.Image, container.Env[0].Value)
+	}
+	if configMap, ok := objects[2].(*k8s_core_api.ConfigMap); !ok || configMap.Namespace != "prod" {
+		t.Errorf("Expect a config map in namespace prod, but got %+v", objects[2])
+	}
+
+	if _, err := Render("web.yml", []byte("image: {{ .Tag }}"), values); err == nil {
+		t.Errorf("Expect error for the unknown value")
+	}
+	values.Variables = map[string]string{"REPLICAS": "3"}
+	if data, err := Render("web.yml", []byte("replicas: {{ .Variables.REPLICAS }}"), values); err != nil || string(data) != "replicas: 3" {
+		t.Errorf("Expect the variable substituted, but got %q and %v", data, err)
+	}
+	if _, err := Render("web.yml", []byte("replicas: {{ .Variables.CPU }}"), values); err == nil {
+		t.Errorf("Expect error for the missing variable")
+	}
+	if _, err := Decode([]byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: web\n")); err != nil {
+		t.Errorf("Expect no error decoding a secret, but got %v", err)
+	}
+}
+
+// TestApplyManifests tests the objects are created or updated.
+func TestApplyManifests(t *testing.T) {
+	existing := newDeployment("web", map[string]string{"app": "web"})
+	client := fake.NewClient([]*k8s_ext_api.Deployment{existing}, []*k8s_core_api.Service{
+		{
+			ObjectMeta: k8s_core_api.ObjectMeta{Name: "web", ResourceVersion: "7"},
+			Spec:       k8s_core_api.ServiceSpec{ClusterIP: "10.0.0.1"},
+		},
+	})
+
+	data, err := Render("web.yml", []byte(testManifests), Values{Image: "web:v2", Version: "v2", Namespace: "default"})
+	if err != nil {
+		t.Fatalf("Expect no error, but got %v", err)
+	}
+	objects, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Expect no error, but got %v", err)
+	}
+	if d := Deployments("default", objects); len(d) != 1 || d[0].Name != "web" || d[0].Namespace != "default" {
+		t.Errorf("Expect deployment web in default, but got %v", d)
+	}
+	deployments, err := ApplyManifests(client, "default", objects)
+	if err != nil {
+		t.Fatalf("Expect no error, but got %v", err)
+	}
+	if len(deployments) != 1 || deployments[0].Name != "web" {
+		t.Errorf("Expect deployment web applied, but got %v", deployments)
+	}
+	if d := client.Deployment("web"); d.Spec.Replicas != 2 || d.Namespace != "default" {
+		t.Errorf("Expect deployment web updated with 2 replicas in default, but got %+v", d)
+	}
+	if s := client.Service("web"); s.Spec.ClusterIP != "10.0.0.1" || s.ResourceVersion != "7" {
+		t.Errorf("Expect the cluster IP and resource version of service web kept, but got %+v", s)
+	}
+	if client.ConfigMap("web") == nil || client.Ingress("web") == nil {
+		t.Errorf("Expect config map and ingress web created")
+	}
+
+	secret, err := Decode([]byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: web\n"))
+	if err != nil {
+		t.Fatalf("Expect no error, but got %v", err)
+	}
+	if _, err := ApplyManifests(client, "default", secret); err == nil {
+		t.Errorf("Expect error for the unsupported kind")
+	}
+}
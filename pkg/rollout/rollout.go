@@ -0,0 +1,221 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rollout updates the container images of kubernetes deployments or
+// applies their manifests, checks whether the rollouts complete and rolls them back.
+package rollout
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	k8s_core_api "k8s.io/kubernetes/pkg/api"
+	k8s_ext_api "k8s.io/kubernetes/pkg/apis/extensions"
+	clientset "k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset"
+	internalversioncore "k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/typed/core/internalversion"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/typed/extensions/internalversion"
+	restclient "k8s.io/kubernetes/pkg/client/restclient"
+)
+
+// progressDeadlineExceeded is the reason of the progressing condition when the
+// deployment does not make progress in its progressDeadlineSeconds.
+const progressDeadlineExceeded = "ProgressDeadlineExceeded"
+
+var (
+	// ErrNoContainer is the error when none of the containers is in the deployment.
+	ErrNoContainer = errors.New("no such container in the deployment")
+	// ErrImageChanged is the error when the containers run other images than the deployed ones.
+	ErrImageChanged = errors.New("images of the containers are changed since the deploy")
+	// ErrRolloutFailed is the error when the deployment stops making progress.
+	ErrRolloutFailed = errors.New("deployment exceeded its progress deadline")
+)
+
+// Client is the client of the kubernetes resources changed by the rollouts.
+type Client interface {
+	internalversion.DeploymentsGetter
+	internalversion.IngressesGetter
+	internalversioncore.ServicesGetter
+	internalversioncore.ConfigMapsGetter
+}
+
+// NewClient creates a client of the kubernetes resources using BearerToken.
+func NewClient(host, token string) (Client, error) {
+	config := &restclient.Config{
+		Host:        host,
+		BearerToken: token,
+		Insecure:    true,
+	}
+	return clientset.NewForConfig(config)
+}
+
+// Images maps the containers to the image, empty container names are skipped.
+func Images(containers []string, image string) map[string]string {
+	images := make(map[string]string)
+	for _, container := range containers {
+		if container != "" {
+			images[container] = image
+		}
+	}
+	return images
+}
+
+// CurrentImages gets the images of the containers in the deployment, images
+// maps the container names to any image.
+func CurrentImages(client internalversion.DeploymentsGetter, namespace, name string,
+	images map[string]string) (map[string]string, error) {
+	deployment, err := client.Deployments(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]string)
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		if _, ok := images[container.Name]; ok {
+			current[container.Name] = container.Image
+		}
+	}
+	if len(current) == 0 {
+		return nil, ErrNoContainer
+	}
+	return current, nil
+}
+
+// SetImages sets the images of the containers in the deployment, images maps
+// the container names to the images. The environment variables in env are set
+// to the containers too. The previous images of the containers are returned, so
+// that the deployment can be rolled back to them, the variables are kept then.
+func SetImages(client internalversion.DeploymentsGetter, namespace, name string,
+	images, env map[string]string) (map[string]string, error) {
+	deployment, err := client.Deployments(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := make(map[string]string)
+	containers := deployment.Spec.Template.Spec.Containers
+	for i := range containers {
+		if image, ok := images[containers[i].Name]; ok {
+			previous[containers[i].Name] = containers[i].Image
+			containers[i].Image = image
+			setEnv(&containers[i], env)
+		}
+	}
+	if len(previous) == 0 {
+		return nil, ErrNoContainer
+	}
+
+	if _, err := client.Deployments(namespace).Update(deployment); err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+// setEnv sets the environment variables of the container, the ones with the
+// same names are replaced.
+func setEnv(container *k8s_core_api.Container, env map[string]string) {
+	if len(env) == 0 {
+		return
+	}
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	vars := append([]k8s_core_api.EnvVar(nil), container.Env...)
+	for _, name := range names {
+		found := false
+		for i := range vars {
+			if vars[i].Name == name {
+				vars[i] = k8s_core_api.EnvVar{Name: name, Value: env[name]}
+				found = true
+			}
+		}
+		if !found {
+			vars = append(vars, k8s_core_api.EnvVar{Name: name, Value: env[name]})
+		}
+	}
+	container.Env = vars
+}
+
+// Rollback restores the images of the containers in the deployment recorded
+// before the deploy, the containers already running them are skipped. It refuses
+// to roll back if the containers are not running the deployed images anymore,
+// e.g. a later deploy replaced them.
+func Rollback(client internalversion.DeploymentsGetter, namespace, name string,
+	images, previous map[string]string) error {
+	deployment, err := client.Deployments(namespace).Get(name)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	containers := deployment.Spec.Template.Spec.Containers
+	for i := range containers {
+		image, ok := previous[containers[i].Name]
+		if !ok || containers[i].Image == image {
+			continue
+		}
+		if containers[i].Image != images[containers[i].Name] {
+			return ErrImageChanged
+		}
+		containers[i].Image = image
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	_, err = client.Deployments(namespace).Update(deployment)
+	return err
+}
+
+// Check checks whether the rollout of the images completes, that is all the
+// replicas of the deployment are updated and available. It returns an error if
+// the rollout can not complete, e.g. the images are replaced by others or the
+// deployment exceeds its progress deadline.
+func Check(client internalversion.DeploymentsGetter, namespace, name string,
+	images map[string]string) (bool, error) {
+	deployment, err := client.Deployments(namespace).Get(name)
+	if err != nil {
+		return false, err
+	}
+
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		if image, ok := images[container.Name]; ok && container.Image != image {
+			return false, fmt.Errorf("image of container %s is %s instead of %s", container.Name, container.Image, image)
+		}
+	}
+
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == k8s_ext_api.DeploymentProgressing &&
+			condition.Status == k8s_core_api.ConditionFalse &&
+			condition.Reason == progressDeadlineExceeded {
+			return false, ErrRolloutFailed
+		}
+	}
+
+	status := deployment.Status
+	replicas := deployment.Spec.Replicas
+	if status.ObservedGeneration < deployment.Generation ||
+		status.UpdatedReplicas < replicas ||
+		status.AvailableReplicas < replicas ||
+		status.Replicas > status.UpdatedReplicas {
+		return false, nil
+	}
+	return true, nil
+}
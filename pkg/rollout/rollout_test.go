@@ -0,0 +1,186 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rollout
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/caicloud/cyclone/pkg/rollout/fake"
+	k8s_core_api "k8s.io/kubernetes/pkg/api"
+	k8s_errors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	k8s_ext_api "k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// newDeployment creates a deployment with containers web and sidecar, running
+// the images web:v1 and sidecar:v1. Its pods are selected by the labels.
+func newDeployment(name string, labels map[string]string) *k8s_ext_api.Deployment {
+	deployment := &k8s_ext_api.Deployment{}
+	deployment.Name = name
+	deployment.Spec.Replicas = 2
+	deployment.Spec.Selector = &unversioned.LabelSelector{MatchLabels: labels}
+	deployment.Spec.Template.Labels = labels
+	deployment.Spec.Template.Spec.Containers = []k8s_core_api.Container{
+		{Name: "web", Image: "web:v1"},
+		{Name: "sidecar", Image: "sidecar:v1"},
+	}
+	return deployment
+}
+
+// newFakeClient creates a fake client with the deployment web.
+func newFakeClient() *fake.Client {
+	deployment := newDeployment("web", map[string]string{"app": "web"})
+	return fake.NewClient([]*k8s_ext_api.Deployment{deployment}, nil)
+}
+
+// TestSetImagesAndRollback tests updating the images and rolling them back.
+func TestSetImagesAndRollback(t *testing.T) {
+	client := newFakeClient()
+	images := Images([]string{"web", ""}, "web:v2")
+
+	previous, err := SetImages(client, "default", "web", images, nil)
+	if err != nil {
+		t.Fatalf("Expect no error, but got %v", err)
+	}
+	if len(previous) != 1 || previous["web"] != "web:v1" {
+		t.Errorf("Expect previous images map[web:web:v1], but got %v", previous)
+	}
+	containers := client.Deployment("web").Spec.Template.Spec.Containers
+	if containers[0].Image != "web:v2" || containers[1].Image != "sidecar:v1" {
+		t.Errorf("Expect only the image of web updated, but got %v", containers)
+	}
+
+	if _, err := SetImages(client, "default", "web", Images([]string{"db"}, "db:v1"), nil); err != ErrNoContainer {
+		t.Errorf("Expect error %v, but got %v", ErrNoContainer, err)
+	}
+	if _, err := SetImages(client, "default", "api", images, nil); !k8s_errors.IsNotFound(err) {
+		t.Errorf("Expect not found error, but got %v", err)
+	}
+
+	// A later deploy replaced the image, it is not rolled back.
+	if err := Rollback(client, "default", "web", Images([]string{"web"}, "web:v0"), previous); err != ErrImageChanged {
+		t.Errorf("Expect error %v, but got %v", ErrImageChanged, err)
+	}
+
+	if err := Rollback(client, "default", "web", images, previous); err != nil {
+		t.Fatalf("Expect no error, but got %v", err)
+	}
+	containers = client.Deployment("web").Spec.Template.Spec.Containers
+	if containers[0].Image != "web:v1" || containers[1].Image != "sidecar:v1" {
+		t.Errorf("Expect the image of web rolled back, but got %v", containers)
+	}
+	// The containers running the previous images are skipped.
+	if err := Rollback(client, "default", "web", images, previous); err != nil {
+		t.Errorf("Expect no error rolling back again, but got %v", err)
+	}
+
+	current, err := CurrentImages(client, "default", "web", Images([]string{"sidecar"}, ""))
+	if err != nil || len(current) != 1 || current["sidecar"] != "sidecar:v1" {
+		t.Errorf("Expect current images map[sidecar:sidecar:v1], but got %v, %v", current, err)
+	}
+}
+
+// TestSetImagesEnv tests the environment variables set to the containers with
+// the images, the ones of the same names are replaced.
+func TestSetImagesEnv(t *testing.T) {
+	client := newFakeClient()
+	client.Deployment("web").Spec.Template.Spec.Containers[0].Env = []k8s_core_api.EnvVar{
+		{Name: "REGION", Value: "east"},
+		{Name: "DEBUG", Value: "true"},
+	}
+
+	env := map[string]string{"REGION": "north", "LEVEL": "info"}
+	if _, err := SetImages(client, "default", "web", Images([]string{"web"}, "web:v2"), env); err != nil {
+		t.Fatalf("Expect no error, but got %v", err)
+	}
+	containers := client.Deployment("web").Spec.Template.Spec.Containers
+	expect := []k8s_core_api.EnvVar{
+		{Name: "REGION", Value: "north"},
+		{Name: "DEBUG", Value: "true"},
+		{Name: "LEVEL", Value: "info"},
+	}
+	if !reflect.DeepEqual(containers[0].Env, expect) {
+		t.Errorf("Expect env %v, but got %v", expect, containers[0].Env)
+	}
+	if len(containers[1].Env) != 0 {
+		t.Errorf("Expect no env set to sidecar, but got %v", containers[1].Env)
+	}
+}
+
+// TestCheck tests checking the rollout of the deployment.
+func TestCheck(t *testing.T) {
+	client := newFakeClient()
+	images := Images([]string{"web"}, "web:v2")
+	if _, err := SetImages(client, "default", "web", images, nil); err != nil {
+		t.Fatalf("Expect no error, but got %v", err)
+	}
+	deployment := client.Deployment("web")
+
+	testCases := map[string]struct {
+		status k8s_ext_api.DeploymentStatus
+		images map[string]string
+		done   bool
+		err    bool
+	}{
+		"not observed": {
+			status: k8s_ext_api.DeploymentStatus{ObservedGeneration: 1, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2},
+			images: images,
+		},
+		"old replicas": {
+			status: k8s_ext_api.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 2, AvailableReplicas: 2},
+			images: images,
+		},
+		"unavailable": {
+			status: k8s_ext_api.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 1},
+			images: images,
+		},
+		"complete": {
+			status: k8s_ext_api.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2},
+			images: images,
+			done:   true,
+		},
+		"image changed": {
+			status: k8s_ext_api.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2},
+			images: Images([]string{"web"}, "web:v3"),
+			err:    true,
+		},
+		"deadline exceeded": {
+			status: k8s_ext_api.DeploymentStatus{
+				ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 1, AvailableReplicas: 2,
+				Conditions: []k8s_ext_api.DeploymentCondition{{
+					Type:   k8s_ext_api.DeploymentProgressing,
+					Status: k8s_core_api.ConditionFalse,
+					Reason: progressDeadlineExceeded,
+				}},
+			},
+			images: images,
+			err:    true,
+		},
+	}
+
+	for name, tc := range testCases {
+		deployment.Status = tc.status
+		done, err := Check(client, "default", "web", tc.images)
+		if done != tc.done {
+			t.Errorf("%s: Expect done %v, but got %v", name, tc.done, done)
+		}
+		if (err != nil) != tc.err {
+			t.Errorf("%s: Expect error %v, but got %v", name, tc.err, err)
+		}
+	}
+}
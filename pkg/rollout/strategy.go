@@ -0,0 +1,234 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rollout
+
+import (
+	"errors"
+	"fmt"
+
+	k8s_core_api "k8s.io/kubernetes/pkg/api"
+	k8s_errors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	k8s_ext_api "k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+const (
+	// TrackLabel is the label distinguishing the canary pods from the stable ones,
+	// the deployment deployed by canary must select its pods by it, e.g. stable.
+	TrackLabel = "cyclone.io/track"
+	// ColorLabel is the label distinguishing the pods of the blue-green deployments,
+	// the deployment deployed by blue-green must select its pods by it, e.g. blue.
+	ColorLabel = "cyclone.io/color"
+
+	trackCanary = "canary"
+	colorBlue   = "blue"
+	colorGreen  = "green"
+)
+
+// ErrServiceChanged is the error when the service is switched to another deployment.
+var ErrServiceChanged = errors.New("service is switched to another deployment since the deploy")
+
+// CanaryName returns the name of the canary deployment of the deployment.
+func CanaryName(name string) string {
+	return name + "-" + trackCanary
+}
+
+// DeployCanary creates or updates the canary deployment of the deployment, it
+// is a copy of the deployment running the images with the replicas. The canary
+// pods are selected by the services of the deployment too, as they only differ
+// from the stable pods by the track label. The canary containers get the
+// environment variables in env too.
+func DeployCanary(client Client, namespace, name string, images, env map[string]string, replicas int32) (string, error) {
+	deployment, err := getDeployment(client, namespace, name, TrackLabel)
+	if err != nil {
+		return "", err
+	}
+	target := CanaryName(name)
+	return target, deployCopy(client, namespace, deployment, target, TrackLabel, trackCanary, images, env, replicas)
+}
+
+// BlueGreenTarget returns the deployment the blue-green deploy rolls out to, it
+// is the one the service does not select. The deployment and its copy of the
+// other color take turns.
+func BlueGreenTarget(client Client, namespace, name, service string) (string, error) {
+	deployment, err := getDeployment(client, namespace, name, ColorLabel)
+	if err != nil {
+		return "", err
+	}
+	return blueGreenTarget(client, namespace, deployment, service)
+}
+
+// DeployBlueGreen rolls the images out to the target deployment of the
+// blue-green deploy with the environment variables in env, the copy of the
+// deployment is created if it does not exist. The service is not switched until
+// SwitchService is called.
+func DeployBlueGreen(client Client, namespace, name, service string, images, env map[string]string) (string, error) {
+	deployment, err := getDeployment(client, namespace, name, ColorLabel)
+	if err != nil {
+		return "", err
+	}
+	target, err := blueGreenTarget(client, namespace, deployment, service)
+	if err != nil {
+		return "", err
+	}
+	if target == name {
+		_, err = SetImages(client, namespace, name, images, env)
+		return target, err
+	}
+	return target, deployCopy(client, namespace, deployment, target, ColorLabel, otherColor(deployment), images, env, -1)
+}
+
+// SwitchService switches the selector of the service to the pods of the deployment.
+func SwitchService(client Client, namespace, service, deployment string) error {
+	d, err := getDeployment(client, namespace, deployment, ColorLabel)
+	if err != nil {
+		return err
+	}
+
+	svc, err := client.Services(namespace).Get(service)
+	if err != nil {
+		return err
+	}
+	svc.Spec.Selector = copyLabels(d.Spec.Selector.MatchLabels)
+	_, err = client.Services(namespace).Update(svc)
+	return err
+}
+
+// SwitchBack switches the service back from the deployment to the other one of
+// the blue-green deploy. It refuses to switch if the service does not select the
+// deployment anymore, or the deployment does not run the images.
+func SwitchBack(client Client, namespace, name, service, deployment string, images map[string]string) error {
+	target, err := BlueGreenTarget(client, namespace, name, service)
+	if err != nil {
+		return err
+	}
+	if target == deployment {
+		return ErrServiceChanged
+	}
+
+	current, err := CurrentImages(client, namespace, deployment, images)
+	if err != nil {
+		return err
+	}
+	for container, image := range current {
+		if images[container] != image {
+			return ErrImageChanged
+		}
+	}
+	return SwitchService(client, namespace, service, target)
+}
+
+// DeleteDeployment deletes the deployment with its pods, it is not an error if
+// the deployment does not exist.
+func DeleteDeployment(client Client, namespace, name string) error {
+	orphan := false
+	err := client.Deployments(namespace).Delete(name, &k8s_core_api.DeleteOptions{OrphanDependents: &orphan})
+	if err != nil && !k8s_errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// getDeployment gets the deployment which must select its pods by the label.
+func getDeployment(client Client, namespace, name, label string) (*k8s_ext_api.Deployment, error) {
+	deployment, err := client.Deployments(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if deployment.Spec.Selector == nil || deployment.Spec.Selector.MatchLabels[label] == "" {
+		return nil, fmt.Errorf("deployment %s does not select its pods by the label %s", name, label)
+	}
+	return deployment, nil
+}
+
+// otherColor returns the color of the copy of the blue-green deployment.
+func otherColor(deployment *k8s_ext_api.Deployment) string {
+	if deployment.Spec.Selector.MatchLabels[ColorLabel] == colorGreen {
+		return colorBlue
+	}
+	return colorGreen
+}
+
+// blueGreenTarget returns the deployment the service does not select, the
+// deployment or its copy of the other color.
+func blueGreenTarget(client Client, namespace string, deployment *k8s_ext_api.Deployment, service string) (string, error) {
+	svc, err := client.Services(namespace).Get(service)
+	if err != nil {
+		return "", err
+	}
+	color := otherColor(deployment)
+	if svc.Spec.Selector[ColorLabel] == color {
+		return deployment.Name, nil
+	}
+	return deployment.Name + "-" + color, nil
+}
+
+// deployCopy creates or updates the deployment named target as a copy of the
+// deployment, whose pods are labeled with the value of the label instead. The
+// copy runs the images with the environment variables in env, and keeps the
+// replicas of the deployment if replicas < 0.
+func deployCopy(client Client, namespace string, deployment *k8s_ext_api.Deployment, target, label, value string,
+	images, env map[string]string, replicas int32) error {
+	spec := deployment.Spec
+	if replicas >= 0 {
+		spec.Replicas = replicas
+	}
+	spec.Selector = &unversioned.LabelSelector{MatchLabels: copyLabels(deployment.Spec.Selector.MatchLabels)}
+	spec.Selector.MatchLabels[label] = value
+	spec.Template.Labels = copyLabels(deployment.Spec.Template.Labels)
+	spec.Template.Labels[label] = value
+	spec.Template.Spec.Containers = append([]k8s_core_api.Container(nil), deployment.Spec.Template.Spec.Containers...)
+
+	found := false
+	for i := range spec.Template.Spec.Containers {
+		if image, ok := images[spec.Template.Spec.Containers[i].Name]; ok {
+			spec.Template.Spec.Containers[i].Image = image
+			setEnv(&spec.Template.Spec.Containers[i], env)
+			found = true
+		}
+	}
+	if !found {
+		return ErrNoContainer
+	}
+
+	existing, err := client.Deployments(namespace).Get(target)
+	if err == nil {
+		existing.Spec = spec
+		_, err = client.Deployments(namespace).Update(existing)
+		return err
+	}
+	if !k8s_errors.IsNotFound(err) {
+		return err
+	}
+
+	copied := &k8s_ext_api.Deployment{Spec: spec}
+	copied.Name = target
+	copied.Namespace = namespace
+	copied.Labels = copyLabels(deployment.Labels)
+	copied.Labels[label] = value
+	_, err = client.Deployments(namespace).Create(copied)
+	return err
+}
+
+// copyLabels returns a copy of the labels which is never nil.
+func copyLabels(labels map[string]string) map[string]string {
+	copied := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		copied[k] = v
+	}
+	return copied
+}
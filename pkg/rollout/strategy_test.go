@@ -0,0 +1,134 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rollout
+
+import (
+	"testing"
+
+	"github.com/caicloud/cyclone/pkg/rollout/fake"
+	k8s_core_api "k8s.io/kubernetes/pkg/api"
+	k8s_ext_api "k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// TestDeployCanary tests deploying and deleting the canary deployment.
+func TestDeployCanary(t *testing.T) {
+	client := fake.NewClient([]*k8s_ext_api.Deployment{
+		newDeployment("web", map[string]string{"app": "web", TrackLabel: "stable"}),
+		newDeployment("api", map[string]string{"app": "api"}),
+	}, nil)
+	images := Images([]string{"web"}, "web:v2")
+
+	if _, err := DeployCanary(client, "default", "api", images, nil, 1); err == nil {
+		t.Errorf("Expect error for the deployment not selecting its pods by the track label")
+	}
+
+	name, err := DeployCanary(client, "default", "web", images, nil, 1)
+	if err != nil {
+		t.Fatalf("Expect no error, but got %v", err)
+	}
+	canary := client.Deployment(name)
+	if name != "web-canary" || canary == nil {
+		t.Fatalf("Expect deployment web-canary created")
+	}
+	if canary.Spec.Replicas != 1 {
+		t.Errorf("Expect 1 canary replica, but got %d", canary.Spec.Replicas)
+	}
+	if canary.Spec.Selector.MatchLabels[TrackLabel] != "canary" || canary.Spec.Template.Labels[TrackLabel] != "canary" ||
+		canary.Spec.Template.Labels["app"] != "web" {
+		t.Errorf("Expect canary pods labeled app=web and track=canary, but got %v", canary.Spec.Template.Labels)
+	}
+	if canary.Spec.Template.Spec.Containers[0].Image != "web:v2" {
+		t.Errorf("Expect canary running web:v2, but got %s", canary.Spec.Template.Spec.Containers[0].Image)
+	}
+	stable := client.Deployment("web")
+	if stable.Spec.Template.Spec.Containers[0].Image != "web:v1" || stable.Spec.Template.Labels[TrackLabel] != "stable" {
+		t.Errorf("Expect the deployment unchanged, but got %+v", stable.Spec.Template)
+	}
+
+	// Deploying again updates the canary.
+	if _, err := DeployCanary(client, "default", "web", Images([]string{"web"}, "web:v3"), nil, 2); err != nil {
+		t.Fatalf("Expect no error, but got %v", err)
+	}
+	if canary = client.Deployment(name); canary.Spec.Replicas != 2 || canary.Spec.Template.Spec.Containers[0].Image != "web:v3" {
+		t.Errorf("Expect canary updated to 2 replicas of web:v3, but got %+v", canary.Spec)
+	}
+
+	if err := DeleteDeployment(client, "default", name); err != nil {
+		t.Errorf("Expect no error, but got %v", err)
+	}
+	if client.Deployment(name) != nil {
+		t.Errorf("Expect deployment %s deleted", name)
+	}
+	if err := DeleteDeployment(client, "default", name); err != nil {
+		t.Errorf("Expect no error deleting the deleted deployment, but got %v", err)
+	}
+}
+
+// TestDeployBlueGreen tests the deployment and its copy take turns to be selected.
+func TestDeployBlueGreen(t *testing.T) {
+	service := &k8s_core_api.Service{}
+	service.Name = "web"
+	service.Spec.Selector = map[string]string{"app": "web", ColorLabel: "blue"}
+	client := fake.NewClient([]*k8s_ext_api.Deployment{
+		newDeployment("web", map[string]string{"app": "web", ColorLabel: "blue"}),
+	}, []*k8s_core_api.Service{service})
+
+	for i, expected := range []struct {
+		target, image string
+	}{
+		{"web-green", "web:v2"},
+		{"web", "web:v3"},
+		{"web-green", "web:v4"},
+	} {
+		images := Images([]string{"web"}, expected.image)
+		target, err := DeployBlueGreen(client, "default", "web", "web", images, nil)
+		if err != nil {
+			t.Fatalf("%d: Expect no error, but got %v", i, err)
+		}
+		if target != expected.target {
+			t.Errorf("%d: Expect target %s, but got %s", i, expected.target, target)
+		}
+		if image := client.Deployment(target).Spec.Template.Spec.Containers[0].Image; image != expected.image {
+			t.Errorf("%d: Expect %s running %s, but got %s", i, target, expected.image, image)
+		}
+		if target, _ := BlueGreenTarget(client, "default", "web", "web"); target != expected.target {
+			t.Errorf("%d: Expect the target not changed before the switch, but got %s", i, target)
+		}
+
+		if err := SwitchService(client, "default", "web", target); err != nil {
+			t.Fatalf("%d: Expect no error, but got %v", i, err)
+		}
+		selector := client.Service("web").Spec.Selector
+		color := client.Deployment(target).Spec.Selector.MatchLabels[ColorLabel]
+		if selector[ColorLabel] != color || selector["app"] != "web" {
+			t.Errorf("%d: Expect the service selecting %s, but got %v", i, target, selector)
+		}
+	}
+	// The service selects web-green running web:v4.
+	if err := SwitchBack(client, "default", "web", "web", "web-green", Images([]string{"web"}, "web:v2")); err != ErrImageChanged {
+		t.Errorf("Expect error %v, but got %v", ErrImageChanged, err)
+	}
+	if err := SwitchBack(client, "default", "web", "web", "web", Images([]string{"web"}, "web:v3")); err != ErrServiceChanged {
+		t.Errorf("Expect error %v, but got %v", ErrServiceChanged, err)
+	}
+	if err := SwitchBack(client, "default", "web", "web", "web-green", Images([]string{"web"}, "web:v4")); err != nil {
+		t.Fatalf("Expect no error, but got %v", err)
+	}
+	if color := client.Service("web").Spec.Selector[ColorLabel]; color != "blue" {
+		t.Errorf("Expect the service switched back to the blue pods, but got %s", color)
+	}
+}
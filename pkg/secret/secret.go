@@ -0,0 +1,83 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrEmptyPassphrase is returned when the passphrase is empty.
+	ErrEmptyPassphrase = errors.New("empty passphrase")
+	// ErrInvalidCiphertext is returned when the ciphertext is malformed or
+	// can't be authenticated with the passphrase.
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+)
+
+// newAEAD creates the AES-256-GCM cipher with the key derived from the
+// passphrase.
+func newAEAD(passphrase string) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, ErrEmptyPassphrase
+	}
+	key := sha256.Sum256([]byte(passphrase))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt encrypts the plaintext with the passphrase, and returns the nonce
+// and the ciphertext encoded in base64.
+func Encrypt(passphrase string, plaintext []byte) (string, error) {
+	aead, err := newAEAD(passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts the ciphertext returned by Encrypt with the passphrase.
+func Decrypt(passphrase, ciphertext string) ([]byte, error) {
+	aead, err := newAEAD(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshkey
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"math/big"
+)
+
+// Bits is the size of the generated RSA keys.
+const Bits = 2048
+
+var (
+	// ErrInvalidPrivateKey is returned when the private key is not a PEM
+	// encoded RSA key.
+	ErrInvalidPrivateKey = errors.New("private key is not a PEM encoded RSA key")
+)
+
+// Generate generates a RSA keypair, and returns the PEM encoded private key
+// and the public key in the authorized_keys format.
+func Generate() (string, string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, Bits)
+	if err != nil {
+		return "", "", err
+	}
+
+	privateKey := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+	return string(privateKey), marshalPublicKey(&key.PublicKey), nil
+}
+
+// PublicKey returns the public key in the authorized_keys format of the PEM
+// encoded RSA private key, in PKCS#1 or PKCS#8.
+func PublicKey(privateKey string) (string, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return "", ErrInvalidPrivateKey
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return "", ErrInvalidPrivateKey
+		}
+		return marshalPublicKey(&key.PublicKey), nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return "", ErrInvalidPrivateKey
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return "", ErrInvalidPrivateKey
+		}
+		return marshalPublicKey(&rsaKey.PublicKey), nil
+	}
+	return "", ErrInvalidPrivateKey
+}
+
+// marshalPublicKey encodes the RSA public key in the SSH wire format, as in
+// RFC 4253 section 6.6, in the authorized_keys format.
+func marshalPublicKey(key *rsa.PublicKey) string {
+	var buf bytes.Buffer
+	writeString(&buf, []byte("ssh-rsa"))
+	writeMPInt(&buf, big.NewInt(int64(key.E)))
+	writeMPInt(&buf, key.N)
+	return "ssh-rsa " + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// writeString writes the SSH string, the data prefixed with its length.
+func writeString(buf *bytes.Buffer, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+}
+
+// writeMPInt writes the positive SSH multiple precision integer, a leading
+// zero byte is added if the most significant bit is set.
+func writeMPInt(buf *bytes.Buffer, n *big.Int) {
+	data := n.Bytes()
+	if len(data) > 0 && data[0]&0x80 != 0 {
+		data = append([]byte{0}, data...)
+	}
+	writeString(buf, data)
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshkey
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGenerate tests the generated keypair matches.
+func TestGenerate(t *testing.T) {
+	privateKey, publicKey, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(publicKey, "ssh-rsa ") {
+		t.Errorf("Expect the public key in the authorized_keys format, got %s", publicKey)
+	}
+
+	derived, err := PublicKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if derived != publicKey {
+		t.Errorf("Expect the public key %s, got %s", publicKey, derived)
+	}
+
+	if _, err := PublicKey("not a key"); err != ErrInvalidPrivateKey {
+		t.Errorf("Expect ErrInvalidPrivateKey, got %v", err)
+	}
+
+	// Check the public key against the one derived by ssh-keygen.
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		return
+	}
+	dir, err := ioutil.TempDir("", "sshkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "id_rsa")
+	if err := ioutil.WriteFile(file, []byte(privateKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+	output, err := exec.Command("ssh-keygen", "-y", "-f", file).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := strings.TrimSpace(string(output)); expected != publicKey {
+		t.Errorf("Expect the public key %s, got %s", expected, publicKey)
+	}
+}
@@ -29,4 +29,9 @@ type Remote interface {
 	CreateHook(service *api.Service) error
 	DeleteHook(service *api.Service) error
 	PostCommitStatus(service *api.Service, version *api.Version) error
+	// CreateDeployKey registers the public key of the service's deploy key
+	// as a read-only deploy key of the repository, and returns its ID.
+	CreateDeployKey(service *api.Service) (int, error)
+	// DeleteDeployKey removes the service's deploy key from the repository.
+	DeleteDeployKey(service *api.Service) error
 }
@@ -384,8 +384,10 @@ func (g *GitHub) PostCommitStatus(service *api.Service, version *api.Version) er
 	var state string
 	if version.Status == api.VersionHealthy {
 		state = api.CISuccess
-	} else if version.Status == api.VersionFailed || version.Status == api.VersionCancel {
+	} else if version.Status == api.VersionFailed {
 		state = api.CIFailure
+	} else if version.Status == api.VersionCancel {
+		state = api.CIError
 	} else {
 		state = api.CIPending
 	}
@@ -404,3 +406,57 @@ func (g *GitHub) PostCommitStatus(service *api.Service, version *api.Version) er
 	_, _, err = client.Repositories.CreateStatus(owner, repo, version.Commit, status)
 	return err
 }
+
+// CreateDeployKey registers the deploy key of the service to Github.
+func (g *GitHub) CreateDeployKey(service *api.Service) (int, error) {
+	client, err := g.newClient(service.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	key := &github.Key{
+		Title:    github.String(deployKeyTitle(service)),
+		Key:      github.String(service.Repository.DeployKey.PublicKey),
+		ReadOnly: github.Bool(true),
+	}
+	owner, name := parseURL(service.Repository.URL)
+	key, _, err = client.Repositories.CreateKey(owner, name, key)
+	if err != nil {
+		return 0, err
+	}
+	return *key.ID, nil
+}
+
+// DeleteDeployKey removes the deploy key of the service from Github.
+func (g *GitHub) DeleteDeployKey(service *api.Service) error {
+	if service.Repository.DeployKey.RemoteID == 0 {
+		return nil
+	}
+
+	client, err := g.newClient(service.UserID)
+	if err != nil {
+		return err
+	}
+
+	owner, name := parseURL(service.Repository.URL)
+	_, err = client.Repositories.DeleteKey(owner, name, service.Repository.DeployKey.RemoteID)
+	return err
+}
+
+// newClient creates a Github client with the token of the user.
+func (g *GitHub) newClient(userID string) (*github.Client, error) {
+	ds := store.NewStore()
+	defer ds.Close()
+
+	tok, err := ds.FindtokenByUserID(userID, api.GITHUB)
+	if err != nil {
+		log.ErrorWithFields("find token failed", log.Fields{"user_id": userID, "error": err})
+		return nil, err
+	}
+
+	ts := oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: tok.Vsctoken.AccessToken},
+	)
+	tc := oauth2.NewClient(oauth2.NoContext, ts)
+	return github.NewClient(tc), nil
+}
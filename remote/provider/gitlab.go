@@ -336,8 +336,10 @@ func (g *GitLab) PostCommitStatus(service *api.Service, version *api.Version) er
 	var state string
 	if version.Status == api.VersionHealthy {
 		state = "success"
-	} else if version.Status == api.VersionFailed || version.Status == api.VersionCancel {
+	} else if version.Status == api.VersionFailed {
 		state = "failed"
+	} else if version.Status == api.VersionCancel {
+		state = "canceled"
 	} else {
 		state = "pending"
 	}
@@ -356,6 +358,60 @@ func (g *GitLab) PostCommitStatus(service *api.Service, version *api.Version) er
 	return system(cmd)
 }
 
+// CreateDeployKey registers the deploy key of the service to GitLab, deploy
+// keys are read-only in GitLab.
+func (g *GitLab) CreateDeployKey(service *api.Service) (int, error) {
+	client, err := g.newClient(service.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	title := deployKeyTitle(service)
+	key := &gitlab.AddDeployKeyOptions{
+		Title: &title,
+		Key:   &service.Repository.DeployKey.PublicKey,
+	}
+	owner, name := parseURL(service.Repository.URL)
+	deployKey, _, err := client.DeployKeys.AddDeployKey(owner+"/"+name, key)
+	if err != nil {
+		return 0, err
+	}
+	return deployKey.ID, nil
+}
+
+// DeleteDeployKey removes the deploy key of the service from GitLab.
+func (g *GitLab) DeleteDeployKey(service *api.Service) error {
+	if service.Repository.DeployKey.RemoteID == 0 {
+		return nil
+	}
+
+	client, err := g.newClient(service.UserID)
+	if err != nil {
+		return err
+	}
+
+	owner, name := parseURL(service.Repository.URL)
+	_, err = client.DeployKeys.DeleteDeployKey(owner+"/"+name, service.Repository.DeployKey.RemoteID)
+	return err
+}
+
+// newClient creates a GitLab client with the token of the user.
+func (g *GitLab) newClient(userID string) (*gitlab.Client, error) {
+	ds := store.NewStore()
+	defer ds.Close()
+
+	tok, err := ds.FindtokenByUserID(userID, api.GITLAB)
+	if err != nil {
+		log.ErrorWithFields("find token failed", log.Fields{"user_id": userID, "error": err})
+		return nil, err
+	}
+
+	gitlabServer := osutil.GetStringEnv("SERVER_GITLAB", "https://gitlab.com")
+	client := gitlab.NewOAuthClient(nil, tok.Vsctoken.AccessToken)
+	client.SetBaseURL(gitlabServer + "/api/v3/")
+	return client, nil
+}
+
 // system func excute the shell scripts.
 func system(s string) error {
 	cmd := exec.Command("/bin/sh", "-c", s)
@@ -25,13 +25,21 @@ import (
 )
 
 // parseURL is a helper func to parse the url,such as https://github.com/caicloud/test.git
-// to return owner(caicloud) and name(test).
+// or git@github.com:caicloud/test.git to return owner(caicloud) and name(test).
 func parseURL(url string) (string, string) {
+	if !strings.Contains(url, "://") {
+		url = "ssh://" + strings.Replace(url, ":", "/", 1)
+	}
 	strs := strings.SplitN(url, "/", -1)
 	name := strings.SplitN(strs[4], ".", -1)
 	return strs[3], name[0]
 }
 
+// deployKeyTitle is a helper to get the title of the service's deploy key.
+func deployKeyTitle(service *api.Service) string {
+	return fmt.Sprintf("cyclone-%s", service.Name)
+}
+
 // getHookURL is a helper to get the web hook url.
 func getHookURL(webhooktype, serviceID string) string {
 	cyclonePath := osutil.GetStringEnv("CYCLONE_SERVER_HOST", "http://127.0.0.1:7099")
@@ -15,6 +15,7 @@ docker run --rm \
        -e GOPATH=/go:/go/src/github.com/caicloud/cyclone/vendor cargo.caicloud.io/caicloud/golang-gcc:1.6-alpine sh \
        -c "cd /go/src/github.com/caicloud/cyclone/worker && go build cyclone-worker.go"
 
-docker build -t cargo.caicloud.io/caicloud/cyclone-worker ./worker
+# GIT_LFS_SHA256 is the checksum of the git-lfs release installed in the worker.
+docker build --build-arg GIT_LFS_SHA256=${GIT_LFS_SHA256:-} -t cargo.caicloud.io/caicloud/cyclone-worker ./worker
 
 cd - > /dev/null
@@ -0,0 +1,321 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/caicloud/cyclone/api"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	// ErrInvalidSort is returned when the documents can't be sorted by the field.
+	ErrInvalidSort = errors.New("invalid sort field")
+	// ErrInvalidContinue is returned when the continue token is malformed or
+	// is of another sort order.
+	ErrInvalidContinue = errors.New("invalid continue token")
+)
+
+// ListOptions are the options to list the documents page by page. The
+// documents are sorted by the sort field and then the id, so the continue
+// token keeps its position even if documents are added or removed.
+type ListOptions struct {
+	// Limit is the max number of documents in a page, all the documents are
+	// listed if it is 0.
+	Limit int
+	// Continue is the token returned by the previous page, the first page is
+	// listed if it is empty.
+	Continue string
+	// Sort is the field to sort by, prefixed by "-" for the descending order.
+	Sort string
+}
+
+// ServiceFilter is the filter to list services.
+type ServiceFilter struct {
+	// NamePrefix is the prefix of the service names.
+	NamePrefix string
+	// Status is the repository status of the services.
+	Status api.RepositoryStatus
+	// UpdatedAfter and UpdatedBefore are the range of the time the last
+	// versions of the services are created.
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+}
+
+// VersionFilter is the filter to list versions.
+type VersionFilter struct {
+	// NamePrefix is the prefix of the version names.
+	NamePrefix string
+	// Status is the status of the versions.
+	Status api.VersionStatus
+	// Operation is one of the operations of the versions.
+	Operation api.VersionOperation
+	// Operator is the operator of the versions.
+	Operator api.VersionOperator
+	// CreatedAfter and CreatedBefore are the range of the create time of the
+	// versions.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// serviceSortFields are the fields to sort services by, and whether they are
+// times.
+var serviceSortFields = map[string]bool{
+	"name":            false,
+	"last_createtime": true,
+}
+
+// versionSortFields are the fields to sort versions by, and whether they are
+// times.
+var versionSortFields = map[string]bool{
+	"name":        false,
+	"create_time": true,
+}
+
+// serviceIndexes are the indexes of the service collection for listing.
+var serviceIndexes = []mgo.Index{
+	{Key: []string{"user_id", "name", "_id"}},
+	{Key: []string{"user_id", "last_createtime", "_id"}},
+}
+
+// versionIndexes are the indexes of the version collection for listing.
+var versionIndexes = []mgo.Index{
+	{Key: []string{"service_id", "create_time", "_id"}},
+	{Key: []string{"service_id", "name", "_id"}},
+	{Key: []string{"service_id", "status", "create_time", "_id"}},
+	{Key: []string{"service_id", "operator", "create_time", "_id"}},
+}
+
+// EnsureIndexes creates the indexes to list services and versions if they
+// don't exist.
+func (d *DataStore) EnsureIndexes() error {
+	col := d.s.DB(defaultDBName).C(serviceCollectionName)
+	for _, index := range serviceIndexes {
+		if err := col.EnsureIndex(index); err != nil {
+			return err
+		}
+	}
+
+	col = d.s.DB(defaultDBName).C(versionCollectionName)
+	for _, index := range versionIndexes {
+		if err := col.EnsureIndex(index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListServices lists a page of the services of the user, and returns the
+// continue token of the next page, which is empty for the last page.
+func (d *DataStore) ListServices(userID string, filter ServiceFilter, opts ListOptions) ([]api.Service, string, error) {
+	if opts.Sort == "" {
+		opts.Sort = "name"
+	}
+	query := bson.M{"user_id": userID}
+	if filter.NamePrefix != "" {
+		query["name"] = prefixRegex(filter.NamePrefix)
+	}
+	if filter.Status != "" {
+		query["repository.status"] = filter.Status
+	}
+	if r := timeRange(filter.UpdatedAfter, filter.UpdatedBefore); r != nil {
+		query["last_createtime"] = r
+	}
+
+	q, err := pageQuery(query, serviceSortFields, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	services := []api.Service{}
+	col := d.s.DB(defaultDBName).C(serviceCollectionName)
+	if err := col.Find(q.query).Sort(q.sort...).Limit(q.limit).All(&services); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if opts.Limit > 0 && len(services) > opts.Limit {
+		services = services[:opts.Limit]
+		last := services[len(services)-1]
+		values := map[string]interface{}{"name": last.Name, "last_createtime": last.LastCreateTIme}
+		next = encodeContinue(q.field, values[q.field], last.ServiceID)
+	}
+	return services, next, nil
+}
+
+// ListVersions lists a page of the versions of the service, and returns the
+// continue token of the next page, which is empty for the last page. The
+// versions are sorted by the create time descendingly by default.
+func (d *DataStore) ListVersions(serviceID string, filter VersionFilter, opts ListOptions) ([]api.Version, string, error) {
+	if opts.Sort == "" {
+		opts.Sort = "-create_time"
+	}
+	query := bson.M{"service_id": serviceID}
+	if filter.NamePrefix != "" {
+		query["name"] = prefixRegex(filter.NamePrefix)
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	if filter.Operation != "" {
+		// The operations of a version are concatenated, e.g. integrationpublish.
+		query["operation"] = bson.RegEx{Pattern: regexp.QuoteMeta(string(filter.Operation))}
+	}
+	if filter.Operator != "" {
+		query["operator"] = filter.Operator
+	}
+	if r := timeRange(filter.CreatedAfter, filter.CreatedBefore); r != nil {
+		query["create_time"] = r
+	}
+
+	q, err := pageQuery(query, versionSortFields, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	versions := []api.Version{}
+	col := d.s.DB(defaultDBName).C(versionCollectionName)
+	if err := col.Find(q.query).Sort(q.sort...).Limit(q.limit).All(&versions); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if opts.Limit > 0 && len(versions) > opts.Limit {
+		versions = versions[:opts.Limit]
+		last := versions[len(versions)-1]
+		values := map[string]interface{}{"name": last.Name, "create_time": last.CreateTime}
+		next = encodeContinue(q.field, values[q.field], last.VersionID)
+	}
+	return versions, next, nil
+}
+
+// page is the mongo query of a page.
+type page struct {
+	query bson.M
+	sort  []string
+	field string
+	limit int
+}
+
+// pageQuery builds the query of the page from the filter query and the list
+// options. One more document than the limit is queried to know whether there
+// is a next page.
+func pageQuery(query bson.M, fields map[string]bool, opts ListOptions) (*page, error) {
+	field := strings.TrimPrefix(opts.Sort, "-")
+	isTime, ok := fields[field]
+	if !ok {
+		return nil, ErrInvalidSort
+	}
+	desc := strings.HasPrefix(opts.Sort, "-")
+
+	p := &page{query: query, field: field, sort: []string{opts.Sort, "_id"}}
+	if desc {
+		p.sort[1] = "-_id"
+	}
+	if opts.Limit > 0 {
+		p.limit = opts.Limit + 1
+	}
+	if opts.Continue == "" {
+		return p, nil
+	}
+
+	value, id, err := decodeContinue(opts.Continue, field, isTime)
+	if err != nil {
+		return nil, err
+	}
+	op := "$gt"
+	if desc {
+		op = "$lt"
+	}
+	after := []bson.M{
+		{field: bson.M{op: value}},
+		{field: value, "_id": bson.M{op: id}},
+	}
+	p.query = bson.M{"$and": []bson.M{query, {"$or": after}}}
+	return p, nil
+}
+
+// continueToken is the position of the last document of a page.
+type continueToken struct {
+	Field string `json:"f"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// encodeContinue encodes the sort field value and the id of the last document
+// of a page as the continue token.
+func encodeContinue(field string, value interface{}, id string) string {
+	token := continueToken{Field: field, ID: id}
+	switch v := value.(type) {
+	case time.Time:
+		token.Value = v.UTC().Format(time.RFC3339Nano)
+	case string:
+		token.Value = v
+	}
+	data, _ := json.Marshal(token)
+	return base64.URLEncoding.EncodeToString(data)
+}
+
+// decodeContinue decodes the sort field value and the id in the continue
+// token, the token must be of the same sort field.
+func decodeContinue(s string, field string, isTime bool) (interface{}, string, error) {
+	data, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, "", ErrInvalidContinue
+	}
+	var token continueToken
+	if err := json.Unmarshal(data, &token); err != nil || token.Field != field || token.ID == "" {
+		return nil, "", ErrInvalidContinue
+	}
+
+	if !isTime {
+		return token.Value, token.ID, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, token.Value)
+	if err != nil {
+		return nil, "", ErrInvalidContinue
+	}
+	return t, token.ID, nil
+}
+
+// prefixRegex matches the strings with the prefix, the anchored regex can use
+// the index.
+func prefixRegex(prefix string) bson.RegEx {
+	return bson.RegEx{Pattern: "^" + regexp.QuoteMeta(prefix)}
+}
+
+// timeRange gets the query of the time range, nil if there is no limit.
+func timeRange(after, before time.Time) bson.M {
+	r := bson.M{}
+	if !after.IsZero() {
+		r["$gte"] = after
+	}
+	if !before.IsZero() {
+		r["$lt"] = before
+	}
+	if len(r) == 0 {
+		return nil
+	}
+	return r
+}
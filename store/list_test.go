@@ -0,0 +1,85 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TestContinueToken tests the round trip of the continue tokens.
+func TestContinueToken(t *testing.T) {
+	now := time.Date(2017, 3, 1, 8, 30, 0, 123000000, time.UTC)
+	token := encodeContinue("create_time", now, "id1")
+	value, id, err := decodeContinue(token, "create_time", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !now.Equal(value.(time.Time)) || id != "id1" {
+		t.Errorf("Expect %v, id1, got %v, %s", now, value, id)
+	}
+
+	token = encodeContinue("name", "v1.0", "id2")
+	value, id, err = decodeContinue(token, "name", false)
+	if err != nil || value != "v1.0" || id != "id2" {
+		t.Errorf("Expect v1.0, id2, got %v, %s, %v", value, id, err)
+	}
+
+	if _, _, err := decodeContinue(token, "create_time", true); err != ErrInvalidContinue {
+		t.Errorf("Expect ErrInvalidContinue for another sort field, got %v", err)
+	}
+	if _, _, err := decodeContinue("bad token", "name", false); err != ErrInvalidContinue {
+		t.Errorf("Expect ErrInvalidContinue for malformed token, got %v", err)
+	}
+}
+
+// TestPageQuery tests building the query of a page.
+func TestPageQuery(t *testing.T) {
+	query := bson.M{"service_id": "s1"}
+	if _, err := pageQuery(query, versionSortFields, ListOptions{Sort: "commit"}); err != ErrInvalidSort {
+		t.Errorf("Expect ErrInvalidSort, got %v", err)
+	}
+
+	p, err := pageQuery(query, versionSortFields, ListOptions{Sort: "-create_time", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.sort, []string{"-create_time", "-_id"}) || p.limit != 11 {
+		t.Errorf("Expect sort by -create_time, -_id with limit 11, got %v, %d", p.sort, p.limit)
+	}
+	if !reflect.DeepEqual(p.query, query) {
+		t.Errorf("Expect query %v for the first page, got %v", query, p.query)
+	}
+
+	p, err = pageQuery(query, versionSortFields, ListOptions{
+		Sort:     "name",
+		Continue: encodeContinue("name", "v1", "id1"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bson.M{"$and": []bson.M{query, {"$or": []bson.M{
+		{"name": bson.M{"$gt": "v1"}},
+		{"name": "v1", "_id": bson.M{"$gt": "id1"}},
+	}}}}
+	if !reflect.DeepEqual(p.query, expected) || p.limit != 0 {
+		t.Errorf("Expect query %v without limit, got %v, %d", expected, p.query, p.limit)
+	}
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package store
+
+import (
+	"github.com/caicloud/cyclone/api"
+	"github.com/satori/go.uuid"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// FindProjectsByCondition finds a list of projects via user ID and project name.
+func (d *DataStore) FindProjectsByCondition(userID, name string) ([]api.Project, error) {
+	projects := []api.Project{}
+	filter := bson.M{"user_id": userID, "name": name}
+	col := d.s.DB(defaultDBName).C(projectCollectionName)
+	err := col.Find(filter).Iter().All(&projects)
+	return projects, err
+}
+
+// NewProjectDocument creates a new document (record) in mongodb. It returns project
+// id of the newly created project.
+func (d *DataStore) NewProjectDocument(project *api.Project) (string, error) {
+	project.ProjectID = uuid.NewV4().String()
+	col := d.s.DB(defaultDBName).C(projectCollectionName)
+	_, err := col.Upsert(bson.M{"_id": project.ProjectID}, project)
+	return project.ProjectID, err
+}
+
+// UpsertProjectDocument upserts a special project document.
+func (d *DataStore) UpsertProjectDocument(project *api.Project) (string, error) {
+	col := d.s.DB(defaultDBName).C(projectCollectionName)
+	_, err := col.Upsert(bson.M{"_id": project.ProjectID}, project)
+	return project.ProjectID, err
+}
+
+// FindProjectByID finds a project entity by ID.
+func (d *DataStore) FindProjectByID(projectID string) (*api.Project, error) {
+	project := &api.Project{}
+	col := d.s.DB(defaultDBName).C(projectCollectionName)
+	err := col.Find(bson.M{"_id": projectID}).One(project)
+	return project, err
+}
+
+// FindProjectsByUserID finds a list of projects via user ID.
+func (d *DataStore) FindProjectsByUserID(userID string) ([]api.Project, error) {
+	projects := []api.Project{}
+	filter := bson.M{"user_id": userID}
+	col := d.s.DB(defaultDBName).C(projectCollectionName)
+	err := col.Find(filter).Sort("name").Iter().All(&projects)
+	return projects, err
+}
+
+// DeleteProjectByID removes project by project_id.
+func (d *DataStore) DeleteProjectByID(projectID string) error {
+	col := d.s.DB(defaultDBName).C(projectCollectionName)
+	return col.Remove(bson.M{"_id": projectID})
+}
+
+// AddNewProjectVersion adds a new version (version ID) to a given project.
+func (d *DataStore) AddNewProjectVersion(projectID string, versionID string) error {
+	change := mgo.Change{
+		Update: bson.M{"$push": bson.M{"versions": versionID}},
+	}
+	col := d.s.DB(defaultDBName).C(projectCollectionName)
+	_, err := col.Find(bson.M{"_id": projectID}).Apply(change, nil)
+	return err
+}
+
+// FindProjectVersionsByCondition finds project versions by project ID and version name.
+func (d *DataStore) FindProjectVersionsByCondition(projectID, name string) ([]api.ProjectVersion, error) {
+	versions := []api.ProjectVersion{}
+	filter := bson.M{"project_id": projectID, "name": name}
+	col := d.s.DB(defaultDBName).C(projectVersionCollectionName)
+	err := col.Find(filter).Iter().All(&versions)
+	return versions, err
+}
+
+// NewProjectVersionDocument creates a new document (record) in mongodb. It returns
+// version id of the newly created project version.
+func (d *DataStore) NewProjectVersionDocument(version *api.ProjectVersion) (string, error) {
+	version.VersionID = uuid.NewV4().String()
+	col := d.s.DB(defaultDBName).C(projectVersionCollectionName)
+	_, err := col.Upsert(bson.M{"_id": version.VersionID}, version)
+	return version.VersionID, err
+}
+
+// UpdateProjectVersionDocument updates a project version entirely.
+func (d *DataStore) UpdateProjectVersionDocument(version *api.ProjectVersion) error {
+	col := d.s.DB(defaultDBName).C(projectVersionCollectionName)
+	return col.Update(bson.M{"_id": version.VersionID}, version)
+}
+
+// FindProjectVersionByID finds a project version entity by ID.
+func (d *DataStore) FindProjectVersionByID(versionID string) (*api.ProjectVersion, error) {
+	version := &api.ProjectVersion{}
+	col := d.s.DB(defaultDBName).C(projectVersionCollectionName)
+	err := col.Find(bson.M{"_id": versionID}).One(version)
+	return version, err
+}
+
+// FindProjectVersionsByProjectID finds the versions of a project, the latest first.
+func (d *DataStore) FindProjectVersionsByProjectID(projectID string) ([]api.ProjectVersion, error) {
+	versions := []api.ProjectVersion{}
+	filter := bson.M{"project_id": projectID}
+	col := d.s.DB(defaultDBName).C(projectVersionCollectionName)
+	err := col.Find(filter).Sort("-create_time").Iter().All(&versions)
+	return versions, err
+}
+
+// DeleteProjectVersionsByProjectID removes all the versions of a project.
+func (d *DataStore) DeleteProjectVersionsByProjectID(projectID string) error {
+	col := d.s.DB(defaultDBName).C(projectVersionCollectionName)
+	_, err := col.RemoveAll(bson.M{"project_id": projectID})
+	return err
+}
+
+// FindProjectsRelateService finds the projects which have the service as a member
+// or a dependency.
+func (d *DataStore) FindProjectsRelateService(serviceID string) ([]api.Project, error) {
+	projects := []api.Project{}
+	filter := bson.M{"$or": []bson.M{
+		{"services.service_id": serviceID},
+		{"services.depend.service_id": serviceID},
+	}}
+	col := d.s.DB(defaultDBName).C(projectCollectionName)
+	err := col.Find(filter).Iter().All(&projects)
+	return projects, err
+}
@@ -112,6 +112,30 @@ func (d *DataStore) UpdateServiceLastInfo(serviceID string, lasttime time.Time,
 	return err
 }
 
+// IncServiceCacheGeneration increases the cache generation of the service, and
+// returns the updated service.
+func (d *DataStore) IncServiceCacheGeneration(serviceID string) (*api.Service, error) {
+	change := mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"cache_generation": 1}},
+		ReturnNew: true,
+	}
+	service := &api.Service{}
+	col := d.s.DB(defaultDBName).C(serviceCollectionName)
+	_, err := col.Find(bson.M{"_id": serviceID}).Apply(change, service)
+	return service, err
+}
+
+// SetServiceDeployKey sets the deploy key of the service's repository, the
+// deploy key is removed if it is empty.
+func (d *DataStore) SetServiceDeployKey(serviceID string, key api.DeployKey) error {
+	update := bson.M{"$set": bson.M{"repository.deploy_key": key}}
+	if key == (api.DeployKey{}) {
+		update = bson.M{"$unset": bson.M{"repository.deploy_key": ""}}
+	}
+	col := d.s.DB(defaultDBName).C(serviceCollectionName)
+	return col.Update(bson.M{"_id": serviceID}, update)
+}
+
 // UpsertServiceDocument upsert a special serivce document
 func (d *DataStore) UpsertServiceDocument(service *api.Service) (string, error) {
 	col := d.s.DB(defaultDBName).C(serviceCollectionName)
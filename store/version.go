@@ -52,6 +52,26 @@ func (d *DataStore) UpdateVersionDocument(versionID string, version api.Version)
 	return err
 }
 
+// CompareAndSetVersion updates a version entirely if it is still in the status,
+// mgo.ErrNotFound is returned otherwise.
+func (d *DataStore) CompareAndSetVersion(versionID string, status api.VersionStatus, version api.Version) error {
+	filter := bson.M{"_id": versionID, "status": status}
+	change := mgo.Change{
+		Update: bson.M{"$set": version},
+	}
+	col := d.s.DB(defaultDBName).C(versionCollectionName)
+	_, err := col.Find(filter).Apply(change, &version)
+	return err
+}
+
+// FindVersionsByStatus finds the versions in the status.
+func (d *DataStore) FindVersionsByStatus(status api.VersionStatus) ([]api.Version, error) {
+	versions := []api.Version{}
+	col := d.s.DB(defaultDBName).C(versionCollectionName)
+	err := col.Find(bson.M{"status": status}).Iter().All(&versions)
+	return versions, err
+}
+
 // FindVersionByID finds a version entity by ID.
 func (d *DataStore) FindVersionByID(versionID string) (*api.Version, error) {
 	version := &api.Version{}
@@ -75,3 +95,32 @@ func (d *DataStore) DeleteVersionByID(versionID string) error {
 	err := col.Remove(bson.M{"_id": versionID})
 	return err
 }
+
+// RemoveLiveInfo removes the live information of the environment from the version,
+// which loses the live tag if it is not live in any environment.
+func (d *DataStore) RemoveLiveInfo(versionID, environment string) error {
+	return d.removeLiveInfo(bson.M{"_id": versionID}, environment)
+}
+
+// RemoveOtherLiveInfo removes the live information of the environment from the
+// versions of the service other than the version, as the version replaces them.
+func (d *DataStore) RemoveOtherLiveInfo(serviceID, versionID, environment string) error {
+	return d.removeLiveInfo(bson.M{"service_id": serviceID, "_id": bson.M{"$ne": versionID}}, environment)
+}
+
+// removeLiveInfo removes the live information of the environment from the
+// versions matching the filter, and the live tag of the ones not live any more.
+func (d *DataStore) removeLiveInfo(filter bson.M, environment string) error {
+	col := d.s.DB(defaultDBName).C(versionCollectionName)
+	filter["live_info.environment"] = environment
+	_, err := col.UpdateAll(filter, bson.M{"$pull": bson.M{"live_info": bson.M{"environment": environment}}})
+	if err != nil {
+		return err
+	}
+
+	delete(filter, "live_info.environment")
+	filter["tags"] = api.LiveVersion
+	filter["live_info"] = bson.M{"$size": 0}
+	_, err = col.UpdateAll(filter, bson.M{"$pull": bson.M{"tags": api.LiveVersion}})
+	return err
+}
@@ -17,8 +17,11 @@ limitations under the License.
 package store
 
 import (
+	"time"
+
 	"github.com/caicloud/cyclone/api"
 	"github.com/satori/go.uuid"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -64,13 +67,20 @@ func (d *DataStore) DeleteWorkerNodeByID(nodeID string) error {
 	return err
 }
 
-// FindSystemWorkerNodeByResource finds a list of system worker node by resouce.
-func (d *DataStore) FindSystemWorkerNodeByResource(resource *api.BuildResource) ([]api.WorkerNode, error) {
+// FindSystemWorkerNodeByResource finds a list of schedulable system worker node by resouce,
+// the nodes must have all the labels in selector.
+func (d *DataStore) FindSystemWorkerNodeByResource(resource *api.BuildResource,
+	selector map[string]string) ([]api.WorkerNode, error) {
 	workerNodes := []api.WorkerNode{}
 	filter := bson.M{
 		"type":                 api.SystemWorkerNode,
 		"left_resource.memory": bson.M{"$gte": resource.Memory},
 		"left_resource.cpu":    bson.M{"$gte": resource.CPU},
+		"status":               bson.M{"$ne": api.WorkerNodeUnreachable},
+		"unschedulable":        bson.M{"$ne": true},
+	}
+	for key, value := range selector {
+		filter["labels."+key] = value
 	}
 	col := d.s.DB(defaultDBName).C(workerNodeCollection)
 	err := col.Find(filter).Sort("-left_resource.memory").Iter().All(&workerNodes)
@@ -83,3 +93,84 @@ func (d *DataStore) UpsertWorkerNodeDocument(node *api.WorkerNode) (string, erro
 	_, err := col.Upsert(bson.M{"_id": node.NodeID}, node)
 	return node.NodeID, err
 }
+
+// ReserveWorkerNodeResource subtracts resource from the left resource of a worker node
+// atomically, IFF the node has enough resource left. It returns mgo.ErrNotFound if the
+// node can not afford the resource.
+func (d *DataStore) ReserveWorkerNodeResource(nodeID string, resource *api.BuildResource) (*api.WorkerNode, error) {
+	node := &api.WorkerNode{}
+	filter := bson.M{
+		"_id":                  nodeID,
+		"left_resource.memory": bson.M{"$gte": resource.Memory},
+		"left_resource.cpu":    bson.M{"$gte": resource.CPU},
+	}
+	change := mgo.Change{
+		Update: bson.M{"$inc": bson.M{
+			"left_resource.memory": -resource.Memory,
+			"left_resource.cpu":    -resource.CPU,
+		}},
+		ReturnNew: true,
+	}
+	col := d.s.DB(defaultDBName).C(workerNodeCollection)
+	_, err := col.Find(filter).Apply(change, node)
+	return node, err
+}
+
+// ReleaseWorkerNodeResource adds resource back to the left resource of the worker node
+// atomically.
+func (d *DataStore) ReleaseWorkerNodeResource(dockerHost string, resource *api.BuildResource) error {
+	update := bson.M{"$inc": bson.M{
+		"left_resource.memory": resource.Memory,
+		"left_resource.cpu":    resource.CPU,
+	}}
+	col := d.s.DB(defaultDBName).C(workerNodeCollection)
+	return col.Update(bson.M{"docker_host": dockerHost}, update)
+}
+
+// CompareAndSetWorkerNodeLeftResource sets the left resource of a worker node IFF it has
+// not been changed since prev was read. It returns mgo.ErrNotFound if it has been changed.
+func (d *DataStore) CompareAndSetWorkerNodeLeftResource(nodeID string, prev, left api.NodeResource) error {
+	filter := bson.M{
+		"_id":                  nodeID,
+		"left_resource.memory": valueOrMissing(prev.Memory),
+		"left_resource.cpu":    valueOrMissing(prev.CPU),
+	}
+	update := bson.M{"$set": bson.M{
+		"left_resource.memory": left.Memory,
+		"left_resource.cpu":    left.CPU,
+	}}
+	col := d.s.DB(defaultDBName).C(workerNodeCollection)
+	return col.Update(filter, update)
+}
+
+// valueOrMissing builds the condition to match a number field, zero value matches
+// the missing field too, as it is omitted when the document is saved.
+func valueOrMissing(value float64) interface{} {
+	if value == 0 {
+		return bson.M{"$in": []interface{}{0, nil}}
+	}
+	return value
+}
+
+// UpdateWorkerNodeStatus updates the status of a worker node, the last seen time is
+// updated if it is not zero.
+func (d *DataStore) UpdateWorkerNodeStatus(nodeID string, status api.WorkerNodeStatus, lastSeen time.Time) error {
+	set := bson.M{"status": status}
+	if !lastSeen.IsZero() {
+		set["last_seen"] = lastSeen
+	}
+	col := d.s.DB(defaultDBName).C(workerNodeCollection)
+	return col.Update(bson.M{"_id": nodeID}, bson.M{"$set": set})
+}
+
+// SetWorkerNodeUnschedulable cordons or uncordons a worker node.
+func (d *DataStore) SetWorkerNodeUnschedulable(nodeID string, unschedulable bool) (*api.WorkerNode, error) {
+	node := &api.WorkerNode{}
+	change := mgo.Change{
+		Update:    bson.M{"$set": bson.M{"unschedulable": unschedulable}},
+		ReturnNew: true,
+	}
+	col := d.s.DB(defaultDBName).C(workerNodeCollection)
+	_, err := col.Find(bson.M{"_id": nodeID}).Apply(change, node)
+	return node, err
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/pkg/executil"
+	"github.com/caicloud/cyclone/pkg/log"
+	"github.com/caicloud/cyclone/worker/ci/yaml"
+)
+
+const (
+	// WORKER_CACHE_DIR is the env of the directory to keep the build cache, it
+	// is the same path on the worker nodes and in the workers.
+	WORKER_CACHE_DIR = "WORKER_CACHE_DIR"
+	// DEFAULT_WORKER_CACHE_DIR is the default value of WORKER_CACHE_DIR.
+	DEFAULT_WORKER_CACHE_DIR = "/var/lib/cyclone/cache"
+
+	// defaultKey is the key of the cache if neither the key nor the branch is given.
+	defaultKey = "default"
+)
+
+var (
+	// ErrInvalidPath is the error when a cache path is not inside the repository.
+	ErrInvalidPath = errors.New("cache path must be a relative path inside the repository")
+
+	invalidKeyChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+)
+
+// Cache keeps the declared directories of a build between versions of a
+// service, it is stored in <root>/<service id>/<cache generation>/<key>.
+type Cache struct {
+	serviceDir string
+	generation string
+	dir        string
+	paths      []string
+}
+
+// New creates the cache of the version from the cache section of caicloud.yml.
+// The cache is keyed by the key in the config, or the branch of the version.
+func New(root string, service *api.Service, version *api.Version, conf yaml.Cache) (*Cache, error) {
+	paths := make([]string, 0, len(conf.Paths))
+	for _, p := range conf.Paths {
+		cleaned := filepath.Clean(p)
+		if filepath.IsAbs(cleaned) || cleaned == "." || cleaned == ".." ||
+			strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("%v: %s", ErrInvalidPath, p)
+		}
+		paths = append(paths, cleaned)
+	}
+
+	key := conf.Key
+	if key == "" {
+		key = version.Branch
+	}
+
+	serviceDir := filepath.Join(root, service.ServiceID)
+	generation := strconv.Itoa(service.CacheGeneration)
+	return &Cache{
+		serviceDir: serviceDir,
+		generation: generation,
+		dir:        filepath.Join(serviceDir, generation, sanitizeKey(key)),
+		paths:      paths,
+	}, nil
+}
+
+// sanitizeKey makes the key usable as a directory name.
+func sanitizeKey(key string) string {
+	key = invalidKeyChars.ReplaceAllString(key, "_")
+	if key == "" {
+		return defaultKey
+	}
+	if strings.HasPrefix(key, ".") {
+		key = "_" + key
+	}
+	return key
+}
+
+// Restore copies the cached directories into the repository, and reports
+// cache hit or miss of each directory to the output.
+func (c *Cache) Restore(contextDir string, output io.Writer) error {
+	for _, p := range c.paths {
+		src := filepath.Join(c.dir, p)
+		if _, err := os.Stat(src); err != nil {
+			if os.IsNotExist(err) {
+				fmt.Fprintf(output, "cache miss: %s\n", p)
+				continue
+			}
+			return err
+		}
+
+		if err := copyDir(src, filepath.Join(contextDir, p)); err != nil {
+			return err
+		}
+		fmt.Fprintf(output, "cache hit: %s\n", p)
+	}
+	return nil
+}
+
+// Save replaces the cached directories with the ones in the repository, and
+// removes the caches of the service left by the purged generations. Builds
+// saving the same cache at the same time never leave a partial one.
+func (c *Cache) Save(contextDir string, output io.Writer) error {
+	for _, p := range c.paths {
+		src := filepath.Join(contextDir, p)
+		if _, err := os.Stat(src); err != nil {
+			if os.IsNotExist(err) {
+				fmt.Fprintf(output, "cache skipped, directory not found: %s\n", p)
+				continue
+			}
+			return err
+		}
+
+		if err := replaceDir(src, filepath.Join(c.dir, p)); err != nil {
+			return err
+		}
+		fmt.Fprintf(output, "cache saved: %s\n", p)
+	}
+
+	c.prune()
+	return nil
+}
+
+// prune removes the caches of the other generations of the service.
+func (c *Cache) prune() {
+	entries, err := ioutil.ReadDir(c.serviceDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.Name() == c.generation {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(c.serviceDir, entry.Name())); err != nil {
+			log.Warnf("Unable to remove the purged cache %s: %v", entry.Name(), err)
+		}
+	}
+}
+
+// replaceDir replaces the dest directory with a copy of the src directory. The
+// copy is made in a temporary directory beside dest and renamed to it, if
+// another build renames its copy first, that copy is kept.
+func replaceDir(src, dest string) error {
+	parent := filepath.Dir(dest)
+	if err := os.MkdirAll(parent, os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(parent, "."+filepath.Base(dest)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := copyDir(src, tmp); err != nil {
+		return err
+	}
+
+	old := tmp + ".old"
+	if err := os.Rename(dest, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	defer os.RemoveAll(old)
+	if err := os.Rename(tmp, dest); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+// copyDir copies the content of the src directory into the dest directory.
+func copyDir(src, dest string) error {
+	dest, err := filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dest, os.ModePerm); err != nil {
+		return err
+	}
+	if out, err := executil.RunInDir(src, "cp", "-a", ".", dest); err != nil {
+		return fmt.Errorf("copy %s to %s: %v, %s", src, dest, err, out)
+	}
+	return nil
+}
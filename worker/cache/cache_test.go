@@ -0,0 +1,175 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/worker/ci/yaml"
+)
+
+// TestNewInvalidPath tests the paths outside the repository are rejected.
+func TestNewInvalidPath(t *testing.T) {
+	service := &api.Service{ServiceID: "s1"}
+	version := &api.Version{Branch: "master"}
+	for _, p := range []string{"/root/.m2", "../vendor", ".", "a/../.."} {
+		if _, err := New("/tmp", service, version, yaml.Cache{Paths: []string{p}}); err == nil {
+			t.Errorf("Expect error for cache path %s", p)
+		}
+	}
+}
+
+// TestKey tests the cache directory is keyed by the key or the branch.
+func TestKey(t *testing.T) {
+	service := &api.Service{ServiceID: "s1", CacheGeneration: 2}
+	cases := []struct {
+		key    string
+		branch string
+		dir    string
+	}{
+		{"deps", "master", "/cache/s1/2/deps"},
+		{"", "feature/x", "/cache/s1/2/feature_x"},
+		{"", "", "/cache/s1/2/default"},
+		{"..", "", "/cache/s1/2/_.."},
+	}
+	for _, c := range cases {
+		cache, err := New("/cache", service, &api.Version{Branch: c.branch}, yaml.Cache{Key: c.key})
+		if err != nil {
+			t.Fatalf("Expect error to be nil, got %v", err)
+		}
+		if cache.dir != c.dir {
+			t.Errorf("Expect cache dir %s, got %s", c.dir, cache.dir)
+		}
+	}
+}
+
+// TestSaveRestore tests the directories are saved, restored, and the caches
+// of the purged generations are removed.
+func TestSaveRestore(t *testing.T) {
+	root, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	repo := filepath.Join(root, "repo")
+	if err := os.MkdirAll(filepath.Join(repo, "vendor", "pkg"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(repo, "vendor", "pkg", "a.go"), []byte("package pkg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stale := filepath.Join(root, "cache", "s1", "0", "master")
+	if err := os.MkdirAll(stale, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	service := &api.Service{ServiceID: "s1", CacheGeneration: 1}
+	version := &api.Version{Branch: "master"}
+	cache, err := New(filepath.Join(root, "cache"), service, version, yaml.Cache{Paths: []string{"vendor"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	if err := cache.Restore(repo, &output); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "cache miss: vendor") {
+		t.Errorf("Expect cache miss, got %s", output.String())
+	}
+
+	if err := cache.Save(repo, &output); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "cache", "s1", "0")); !os.IsNotExist(err) {
+		t.Errorf("Expect the purged generation to be removed, got %v", err)
+	}
+
+	next := filepath.Join(root, "next")
+	output.Reset()
+	if err := cache.Restore(next, &output); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "cache hit: vendor") {
+		t.Errorf("Expect cache hit, got %s", output.String())
+	}
+	content, err := ioutil.ReadFile(filepath.Join(next, "vendor", "pkg", "a.go"))
+	if err != nil || string(content) != "package pkg" {
+		t.Errorf("Expect the cached file to be restored, got %s, %v", content, err)
+	}
+}
+
+// TestSaveConcurrently tests builds saving the same cache at the same time
+// leave one complete copy and no temporary directories.
+func TestSaveConcurrently(t *testing.T) {
+	root, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	service := &api.Service{ServiceID: "s1"}
+	version := &api.Version{Branch: "master"}
+	cache, err := New(filepath.Join(root, "cache"), service, version, yaml.Cache{Paths: []string{"vendor"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		repo := filepath.Join(root, "repo"+strconv.Itoa(i))
+		if err := os.MkdirAll(filepath.Join(repo, "vendor"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"a", "b"} {
+			if err := ioutil.WriteFile(filepath.Join(repo, "vendor", name), []byte(strconv.Itoa(i)), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := cache.Save(repo, ioutil.Discard); err != nil {
+				t.Errorf("Expect no error, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	a, errA := ioutil.ReadFile(filepath.Join(cache.dir, "vendor", "a"))
+	b, errB := ioutil.ReadFile(filepath.Join(cache.dir, "vendor", "b"))
+	if errA != nil || errB != nil || string(a) != string(b) {
+		t.Errorf("Expect one complete copy of the cache, got %s, %s, %v, %v", a, b, errA, errB)
+	}
+	entries, err := ioutil.ReadDir(cache.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expect only the cached directory, got %d entries", len(entries))
+	}
+}
@@ -115,6 +115,18 @@ func (cm *Manager) ExecPublish(r *runner.Build) error {
 	return nil
 }
 
+// ExecOnFailure executes the steps left in the sections of the flags which
+// run on failure, after the build fails with the error.
+func (cm *Manager) ExecOnFailure(r *runner.Build, flags parser.NodeType, err error) error {
+	log.Info("About to run the steps on failure.")
+	err = r.RunOnFailure(flags, err)
+	if err != nil {
+		log.Info("Run the steps on failure failed.")
+		return err
+	}
+	return nil
+}
+
 // ExecPostBuild executes the 'postbuild' section in yaml file
 func (cm *Manager) ExecPostBuild(r *runner.Build) error {
 	log.Info("About to run post build event.")
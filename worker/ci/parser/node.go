@@ -18,6 +18,10 @@ limitations under the License.
 package parser
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/caicloud/cyclone/worker/ci/yaml"
 )
 
@@ -39,6 +43,7 @@ const (
 	NodePostBuild
 	NodeService
 	NodeDeploy
+	NodeMatrix
 )
 
 // Nodes.
@@ -60,12 +65,8 @@ type DockerNode struct {
 	// NodeType defines the type of the DockerNode.
 	NodeType
 
-	//
-	// Fields only for ServiceNode
-	//
-
-	// Name of the node, when the node is running, the name is used to
-	// service discovery.
+	// Name of the node, when the service node is running, the name is used
+	// to service discovery. The steps in a DAGNode are identified by the name.
 	Name string
 
 	//
@@ -74,6 +75,8 @@ type DockerNode struct {
 
 	// Commands is only for BuildNode and PreBuildNode, to support command list.
 	Commands []string
+	// DependsOn is the names of the steps to finish before the step.
+	DependsOn []string
 
 	//
 	// Common fields
@@ -98,6 +101,37 @@ type DockerNode struct {
 	DockerfilePath string
 	DockerfileName string
 	Vargs          map[string]interface{}
+	// Timeout is the max time in seconds to run the step, 0 means no limit.
+	Timeout int
+	// When is the conditions to run the step.
+	When yaml.When
+}
+
+// MatrixNode holds the integration subtrees, one for each cell of the matrix.
+type MatrixNode struct {
+	// NodeType defines the type of the MatrixNode.
+	NodeType
+
+	Cells []*CellNode
+}
+
+// CellNode is the integration subtree of a matrix cell, the services and the
+// integration of the cell run with the environment variables of the cell.
+type CellNode struct {
+	// Name identifies the cell in logs and statuses, e.g. GO_VERSION=1.7 DB=mysql.
+	Name string
+	// Environment is the environment variables of the cell in KEY=value format.
+	Environment []string
+	Root        *ListNode
+}
+
+// DAGNode holds the named steps of a section declaring depends_on, a step runs
+// after the steps it depends on, and the independent steps run concurrently.
+type DAGNode struct {
+	// NodeType defines the type of the steps, NodePreBuild or NodePostBuild.
+	NodeType
+
+	Steps []*DockerNode
 }
 
 // DeployNode is the type for deploy section in yml.
@@ -126,6 +160,8 @@ func newDockerNode(typ NodeType, c yaml.Container) *DockerNode {
 		Memory:         c.Memory,
 		CPUSetCPUs:     c.CPUSetCPUs,
 		OomKillDisable: c.OomKillDisable,
+		Timeout:        c.Timeout,
+		When:           c.When,
 	}
 }
 
@@ -144,6 +180,8 @@ func newBuildNode(typ NodeType, b yaml.Build) *DockerNode {
 	node.DockerfilePath = b.DockerfilePath
 	node.DockerfileName = b.DockerfileName
 	node.Commands = b.Commands
+	node.Name = b.Name
+	node.DependsOn = b.DependsOn
 	return node
 }
 
@@ -153,9 +191,96 @@ func newPreBuildNode(typ NodeType, b yaml.PreBuild) *DockerNode {
 	node.Outputs = b.Outputs
 	node.DockerfilePath = b.DockerfilePath
 	node.DockerfileName = b.DockerfileName
+	node.Name = b.Name
+	node.DependsOn = b.DependsOn
 	return node
 }
 
+// newDAGNode returns a new DAGNode of the steps. The steps must have unique
+// names, and depend on the existing steps without cycles.
+func newDAGNode(typ NodeType, steps []*DockerNode) (*DAGNode, error) {
+	waiting := make(map[string]int, len(steps))
+	for _, step := range steps {
+		if step.Name == "" {
+			return nil, fmt.Errorf("steps with depends_on must be named")
+		}
+		if _, ok := waiting[step.Name]; ok {
+			return nil, fmt.Errorf("duplicate step %s", step.Name)
+		}
+		waiting[step.Name] = len(step.DependsOn)
+	}
+
+	dependents := make(map[string][]string)
+	var ready []string
+	for _, step := range steps {
+		for _, dep := range step.DependsOn {
+			if _, ok := waiting[dep]; !ok {
+				return nil, fmt.Errorf("step %s depends on unknown step %s", step.Name, dep)
+			}
+			dependents[dep] = append(dependents[dep], step.Name)
+		}
+		if len(step.DependsOn) == 0 {
+			ready = append(ready, step.Name)
+		}
+	}
+
+	// Remove the steps without dependencies one by one, the steps left
+	// are in cycles.
+	sorted := 0
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		sorted++
+		for _, next := range dependents[name] {
+			waiting[next]--
+			if waiting[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+	if sorted != len(steps) {
+		return nil, fmt.Errorf("steps depend on each other in a cycle")
+	}
+
+	return &DAGNode{NodeType: typ, Steps: steps}, nil
+}
+
+// newCellNode returns a new CellNode with the services and the integration
+// of the cell. The $KEY or ${KEY} in the images are replaced by the values of
+// the cell, and the environment variables of the cell are appended to the nodes.
+func newCellNode(services []yaml.Container, build yaml.Build, env []string) *CellNode {
+	values := make(map[string]string, len(env))
+	for _, e := range env {
+		kv := strings.SplitN(e, "=", 2)
+		values[kv[0]] = kv[1]
+	}
+	expand := func(s string) string {
+		return os.Expand(s, func(key string) string {
+			if value, ok := values[key]; ok {
+				return value
+			}
+			return "${" + key + "}"
+		})
+	}
+
+	cell := &CellNode{
+		Name:        strings.Join(env, " "),
+		Environment: env,
+		Root:        &ListNode{NodeType: NodeList},
+	}
+	nodes := make([]*DockerNode, 0, len(services)+1)
+	for _, service := range services {
+		nodes = append(nodes, newServiceNode(service, service.Name))
+	}
+	nodes = append(nodes, newBuildNode(NodeIntegration, build))
+	for _, node := range nodes {
+		node.Image = expand(node.Image)
+		node.Environment = append(append([]string{}, node.Environment...), env...)
+		cell.Root.append(node)
+	}
+	return cell
+}
+
 // newDeployNode returns a new DeployNode. A DeployNode represents an action deploying
 // a version to an application.
 func newDeployNode(d yaml.DeployStep) *DeployNode {
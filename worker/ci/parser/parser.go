@@ -29,6 +29,12 @@ type Tree struct {
 	DeployConfig *DeployNode
 
 	NumberContatiner int
+
+	// Timeout is the max time in seconds to run all the steps, 0 means no limit.
+	Timeout int
+
+	// Cache is the directories kept between the builds of the service.
+	Cache yaml.Cache
 }
 
 // newTree allocates a new parse tree.
@@ -63,6 +69,8 @@ func Parse(raw []byte) (*Tree, error) {
 func Load(conf *yaml.Config) (*Tree, error) {
 	var tree = newTree()
 	var err error
+	tree.Timeout = conf.Timeout
+	tree.Cache = conf.Cache
 
 	// append the prebuild step to execution Tree.
 	err = tree.appendPreBuild(conf.PreBuild.Slice())
@@ -76,16 +84,24 @@ func Load(conf *yaml.Config) (*Tree, error) {
 		return nil, err
 	}
 
-	// append the service map to execution Tree.
-	err = tree.appendServices(conf.Integration.ServiceSlice())
-	if err != nil {
-		return nil, err
-	}
+	if cells := conf.Matrix.Cells(); len(cells) > 0 {
+		// append the integration subtree of each matrix cell to execution Tree.
+		err = tree.appendMatrix(conf.Integration.ServiceSlice(), conf.Integration.Build(), cells)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// append the service map to execution Tree.
+		err = tree.appendServices(conf.Integration.ServiceSlice())
+		if err != nil {
+			return nil, err
+		}
 
-	// append the integration step to execution Tree.
-	err = tree.appendIntegration(conf.Integration.Build())
-	if err != nil {
-		return nil, err
+		// append the integration step to execution Tree.
+		err = tree.appendIntegration(conf.Integration.Build())
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// append the postbuild step to execution Tree.
@@ -104,8 +120,29 @@ func Load(conf *yaml.Config) (*Tree, error) {
 }
 
 func (t *Tree) appendPreBuild(prebuilds []yaml.PreBuild) error {
+	nodes := make([]*DockerNode, 0, len(prebuilds))
 	for _, prebuild := range prebuilds {
-		node := newPreBuildNode(NodePreBuild, prebuild)
+		nodes = append(nodes, newPreBuildNode(NodePreBuild, prebuild))
+	}
+	return t.appendSteps(NodePreBuild, nodes)
+}
+
+// appendSteps appends the steps to the root, the steps are appended as a DAG
+// node if any of them declares depends_on, otherwise they run one by one.
+func (t *Tree) appendSteps(typ NodeType, nodes []*DockerNode) error {
+	for _, node := range nodes {
+		if len(node.DependsOn) == 0 {
+			continue
+		}
+		dag, err := newDAGNode(typ, nodes)
+		if err != nil {
+			return err
+		}
+		t.Root.append(dag)
+		return nil
+	}
+
+	for _, node := range nodes {
 		t.Root.append(node)
 	}
 	return nil
@@ -130,6 +167,17 @@ func (t *Tree) appendIntegration(build yaml.Build) error {
 	return nil
 }
 
+// appendMatrix appends the matrix node to the root, the matrix has one
+// integration subtree for each cell.
+func (t *Tree) appendMatrix(services []yaml.Container, build yaml.Build, cells [][]string) error {
+	node := &MatrixNode{NodeType: NodeMatrix}
+	for _, env := range cells {
+		node.Cells = append(node.Cells, newCellNode(services, build, env))
+	}
+	t.Root.append(node)
+	return nil
+}
+
 // appendBuild appends the build node to the root.
 func (t *Tree) appendBuild(builds []yaml.Build) error {
 	for _, build := range builds {
@@ -141,11 +189,11 @@ func (t *Tree) appendBuild(builds []yaml.Build) error {
 
 // appendPostBuild appends the postbuild hook node to the root.
 func (t *Tree) appendPostBuild(builds []yaml.Build) error {
+	nodes := make([]*DockerNode, 0, len(builds))
 	for _, build := range builds {
-		node := newBuildNode(NodePostBuild, build)
-		t.Root.append(node)
+		nodes = append(nodes, newBuildNode(NodePostBuild, build))
 	}
-	return nil
+	return t.appendSteps(NodePostBuild, nodes)
 }
 
 // appendDeploy appends the deploy node to the root
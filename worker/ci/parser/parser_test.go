@@ -16,7 +16,10 @@ limitations under the License.
 
 package parser
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 const configStr = `
 integration:
@@ -89,3 +92,134 @@ func TestParse(t *testing.T) {
 		t.Error("Expect error to be nil")
 	}
 }
+
+// TestParseMatrix tests the integration subtree is created for each cell.
+func TestParseMatrix(t *testing.T) {
+	tree, err := ParseString(`
+integration:
+  services:
+    db:
+      image: $DB
+  image: golang:${GO_VERSION}
+  environment:
+    - CGO_ENABLED=0
+  commands:
+    - go test
+matrix:
+  GO_VERSION:
+    - 1.6
+    - 1.7
+  DB:
+    - mysql
+`)
+	if err != nil {
+		t.Fatalf("Expect error to be nil, got %v", err)
+	}
+
+	var matrix *MatrixNode
+	for _, node := range tree.Root.Nodes {
+		if n, ok := node.(*MatrixNode); ok {
+			matrix = n
+		}
+		if n, ok := node.(*DockerNode); ok && (n.Type() == NodeIntegration || n.Type() == NodeService) {
+			t.Errorf("Expect no integration outside the matrix, got %+v", n)
+		}
+	}
+	if matrix == nil || len(matrix.Cells) != 2 {
+		t.Fatalf("Expect 2 matrix cells, got %+v", matrix)
+	}
+
+	cell := matrix.Cells[1]
+	if cell.Name != "GO_VERSION=1.7 DB=mysql" {
+		t.Errorf("Expect cell name GO_VERSION=1.7 DB=mysql, got %s", cell.Name)
+	}
+	if len(cell.Root.Nodes) != 2 {
+		t.Fatalf("Expect service and integration in the cell, got %d nodes", len(cell.Root.Nodes))
+	}
+	service := cell.Root.Nodes[0].(*DockerNode)
+	if service.Type() != NodeService || service.Image != "mysql" {
+		t.Errorf("Expect service image mysql, got %s", service.Image)
+	}
+	integration := cell.Root.Nodes[1].(*DockerNode)
+	if integration.Image != "golang:1.7" {
+		t.Errorf("Expect integration image golang:1.7, got %s", integration.Image)
+	}
+	expected := "CGO_ENABLED=0 GO_VERSION=1.7 DB=mysql"
+	if env := strings.Join(integration.Environment, " "); env != expected {
+		t.Errorf("Expect environment %s, got %s", expected, env)
+	}
+	if env := matrix.Cells[0].Root.Nodes[1].(*DockerNode).Environment; len(env) != 3 || env[1] != "GO_VERSION=1.6" {
+		t.Errorf("Expect environment of the first cell to be kept, got %v", env)
+	}
+}
+
+// TestParseDependsOn tests the steps declaring depends_on are parsed to a DAG.
+func TestParseDependsOn(t *testing.T) {
+	tree, err := ParseString(`
+pre_build:
+  compile:
+    image: golang:1.7
+    commands:
+      - go build
+  lint:
+    image: golang:1.7
+    commands:
+      - golint ./...
+  test:
+    image: golang:1.7
+    depends_on:
+      - compile
+    commands:
+      - go test
+`)
+	if err != nil {
+		t.Fatalf("Expect error to be nil, got %v", err)
+	}
+	dag, ok := tree.Root.Nodes[0].(*DAGNode)
+	if !ok || dag.Type() != NodePreBuild || len(dag.Steps) != 3 {
+		t.Fatalf("Expect a pre_build DAG of 3 steps, got %+v", tree.Root.Nodes[0])
+	}
+	if test := dag.Steps[2]; test.Name != "test" || len(test.DependsOn) != 1 || test.DependsOn[0] != "compile" {
+		t.Errorf("Expect step test depending on compile, got %+v", test)
+	}
+
+	tree, err = ParseString(`
+post_build:
+  a:
+    image: busybox
+  b:
+    image: busybox
+`)
+	if err != nil {
+		t.Fatalf("Expect error to be nil, got %v", err)
+	}
+	postBuilds := 0
+	for _, node := range tree.Root.Nodes {
+		if n, ok := node.(*DockerNode); ok && n.Type() == NodePostBuild {
+			postBuilds++
+		}
+	}
+	if postBuilds != 2 {
+		t.Errorf("Expect the steps without depends_on to run one by one, got %d", postBuilds)
+	}
+
+	invalid := []string{`
+pre_build:
+  a:
+    image: busybox
+    depends_on: [b]
+  b:
+    image: busybox
+    depends_on: [a]
+`, `
+pre_build:
+  a:
+    image: busybox
+    depends_on: [missing]
+`}
+	for _, config := range invalid {
+		if _, err := ParseString(config); err == nil {
+			t.Errorf("Expect error for the invalid dependencies %s", config)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/caicloud/cyclone/worker/ci/parser"
+	"github.com/caicloud/cyclone/worker/ci/yaml"
+	steplog "github.com/caicloud/cyclone/worker/log"
+)
+
+// stepFunc runs a step of the DAG, failed is whether a step of the DAG has
+// failed before, and the step should stop once abort is closed.
+type stepFunc func(step *parser.DockerNode, failed bool, abort <-chan struct{}) error
+
+// stepResult is the result of a step of the DAG.
+type stepResult struct {
+	step *parser.DockerNode
+	err  error
+}
+
+// dockerfileLock serializes the pre_build steps built by Dockerfile, as they
+// share the name of the output image.
+var dockerfileLock sync.Mutex
+
+// runDAG runs the steps of the DAG node, each step runs after the steps it
+// depends on, and the independent steps run concurrently.
+func (b *Build) runDAG(dag *parser.DAGNode) error {
+	stepEvent := steplog.PreBuild
+	if dag.Type() == parser.NodePostBuild {
+		stepEvent = steplog.PostBuild
+	}
+	output := &syncOutput{w: steplog.Output}
+	output.insertStepLog(b, stepEvent, steplog.Start, nil)
+
+	err := schedule(dag.Steps, b.parallelism(), func(step *parser.DockerNode, failed bool, abort <-chan struct{}) error {
+		stepEvent := stepEventOf(step)
+		if !b.shouldRunAfter(step, failed) {
+			output.insertStepLog(b, stepEvent, steplog.Skip, nil)
+			return nil
+		}
+		output.insertStepLog(b, stepEvent, steplog.Start, nil)
+
+		writer := &prefixWriter{out: output, prefix: []byte("[" + step.Name + "] ")}
+		err := b.runDAGStep(step, writer, abort)
+		writer.Flush()
+		if err != nil {
+			output.insertStepLog(b, stepEvent, steplog.Stop, err)
+			return err
+		}
+		output.insertStepLog(b, stepEvent, steplog.Finish, nil)
+		return nil
+	})
+	if err != nil {
+		output.insertStepLog(b, stepEvent, steplog.Stop, err)
+		return err
+	}
+	output.insertStepLog(b, stepEvent, steplog.Finish, nil)
+	return nil
+}
+
+// runDAGStep runs a step of the DAG node, the output of the step is written
+// to the output.
+func (b *Build) runDAGStep(step *parser.DockerNode, output io.Writer, abort <-chan struct{}) error {
+	if isLackOfCriticalConfig(step) {
+		return nil
+	}
+	timeout, err := b.stepTimeout(step)
+	if err != nil {
+		return err
+	}
+	outPutPath := b.event.Data["context-dir"].(string) + "/"
+
+	if step.Type() == parser.NodePreBuild && ("" != step.DockerfilePath || "" != step.DockerfileName) {
+		dockerfileLock.Lock()
+		defer dockerfileLock.Unlock()
+		return preBuildByDockerfile(output, b.dockerManager, b.event, step.DockerfilePath,
+			step.DockerfileName, step.Outputs, outPutPath, timeout)
+	}
+
+	createContainerOptions := toBuildContainerConfig(step, b, step.Type())
+	// Encode the commands to one line script.
+	Encode(createContainerOptions, step)
+
+	// Run the docker container.
+	container, err := run(b, createContainerOptions, step.Outputs, outPutPath, step.Type(), output, timeout, abort)
+	if err != nil {
+		return err
+	}
+	// Check the exitcode from container
+	if container.State.ExitCode != 0 {
+		return fmt.Errorf("container meets error")
+	}
+	return nil
+}
+
+// parallelism gets the max number of steps to run concurrently, one for each
+// core allocated to the worker, or each core of the node if not limited.
+func (b *Build) parallelism() int {
+	// CPU of the worker is in docker cpu shares, 1024 shares for a core.
+	cpu := b.event.WorkerInfo.UsedResource.CPU
+	if cpu <= 0 {
+		return runtime.NumCPU()
+	}
+	return int(math.Ceil(cpu / 1024))
+}
+
+// schedule runs each step after the steps it depends on finish, at most
+// parallelism steps at a time. It fails fast, once a step fails the running
+// steps are aborted, and only the steps with the when status on_failure or
+// always are started after, the others are skipped.
+func schedule(steps []*parser.DockerNode, parallelism int, runStep stepFunc) error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	waiting := make(map[string]int, len(steps))
+	dependents := make(map[string][]*parser.DockerNode)
+	var ready []*parser.DockerNode
+	for _, step := range steps {
+		waiting[step.Name] = len(step.DependsOn)
+		for _, dep := range step.DependsOn {
+			dependents[dep] = append(dependents[dep], step)
+		}
+		if len(step.DependsOn) == 0 {
+			ready = append(ready, step)
+		}
+	}
+
+	// done makes the dependents of the finished or skipped step ready once all
+	// the steps they depend on are done.
+	done := func(step *parser.DockerNode) {
+		for _, next := range dependents[step.Name] {
+			waiting[next.Name]--
+			if waiting[next.Name] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+
+	// The steps started after the failure are not aborted, abort is nil for them.
+	abort := make(chan struct{})
+	results := make(chan stepResult, len(steps))
+	running := 0
+	var failure error
+	for {
+		for running < parallelism && len(ready) > 0 {
+			step := ready[0]
+			ready = ready[1:]
+			if failure != nil && !runsOnFailure(step) {
+				done(step)
+				continue
+			}
+			running++
+			go func(step *parser.DockerNode, failed bool, abort <-chan struct{}) {
+				results <- stepResult{step: step, err: runStep(step, failed, abort)}
+			}(step, failure != nil, abort)
+		}
+		if running == 0 {
+			break
+		}
+
+		result := <-results
+		running--
+		if result.err != nil && failure == nil {
+			failure = result.err
+			if !IsTimeout(result.err) {
+				failure = fmt.Errorf("step %s failed: %v", result.step.Name, result.err)
+			}
+			close(abort)
+			abort = nil
+		}
+		done(result.step)
+	}
+	return failure
+}
+
+// runsOnFailure gets whether the step may run after a step has failed.
+func runsOnFailure(step *parser.DockerNode) bool {
+	return step.When.Status == yaml.StatusOnFailure || step.When.Status == yaml.StatusAlways
+}
+
+// syncOutput serializes the writes of the steps running concurrently.
+type syncOutput struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// write writes the line with the prefix.
+func (o *syncOutput) write(prefix, line []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.w.Write(append(append([]byte{}, prefix...), line...))
+}
+
+// insertStepLog inserts the step information into the log.
+func (o *syncOutput) insertStepLog(b *Build, stepEvent steplog.StepEvent, state steplog.StepState, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	steplog.InsertStepLog(b.event, stepEvent, state, err)
+}
+
+// prefixWriter writes the output of a step line by line, each line is
+// prefixed by the name of the step.
+type prefixWriter struct {
+	out    *syncOutput
+	prefix []byte
+	buf    []byte
+}
+
+// Write implements io.Writer.
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		p.out.write(p.prefix, p.buf[:i+1])
+		p.buf = p.buf[i+1:]
+	}
+	return len(data), nil
+}
+
+// Flush writes the last line without line break.
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.out.write(p.prefix, append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
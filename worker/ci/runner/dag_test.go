@@ -0,0 +1,165 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caicloud/cyclone/worker/ci/parser"
+	"github.com/caicloud/cyclone/worker/ci/yaml"
+)
+
+// TestSchedule tests the steps run after the steps they depend on, and at most
+// parallelism steps run at a time.
+func TestSchedule(t *testing.T) {
+	steps := []*parser.DockerNode{
+		{Name: "compile"},
+		{Name: "lint"},
+		{Name: "test", DependsOn: []string{"compile"}},
+		{Name: "package", DependsOn: []string{"test", "lint"}},
+	}
+
+	var mu sync.Mutex
+	finished := map[string]bool{}
+	running, maxRunning := 0, 0
+	err := schedule(steps, 2, func(step *parser.DockerNode, failed bool, abort <-chan struct{}) error {
+		mu.Lock()
+		for _, dep := range step.DependsOn {
+			if !finished[dep] {
+				t.Errorf("Expect %s to finish before %s", dep, step.Name)
+			}
+		}
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		finished[step.Name] = true
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expect error to be nil, got %v", err)
+	}
+	if len(finished) != len(steps) {
+		t.Errorf("Expect all steps to finish, got %v", finished)
+	}
+	if maxRunning != 2 {
+		t.Errorf("Expect 2 steps running concurrently, got %d", maxRunning)
+	}
+}
+
+// TestScheduleFailFast tests the running steps are aborted and no more steps
+// are started after a step fails.
+func TestScheduleFailFast(t *testing.T) {
+	steps := []*parser.DockerNode{
+		{Name: "slow"},
+		{Name: "broken"},
+		{Name: "after", DependsOn: []string{"slow"}},
+	}
+
+	var mu sync.Mutex
+	started := map[string]bool{}
+	aborted := false
+	err := schedule(steps, 2, func(step *parser.DockerNode, failed bool, abort <-chan struct{}) error {
+		mu.Lock()
+		started[step.Name] = true
+		mu.Unlock()
+
+		switch step.Name {
+		case "broken":
+			return errors.New("exit code 1")
+		case "slow":
+			select {
+			case <-abort:
+				mu.Lock()
+				aborted = true
+				mu.Unlock()
+				return ErrStepAborted
+			case <-time.After(5 * time.Second):
+			}
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "step broken failed: exit code 1" {
+		t.Errorf("Expect the error of the broken step, got %v", err)
+	}
+	if !aborted {
+		t.Errorf("Expect the slow step to be aborted")
+	}
+	if started["after"] {
+		t.Errorf("Expect the step after the failure not to start")
+	}
+}
+
+// TestScheduleOnFailure tests the steps with the when status on_failure or
+// always still run after a step fails, and the other steps are skipped.
+func TestScheduleOnFailure(t *testing.T) {
+	steps := []*parser.DockerNode{
+		{Name: "compile"},
+		{Name: "test", DependsOn: []string{"compile"}},
+		{Name: "notify", DependsOn: []string{"test"}, When: yaml.When{Status: yaml.StatusOnFailure}},
+		{Name: "cleanup", DependsOn: []string{"compile"}, When: yaml.When{Status: yaml.StatusAlways}},
+	}
+
+	var mu sync.Mutex
+	started := map[string]bool{}
+	err := schedule(steps, 2, func(step *parser.DockerNode, failed bool, abort <-chan struct{}) error {
+		mu.Lock()
+		started[step.Name] = failed
+		mu.Unlock()
+
+		if step.Name == "compile" {
+			return errors.New("exit code 1")
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "step compile failed: exit code 1" {
+		t.Errorf("Expect the error of the compile step, got %v", err)
+	}
+	if _, ok := started["test"]; ok {
+		t.Errorf("Expect the step on success not to start after the failure")
+	}
+	for _, name := range []string{"notify", "cleanup"} {
+		if failed, ok := started[name]; !ok || !failed {
+			t.Errorf("Expect the step %s to start after the failure, got %v, %v", name, ok, failed)
+		}
+	}
+}
+
+// TestPrefixWriter tests each line of the output is prefixed.
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	writer := &prefixWriter{out: &syncOutput{w: &buf}, prefix: []byte("[lint] ")}
+	writer.Write([]byte("ok\nfa"))
+	writer.Write([]byte("iled\nlast"))
+	writer.Flush()
+
+	expected := "[lint] ok\n[lint] failed\n[lint] last\n"
+	if buf.String() != expected {
+		t.Errorf("Expect output %q, got %q", expected, buf.String())
+	}
+}
@@ -24,15 +24,17 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/caicloud/cyclone/api"
 
 	"github.com/caicloud/cyclone/docker"
-	"github.com/caicloud/cyclone/pkg/filebuffer"
 	"github.com/caicloud/cyclone/pkg/log"
 	"github.com/caicloud/cyclone/worker/ci/parser"
 	docker_client "github.com/fsouza/go-dockerclient"
+	"golang.org/x/net/context"
 )
 
 const (
@@ -48,6 +50,26 @@ func getNameInNetwork(dn *parser.DockerNode, b *Build) string {
 	return fmt.Sprintf("%s", dn.Name)
 }
 
+// containerEnv gets the environment variables of the container, the build
+// variables of the version go first so that the step can override them.
+func containerEnv(dn *parser.DockerNode, b *Build) []string {
+	if b.event == nil || len(b.event.Version.BuildVariables) == 0 {
+		return dn.Environment
+	}
+	variables := b.event.Version.BuildVariables
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	env := make([]string, 0, len(names)+len(dn.Environment))
+	for _, name := range names {
+		env = append(env, name+"="+variables[name])
+	}
+	return append(env, dn.Environment...)
+}
+
 // toServiceContainerConfig creates CreateContainerOptions from ServiceNode.
 func toServiceContainerConfig(dn *parser.DockerNode, b *Build) *docker_client.CreateContainerOptions {
 
@@ -70,7 +92,7 @@ func toServiceContainerConfig(dn *parser.DockerNode, b *Build) *docker_client.Cr
 
 	config := &docker_client.Config{
 		Image:      dn.Image,
-		Env:        dn.Environment,
+		Env:        containerEnv(dn, b),
 		Cmd:        dn.Command,
 		Entrypoint: dn.Entrypoint,
 	}
@@ -127,7 +149,7 @@ func toServiceContainerConfig(dn *parser.DockerNode, b *Build) *docker_client.Cr
 func toBuildContainerConfig(dn *parser.DockerNode, b *Build, nodetype parser.NodeType) *docker_client.CreateContainerOptions {
 	config := &docker_client.Config{
 		Image:      dn.Image,
-		Env:        dn.Environment,
+		Env:        containerEnv(dn, b),
 		Cmd:        dn.Command,
 		Entrypoint: dn.Entrypoint,
 	}
@@ -244,9 +266,10 @@ func start(b *Build, cco *docker_client.CreateContainerOptions) (*docker_client.
 
 // run a container with the given CreateContainerOptions, currently it
 // involves: start the container, wait it to stop and record the log
-// into output.
-func run(b *Build, cco *docker_client.CreateContainerOptions,
-	outPutFiles []string, outPutPath string, nodetype parser.NodeType, output filebuffer.FileBuffer) (*docker_client.Container, error) {
+// into output. The container is killed if it runs longer than the timeout,
+// 0 means no limit, or if abort is closed.
+func run(b *Build, cco *docker_client.CreateContainerOptions, outPutFiles []string, outPutPath string,
+	nodetype parser.NodeType, output io.Writer, timeout time.Duration, abort <-chan struct{}) (*docker_client.Container, error) {
 	// Fetches the container information.
 	client := b.dockerManager.Client
 	container, err := start(b, cco)
@@ -322,6 +345,13 @@ func run(b *Build, cco *docker_client.CreateContainerOptions,
 		errc <- errors.New("Maximum number of attempts made while tailing logs.")
 	}()
 
+	var timeoutc <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutc = timer.C
+	}
+
 	select {
 	case info := <-containerc:
 		err = CopyOutPutFiles(b.dockerManager, container.ID, outPutFiles, outPutPath)
@@ -332,6 +362,12 @@ func run(b *Build, cco *docker_client.CreateContainerOptions,
 	case err := <-errc:
 		log.InfoWithFields("Run the container failed.", log.Fields{"config": cco})
 		return container, err
+	case <-timeoutc:
+		log.InfoWithFields("Run the container time out.", log.Fields{"config": cco, "timeout": timeout})
+		return container, ErrStepTimeout
+	case <-abort:
+		log.InfoWithFields("Run the container aborted.", log.Fields{"config": cco})
+		return container, ErrStepAborted
 	}
 }
 
@@ -400,9 +436,10 @@ func CopyFromContainer(client *docker_client.Client,
 	return nil
 }
 
-// PreBuildByDockerfile prebuilds bin by Dockerfile.
-func preBuildByDockerfile(output filebuffer.FileBuffer, dockerManager *docker.Manager, event *api.Event,
-	dockerfilePath string, dockerfileName string, outPutFiles []string, outPutPath string) error {
+// PreBuildByDockerfile prebuilds bin by Dockerfile, the build is cancelled if
+// it runs longer than the timeout, 0 means no limit.
+func preBuildByDockerfile(output io.Writer, dockerManager *docker.Manager, event *api.Event,
+	dockerfilePath string, dockerfileName string, outPutFiles []string, outPutPath string, timeout time.Duration) error {
 	contextdir, ok := event.Data["context-dir"]
 	if !ok {
 		return fmt.Errorf("Unable to retrieve name and context directory from Event %#+v: %t",
@@ -427,13 +464,20 @@ func preBuildByDockerfile(output filebuffer.FileBuffer, dockerManager *docker.Ma
 		OutputStream:   output,
 		RmTmpContainer: true,
 		AuthConfigs:    dockerManager.GetAuthOpts(),
+		BuildArgs:      docker.BuildArgs(event.Version.BuildVariables, contextDir+"/"+dockerfileName),
+	}
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		opt.Context = ctx
 	}
 
 	client := dockerManager.Client
+	start := time.Now()
 	err := client.BuildImage(opt)
 	if err != nil {
 		log.Errorf("prebuild build images err: %v", err)
-		return err
+		return timeoutError(err, start, timeout)
 	}
 
 	cco := &docker_client.CreateContainerOptions{
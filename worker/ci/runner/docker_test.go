@@ -17,8 +17,10 @@ limitations under the License.
 package runner
 
 import (
+	"reflect"
 	"testing"
 
+	"github.com/caicloud/cyclone/api"
 	"github.com/caicloud/cyclone/docker"
 	"github.com/caicloud/cyclone/worker/ci/parser"
 )
@@ -115,3 +117,32 @@ func TestToServiceContainerConfig(t *testing.T) {
 		t.Errorf("Expected the length of config's env is 1 but got %d", len(option.Config.Env))
 	}
 }
+
+// TestContainerEnv tests the build variables go before the step environment.
+func TestContainerEnv(t *testing.T) {
+	dn := &parser.DockerNode{
+		NodeType:    parser.NodeIntegration,
+		Environment: []string{"BASE_IMAGE=override"},
+	}
+	b := &Build{
+		event: &api.Event{Version: api.Version{BuildVariables: map[string]string{
+			"UPSTREAM_IMAGE": "cargo.caicloud.io/alice/base:v1",
+			"BASE_IMAGE":     "cargo.caicloud.io/alice/base:v1",
+		}}},
+	}
+
+	env := containerEnv(dn, b)
+	expected := []string{
+		"BASE_IMAGE=cargo.caicloud.io/alice/base:v1",
+		"UPSTREAM_IMAGE=cargo.caicloud.io/alice/base:v1",
+		"BASE_IMAGE=override",
+	}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("Expected env %v, but got %v", expected, env)
+	}
+
+	b.event.Version.BuildVariables = nil
+	if env := containerEnv(dn, b); !reflect.DeepEqual(env, dn.Environment) {
+		t.Errorf("Expected env %v, but got %v", dn.Environment, env)
+	}
+}
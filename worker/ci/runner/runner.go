@@ -18,7 +18,9 @@ limitations under the License.
 package runner
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/caicloud/cyclone/api"
 	"github.com/caicloud/cyclone/docker"
@@ -40,20 +42,38 @@ type Build struct {
 	flags               parser.NodeType
 	ciServiceContainers []string
 	status              BuildStatus
+	// deadline is the time before which all steps must finish, zero means no limit.
+	deadline time.Time
+	// failure is the first error of the steps, the steps after it run only if
+	// their when status is on_failure or always.
+	failure error
+	// visited is the nodes which have run or been skipped.
+	visited map[parser.Node]bool
 }
 
 const (
 	pushImageSuccess BuildStatus = 1 << iota
 )
 
+var (
+	// ErrStepTimeout is the error when a step runs out of time.
+	ErrStepTimeout = errors.New("step time out")
+	// ErrStepAborted is the error when a step is stopped as a sibling step fails.
+	ErrStepAborted = errors.New("step aborted")
+)
+
 // Load loads the tree to the build job.
 func Load(contextDir string, event *api.Event, dockerManager *docker.Manager, tree *parser.Tree) *Build {
-	return &Build{
+	b := &Build{
 		contextDir:    contextDir,
 		event:         event,
 		dockerManager: dockerManager,
 		tree:          tree,
 	}
+	if tree.Timeout > 0 {
+		b.deadline = time.Now().Add(time.Duration(tree.Timeout) * time.Second)
+	}
+	return b
 }
 
 // Setup environments.
@@ -72,25 +92,62 @@ func (b *Build) RunNode(flags parser.NodeType) error {
 	return b.walk(b.tree.Root)
 }
 
+// RunOnFailure runs the steps left in the sections of the flags whose when
+// status is on_failure or always, after the build fails with the error.
+func (b *Build) RunOnFailure(flags parser.NodeType, err error) error {
+	if b.failure == nil {
+		b.failure = err
+	}
+	return b.RunNode(flags)
+}
+
 // walk through the tree, recursively.
 func (b *Build) walk(node parser.Node) (err error) {
 	outPutPath := b.event.Data["context-dir"].(string) + "/"
 	switch node := node.(type) {
 	case *parser.ListNode:
+		// Keep walking after a step fails, so that the steps running on
+		// failure run, the other steps are skipped.
+		var failure error
 		for _, node := range node.Nodes {
-			err = b.walk(node)
-			if err != nil {
-				return err
+			if err := b.walk(node); err != nil {
+				if failure == nil {
+					failure = err
+				}
+				if b.failure == nil {
+					b.failure = err
+				}
 			}
 		}
+		return failure
+
+	case *parser.DAGNode:
+		if shouldSkip(b.flags, node.NodeType) || !b.visit(node) {
+			break
+		}
+		return b.runDAG(node)
+
+	case *parser.MatrixNode:
+		if shouldSkip(b.flags, parser.NodeIntegration) || !b.visit(node) {
+			break
+		}
+		return b.runMatrix(node)
 
 	case *parser.DockerNode:
 		if shouldSkip(b.flags, node.NodeType) {
 			break
 		}
-		if isLackOfCriticalConfig(node) {
+		if isLackOfCriticalConfig(node) || !b.visit(node) {
 			break
 		}
+		if !b.shouldRun(node) {
+			steplog.InsertStepLog(b.event, stepEventOf(node), steplog.Skip, nil)
+			break
+		}
+		timeout, err := b.stepTimeout(node)
+		if err != nil {
+			return err
+		}
 
 		switch node.Type() {
 		case parser.NodeService:
@@ -113,7 +170,7 @@ func (b *Build) walk(node parser.Node) (err error) {
 			Encode(createContainerOptions, node)
 
 			// Run the docker container.
-			container, err := run(b, createContainerOptions, node.Outputs, outPutPath, node.Type(), steplog.Output)
+			container, err := run(b, createContainerOptions, node.Outputs, outPutPath, node.Type(), steplog.Output, timeout, nil)
 			if err != nil {
 				return err
 			}
@@ -126,9 +183,10 @@ func (b *Build) walk(node parser.Node) (err error) {
 		case parser.NodeBuild:
 			log.Info("Build with Dockerfile path: ", node.DockerfilePath,
 				" ", node.DockerfileName)
+			start := time.Now()
 			if err := b.dockerManager.BuildImageSpecifyDockerfile(b.event,
-				node.DockerfilePath, node.DockerfileName, steplog.Output); err != nil {
-				return err
+				node.DockerfilePath, node.DockerfileName, steplog.Output, timeout); err != nil {
+				return timeoutError(err, start, timeout)
 			}
 
 		case parser.NodePreBuild:
@@ -139,7 +197,7 @@ func (b *Build) walk(node parser.Node) (err error) {
 					" ", node.DockerfileName)
 				errDockerfile := preBuildByDockerfile(steplog.Output, b.dockerManager,
 					b.event, node.DockerfilePath, node.DockerfileName, node.Outputs,
-					outPutPath)
+					outPutPath, timeout)
 				if nil != errDockerfile {
 					steplog.InsertStepLog(b.event, steplog.PreBuild, steplog.Stop, errDockerfile)
 					return errDockerfile
@@ -150,7 +208,7 @@ func (b *Build) walk(node parser.Node) (err error) {
 				Encode(createContainerOptions, node)
 
 				// Run the docker container.
-				container, err := run(b, createContainerOptions, node.Outputs, outPutPath, node.Type(), steplog.Output)
+				container, err := run(b, createContainerOptions, node.Outputs, outPutPath, node.Type(), steplog.Output, timeout, nil)
 				if err != nil {
 					steplog.InsertStepLog(b.event, steplog.PreBuild, steplog.Stop, err)
 					return err
@@ -173,7 +231,7 @@ func (b *Build) walk(node parser.Node) (err error) {
 			Encode(createContainerOptions, node)
 
 			// Run the docker container.
-			container, err := run(b, createContainerOptions, node.Outputs, outPutPath, node.Type(), steplog.Output)
+			container, err := run(b, createContainerOptions, node.Outputs, outPutPath, node.Type(), steplog.Output, timeout, nil)
 			if err != nil {
 				steplog.InsertStepLog(b.event, steplog.PostBuild, steplog.Stop, err)
 				return err
@@ -190,6 +248,76 @@ func (b *Build) walk(node parser.Node) (err error) {
 	return nil
 }
 
+// runMatrix runs the integration of each cell in the matrix. A failed cell does
+// not stop the others, and the matrix fails if any cell fails.
+func (b *Build) runMatrix(matrix *parser.MatrixNode) error {
+	flags := b.flags
+	defer func() {
+		b.flags = flags
+	}()
+
+	var timeoutErr error
+	failed := 0
+	statuses := make([]api.MatrixCellStatus, 0, len(matrix.Cells))
+	for _, cell := range matrix.Cells {
+		stepEvent := steplog.StepEvent(fmt.Sprintf("%s [%s]", steplog.Integration, cell.Name))
+		if integration := cell.Root.Nodes[len(cell.Root.Nodes)-1].(*parser.DockerNode); !b.shouldRun(integration) {
+			steplog.InsertStepLog(b.event, stepEvent, steplog.Skip, nil)
+			continue
+		}
+		steplog.InsertStepLog(b.event, stepEvent, steplog.Start, nil)
+
+		status := api.MatrixCellStatus{
+			Name:        cell.Name,
+			Environment: cell.Environment,
+			Status:      api.VersionHealthy,
+		}
+		// A failed cell does not skip the steps of the other cells.
+		failure := b.failure
+		err := b.runCell(cell)
+		b.failure = failure
+		if err != nil {
+			failed++
+			if IsTimeout(err) {
+				timeoutErr = err
+			}
+			status.Status = api.VersionFailed
+			status.ErrorMessage = err.Error()
+			steplog.InsertStepLog(b.event, stepEvent, steplog.Stop, err)
+		} else {
+			steplog.InsertStepLog(b.event, stepEvent, steplog.Finish, nil)
+		}
+		statuses = append(statuses, status)
+	}
+	b.event.Version.MatrixStatuses = statuses
+
+	if timeoutErr != nil {
+		return timeoutErr
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d matrix cells failed", failed, len(matrix.Cells))
+	}
+	return nil
+}
+
+// runCell starts the services of the cell and runs its integration, the
+// services are removed after the integration.
+func (b *Build) runCell(cell *parser.CellNode) error {
+	defer func() {
+		for _, ID := range b.ciServiceContainers {
+			b.dockerManager.StopAndRemoveContainer(ID)
+		}
+		b.ciServiceContainers = nil
+	}()
+
+	b.flags = parser.NodeService
+	if err := b.walk(cell.Root); err != nil {
+		return err
+	}
+	b.flags = parser.NodeIntegration
+	return b.walk(cell.Root)
+}
+
 // PublishImage publish image to registry.
 func (b *Build) PublishImage() (err error) {
 	steplog.InsertStepLog(b.event, steplog.PushImage, steplog.Start, nil)
@@ -214,6 +342,38 @@ func (b *Build) IsPushImageSuccess() bool {
 	return (b.status & pushImageSuccess) != 0
 }
 
+// stepTimeout gets the max time to run the step, the smaller one of the step's
+// timeout and the time left before the deadline, 0 means no limit.
+func (b *Build) stepTimeout(node *parser.DockerNode) (time.Duration, error) {
+	timeout := time.Duration(node.Timeout) * time.Second
+	if b.deadline.IsZero() {
+		return timeout, nil
+	}
+
+	left := b.deadline.Sub(time.Now())
+	if left <= 0 {
+		return 0, ErrStepTimeout
+	}
+	if timeout <= 0 || left < timeout {
+		timeout = left
+	}
+	return timeout, nil
+}
+
+// timeoutError returns ErrStepTimeout if the step failed after running out of
+// time, otherwise the error itself.
+func timeoutError(err error, start time.Time, timeout time.Duration) error {
+	if err != nil && timeout > 0 && time.Since(start) >= timeout {
+		return ErrStepTimeout
+	}
+	return err
+}
+
+// IsTimeout returns whether the error is caused by step timeout.
+func IsTimeout(err error) bool {
+	return err == ErrStepTimeout
+}
+
 // shouldSkip is a helper function that returns true if
 // node execution should be skipped. This happens when
 // the build is executed for a subset of build steps.
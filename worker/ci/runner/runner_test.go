@@ -0,0 +1,64 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/caicloud/cyclone/worker/ci/parser"
+)
+
+// TestStepTimeout tests the timeout of steps is limited by the build deadline.
+func TestStepTimeout(t *testing.T) {
+	node := &parser.DockerNode{Timeout: 60}
+
+	b := &Build{}
+	if timeout, err := b.stepTimeout(node); err != nil || timeout != time.Minute {
+		t.Errorf("Expect the step timeout 1m, got %v, %v", timeout, err)
+	}
+
+	b.deadline = time.Now().Add(time.Hour)
+	if timeout, err := b.stepTimeout(node); err != nil || timeout != time.Minute {
+		t.Errorf("Expect the step timeout 1m, got %v, %v", timeout, err)
+	}
+
+	node.Timeout = 0
+	if timeout, err := b.stepTimeout(node); err != nil || timeout <= 59*time.Minute || timeout > time.Hour {
+		t.Errorf("Expect the time left before the deadline, got %v, %v", timeout, err)
+	}
+
+	b.deadline = time.Now().Add(-time.Second)
+	if _, err := b.stepTimeout(node); err != ErrStepTimeout {
+		t.Errorf("Expect ErrStepTimeout after the deadline, got %v", err)
+	}
+}
+
+// TestTimeoutError tests the errors of steps running out of time.
+func TestTimeoutError(t *testing.T) {
+	err := errors.New("build failed")
+	if got := timeoutError(err, time.Now(), time.Minute); got != err {
+		t.Errorf("Expect the error kept, got %v", got)
+	}
+	if got := timeoutError(err, time.Now().Add(-time.Minute), time.Minute); !IsTimeout(got) {
+		t.Errorf("Expect ErrStepTimeout, got %v", got)
+	}
+	if got := timeoutError(err, time.Now().Add(-time.Minute), 0); got != err {
+		t.Errorf("Expect the error kept without timeout, got %v", got)
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/worker/ci/parser"
+	"github.com/caicloud/cyclone/worker/ci/yaml"
+	steplog "github.com/caicloud/cyclone/worker/log"
+)
+
+// shouldRun gets whether the conditions to run the step are met.
+func (b *Build) shouldRun(node *parser.DockerNode) bool {
+	return b.shouldRunAfter(node, false)
+}
+
+// shouldRunAfter gets whether the conditions to run the step are met, failed
+// is whether a step of the same DAG has failed.
+func (b *Build) shouldRunAfter(node *parser.DockerNode, failed bool) bool {
+	var version *api.Version
+	if b.event != nil {
+		version = &b.event.Version
+	}
+	return matchWhen(node.When, version, failed || b.failure != nil)
+}
+
+// visit marks the node as visited, it returns false if the node has been
+// visited, so that the nodes are not run again on failure.
+func (b *Build) visit(node parser.Node) bool {
+	if b.visited == nil {
+		b.visited = make(map[parser.Node]bool)
+	}
+	if b.visited[node] {
+		return false
+	}
+	b.visited[node] = true
+	return true
+}
+
+// stepEventOf gets the step event of the node in the step log.
+func stepEventOf(node *parser.DockerNode) steplog.StepEvent {
+	var stepEvent steplog.StepEvent
+	switch node.Type() {
+	case parser.NodePreBuild:
+		stepEvent = steplog.PreBuild
+	case parser.NodeBuild:
+		stepEvent = steplog.BuildImage
+	case parser.NodeService:
+		stepEvent = steplog.Service
+	case parser.NodeIntegration:
+		stepEvent = steplog.Integration
+	case parser.NodePostBuild:
+		stepEvent = steplog.PostBuild
+	}
+	if node.Name != "" {
+		return steplog.StepEvent(fmt.Sprintf("%s [%s]", stepEvent, node.Name))
+	}
+	return stepEvent
+}
+
+// matchWhen gets whether the conditions are met by the version, failed is
+// whether a previous step has failed.
+func matchWhen(when yaml.When, version *api.Version, failed bool) bool {
+	switch when.Status {
+	case yaml.StatusAlways:
+	case yaml.StatusOnFailure:
+		if !failed {
+			return false
+		}
+	default:
+		if failed {
+			return false
+		}
+	}
+
+	if version == nil {
+		version = &api.Version{}
+	}
+	if patterns := when.Branch.Slice(); len(patterns) > 0 && !matchAny(patterns, version.Branch) {
+		return false
+	}
+	if events := when.Event.Slice(); len(events) > 0 && !matchAny(events, string(version.Trigger)) {
+		return false
+	}
+	// The changed files are only known for pushes, the paths are not checked
+	// if unknown.
+	if patterns := when.Paths.Slice(); len(patterns) > 0 && len(version.ChangedFiles) > 0 {
+		matched := false
+		for _, file := range version.ChangedFiles {
+			if matchAny(patterns, file) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny gets whether the name matches any of the glob patterns, the
+// pattern dir/** matches all the names in the directory.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "/**") {
+			if strings.HasPrefix(name, strings.TrimSuffix(pattern, "**")) {
+				return true
+			}
+			continue
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"testing"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/worker/ci/yaml"
+)
+
+// TestMatchWhen tests the conditions on branch, event, changed files and status.
+func TestMatchWhen(t *testing.T) {
+	config, err := yaml.ParseString(`
+pre_build:
+  image: busybox
+  when:
+    branch: [master, release/*]
+    event: [push, tag]
+    paths: [docs/**, "*.md"]
+post_build:
+  image: busybox
+  when:
+    status: on_failure
+integration:
+  image: busybox
+  when:
+    status: always
+`)
+	if err != nil {
+		t.Fatalf("Expect error to be nil, got %v", err)
+	}
+	when := config.PreBuild.Slice()[0].When
+
+	cases := []struct {
+		version api.Version
+		match   bool
+	}{
+		{api.Version{Branch: "master", Trigger: api.TriggerPush, ChangedFiles: []string{"docs/setup.md"}}, true},
+		{api.Version{Branch: "release/v1", Trigger: api.TriggerTag, ChangedFiles: []string{"README.md"}}, true},
+		{api.Version{Branch: "master", Trigger: api.TriggerPush}, true},
+		{api.Version{Branch: "feature/x", Trigger: api.TriggerPush}, false},
+		{api.Version{Branch: "master", Trigger: api.TriggerPullRequest}, false},
+		{api.Version{Branch: "master", Trigger: api.TriggerPush, ChangedFiles: []string{"api/types.go"}}, false},
+	}
+	for _, c := range cases {
+		version := c.version
+		if match := matchWhen(when, &version, false); match != c.match {
+			t.Errorf("Expect match %v for version %+v, got %v", c.match, c.version, match)
+		}
+	}
+	if matchWhen(when, &api.Version{Branch: "master", Trigger: api.TriggerPush}, true) {
+		t.Errorf("Expect the step on success not to run after failure")
+	}
+
+	onFailure := config.PostBuild.Slice()[0].When
+	if matchWhen(onFailure, nil, false) || !matchWhen(onFailure, nil, true) {
+		t.Errorf("Expect the step on failure to run only after failure")
+	}
+	always := config.Integration.Build().When
+	if !matchWhen(always, nil, false) || !matchWhen(always, nil, true) {
+		t.Errorf("Expect the step always to run")
+	}
+}
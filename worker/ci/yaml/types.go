@@ -19,6 +19,8 @@ package yaml
 
 import (
 	"fmt"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/flynn/go-shlex"
@@ -33,6 +35,21 @@ type Config struct {
 	Build       BuildStep       `yaml:"build"`
 	PostBuild   BuildStep       `yaml:"post_build"`
 	Deploy      DeployStep      `yaml:",inline"`
+	// Timeout is the max time in seconds to run all the steps.
+	Timeout int `yaml:"timeout"`
+	// Cache is the directories kept between the builds of the service.
+	Cache Cache `yaml:"cache"`
+	// Matrix expands the integration into one run for each cell.
+	Matrix Matrix `yaml:"matrix"`
+}
+
+// Cache is a typed representation of the cache
+// section in the Yaml configuration file.
+type Cache struct {
+	// Paths are the directories relative to the repository to cache.
+	Paths []string `yaml:"paths"`
+	// Key separates the caches of the service, the branch is used if empty.
+	Key string `yaml:"key"`
 }
 
 // Container is a typed representation of a
@@ -55,6 +72,33 @@ type Container struct {
 	Memory         int64         `yaml:"mem_limit"`
 	CPUSetCPUs     string        `yaml:"cpuset"`
 	OomKillDisable bool          `yaml:"oom_kill_disable"`
+	// Timeout is the max time in seconds to run the step.
+	Timeout int `yaml:"timeout"`
+	// When is the conditions to run the step.
+	When When `yaml:"when"`
+}
+
+const (
+	// StatusOnSuccess runs the step if no step has failed, it is the default.
+	StatusOnSuccess = "on_success"
+	// StatusOnFailure runs the step only if a previous step has failed.
+	StatusOnFailure = "on_failure"
+	// StatusAlways runs the step whether the previous steps fail or not.
+	StatusAlways = "always"
+)
+
+// When is a typed representation of the conditions to run a step, the step
+// runs only if all the conditions are met.
+type When struct {
+	// Branch is the glob patterns of the branch.
+	Branch Stringorslice `yaml:"branch"`
+	// Event is the kinds of the change, push, pull_request or tag.
+	Event Stringorslice `yaml:"event"`
+	// Paths is the glob patterns of the changed files, dir/** matches all
+	// the files in the directory.
+	Paths Stringorslice `yaml:"paths"`
+	// Status is on_success, on_failure or always.
+	Status string `yaml:"status"`
 }
 
 // Containerslice is a slice of Containers with a custom
@@ -73,6 +117,8 @@ type PreBuild struct {
 
 	Commands []string `yaml:"commands"`
 	Outputs  []string `yaml:"outputs"`
+	// DependsOn is the names of the steps to finish before the step.
+	DependsOn []string `yaml:"depends_on"`
 }
 
 // PreBuildStep holds the pre_build step configuration using a custom
@@ -81,6 +127,13 @@ type PreBuildStep struct {
 	parts []PreBuild
 }
 
+// Matrix holds the cells of the build matrix, each cell is a list of
+// environment variables in KEY=value format. The matrix is either the axes
+// whose values are combined, or the cells listed in include.
+type Matrix struct {
+	cells [][]string
+}
+
 // IntegrationStep holds the integration step configuration using
 // a custom Yaml unmarshal function to preserve ordering.
 type IntegrationStep struct {
@@ -97,6 +150,8 @@ type Build struct {
 	Container `yaml:",inline"`
 
 	Commands []string `yaml:"commands"`
+	// DependsOn is the names of the steps to finish before the step.
+	DependsOn []string `yaml:"depends_on"`
 }
 
 // BuildStep holds the build step configuration using a custom
@@ -120,6 +175,32 @@ type Application struct {
 	NamespaceName  string   `yaml:"namespace"`
 	DeploymentName string   `yaml:"deployment"`
 	Containers     []string `yaml:"containers"`
+	// Strategy is the strategy to deploy to kubernetes.
+	Strategy Strategy `yaml:"strategy"`
+	// Manifests is the path of the kubernetes manifests file or directory in the
+	// repository, they are applied instead of updating the images of the deployment.
+	Manifests string `yaml:"manifests"`
+}
+
+const (
+	// StrategyRolling updates the images of the deployment by its rolling update, it is the default.
+	StrategyRolling = "rolling"
+	// StrategyCanary rolls the images out to a canary deployment first, then to the deployment.
+	StrategyCanary = "canary"
+	// StrategyBlueGreen rolls the images out to a new deployment, then switches the service to it.
+	StrategyBlueGreen = "blue-green"
+)
+
+// Strategy is the strategy to deploy to kubernetes.
+type Strategy struct {
+	// Type is rolling, canary or blue-green.
+	Type string `yaml:"type"`
+	// Replicas is the number of the canary replicas, 1 by default.
+	Replicas int32 `yaml:"replicas"`
+	// BakeTime is the time in seconds the canary keeps healthy before it is promoted.
+	BakeTime int `yaml:"bake_time"`
+	// Service is the service switched to the new deployment by blue-green.
+	Service string `yaml:"service"`
 }
 
 // MapEqualSlice is the type for env map slice.
@@ -305,6 +386,9 @@ func (s *PreBuildStep) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		if err != nil {
 			return err
 		}
+		if prebuild.Name == "" {
+			prebuild.Name = key
+		}
 		s.parts = append(s.parts, prebuild)
 		return nil
 	})
@@ -420,6 +504,9 @@ func (s *BuildStep) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		if err != nil {
 			return err
 		}
+		if build.Name == "" {
+			build.Name = key
+		}
 		s.parts = append(s.parts, build)
 		return nil
 	})
@@ -456,3 +543,134 @@ func unmarshalYaml(v yaml.MapSlice, emit unmarshal) error {
 	}
 	return nil
 }
+
+// UnmarshalYAML implements the Unmarshaller interface.
+func (m *Matrix) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	include := struct {
+		Include []map[string]string `yaml:"include"`
+	}{}
+	if err := unmarshal(&include); err == nil && len(include.Include) > 0 {
+		for _, axis := range include.Include {
+			keys := make([]string, 0, len(axis))
+			for key := range axis {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			cell := make([]string, 0, len(keys))
+			for _, key := range keys {
+				cell = append(cell, key+"="+axis[key])
+			}
+			m.cells = append(m.cells, cell)
+		}
+		return nil
+	}
+
+	// unmarshal the yaml into the generic
+	// mapSlice type to preserve ordering.
+	obj := yaml.MapSlice{}
+	if err := unmarshal(&obj); err != nil {
+		return err
+	}
+
+	// combine the values of each axis with
+	// the cells of the previous axes.
+	cells := [][]string{{}}
+	err := unmarshalYaml(obj, func(key string, val []byte) error {
+		var values []string
+		if err := yaml.Unmarshal(val, &values); err != nil {
+			return err
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("matrix axis %s has no values", key)
+		}
+
+		combined := make([][]string, 0, len(cells)*len(values))
+		for _, cell := range cells {
+			for _, value := range values {
+				next := make([]string, len(cell), len(cell)+1)
+				copy(next, cell)
+				combined = append(combined, append(next, key+"="+value))
+			}
+		}
+		cells = combined
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(obj) > 0 {
+		m.cells = cells
+	}
+	return nil
+}
+
+// Cells gets the cells of the matrix.
+func (m *Matrix) Cells() [][]string {
+	return m.cells
+}
+
+// UnmarshalYAML implements the Unmarshaller interface.
+func (w *When) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// when is an alias without the UnmarshalYAML method.
+	type when When
+	if err := unmarshal((*when)(w)); err != nil {
+		return err
+	}
+
+	switch w.Status {
+	case "", StatusOnSuccess, StatusOnFailure, StatusAlways:
+		return nil
+	default:
+		return fmt.Errorf("unknown status %s in when, should be %s, %s or %s",
+			w.Status, StatusOnSuccess, StatusOnFailure, StatusAlways)
+	}
+}
+
+// UnmarshalYAML implements the Unmarshaller interface.
+func (a *Application) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// application is an alias without the UnmarshalYAML method.
+	type application Application
+	if err := unmarshal((*application)(a)); err != nil {
+		return err
+	}
+
+	if a.Manifests != "" {
+		return a.validateManifests()
+	}
+
+	switch a.Strategy.Type {
+	case "", StrategyRolling:
+		return nil
+	case StrategyCanary, StrategyBlueGreen:
+	default:
+		return fmt.Errorf("unknown strategy %s of deployment %s, should be %s, %s or %s",
+			a.Strategy.Type, a.DeploymentName, StrategyRolling, StrategyCanary, StrategyBlueGreen)
+	}
+	if a.ClusterType != "kubernetes" {
+		return fmt.Errorf("strategy %s of deployment %s needs the kubernetes type", a.Strategy.Type, a.DeploymentName)
+	}
+	if a.Strategy.Replicas < 0 || a.Strategy.BakeTime < 0 {
+		return fmt.Errorf("replicas and bake_time of deployment %s can not be negative", a.DeploymentName)
+	}
+	if a.Strategy.Type == StrategyBlueGreen && a.Strategy.Service == "" {
+		return fmt.Errorf("blue-green strategy of deployment %s needs the service", a.DeploymentName)
+	}
+	return nil
+}
+
+// validateManifests validates the application deployed by the manifests, which
+// must be in the repository and are applied by the rolling strategy only.
+func (a *Application) validateManifests() error {
+	if a.ClusterType != "kubernetes" {
+		return fmt.Errorf("manifests %s need the kubernetes type", a.Manifests)
+	}
+	clean := filepath.Clean(a.Manifests)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("manifests %s should be a relative path in the repository", a.Manifests)
+	}
+	if a.Strategy.Type != "" && a.Strategy.Type != StrategyRolling {
+		return fmt.Errorf("manifests %s can not be deployed by strategy %s", a.Manifests, a.Strategy.Type)
+	}
+	return nil
+}
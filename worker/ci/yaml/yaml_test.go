@@ -17,6 +17,7 @@ limitations under the License.
 package yaml
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -79,3 +80,156 @@ func TestParseString(t *testing.T) {
 		t.Errorf("Expected error %v to be nil.", err)
 	}
 }
+
+// TestParseTimeout tests parsing the timeouts of the build and its steps.
+func TestParseTimeout(t *testing.T) {
+	config, err := ParseString(`
+timeout: 1800
+pre_build:
+  image: golang:1.6
+  timeout: 600
+  commands:
+    - go build
+integration:
+  image: golang:1.6
+  timeout: 300
+  commands:
+    - go test
+`)
+	if err != nil {
+		t.Fatalf("Expected error %v to be nil.", err)
+	}
+	if config.Timeout != 1800 {
+		t.Errorf("Expected timeout 1800, got %d", config.Timeout)
+	}
+	if prebuilds := config.PreBuild.Slice(); len(prebuilds) != 1 || prebuilds[0].Timeout != 600 {
+		t.Errorf("Expected pre_build timeout 600, got %+v", prebuilds)
+	}
+	if build := config.Integration.Build(); build.Timeout != 300 {
+		t.Errorf("Expected integration timeout 300, got %d", build.Timeout)
+	}
+}
+
+// TestParseCache tests parsing the cache section.
+func TestParseCache(t *testing.T) {
+	config, err := ParseString(`
+cache:
+  key: deps
+  paths:
+    - vendor
+    - .m2/repository
+`)
+	if err != nil {
+		t.Fatalf("Expected error %v to be nil.", err)
+	}
+	if config.Cache.Key != "deps" {
+		t.Errorf("Expected cache key deps, got %s", config.Cache.Key)
+	}
+	if len(config.Cache.Paths) != 2 || config.Cache.Paths[1] != ".m2/repository" {
+		t.Errorf("Expected cache paths [vendor .m2/repository], got %v", config.Cache.Paths)
+	}
+}
+
+// TestParseMatrix tests expanding the matrix axes and listing the cells.
+func TestParseMatrix(t *testing.T) {
+	config, err := ParseString(`
+matrix:
+  GO_VERSION:
+    - 1.6
+    - 1.7
+  DB:
+    - mysql
+    - mongo
+`)
+	if err != nil {
+		t.Fatalf("Expected error %v to be nil.", err)
+	}
+	expected := [][]string{
+		{"GO_VERSION=1.6", "DB=mysql"},
+		{"GO_VERSION=1.6", "DB=mongo"},
+		{"GO_VERSION=1.7", "DB=mysql"},
+		{"GO_VERSION=1.7", "DB=mongo"},
+	}
+	if !reflect.DeepEqual(config.Matrix.Cells(), expected) {
+		t.Errorf("Expected cells %v, got %v", expected, config.Matrix.Cells())
+	}
+
+	config, err = ParseString(`
+matrix:
+  include:
+    - GO_VERSION: 1.6
+      DB: mysql
+    - GO_VERSION: 1.7
+`)
+	if err != nil {
+		t.Fatalf("Expected error %v to be nil.", err)
+	}
+	expected = [][]string{
+		{"DB=mysql", "GO_VERSION=1.6"},
+		{"GO_VERSION=1.7"},
+	}
+	if !reflect.DeepEqual(config.Matrix.Cells(), expected) {
+		t.Errorf("Expected cells %v, got %v", expected, config.Matrix.Cells())
+	}
+
+	if _, err = ParseString("matrix:\n  GO_VERSION: []\n"); err == nil {
+		t.Errorf("Expected error for the axis without values")
+	}
+}
+
+// TestParseWhenStatus tests the unknown status in when is rejected.
+func TestParseWhenStatus(t *testing.T) {
+	if _, err := ParseString("pre_build:\n  image: busybox\n  when:\n    status: on_failure\n"); err != nil {
+		t.Errorf("Expected error %v to be nil.", err)
+	}
+	if _, err := ParseString("pre_build:\n  image: busybox\n  when:\n    status: sometimes\n"); err == nil {
+		t.Errorf("Expected error for the unknown status")
+	}
+}
+
+// TestParseDeployStrategy tests the strategies of the deploy are validated.
+func TestParseDeployStrategy(t *testing.T) {
+	config, err := ParseString("deploy:\n  - type: kubernetes\n    deployment: web\n    strategy:\n      type: canary\n      replicas: 2\n      bake_time: 300\n")
+	if err != nil {
+		t.Fatalf("Expected error %v to be nil.", err)
+	}
+	strategy := config.Deploy.Applications[0].Strategy
+	if strategy.Type != StrategyCanary || strategy.Replicas != 2 || strategy.BakeTime != 300 {
+		t.Errorf("Expected canary strategy with 2 replicas and 300s bake time, but got %+v", strategy)
+	}
+
+	invalid := []string{
+		"deploy:\n  - type: kubernetes\n    deployment: web\n    strategy:\n      type: shadow\n",
+		"deploy:\n  - deployment: web\n    strategy:\n      type: canary\n",
+		"deploy:\n  - type: kubernetes\n    deployment: web\n    strategy:\n      type: blue-green\n",
+		"deploy:\n  - type: kubernetes\n    deployment: web\n    strategy:\n      type: canary\n      replicas: -1\n",
+	}
+	for _, s := range invalid {
+		if _, err := ParseString(s); err == nil {
+			t.Errorf("Expected error for the invalid strategy in %q", s)
+		}
+	}
+}
+
+// TestParseDeployManifests tests the manifests of the deploy are validated.
+func TestParseDeployManifests(t *testing.T) {
+	config, err := ParseString("deploy:\n  - type: kubernetes\n    namespace: prod\n    manifests: deploy/k8s\n")
+	if err != nil {
+		t.Fatalf("Expected error %v to be nil.", err)
+	}
+	if manifests := config.Deploy.Applications[0].Manifests; manifests != "deploy/k8s" {
+		t.Errorf("Expected manifests deploy/k8s, but got %s", manifests)
+	}
+
+	invalid := []string{
+		"deploy:\n  - manifests: deploy/k8s\n",
+		"deploy:\n  - type: kubernetes\n    manifests: /etc/k8s\n",
+		"deploy:\n  - type: kubernetes\n    manifests: ../k8s\n",
+		"deploy:\n  - type: kubernetes\n    manifests: deploy/k8s\n    strategy:\n      type: canary\n",
+	}
+	for _, s := range invalid {
+		if _, err := ParseString(s); err == nil {
+			t.Errorf("Expected error for the invalid manifests in %q", s)
+		}
+	}
+}
@@ -28,10 +28,12 @@ import (
 	"github.com/caicloud/cyclone/websocket"
 	"github.com/caicloud/cyclone/worker/ci"
 	"github.com/caicloud/cyclone/worker/ci/parser"
+	"github.com/caicloud/cyclone/worker/ci/runner"
 	"github.com/caicloud/cyclone/worker/handler"
 	"github.com/caicloud/cyclone/worker/helper"
 	worker_log "github.com/caicloud/cyclone/worker/log"
 	"github.com/caicloud/cyclone/worker/vcs"
+	"github.com/caicloud/cyclone/worker/vcs/provider"
 )
 
 const (
@@ -127,6 +129,7 @@ func createService(vcsManager *vcs.Manager, event *api.Event) {
 	if err != nil {
 		event.Status = api.EventStatusFail
 		event.ErrorMessage = err.Error()
+		event.ErrorClass = cloneErrorClass(err)
 		log.ErrorWithFields("Operation failed", log.Fields{"event": event})
 	} else {
 		event.Status = api.EventStatusSuccess
@@ -157,6 +160,7 @@ func createVersion(vcsManager *vcs.Manager, event *api.Event) {
 	if err != nil {
 		event.Status = api.EventStatusFail
 		event.ErrorMessage = err.Error()
+		event.ErrorClass = api.ErrorClassInfrastructure
 		log.ErrorWithFields("Operation failed", log.Fields{"event": event})
 		return
 	}
@@ -167,6 +171,7 @@ func createVersion(vcsManager *vcs.Manager, event *api.Event) {
 		if err := helper.PushLogToCyclone(event); err != nil {
 			event.Status = api.EventStatusFail
 			event.ErrorMessage = err.Error()
+			event.ErrorClass = api.ErrorClassInfrastructure
 			log.ErrorWithFields("Operation failed", log.Fields{"event": event})
 		}
 		output.Close()
@@ -192,6 +197,7 @@ func createVersion(vcsManager *vcs.Manager, event *api.Event) {
 	if err = vcsManager.CloneVersionRepository(event); err != nil {
 		event.Status = api.EventStatusFail
 		event.ErrorMessage = err.Error()
+		event.ErrorClass = cloneErrorClass(err)
 		log.ErrorWithFields("Operation failed", log.Fields{"event": event})
 		return
 	}
@@ -205,6 +211,7 @@ func createVersion(vcsManager *vcs.Manager, event *api.Event) {
 		if err != ci.ErrYamlNotExist {
 			event.Status = api.EventStatusFail
 			event.ErrorMessage = err.Error()
+			event.ErrorClass = api.ErrorClassBuild
 			log.ErrorWithFields("Operation failed", log.Fields{"event": event})
 			return
 		}
@@ -218,12 +225,14 @@ func createVersion(vcsManager *vcs.Manager, event *api.Event) {
 
 // noYamlBuild func uses for build without yaml file.
 func noYamlBuild(event *api.Event, dockerManager *docker.Manager) {
-	bHasPublishSuccessful := false
 	operation := string(event.Version.Operation)
+	// The version deploying only has published its image before.
+	bHasPublishSuccessful := operation == string(api.DeployOperation)
 	if strings.Contains(operation, string(api.PublishOperation)) {
 		if err := helper.Publish(event, dockerManager); err != nil {
 			event.Status = api.EventStatusFail
 			event.ErrorMessage = err.Error()
+			event.ErrorClass = api.ErrorClassPush
 			log.ErrorWithFields("Operation failed", log.Fields{"event": event})
 			return
 		}
@@ -235,6 +244,7 @@ func noYamlBuild(event *api.Event, dockerManager *docker.Manager) {
 		if err := helper.DoPlansDeploy(bHasPublishSuccessful, event, dockerManager); err != nil {
 			event.Status = api.EventStatusFail
 			event.ErrorMessage = err.Error()
+			event.ErrorClass = api.ErrorClassBuild
 			log.ErrorWithFields("Operation failed", log.Fields{"event": event})
 			return
 		}
@@ -253,16 +263,43 @@ func yamlBuild(event *api.Event, tree *parser.Tree, dockerManager *docker.Manage
 	if err != nil {
 		event.Status = api.EventStatusFail
 		event.ErrorMessage = err.Error()
+		event.ErrorClass = api.ErrorClassBuild
 		log.ErrorWithFields("Operation failed", log.Fields{"event": event})
 		return
 	}
 
-	// Build image
-	if err = helper.ExecBuild(ciManager, r); err != nil {
-		event.Status = api.EventStatusFail
-		event.ErrorMessage = err.Error()
-		log.ErrorWithFields("Operation failed", log.Fields{"event": event})
-		return
+	// Run the steps declared to run on failure if the build fails.
+	defer func() {
+		if event.Status != api.EventStatusFail {
+			return
+		}
+		flags := parser.NodePreBuild | parser.NodeBuild
+		if strings.Contains(operation, "integration") {
+			flags |= parser.NodeService | parser.NodeIntegration
+		}
+		if strings.Contains(operation, "publish") {
+			flags |= parser.NodePostBuild
+		}
+		if errFailure := ciManager.ExecOnFailure(r, flags, err); errFailure != nil {
+			log.ErrorWithFields("Unable to run the steps on failure", log.Fields{"err": errFailure})
+		}
+	}()
+
+	// The version deploying only has built and published its image before,
+	// e.g. it is approved to deploy.
+	if operation != string(api.DeployOperation) {
+		// Restore the cache declared in caicloud.yml
+		buildCache := helper.ExecRestoreCache(event, tree)
+
+		// Build image
+		if err = helper.ExecBuild(ciManager, r); err != nil {
+			event.Status = api.EventStatusFail
+			event.ErrorMessage = err.Error()
+			event.ErrorClass = errorClass(err, api.ErrorClassBuild)
+			log.ErrorWithFields("Operation failed", log.Fields{"event": event})
+			return
+		}
+		helper.ExecSaveCache(event, buildCache)
 	}
 
 	// If need integration
@@ -271,6 +308,7 @@ func yamlBuild(event *api.Event, tree *parser.Tree, dockerManager *docker.Manage
 		if err = helper.ExecIntegration(ciManager, r); err != nil {
 			event.Status = api.EventStatusFail
 			event.ErrorMessage = err.Error()
+			event.ErrorClass = errorClass(err, api.ErrorClassBuild)
 			log.ErrorWithFields("Operation failed", log.Fields{"event": event})
 			return
 		}
@@ -282,6 +320,7 @@ func yamlBuild(event *api.Event, tree *parser.Tree, dockerManager *docker.Manage
 		if err = helper.ExecPublish(ciManager, r); err != nil {
 			event.Status = api.EventStatusFail
 			event.ErrorMessage = err.Error()
+			event.ErrorClass = errorClass(err, api.ErrorClassPush)
 			log.ErrorWithFields("Operation failed", log.Fields{"event": event})
 			return
 		}
@@ -293,6 +332,7 @@ func yamlBuild(event *api.Event, tree *parser.Tree, dockerManager *docker.Manage
 		if err = helper.ExecDeploy(event, dockerManager, r, tree); err != nil {
 			event.Status = api.EventStatusFail
 			event.ErrorMessage = err.Error()
+			event.ErrorClass = api.ErrorClassBuild
 			log.ErrorWithFields("Operation failed", log.Fields{"event": event})
 			return
 		}
@@ -309,6 +349,24 @@ func yamlBuild(event *api.Event, tree *parser.Tree, dockerManager *docker.Manage
 	event.Status = api.EventStatusSuccess
 }
 
+// errorClass gets the class of the error failing the step, timeout if the step
+// runs out of time.
+func errorClass(err error, class api.ErrorClass) api.ErrorClass {
+	if runner.IsTimeout(err) {
+		return api.ErrorClassTimeout
+	}
+	return class
+}
+
+// cloneErrorClass gets the class of the error failing the clone, the clone is
+// not retried if the repository does not exist or the credentials are refused.
+func cloneErrorClass(err error) api.ErrorClass {
+	if provider.IsUnavailable(err) {
+		return api.ErrorClassRepository
+	}
+	return api.ErrorClassClone
+}
+
 // sendEvent used for setting event for circe server
 func sendEvent(event api.Event) error {
 	eventID := osutil.GetStringEnv(WORKER_EVENTID, "")
@@ -29,8 +29,10 @@ import (
 	"github.com/caicloud/cyclone/docker"
 	"github.com/caicloud/cyclone/pkg/log"
 	"github.com/caicloud/cyclone/pkg/osutil"
+	"github.com/caicloud/cyclone/pkg/rollout"
 	"github.com/caicloud/cyclone/pkg/wait"
 	"github.com/caicloud/cyclone/utils"
+	"github.com/caicloud/cyclone/worker/cache"
 	"github.com/caicloud/cyclone/worker/ci"
 	"github.com/caicloud/cyclone/worker/ci/parser"
 	"github.com/caicloud/cyclone/worker/ci/runner"
@@ -59,20 +61,30 @@ type Application struct {
 
 const (
 	// KUBERNETES is the cluster type of kubernetes.
-	KUBERNETES = "kubernetes"
+	KUBERNETES = api.ClusterTypeKubernetes
 
-	checkDeployStatusPeriod = 10 * time.Second
-	checkDeployTimeout      = 10 * time.Minute
+	// yamlK8sDeploysKey is the key in the event data of the kubernetes deploys
+	// started by the yaml deploy, one per application.
+	yamlK8sDeploysKey = "yaml-k8s-deploys"
+	// rollbackReasonCheckFailed is the reason of the rollback after the deploy check fails.
+	rollbackReasonCheckFailed = "deploy check failed or timed out"
 )
 
 var (
 	codeDeployReady = 1
+
+	checkDeployStatusPeriod = 10 * time.Second
+	checkDeployTimeout      = 10 * time.Minute
+
+	// newDeploymentsClient creates the client of the kubernetes resources.
+	newDeploymentsClient = rollout.NewClient
 )
 
 // Result is the type for result.
 type Result struct {
-	position int
-	err      error
+	position   int
+	err        error
+	rolledBack bool
 }
 
 // appVersionInfo defines which versions are used in an application.
@@ -89,6 +101,9 @@ type appVersionInfo struct {
 	ClusterType   string                 `bson:"cluster_type,omitempty" json:"cluster_type,omitempty"` // kubernetes, caicloud_claas, mesos
 	ClusterHost   string                 `bson:"cluster_host,omitempty" json:"cluster_host,omitempty"`
 	ClusterToken  string                 `bson:"cluster_token,omitempty" json:"cluster_token,omitempty"`
+
+	// k8s is the deploy by the kubernetes API, nil if the cluster is not kubernetes.
+	k8s *k8sDeploy
 }
 
 // containerVersionInfo defines which versions are used in an container.
@@ -97,6 +112,47 @@ type containerVersionInfo struct {
 	VersionName   string `bson:"version,omitempty" json:"version,omitempty"`
 }
 
+// ExecRestoreCache restores the directories declared in the cache section of
+// caicloud.yml, it returns nil if there is no cache. Failing to restore the
+// cache does not fail the build.
+func ExecRestoreCache(event *api.Event, tree *parser.Tree) *cache.Cache {
+	if len(tree.Cache.Paths) == 0 {
+		return nil
+	}
+
+	steplog.InsertStepLog(event, steplog.RestoreCache, steplog.Start, nil)
+	root := osutil.GetStringEnv(cache.WORKER_CACHE_DIR, cache.DEFAULT_WORKER_CACHE_DIR)
+	c, err := cache.New(root, &event.Service, &event.Version, tree.Cache)
+	if err != nil {
+		steplog.InsertStepLog(event, steplog.RestoreCache, steplog.Stop, err)
+		return nil
+	}
+
+	contextDir, _ := event.Data["context-dir"].(string)
+	if err = c.Restore(contextDir, steplog.Output); err != nil {
+		steplog.InsertStepLog(event, steplog.RestoreCache, steplog.Stop, err)
+		return c
+	}
+	steplog.InsertStepLog(event, steplog.RestoreCache, steplog.Finish, nil)
+	return c
+}
+
+// ExecSaveCache saves the cached directories after the build. Failing to save
+// the cache does not fail the build.
+func ExecSaveCache(event *api.Event, c *cache.Cache) {
+	if c == nil {
+		return
+	}
+
+	steplog.InsertStepLog(event, steplog.SaveCache, steplog.Start, nil)
+	contextDir, _ := event.Data["context-dir"].(string)
+	if err := c.Save(contextDir, steplog.Output); err != nil {
+		steplog.InsertStepLog(event, steplog.SaveCache, steplog.Stop, err)
+		return
+	}
+	steplog.InsertStepLog(event, steplog.SaveCache, steplog.Finish, nil)
+}
+
 // ExecBuild exec the publish steps
 // Step1: Prebuild
 // Step2: Build
@@ -178,6 +234,11 @@ func DoYamlDeploy(r *runner.Build, tree *parser.Tree, event *api.Event, dmanager
 		log.Infof("Skip deploy due to deploy section not defined or yaml deploy not be choosed")
 		return nil
 	}
+	// The deploy section belongs to the first environment of the service.
+	if envs := event.Service.Environments; len(envs) > 0 && event.Version.Environment != envs[0].Name {
+		log.Infof("Skip yaml deploy as the version is promoted to environment %s", event.Version.Environment)
+		return nil
+	}
 
 	if r.IsPushImageSuccess() == false {
 		return fmt.Errorf("Failed to deploy version due to build section undefined or push image failed.")
@@ -191,8 +252,16 @@ func DoYamlDeploy(r *runner.Build, tree *parser.Tree, event *api.Event, dmanager
 	}
 	imageName := imagename.(string) + ":" + tagname.(string)
 
-	for _, application := range tree.DeployConfig.Applications {
-		if err := updateContainerInClusterWithYaml(event.Service.UserID, imageName, application); err != nil {
+	// Keep the kubernetes deploys to complete them in the deploy check.
+	deploys := make([]*k8sDeploy, len(tree.DeployConfig.Applications))
+	event.Data[yamlK8sDeploysKey] = deploys
+	defer func() {
+		event.Version.YamlDeploySubStatuses = yamlSubStatuses(deploys)
+	}()
+	for i, application := range tree.DeployConfig.Applications {
+		deploy, err := updateContainerInClusterWithYaml(event, imageName, application)
+		deploys[i] = deploy
+		if err != nil {
 			event.Version.YamlDeployStatus = api.DeployFailed
 			return err
 		}
@@ -201,6 +270,17 @@ func DoYamlDeploy(r *runner.Build, tree *parser.Tree, event *api.Event, dmanager
 	return nil
 }
 
+// yamlSubStatuses collects the sub statuses of the kubernetes deploys of the applications.
+func yamlSubStatuses(deploys []*k8sDeploy) []api.DeploySubStatus {
+	subStatuses := []api.DeploySubStatus{}
+	for _, deploy := range deploys {
+		if deploy != nil {
+			subStatuses = append(subStatuses, *deploy.subStatuses...)
+		}
+	}
+	return subStatuses
+}
+
 // DoPlansDeploy is a wrapper of deploy to do some extra work by Plans information.
 func DoPlansDeploy(bHasPublishSuccessful bool, event *api.Event, dmanager *docker.Manager) error {
 	if bHasPublishSuccessful == false {
@@ -215,11 +295,19 @@ func DoPlansDeploy(bHasPublishSuccessful bool, event *api.Event, dmanager *docke
 	imageName := imagename.(string) + ":" + tagname.(string)
 
 	for i := 0; i < len(event.Version.DeployPlansStatuses); i++ {
-		plan := event.Version.DeployPlansStatuses[i]
-		if err := updateContainerInClusterWithPlan(event.Service.UserID, imageName, plan.Config); err != nil {
-			event.Version.DeployPlansStatuses[i].Status = api.DeployFailed
+		plan := &event.Version.DeployPlansStatuses[i]
+		// Only the plans of the environment the version is deployed to run.
+		if plan.Environment != event.Version.Environment {
+			continue
+		}
+		plan.SubStatuses = nil
+		if err := updateContainerInClusterWithPlan(event, imageName, plan); err != nil {
+			plan.Status = api.DeployFailed
+			continue
 		}
-		event.Version.DeployPlansStatuses[i].Status = api.DeployPending
+		plan.Status = api.DeployPending
+		plan.Image = imageName
+		plan.Deployments = append(plan.Deployments, newVersionDeployment(event, plan, api.DeploymentKindNew, ""))
 	}
 	return nil
 }
@@ -248,10 +336,31 @@ func Publish(event *api.Event, dmanager *docker.Manager) error {
 }
 
 // updateContainerInClusterWithYaml func use to update container in cluster according the caicloud.yaml.
-func updateContainerInClusterWithYaml(userID, imageName string, application yaml.Application) error {
+// The deploy is returned if the cluster is kubernetes, to be completed by the deploy check.
+func updateContainerInClusterWithYaml(event *api.Event, imageName string, application yaml.Application) (*k8sDeploy, error) {
+	userID := event.Service.UserID
 	clusterName := application.ClusterName
 	namespaceName := application.NamespaceName
 	deploymentName := application.DeploymentName
+	if application.ClusterType == KUBERNETES {
+		log.InfoWithFields("Deploy with k8s api for yaml deploy: ",
+			log.Fields{
+				"user id":     userID,
+				"namespace":   namespaceName,
+				"application": deploymentName,
+				"containers":  application.Containers,
+				"image":       imageName,
+				"strategy":    application.Strategy.Type,
+				"manifests":   application.Manifests,
+			})
+		deploy := newK8sDeployFromYaml(event, application, imageName, &[]api.DeploySubStatus{})
+		if err := deploy.start(); err != nil {
+			log.ErrorWithFields("Failed to deploy with yaml information use k8s api", log.Fields{"err": err})
+			return deploy, err
+		}
+		return deploy, nil
+	}
+
 	for _, containerName := range application.Containers {
 		log.InfoWithFields("Send post request to updateImage API for yaml deploy: ",
 			log.Fields{
@@ -262,33 +371,48 @@ func updateContainerInClusterWithYaml(userID, imageName string, application yaml
 				"container":   containerName,
 				"image":       imageName,
 			})
-		if application.ClusterType == KUBERNETES {
-			if err := InvokeUpdateImageK8sAPI(deploymentName, namespaceName, containerName, imageName,
-				application.ClusterHost, application.ClusterToken); err != nil {
-				log.ErrorWithFields("Failed to deploy with yaml information use k8s api", log.Fields{"err": err})
-				return err
-			}
-		} else {
-			consoleWebEndpoint := osutil.GetStringEnv("CONSOLE_WEB_ENDPOINT", "http://127.0.0.1:3000")
-			endpoint := consoleWebEndpoint + "/api/application/updateImage"
-			if err := InvokeUpdateImageAPI(userID, deploymentName, clusterName, namespaceName,
-				containerName, imageName, endpoint); err != nil {
-				log.ErrorWithFields("Failed to deploy with yaml information", log.Fields{"err": err})
-				return err
-			}
+		consoleWebEndpoint := osutil.GetStringEnv("CONSOLE_WEB_ENDPOINT", "http://127.0.0.1:3000")
+		endpoint := consoleWebEndpoint + "/api/application/updateImage"
+		if err := InvokeUpdateImageAPI(userID, deploymentName, clusterName, namespaceName,
+			containerName, imageName, endpoint); err != nil {
+			log.ErrorWithFields("Failed to deploy with yaml information", log.Fields{"err": err})
+			return nil, err
 		}
 	}
-	return nil
+	return nil, nil
 }
 
 // updateContainerInClusterWithPlan func use to update container in cluster according the plan setting.
-func updateContainerInClusterWithPlan(userID, imageName string, application api.DeployConfig) error {
+// The previous images of the containers and the progress are recorded in the plan if the cluster is kubernetes.
+func updateContainerInClusterWithPlan(event *api.Event, imageName string, plan *api.DeployPlanStatus) error {
+	userID := event.Service.UserID
 	consoleWebEndpoint := osutil.GetStringEnv("CONSOLE_WEB_ENDPOINT", "http://127.0.0.1:3000")
 	endpoint := consoleWebEndpoint + "/api/application/updateImage"
 
+	application := plan.Config
 	clusterName := application.ClusterID
 	namespaceName := application.Namespace
 	deploymentName := application.Deployment
+	if application.ClusterType == KUBERNETES {
+		log.InfoWithFields("Deploy with k8s api for plan deploy: ",
+			log.Fields{
+				"user id":     userID,
+				"namespace":   namespaceName,
+				"application": deploymentName,
+				"containers":  application.Containers,
+				"image":       imageName,
+				"strategy":    application.Strategy.Type,
+			})
+		deploy := newK8sDeployFromPlan(event, plan, imageName)
+		err := deploy.start()
+		plan.PreviousImages = deploy.previousImages
+		if err != nil {
+			log.ErrorWithFields("Failed to deploy with plan information use k8s api", log.Fields{"err": err})
+			return err
+		}
+		return nil
+	}
+
 	for _, containerName := range application.Containers {
 		// Web may send the empty contianter name, so there need make some judgment
 		if containerName == "" {
@@ -322,14 +446,20 @@ func ExecDeployCheck(event *api.Event, tree *parser.Tree) {
 	DoPlanDeployCheck(event)
 }
 
-// DoYamlDeployCheck uses for yaml deploy state check.
+// DoYamlDeployCheck uses for yaml deploy state check. The deploys to kubernetes
+// are completed by their strategies, and rolled back if they fail.
 func DoYamlDeployCheck(event *api.Event, tree *parser.Tree) {
 	appList := []appVersionInfo{}
-	value := []int{}
+	failed := []Result{}
 	if event.Version.YamlDeployStatus == api.DeployPending {
 		finalStatus := api.DeploySuccess
-		for _, app := range tree.DeployConfig.Applications {
-			appList = append(appList, *parseAppVersionInfo(event, &app))
+		deploys, _ := event.Data[yamlK8sDeploysKey].([]*k8sDeploy)
+		for i, app := range tree.DeployConfig.Applications {
+			info := parseAppVersionInfo(event, &app)
+			if i < len(deploys) {
+				info.k8s = deploys[i]
+			}
+			appList = append(appList, *info)
 		}
 
 		log.InfoWithFields("About to check deploy state for yaml depoly", log.Fields{
@@ -337,54 +467,95 @@ func DoYamlDeployCheck(event *api.Event, tree *parser.Tree) {
 			"applist":    appList,
 		})
 
-		if false == checkDeployStatus(event, appList, &value) {
-			finalStatus = api.DeployFailed
+		if false == checkDeployStatus(event, appList, &failed) {
+			finalStatus = api.DeployRolledBack
+			for _, result := range failed {
+				if !result.rolledBack {
+					finalStatus = api.DeployFailed
+				}
+			}
 		}
 
 		event.Version.YamlDeployStatus = finalStatus
+		if len(deploys) > 0 {
+			event.Version.YamlDeploySubStatuses = yamlSubStatuses(deploys)
+		}
 	}
 }
 
-// DoPlanDeployCheck uses for plan deploy state check.
+// DoPlanDeployCheck uses for plan deploy state check. The plans deployed to
+// kubernetes are completed by their strategies, and rolled back if they fail.
 func DoPlanDeployCheck(event *api.Event) {
 	// Plan deploy check
 	appList := []appVersionInfo{}
-	value := []int{}
+	plans := []int{}
+	failed := []Result{}
 	for i, plan := range event.Version.DeployPlansStatuses {
 		// Only check pending status
 		if plan.Status != api.DeployPending {
 			continue
 		}
 		event.Version.DeployPlansStatuses[i].Status = api.DeploySuccess
-		appList = append(appList, *getAppVersionInfoFromPlan(event, &(plan.Config)))
+		appList = append(appList, *getAppVersionInfoFromPlan(event, &event.Version.DeployPlansStatuses[i]))
+		plans = append(plans, i)
 	}
 	log.InfoWithFields("About to check deploy state for plan depoly", log.Fields{
 		"version_id": event.Version.VersionID,
 		"applist":    appList,
 	})
 
-	checkDeployStatus(event, appList, &value)
+	checkDeployStatus(event, appList, &failed)
 
-	for _, position := range value {
-		if position != -1 {
-			event.Version.DeployPlansStatuses[position].Status = api.DeployFailed
+	for _, result := range failed {
+		if result.position == -1 {
+			continue
+		}
+		plan := &event.Version.DeployPlansStatuses[plans[result.position]]
+		plan.Status = api.DeployFailed
+		if result.rolledBack {
+			plan.Status = api.DeployRolledBack
+			plan.Deployments = append(plan.Deployments, newVersionDeployment(event, plan,
+				api.DeploymentKindRollack, rollbackReasonCheckFailed))
 		}
 	}
 }
 
+// newVersionDeployment creates the record of a deployment of the version by the plan.
+func newVersionDeployment(event *api.Event, plan *api.DeployPlanStatus, kind api.DeploymentKind,
+	reason string) api.VersionDeployment {
+	return api.VersionDeployment{
+		VersionID:      event.Version.VersionID,
+		DeploymentKind: kind,
+		VersionLiveInfo: api.VersionLiveInfo{
+			Environment: plan.Environment,
+			Cluster:     plan.Config.ClusterName,
+			Project:     plan.Config.Namespace,
+			DeployTime:  time.Now(),
+		},
+		Reason: reason,
+	}
+}
+
 // checkOneDeployStatus func use to check deploy update status once.
 func checkOneDeployStatus(versionID string, checkChan chan Result, app appVersionInfo, position int) {
-	err := wait.Poll(checkDeployStatusPeriod, checkDeployTimeout, func() (bool, error) {
-		var err error
-		if app.ClusterType == "kubernetes" {
-			err = InvokeCheckDeployStateK8sAPI(app)
-		} else {
-			consoleWebEndpoint := osutil.GetStringEnv("CONSOLE_WEB_ENDPOINT", "http://127.0.0.1:3000")
-			endpoint := consoleWebEndpoint + "/api/application/checkVersionDeployState"
-			err = InvokeCheckDeployStateAPI(app, endpoint)
+	if app.k8s != nil {
+		rolledBack, err := app.k8s.check()
+		if err != nil {
+			log.ErrorWithFields("Failed to deploy with k8s api", log.Fields{
+				"version_id":  versionID,
+				"application": app.Deployment,
+				"rolled back": rolledBack,
+				"err":         err,
+			})
 		}
+		checkChan <- Result{position, err, rolledBack}
+		return
+	}
 
-		if err != nil {
+	err := wait.Poll(checkDeployStatusPeriod, checkDeployTimeout, func() (bool, error) {
+		consoleWebEndpoint := osutil.GetStringEnv("CONSOLE_WEB_ENDPOINT", "http://127.0.0.1:3000")
+		endpoint := consoleWebEndpoint + "/api/application/checkVersionDeployState"
+		if err := InvokeCheckDeployStateAPI(app, endpoint); err != nil {
 			// May Failed because of network problem, just print error
 			log.ErrorWithFields("Failed to call checkDeployAPI", log.Fields{
 				"applicationName": app.Deployment,
@@ -401,11 +572,11 @@ func checkOneDeployStatus(versionID string, checkChan chan Result, app appVersio
 			"err":        err,
 		})
 	}
-	checkChan <- Result{position, err}
+	checkChan <- Result{position, err, false}
 }
 
 // checkDeployStatus func use to check deploy update status.
-func checkDeployStatus(event *api.Event, appList []appVersionInfo, value *[]int) bool {
+func checkDeployStatus(event *api.Event, appList []appVersionInfo, failed *[]Result) bool {
 	// In e2e-test, we dont really send a http request. The call will be always successful.
 	if event.Service.UserID == utils.DeployUID {
 		return true
@@ -422,19 +593,19 @@ func checkDeployStatus(event *api.Event, appList []appVersionInfo, value *[]int)
 		go checkOneDeployStatus(event.Version.VersionID, checkChan, app, i)
 	}
 
-	go checkUpdateResult(len(appList), checkChan, exitChan, value)
+	go checkUpdateResult(len(appList), checkChan, exitChan, failed)
 
 	return <-exitChan
 }
 
 // checkUpdateResult func collect all the check result.
-func checkUpdateResult(length int, checkChan chan Result, exitChan chan bool, value *[]int) {
+func checkUpdateResult(length int, checkChan chan Result, exitChan chan bool, failed *[]Result) {
 	final := true
 	for i := 0; i < length; i++ {
 		result := <-checkChan
 		if result.err != nil {
 			final = false
-			*value = append(*value, result.position)
+			*failed = append(*failed, result)
 		}
 	}
 	exitChan <- final
@@ -473,7 +644,8 @@ func parseAppVersionInfo(event *api.Event, a *yaml.Application) *appVersionInfo
 }
 
 // getAppVersionInfoFromPlan func parse the plan's application struct to appVersionInfo struct.
-func getAppVersionInfoFromPlan(event *api.Event, plan *api.DeployConfig) *appVersionInfo {
+func getAppVersionInfoFromPlan(event *api.Event, planStatus *api.DeployPlanStatus) *appVersionInfo {
+	plan := &planStatus.Config
 	containerList := []containerVersionInfo{}
 
 	for _, c := range plan.Containers {
@@ -491,7 +663,7 @@ func getAppVersionInfoFromPlan(event *api.Event, plan *api.DeployConfig) *appVer
 	tagname, _ := event.Data["tag-name"]
 	imageName := imagename.(string) + ":" + tagname.(string)
 
-	return &appVersionInfo{
+	info := &appVersionInfo{
 		UserName:    event.Service.Username,
 		UserID:      event.Service.UserID,
 		ServiceName: event.Service.Name,
@@ -502,7 +674,15 @@ func getAppVersionInfoFromPlan(event *api.Event, plan *api.DeployConfig) *appVer
 		ContainerList: containerList,
 		DeployOk:      false,
 		ImageName:     imageName,
+		ClusterType:   plan.ClusterType,
+		ClusterHost:   plan.ClusterHost,
+		ClusterToken:  clusterToken(plan.ClusterToken),
+	}
+	if plan.ClusterType == KUBERNETES {
+		info.k8s = newK8sDeployFromPlan(event, planStatus, imageName)
+		info.k8s.previousImages = planStatus.PreviousImages
 	}
+	return info
 }
 
 // InvokeUpdateImageAPI used for call api to updape application in cluster.
@@ -594,71 +774,6 @@ func InvokeCheckDeployStateAPI(app appVersionInfo, endpoint string) error {
 	return nil
 }
 
-// InvokeUpdateImageK8sAPI invokes k8s api to update the depolyment.
-func InvokeUpdateImageK8sAPI(deploymentName, namespaceName, containerName,
-	imageName, host, token string) error {
-	k8sClient, err := NewClientWithToken(host, token)
-	if err != nil {
-		return err
-	}
-
-	// Get applciton depolyment
-	var deployment *k8s_ext_api.Deployment
-	if deployment, err = k8sClient.Deployments(namespaceName).Get(deploymentName); err != nil {
-		return err
-	}
-
-	log.Infof("deployment %+v", *deployment)
-	for i := 0; i < len(deployment.Spec.Template.Spec.Containers); i++ {
-		if deployment.Spec.Template.Spec.Containers[i].Name == containerName {
-			deployment.Spec.Template.Spec.Containers[i].Image = imageName
-		}
-	}
-
-	// Update deployment. Skip updating deployment if name is empty.
-	if deployment.Name != "" {
-		if _, err := k8sClient.Deployments(namespaceName).Update(deployment); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-// InvokeCheckDeployStateK8sAPI func call k8s api to check whether the updating is successful.
-func InvokeCheckDeployStateK8sAPI(app appVersionInfo) error {
-	k8sClient, err := NewClientWithToken(app.ClusterHost, app.ClusterToken)
-	if err != nil {
-		return err
-	}
-
-	// Get applciton depolyment
-	var deployment *k8s_ext_api.Deployment
-	if deployment, err = k8sClient.Deployments(app.Namespace).Get(app.Deployment); err != nil {
-		return err
-	}
-
-	log.Infof("deployment %+v", *deployment)
-	for i := 0; i < len(deployment.Spec.Template.Spec.Containers); i++ {
-		if checkInArray(app.ContainerList, deployment.Spec.Template.Spec.Containers[i].Name) &&
-			deployment.Spec.Template.Spec.Containers[i].Image != app.ImageName {
-			return fmt.Errorf("update fail, %s vs %s", deployment.Spec.Template.Spec.Containers[i].Image, app.ImageName)
-		}
-	}
-	return nil
-}
-
-// checkInArray func use to check  whether contianerName is in containerList.
-func checkInArray(containerList []containerVersionInfo, contianerName string) bool {
-	for _, container := range containerList {
-		if container.ContainerName == contianerName {
-			return true
-		}
-	}
-
-	return false
-}
-
 // NewClientWithToken creates a new kubernetes client using BearerToken.
 func NewClientWithToken(host, token string) (*clientset.Clientset, error) {
 	config := &restclient.Config{
@@ -17,10 +17,22 @@ limitations under the License.
 package helper
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/pkg/rollout"
+	"github.com/caicloud/cyclone/pkg/rollout/fake"
 	"github.com/caicloud/cyclone/utils"
+	"github.com/caicloud/cyclone/worker/ci/parser"
 	"github.com/caicloud/cyclone/worker/ci/yaml"
+	k8s_core_api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	k8s_ext_api "k8s.io/kubernetes/pkg/apis/extensions"
 )
 
 const (
@@ -33,7 +45,396 @@ func TestUpdateContainerInClusterWithYaml(t *testing.T) {
 	app := yaml.Application{
 		ClusterType: "NOT_K8S",
 	}
-	if err := updateContainerInClusterWithYaml(utils.DeployUID, imageName, app); err != nil {
+	event := &api.Event{Service: api.Service{UserID: utils.DeployUID}}
+	if _, err := updateContainerInClusterWithYaml(event, imageName, app); err != nil {
 		t.Errorf("Expected err %v to be nil", err)
 	}
 }
+
+// useFakeClient makes the deploys use the fake client with the deployment web
+// of the container web running web:v1, and the service web selecting it. The
+// returned function restores the client and the periods to check the deploys.
+func useFakeClient() (*fake.Client, func()) {
+	labels := map[string]string{"app": "web", rollout.TrackLabel: "stable", rollout.ColorLabel: "blue"}
+	deployment := &k8s_ext_api.Deployment{}
+	deployment.Name = "web"
+	deployment.Spec.Replicas = 2
+	deployment.Spec.Selector = &unversioned.LabelSelector{MatchLabels: labels}
+	deployment.Spec.Template.Labels = labels
+	deployment.Spec.Template.Spec.Containers = []k8s_core_api.Container{{Name: "web", Image: "web:v1"}}
+	service := &k8s_core_api.Service{}
+	service.Name = "web"
+	service.Spec.Selector = map[string]string{"app": "web", rollout.ColorLabel: "blue"}
+	client := fake.NewClient([]*k8s_ext_api.Deployment{deployment}, []*k8s_core_api.Service{service})
+
+	newClient, period, timeout := newDeploymentsClient, checkDeployStatusPeriod, checkDeployTimeout
+	newDeploymentsClient = func(host, token string) (rollout.Client, error) {
+		return client, nil
+	}
+	checkDeployStatusPeriod, checkDeployTimeout = time.Millisecond, 20*time.Millisecond
+	return client, func() {
+		newDeploymentsClient, checkDeployStatusPeriod, checkDeployTimeout = newClient, period, timeout
+	}
+}
+
+// newPlanDeployEvent creates the event deploying web:v2 by the plan with the strategy.
+func newPlanDeployEvent(strategy api.DeployStrategy) *api.Event {
+	return &api.Event{
+		Data: map[string]interface{}{"image-name": "web", "tag-name": "v2"},
+		Service: api.Service{
+			UserID: mockUserID,
+		},
+		Version: api.Version{
+			VersionID: "mock-version",
+			DeployPlansStatuses: []api.DeployPlanStatus{
+				{
+					PlanName: "plan",
+					Config: api.DeployConfig{
+						Deployment:  "web",
+						Containers:  []string{"web"},
+						ClusterType: KUBERNETES,
+						Strategy:    strategy,
+					},
+				},
+			},
+		},
+	}
+}
+
+// phases returns the phases and their statuses in the sub statuses.
+func phases(subStatuses []api.DeploySubStatus) []string {
+	result := []string{}
+	for _, s := range subStatuses {
+		result = append(result, string(s.Phase)+":"+string(s.Status))
+	}
+	return result
+}
+
+// TestPlanDeployRollback tests the rolling deploy rolled back when the rollout fails.
+func TestPlanDeployRollback(t *testing.T) {
+	client, restore := useFakeClient()
+	defer restore()
+
+	event := newPlanDeployEvent(api.DeployStrategy{})
+	if err := DoPlansDeploy(true, event, nil); err != nil {
+		t.Fatalf("Expected err %v to be nil", err)
+	}
+	plan := &event.Version.DeployPlansStatuses[0]
+	if plan.Status != api.DeployPending || plan.Image != "web:v2" || plan.PreviousImages["web"] != "web:v1" {
+		t.Errorf("Expected plan pending with previous image web:v1, but got %+v", plan)
+	}
+	DoPlanDeployCheck(event)
+	if plan.Status != api.DeploySuccess {
+		t.Errorf("Expected plan successful, but got %s", plan.Status)
+	}
+
+	client.BrokenImages["web:v3"] = true
+	event = newPlanDeployEvent(api.DeployStrategy{})
+	event.Data["tag-name"] = "v3"
+	if err := DoPlansDeploy(true, event, nil); err != nil {
+		t.Fatalf("Expected err %v to be nil", err)
+	}
+	DoPlanDeployCheck(event)
+	plan = &event.Version.DeployPlansStatuses[0]
+	if plan.Status != api.DeployRolledBack {
+		t.Errorf("Expected plan rolled back, but got %s", plan.Status)
+	}
+	if image := client.Deployment("web").Spec.Template.Spec.Containers[0].Image; image != "web:v2" {
+		t.Errorf("Expected image web:v2 restored, but got %s", image)
+	}
+	if len(plan.Deployments) != 2 || plan.Deployments[1].DeploymentKind != api.DeploymentKindRollack {
+		t.Errorf("Expected the deploy and rollback recorded, but got %+v", plan.Deployments)
+	}
+	if got := phases(plan.SubStatuses); !reflect.DeepEqual(got, []string{"rollout:failed", "rollback:success"}) {
+		t.Errorf("Expected rollout failed and rolled back, but got %v", got)
+	}
+}
+
+// TestPlanDeployCanary tests the canary promoted, or aborted if it is broken.
+func TestPlanDeployCanary(t *testing.T) {
+	client, restore := useFakeClient()
+	defer restore()
+
+	strategy := api.DeployStrategy{Type: api.DeployStrategyCanary, BakeTime: 0}
+	event := newPlanDeployEvent(strategy)
+	if err := DoPlansDeploy(true, event, nil); err != nil {
+		t.Fatalf("Expected err %v to be nil", err)
+	}
+	if canary := client.Deployment("web-canary"); canary == nil || canary.Spec.Replicas != defaultCanaryReplicas {
+		t.Fatalf("Expected canary deployment with %d replica, but got %+v", defaultCanaryReplicas, canary)
+	}
+	DoPlanDeployCheck(event)
+	plan := &event.Version.DeployPlansStatuses[0]
+	if plan.Status != api.DeploySuccess {
+		t.Errorf("Expected plan successful, but got %s", plan.Status)
+	}
+	if got := phases(plan.SubStatuses); !reflect.DeepEqual(got, []string{"canary:success", "bake:success", "promote:success"}) {
+		t.Errorf("Expected canary promoted, but got %v", got)
+	}
+	if image := client.Deployment("web").Spec.Template.Spec.Containers[0].Image; image != "web:v2" {
+		t.Errorf("Expected image web:v2 promoted, but got %s", image)
+	}
+	if client.Deployment("web-canary") != nil {
+		t.Errorf("Expected canary deployment deleted")
+	}
+
+	client.BrokenImages["web:v3"] = true
+	event = newPlanDeployEvent(strategy)
+	event.Data["tag-name"] = "v3"
+	if err := DoPlansDeploy(true, event, nil); err != nil {
+		t.Fatalf("Expected err %v to be nil", err)
+	}
+	DoPlanDeployCheck(event)
+	plan = &event.Version.DeployPlansStatuses[0]
+	if plan.Status != api.DeployRolledBack {
+		t.Errorf("Expected plan rolled back, but got %s", plan.Status)
+	}
+	if got := phases(plan.SubStatuses); !reflect.DeepEqual(got, []string{"canary:failed", "abort:success", "rollback:success"}) {
+		t.Errorf("Expected canary aborted, but got %v", got)
+	}
+	if image := client.Deployment("web").Spec.Template.Spec.Containers[0].Image; image != "web:v2" {
+		t.Errorf("Expected image web:v2 kept, but got %s", image)
+	}
+	if client.Deployment("web-canary") != nil {
+		t.Errorf("Expected canary deployment deleted")
+	}
+}
+
+// TestYamlDeployBlueGreen tests the service switched to the new deployment.
+func TestYamlDeployBlueGreen(t *testing.T) {
+	client, restore := useFakeClient()
+	defer restore()
+
+	event := newPlanDeployEvent(api.DeployStrategy{})
+	tree := &parser.Tree{DeployConfig: &parser.DeployNode{
+		Applications: []yaml.Application{
+			{
+				ClusterType:    KUBERNETES,
+				DeploymentName: "web",
+				Containers:     []string{"web"},
+				Strategy:       yaml.Strategy{Type: yaml.StrategyBlueGreen, Service: "web"},
+			},
+		},
+	}}
+	deploy, err := updateContainerInClusterWithYaml(event, "web:v2", tree.DeployConfig.Applications[0])
+	if err != nil {
+		t.Fatalf("Expected err %v to be nil", err)
+	}
+	event.Data[yamlK8sDeploysKey] = []*k8sDeploy{deploy}
+	event.Version.YamlDeployStatus = api.DeployPending
+
+	DoYamlDeployCheck(event, tree)
+	if event.Version.YamlDeployStatus != api.DeploySuccess {
+		t.Errorf("Expected yaml deploy successful, but got %s", event.Version.YamlDeployStatus)
+	}
+	if got := phases(event.Version.YamlDeploySubStatuses); !reflect.DeepEqual(got, []string{"rollout:success", "switch:success"}) {
+		t.Errorf("Expected the service switched, but got %v", got)
+	}
+	if color := client.Service("web").Spec.Selector[rollout.ColorLabel]; color != "green" {
+		t.Errorf("Expected the service selecting the green pods, but got %s", color)
+	}
+	if image := client.Deployment("web-green").Spec.Template.Spec.Containers[0].Image; image != "web:v2" {
+		t.Errorf("Expected web-green running web:v2, but got %s", image)
+	}
+}
+
+// TestYamlDeployManifests tests the manifests in the repository applied and rolled out.
+func TestYamlDeployManifests(t *testing.T) {
+	client, restore := useFakeClient()
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "k8s"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	manifests := `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: worker
+spec:
+  template:
+    metadata:
+      labels:
+        app: worker
+    spec:
+      containers:
+      - name: worker
+        image: {{ .Image }}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: worker
+data:
+  version: {{ .Version }}
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "k8s", "worker.yml"), []byte(manifests), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	event := newPlanDeployEvent(api.DeployStrategy{})
+	event.Data["context-dir"] = dir
+	event.Version.Name = "v2"
+	tree := &parser.Tree{DeployConfig: &parser.DeployNode{
+		Applications: []yaml.Application{
+			{
+				ClusterType:   KUBERNETES,
+				NamespaceName: "default",
+				Manifests:     "k8s",
+			},
+		},
+	}}
+	deploy, err := updateContainerInClusterWithYaml(event, "web:v2", tree.DeployConfig.Applications[0])
+	if err != nil {
+		t.Fatalf("Expected err %v to be nil", err)
+	}
+	event.Data[yamlK8sDeploysKey] = []*k8sDeploy{deploy}
+	event.Version.YamlDeployStatus = api.DeployPending
+	if image := client.Deployment("worker").Spec.Template.Spec.Containers[0].Image; image != "web:v2" {
+		t.Errorf("Expected deployment worker running web:v2, but got %s", image)
+	}
+	if version := client.ConfigMap("worker").Data["version"]; version != "v2" {
+		t.Errorf("Expected config map worker with version v2, but got %s", version)
+	}
+
+	DoYamlDeployCheck(event, tree)
+	if event.Version.YamlDeployStatus != api.DeploySuccess {
+		t.Errorf("Expected yaml deploy successful, but got %s", event.Version.YamlDeployStatus)
+	}
+	if got := phases(event.Version.YamlDeploySubStatuses); !reflect.DeepEqual(got, []string{"apply:success", "rollout:success"}) {
+		t.Errorf("Expected the manifests applied and rolled out, but got %v", got)
+	}
+}
+
+// TestPlanDeployEnvironment tests only the plans of the environment of the version
+// deployed, with the manifests rendered by the variables of the environment.
+func TestPlanDeployEnvironment(t *testing.T) {
+	client, restore := useFakeClient()
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	manifests := `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: api
+spec:
+  template:
+    metadata:
+      labels:
+        app: api
+    spec:
+      containers:
+      - name: api
+        image: {{ .Image }}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: api
+data:
+  environment: {{ .Environment }}
+  region: {{ .Variables.region }}
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "api.yml"), []byte(manifests), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	event := newPlanDeployEvent(api.DeployStrategy{})
+	event.Data["context-dir"] = dir
+	event.Service.Environments = []api.Environment{
+		{Name: "dev", Variables: map[string]string{"region": "north"}},
+		{Name: "prod", Variables: map[string]string{"region": "south"}},
+	}
+	event.Version.Environment = "dev"
+	event.Version.DeployPlansStatuses[0].Environment = "prod"
+	event.Version.DeployPlansStatuses[0].Status = api.DeployNoRun
+	event.Version.DeployPlansStatuses = append(event.Version.DeployPlansStatuses, api.DeployPlanStatus{
+		PlanName:    "api",
+		Environment: "dev",
+		Config: api.DeployConfig{
+			Namespace:   "default",
+			ClusterType: KUBERNETES,
+			Manifests:   "api.yml",
+		},
+	})
+
+	if err := DoPlansDeploy(true, event, nil); err != nil {
+		t.Fatalf("Expected err %v to be nil", err)
+	}
+	DoPlanDeployCheck(event)
+	prod, dev := event.Version.DeployPlansStatuses[0], event.Version.DeployPlansStatuses[1]
+	if prod.Status != api.DeployNoRun || client.Deployment("web").Spec.Template.Spec.Containers[0].Image != "web:v1" {
+		t.Errorf("Expected plan of prod not deployed, but got %+v", prod)
+	}
+	if dev.Status != api.DeploySuccess {
+		t.Errorf("Expected plan of dev successful, but got %s", dev.Status)
+	}
+	if got := phases(dev.SubStatuses); !reflect.DeepEqual(got, []string{"apply:success", "rollout:success"}) {
+		t.Errorf("Expected the manifests applied and rolled out, but got %v", got)
+	}
+	if data := client.ConfigMap("api").Data; data["environment"] != "dev" || data["region"] != "north" {
+		t.Errorf("Expected config map api rendered for dev, but got %v", data)
+	}
+	if len(dev.Deployments) != 1 || dev.Deployments[0].VersionLiveInfo.Environment != "dev" {
+		t.Errorf("Expected the deployment to dev recorded, but got %+v", dev.Deployments)
+	}
+}
+
+// TestPlanDeployVariables tests the variables of the environment are set to the
+// containers deployed by the images.
+func TestPlanDeployVariables(t *testing.T) {
+	client, restore := useFakeClient()
+	defer restore()
+
+	event := newPlanDeployEvent(api.DeployStrategy{})
+	event.Service.Environments = []api.Environment{
+		{Name: "dev", Variables: map[string]string{"REGION": "north"}},
+	}
+	event.Version.Environment = "dev"
+	event.Version.DeployPlansStatuses[0].Environment = "dev"
+	if err := DoPlansDeploy(true, event, nil); err != nil {
+		t.Fatalf("Expected err %v to be nil", err)
+	}
+	DoPlanDeployCheck(event)
+	if status := event.Version.DeployPlansStatuses[0].Status; status != api.DeploySuccess {
+		t.Errorf("Expected plan successful, but got %s", status)
+	}
+	container := client.Deployment("web").Spec.Template.Spec.Containers[0]
+	if container.Image != "web:v2" || len(container.Env) != 1 ||
+		container.Env[0].Name != "REGION" || container.Env[0].Value != "north" {
+		t.Errorf("Expected web:v2 with the variables of dev, but got %+v", container)
+	}
+}
+
+// TestClusterToken tests the cluster tokens are decrypted by the file given by
+// the server.
+func TestClusterToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(file string) { clusterTokensFile = file }(clusterTokensFile)
+	clusterTokensFile = filepath.Join(dir, "cluster-tokens")
+
+	if token := clusterToken("encrypted"); token != "" {
+		t.Errorf("Expected no token without the file, but got %s", token)
+	}
+	if err := ioutil.WriteFile(clusterTokensFile, []byte(`{"encrypted":"token"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if token := clusterToken("encrypted"); token != "token" {
+		t.Errorf("Expected the decrypted token, but got %s", token)
+	}
+	if token := clusterToken("other"); token != "" {
+		t.Errorf("Expected no token for another plan, but got %s", token)
+	}
+}
@@ -0,0 +1,391 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/pkg/log"
+	"github.com/caicloud/cyclone/pkg/rollout"
+	"github.com/caicloud/cyclone/pkg/wait"
+	"github.com/caicloud/cyclone/worker/ci/yaml"
+	k8s_ext_api "k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// defaultCanaryReplicas is the replicas of the canary deployment if not set.
+const defaultCanaryReplicas = 1
+
+// errCanaryUnhealthy is the error when the canary is not healthy during the bake time.
+var errCanaryUnhealthy = errors.New("canary deployment is not healthy")
+
+// k8sDeploy deploys the image to the containers of a kubernetes deployment by
+// the strategy, or applies the manifests with the image. It is started by the
+// deploy step and completed by the deploy check, the progress is recorded as
+// the sub statuses of the deploy.
+type k8sDeploy struct {
+	host       string
+	token      string
+	namespace  string
+	deployment string
+	containers []string
+	image      string
+	strategy   api.DeployStrategy
+	// manifests is the path of the manifests applied instead of setting the
+	// images, the version and the environment are substituted into them.
+	manifests   string
+	version     string
+	environment string
+	// variables are the variables of the environment, set to the containers or
+	// substituted into the manifests.
+	variables map[string]string
+	// deployments are the deployments applied by the manifests.
+	deployments []*k8s_ext_api.Deployment
+	// previousImages are the images of the containers before the deploy.
+	previousImages map[string]string
+	subStatuses    *[]api.DeploySubStatus
+}
+
+// newK8sDeployFromYaml creates the deploy of the application in caicloud.yml to
+// the environment of the version.
+func newK8sDeployFromYaml(event *api.Event, application yaml.Application, image string,
+	subStatuses *[]api.DeploySubStatus) *k8sDeploy {
+	deploy := &k8sDeploy{
+		host:       application.ClusterHost,
+		token:      application.ClusterToken,
+		namespace:  application.NamespaceName,
+		deployment: application.DeploymentName,
+		containers: application.Containers,
+		image:      image,
+		strategy: api.DeployStrategy{
+			Type:     api.DeployStrategyType(application.Strategy.Type),
+			Replicas: application.Strategy.Replicas,
+			BakeTime: application.Strategy.BakeTime,
+			Service:  application.Strategy.Service,
+		},
+		subStatuses: subStatuses,
+	}
+	deploy.setEnvironment(event, application.Manifests, event.Version.Environment)
+	return deploy
+}
+
+// newK8sDeployFromPlan creates the deploy of the deploy plan.
+func newK8sDeployFromPlan(event *api.Event, plan *api.DeployPlanStatus, image string) *k8sDeploy {
+	config := plan.Config
+	deploy := &k8sDeploy{
+		host:        config.ClusterHost,
+		token:       clusterToken(config.ClusterToken),
+		namespace:   config.Namespace,
+		deployment:  config.Deployment,
+		containers:  config.Containers,
+		image:       image,
+		strategy:    config.Strategy,
+		subStatuses: &plan.SubStatuses,
+	}
+	deploy.setEnvironment(event, config.Manifests, plan.Environment)
+	return deploy
+}
+
+// clusterTokensFile is the file of the decrypted cluster tokens, it is replaced in tests.
+var clusterTokensFile = api.ClusterTokensFile
+
+// clusterToken gets the decrypted cluster token of a deploy plan from the file
+// given by the server, the token stored encrypted is not usable. It is empty if
+// the token is not found.
+func clusterToken(token string) string {
+	if token == "" {
+		return ""
+	}
+	data, err := ioutil.ReadFile(clusterTokensFile)
+	if err != nil {
+		log.Errorf("Unable to read cluster tokens: %v", err)
+		return ""
+	}
+	tokens := make(map[string]string)
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		log.Errorf("Unable to parse cluster tokens: %v", err)
+		return ""
+	}
+	return tokens[token]
+}
+
+// setEnvironment makes the deploy use the variables of the environment of the
+// service, they are set to the containers or substituted into the manifests.
+// The manifests in the repository cloned for the event are applied if set.
+func (d *k8sDeploy) setEnvironment(event *api.Event, manifests, environment string) {
+	for _, env := range event.Service.Environments {
+		if env.Name == environment {
+			d.variables = env.Variables
+		}
+	}
+	if manifests == "" {
+		return
+	}
+	contextDir, _ := event.Data["context-dir"].(string)
+	d.manifests = filepath.Join(contextDir, manifests)
+	d.version = event.Version.Name
+	d.environment = environment
+}
+
+// values returns the values substituted into the manifests.
+func (d *k8sDeploy) values() rollout.Values {
+	return rollout.Values{
+		Image:       d.image,
+		Version:     d.version,
+		Namespace:   d.namespace,
+		Environment: d.environment,
+		Variables:   d.variables,
+	}
+}
+
+// images maps the containers to the deployed image.
+func (d *k8sDeploy) images() map[string]string {
+	return rollout.Images(d.containers, d.image)
+}
+
+// start starts the deploy, the images are rolled out to the deployment, the
+// canary deployment or the blue-green target. The previous images of the
+// containers in the deployment are recorded.
+func (d *k8sDeploy) start() (err error) {
+	client, err := newDeploymentsClient(d.host, d.token)
+	if err != nil {
+		return err
+	}
+	if d.manifests != "" {
+		return d.applyManifests(client)
+	}
+
+	switch d.strategy.Type {
+	case api.DeployStrategyCanary:
+		if d.previousImages, err = rollout.CurrentImages(client, d.namespace, d.deployment, d.images()); err != nil {
+			return err
+		}
+		replicas := d.strategy.Replicas
+		if replicas == 0 {
+			replicas = defaultCanaryReplicas
+		}
+		d.startPhase(rollout.CanaryName(d.deployment), api.DeployPhaseCanary)
+		_, err = rollout.DeployCanary(client, d.namespace, d.deployment, d.images(), d.variables, replicas)
+	case api.DeployStrategyBlueGreen:
+		d.startPhase(d.deployment, api.DeployPhaseRollout)
+		var target string
+		if target, err = rollout.DeployBlueGreen(client, d.namespace, d.deployment, d.strategy.Service, d.images(), d.variables); err == nil {
+			d.lastPhase().Deployment = target
+		}
+	default:
+		d.startPhase(d.deployment, api.DeployPhaseRollout)
+		d.previousImages, err = rollout.SetImages(client, d.namespace, d.deployment, d.images(), d.variables)
+	}
+	if err != nil {
+		d.finishPhase(err)
+	}
+	return err
+}
+
+// check completes the deploy by the strategy, and waits until the images roll
+// out. The deploy is rolled back if it fails, except blue-green, which does not
+// switch the service before the images roll out.
+func (d *k8sDeploy) check() (rolledBack bool, err error) {
+	client, err := newDeploymentsClient(d.host, d.token)
+	if err != nil {
+		d.finishPhase(err)
+		return false, err
+	}
+	if d.manifests != "" {
+		return false, d.checkManifests(client)
+	}
+
+	switch d.strategy.Type {
+	case api.DeployStrategyCanary:
+		err = d.checkCanary(client)
+	case api.DeployStrategyBlueGreen:
+		return false, d.checkBlueGreen(client)
+	default:
+		err = waitRollout(client, d.namespace, d.deployment, d.images())
+		d.finishPhase(err)
+	}
+	if err == nil {
+		return false, nil
+	}
+
+	if len(d.previousImages) == 0 {
+		return false, err
+	}
+	d.startPhase(d.deployment, api.DeployPhaseRollback)
+	errRollback := rollout.Rollback(client, d.namespace, d.deployment, d.images(), d.previousImages)
+	d.finishPhase(errRollback)
+	if errRollback != nil {
+		log.ErrorWithFields("Failed to roll back the deploy", log.Fields{"deployment": d.deployment, "err": errRollback})
+		return false, err
+	}
+	log.InfoWithFields("Rolled back the deploy", log.Fields{"deployment": d.deployment, "images": d.previousImages})
+	return true, err
+}
+
+// applyManifests renders the manifests with the image, version and environment,
+// and creates or updates the objects in them.
+func (d *k8sDeploy) applyManifests(client rollout.Client) (err error) {
+	d.startPhase("", api.DeployPhaseApply)
+	defer func() {
+		d.finishPhase(err)
+	}()
+
+	objects, err := rollout.ReadManifests(d.manifests, d.values())
+	if err != nil {
+		return err
+	}
+	d.deployments, err = rollout.ApplyManifests(client, d.namespace, objects)
+	return err
+}
+
+// checkManifests waits until the deployments applied by the manifests roll out
+// one by one. They are not rolled back, as the manifests may change more than
+// the images. The deployments are read from the manifests again if the deploy
+// is restored from a deploy plan.
+func (d *k8sDeploy) checkManifests(client rollout.Client) error {
+	if d.deployments == nil {
+		objects, err := rollout.ReadManifests(d.manifests, d.values())
+		if err != nil {
+			d.startPhase("", api.DeployPhaseRollout)
+			d.finishPhase(err)
+			return err
+		}
+		d.deployments = rollout.Deployments(d.namespace, objects)
+	}
+
+	for _, deployment := range d.deployments {
+		d.startPhase(deployment.Name, api.DeployPhaseRollout)
+		err := waitRollout(client, deployment.Namespace, deployment.Name, nil)
+		d.finishPhase(err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkCanary waits until the canary rolls out, watches it for the bake time,
+// and promotes the images to the deployment. The canary deployment is removed
+// at last, whether the deploy fails or not.
+func (d *k8sDeploy) checkCanary(client rollout.Client) error {
+	canary := rollout.CanaryName(d.deployment)
+	defer func() {
+		if err := rollout.DeleteDeployment(client, d.namespace, canary); err != nil {
+			log.ErrorWithFields("Failed to delete the canary deployment", log.Fields{"deployment": canary, "err": err})
+		}
+	}()
+
+	err := waitRollout(client, d.namespace, canary, d.images())
+	d.finishPhase(err)
+	if err == nil {
+		d.startPhase(canary, api.DeployPhaseBake)
+		err = bake(client, d.namespace, canary, d.images(), time.Duration(d.strategy.BakeTime)*time.Second)
+		d.finishPhase(err)
+	}
+	if err != nil {
+		d.startPhase(canary, api.DeployPhaseAbort)
+		d.finishPhase(nil)
+		return err
+	}
+
+	d.startPhase(d.deployment, api.DeployPhasePromote)
+	if _, err = rollout.SetImages(client, d.namespace, d.deployment, d.images(), d.variables); err == nil {
+		err = waitRollout(client, d.namespace, d.deployment, d.images())
+	}
+	d.finishPhase(err)
+	return err
+}
+
+// checkBlueGreen waits until the target deployment rolls out, and switches the
+// service to it.
+func (d *k8sDeploy) checkBlueGreen(client rollout.Client) error {
+	// The target is not changed as the service is not switched yet.
+	target, err := rollout.BlueGreenTarget(client, d.namespace, d.deployment, d.strategy.Service)
+	if err == nil {
+		err = waitRollout(client, d.namespace, target, d.images())
+	}
+	d.finishPhase(err)
+	if err != nil {
+		return err
+	}
+
+	d.startPhase(target, api.DeployPhaseSwitch)
+	err = rollout.SwitchService(client, d.namespace, d.strategy.Service, target)
+	d.finishPhase(err)
+	return err
+}
+
+// startPhase records the phase of the deploy working on the deployment as pending.
+func (d *k8sDeploy) startPhase(deployment string, phase api.DeployPhase) {
+	*d.subStatuses = append(*d.subStatuses, api.DeploySubStatus{
+		Deployment: deployment,
+		Phase:      phase,
+		Status:     api.DeployPending,
+		StartTime:  time.Now(),
+	})
+}
+
+// finishPhase records the result of the last phase of the deploy.
+func (d *k8sDeploy) finishPhase(err error) {
+	if len(*d.subStatuses) == 0 {
+		return
+	}
+	status := d.lastPhase()
+	status.Status = api.DeploySuccess
+	if err != nil {
+		status.Status = api.DeployFailed
+		status.ErrorMessage = err.Error()
+	}
+	status.EndTime = time.Now()
+}
+
+// lastPhase returns the sub status of the last phase of the deploy.
+func (d *k8sDeploy) lastPhase() *api.DeploySubStatus {
+	return &(*d.subStatuses)[len(*d.subStatuses)-1]
+}
+
+// waitRollout waits until the images roll out to the deployment.
+func waitRollout(client rollout.Client, namespace, name string, images map[string]string) error {
+	return wait.Poll(checkDeployStatusPeriod, checkDeployTimeout, func() (bool, error) {
+		return rollout.Check(client, namespace, name, images)
+	})
+}
+
+// bake watches the canary deployment for the bake time, it fails once not all
+// the replicas of the canary are available.
+func bake(client rollout.Client, namespace, name string, images map[string]string, bakeTime time.Duration) error {
+	for deadline := time.Now().Add(bakeTime); time.Now().Before(deadline); {
+		interval := deadline.Sub(time.Now())
+		if interval > checkDeployStatusPeriod {
+			interval = checkDeployStatusPeriod
+		}
+		time.Sleep(interval)
+
+		healthy, err := rollout.Check(client, namespace, name, images)
+		if err != nil {
+			return err
+		}
+		if !healthy {
+			return errCanaryUnhealthy
+		}
+	}
+	return nil
+}
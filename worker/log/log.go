@@ -64,6 +64,9 @@ const (
 	Deploy          StepEvent = "Deploy application"
 	ApplyResource   StepEvent = "Apply Resource"
 	ParseYaml       StepEvent = "Parse Yaml"
+	RestoreCache    StepEvent = "Restore Cache"
+	SaveCache       StepEvent = "Save Cache"
+	Service         StepEvent = "Service"
 )
 
 // StepState is information about step event's state
@@ -73,6 +76,7 @@ const (
 	Start  StepState = "start"
 	Stop   StepState = "stop"
 	Finish StepState = "finish"
+	Skip   StepState = "skip"
 )
 
 // InsertStepLog inserts the step information into the log file.
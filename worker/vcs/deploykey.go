@@ -0,0 +1,177 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/pkg/executil"
+	"github.com/caicloud/cyclone/pkg/log"
+)
+
+const (
+	// DEPLOY_KEY_FILE is the file in the worker with the private key of the
+	// deploy key of the service, it is put there by the worker backend.
+	DEPLOY_KEY_FILE = api.WorkerSecretsDir + "/id_deploy"
+)
+
+var (
+	// ErrNoDeployKey is returned when the service has a deploy key, but its
+	// private key is not given to the worker.
+	ErrNoDeployKey = errors.New("private key of the deploy key is not given")
+	// ErrNotSSHURL is returned when the repository with a deploy key is not
+	// an SSH URL.
+	ErrNotSSHURL = errors.New("repository with a deploy key should be an SSH URL")
+
+	// deployKeyFile is the file with the private key, it is replaced in tests.
+	deployKeyFile = DEPLOY_KEY_FILE
+)
+
+// cloneURL gets the url to clone the repository of the event. The credentials
+// are added to the url, or git is configured to use the deploy key if the
+// service has one. The returned func removes the configuration once the
+// repository is cloned.
+func cloneURL(event *api.Event, rawurl string) (string, func(), error) {
+	if event.Service.Repository.DeployKey.PublicKey == "" {
+		return getUrlwithToken(rawurl, event.Service.Repository.SubVcs, event.Data["Token"].(string)), func() {}, nil
+	}
+
+	rawurl, err := toSSHURL(rawurl, event.Service.Repository.URL)
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := ioutil.TempDir("", "deploykey")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() {
+		os.Unsetenv("GIT_SSH_COMMAND")
+		os.RemoveAll(dir)
+	}
+	if err := setupDeployKey(dir, rawurl, event.Service.Repository.DeployKey); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return rawurl, cleanup, nil
+}
+
+// toSSHURL rewrites the http or https url on the host of the repository, like
+// the url of a pull request, to an SSH url, so that it's cloned with the
+// deploy key. Other urls are returned as they are.
+func toSSHURL(rawurl, repoURL string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return rawurl, nil
+	}
+
+	host, port, err := sshHost(repoURL)
+	if err != nil {
+		return "", err
+	}
+	urlHost := u.Host
+	if i := strings.LastIndex(urlHost, ":"); i >= 0 {
+		urlHost = urlHost[:i]
+	}
+	if !strings.EqualFold(urlHost, host) {
+		return "", ErrNotSSHURL
+	}
+
+	user := "git"
+	if i := strings.Index(repoURL, "@"); i >= 0 {
+		user = strings.TrimPrefix(repoURL[:i], "ssh://")
+	}
+	path := strings.Trim(u.Path, "/")
+	if !strings.HasSuffix(path, ".git") {
+		path += ".git"
+	}
+	if port != "" {
+		return fmt.Sprintf("ssh://%s@%s:%s/%s", user, host, port, path), nil
+	}
+	return fmt.Sprintf("%s@%s:%s", user, host, path), nil
+}
+
+// setupDeployKey writes the known hosts to the directory, and configures git
+// to use them and the private key by GIT_SSH_COMMAND. The host keys are
+// scanned if the known hosts are not given, they are trusted on the first use.
+func setupDeployKey(dir, rawurl string, key api.DeployKey) error {
+	host, port, err := sshHost(rawurl)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(deployKeyFile); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNoDeployKey
+		}
+		return err
+	}
+
+	knownHosts := []byte(key.KnownHosts)
+	if key.KnownHosts == "" {
+		log.Warnf("Known hosts of the deploy key are not given, trust the host keys of %s", host)
+		args := []string{"-T", "10"}
+		if port != "" {
+			args = append(args, "-p", port)
+		}
+		args = append(args, host)
+		knownHosts, err = executil.RunInDir(dir, "ssh-keyscan", args...)
+		if err != nil {
+			return fmt.Errorf("Unable to scan host keys of %s: %v", host, err)
+		}
+	}
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	if err := ioutil.WriteFile(knownHostsFile, knownHosts, 0600); err != nil {
+		return err
+	}
+
+	command := fmt.Sprintf("ssh -i %s -o IdentitiesOnly=yes -o UserKnownHostsFile=%s -o StrictHostKeyChecking=yes",
+		deployKeyFile, knownHostsFile)
+	return os.Setenv("GIT_SSH_COMMAND", command)
+}
+
+// sshHost gets the host and port of the SSH url, like ssh://git@github.com:22/caicloud/cyclone.git
+// or git@github.com:caicloud/cyclone.git, the port is empty if not specified.
+func sshHost(rawurl string) (string, string, error) {
+	if strings.HasPrefix(rawurl, "ssh://") {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return "", "", err
+		}
+		host, port := u.Host, ""
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host, port = host[:i], host[i+1:]
+		}
+		return host, port, nil
+	}
+
+	// The scp-like syntax, [user@]host:path.
+	if strings.Contains(rawurl, "://") || !strings.Contains(rawurl, ":") {
+		return "", "", ErrNotSSHURL
+	}
+	host := rawurl[:strings.Index(rawurl, ":")]
+	if i := strings.Index(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	return host, "", nil
+}
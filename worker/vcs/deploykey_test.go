@@ -0,0 +1,155 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caicloud/cyclone/api"
+)
+
+// TestSSHHost tests getting the host of SSH urls.
+func TestSSHHost(t *testing.T) {
+	cases := []struct {
+		url  string
+		host string
+		port string
+		err  error
+	}{
+		{"git@github.com:caicloud/cyclone.git", "github.com", "", nil},
+		{"ssh://git@gitlab.example.com:2222/caicloud/cyclone.git", "gitlab.example.com", "2222", nil},
+		{"ssh://gitlab.example.com/caicloud/cyclone.git", "gitlab.example.com", "", nil},
+		{"https://github.com/caicloud/cyclone.git", "", "", ErrNotSSHURL},
+		{"/tmp/cyclone", "", "", ErrNotSSHURL},
+	}
+	for _, c := range cases {
+		host, port, err := sshHost(c.url)
+		if host != c.host || port != c.port || err != c.err {
+			t.Errorf("Expect %s, %s, %v for %s, got %s, %s, %v", c.host, c.port, c.err, c.url, host, port, err)
+		}
+	}
+}
+
+// TestToSSHURL tests rewriting the http urls on the host of the repository,
+// like the urls of pull requests, to SSH urls.
+func TestToSSHURL(t *testing.T) {
+	cases := []struct {
+		url      string
+		repoURL  string
+		expected string
+		err      error
+	}{
+		{"https://github.com/someone/cyclone.git", "git@github.com:caicloud/cyclone.git", "git@github.com:someone/cyclone.git", nil},
+		{"https://GitHub.com/someone/cyclone", "git@github.com:caicloud/cyclone.git", "git@github.com:someone/cyclone.git", nil},
+		{"http://gitlab.example.com/caicloud/cyclone.git", "ssh://gitlab@gitlab.example.com:2222/caicloud/cyclone.git", "ssh://gitlab@gitlab.example.com:2222/caicloud/cyclone.git", nil},
+		{"git@github.com:caicloud/cyclone.git", "git@github.com:caicloud/cyclone.git", "git@github.com:caicloud/cyclone.git", nil},
+		{"https://gitlab.com/someone/cyclone.git", "git@github.com:caicloud/cyclone.git", "", ErrNotSSHURL},
+	}
+	for _, c := range cases {
+		url, err := toSSHURL(c.url, c.repoURL)
+		if url != c.expected || err != c.err {
+			t.Errorf("Expect %s, %v for %s, got %s, %v", c.expected, c.err, c.url, url, err)
+		}
+	}
+}
+
+// TestSetupDeployKey tests configuring git with the deploy key.
+func TestSetupDeployKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deploykey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv("GIT_SSH_COMMAND")
+	defer func(file string) { deployKeyFile = file }(deployKeyFile)
+
+	key := api.DeployKey{
+		PublicKey:  "ssh-rsa AAAA",
+		KnownHosts: "github.com ssh-rsa AAAA",
+	}
+	url := "git@github.com:caicloud/cyclone.git"
+	deployKeyFile = filepath.Join(dir, "id_deploy")
+	if err := setupDeployKey(dir, url, key); err != ErrNoDeployKey {
+		t.Errorf("Expect ErrNoDeployKey, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(deployKeyFile, []byte("private key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := setupDeployKey(dir, url, key); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "known_hosts")); err != nil || string(content) != key.KnownHosts {
+		t.Errorf("Expect known hosts %s, got %s, %v", key.KnownHosts, content, err)
+	}
+	command := os.Getenv("GIT_SSH_COMMAND")
+	if !strings.Contains(command, "-i "+deployKeyFile) || !strings.Contains(command, "StrictHostKeyChecking=yes") {
+		t.Errorf("Expect GIT_SSH_COMMAND to use the deploy key strictly, got %s", command)
+	}
+}
+
+// TestCloneURLOfPullRequest tests the https url of a pull request version is
+// cloned by SSH with the deploy key, and the configuration is removed after.
+func TestCloneURLOfPullRequest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deploykey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(file string) { deployKeyFile = file }(deployKeyFile)
+	deployKeyFile = filepath.Join(dir, "id_deploy")
+	if err := ioutil.WriteFile(deployKeyFile, []byte("private key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	event := &api.Event{}
+	event.Service.Repository = api.ServiceRepository{
+		URL: "git@github.com:caicloud/cyclone.git",
+		DeployKey: api.DeployKey{
+			PublicKey:  "ssh-rsa AAAA",
+			KnownHosts: "github.com ssh-rsa AAAA",
+		},
+	}
+	event.Version.URL = "https://github.com/someone/cyclone.git"
+
+	url, cleanup, err := cloneURL(event, event.Version.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "git@github.com:someone/cyclone.git" {
+		t.Errorf("Expect the SSH url of the pull request, got %s", url)
+	}
+	command := os.Getenv("GIT_SSH_COMMAND")
+	if !strings.Contains(command, "-i "+deployKeyFile) {
+		t.Errorf("Expect GIT_SSH_COMMAND to use the deploy key, got %s", command)
+	}
+
+	cleanup()
+	if command := os.Getenv("GIT_SSH_COMMAND"); command != "" {
+		t.Errorf("Expect GIT_SSH_COMMAND to be unset, got %s", command)
+	}
+	knownHostsFile := command[strings.Index(command, "UserKnownHostsFile=")+len("UserKnownHostsFile="):]
+	knownHostsFile = knownHostsFile[:strings.Index(knownHostsFile, " ")]
+	if _, err := os.Stat(filepath.Dir(knownHostsFile)); !os.IsNotExist(err) {
+		t.Errorf("Expect the temporary directory to be removed, got %v", err)
+	}
+}
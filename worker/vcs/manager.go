@@ -102,10 +102,15 @@ func (vm *Manager) CloneServiceRepository(event *api.Event) error {
 		return fmt.Errorf("Unable to write to event output for event: %v\n", err)
 	}
 
-	url := getUrlwithToken(event.Service.Repository.URL, event.Service.Repository.SubVcs, event.Data["Token"].(string))
+	url, cleanup, err := cloneURL(event, event.Service.Repository.URL)
+	if err != nil {
+		event.Service.Repository.Status = api.RepositoryMissing
+		return cloneError(err, "Unable to set up deploy key for service: %v\n")
+	}
+	defer cleanup()
 	if err := worker.CloneRepo(url, destPath, event); err != nil {
 		event.Service.Repository.Status = api.RepositoryMissing
-		return fmt.Errorf("Unable to clone repository for service: %v\n", err)
+		return cloneError(err, "Unable to clone repository for service: %v\n")
 	}
 
 	// Happy path - update status to healthy and return nil error. Database status
@@ -115,6 +120,17 @@ func (vm *Manager) CloneServiceRepository(event *api.Event) error {
 	return nil
 }
 
+// cloneError formats the error of the clone, it is still unavailable if the
+// repository does not exist or the credentials are refused. The deploy key not
+// given or not usable for the url is also unavailable.
+func cloneError(err error, format string) error {
+	formatted := fmt.Errorf(format, err)
+	if provider.IsUnavailable(err) || err == ErrNoDeployKey || err == ErrNotSSHURL {
+		return &provider.UnavailableError{Err: formatted}
+	}
+	return formatted
+}
+
 // CloneVersionRepository clones a version's repo
 func (vm *Manager) CloneVersionRepository(event *api.Event) error {
 	// Get the path to store cloned repository.
@@ -129,11 +145,16 @@ func (vm *Manager) CloneVersionRepository(event *api.Event) error {
 		return fmt.Errorf("Unable to write to event output for event: %v\n", err)
 	}
 
-	url := getUrlwithToken(event.Version.URL, event.Service.Repository.SubVcs, event.Data["Token"].(string))
 	steplog.InsertStepLog(event, steplog.CloneRepository, steplog.Start, nil)
+	url, cleanup, err := cloneURL(event, event.Version.URL)
+	if err != nil {
+		steplog.InsertStepLog(event, steplog.CloneRepository, steplog.Stop, err)
+		return cloneError(err, "Unable to set up deploy key for version: %v\n")
+	}
+	defer cleanup()
 	if err := worker.CloneRepo(url, destPath, event); err != nil {
 		steplog.InsertStepLog(event, steplog.CloneRepository, steplog.Stop, err)
-		return fmt.Errorf("Unable to clone repository for version: %v\n", err)
+		return cloneError(err, "Unable to clone repository for version: %v\n")
 	}
 	// create version call by UI API, the commit is empty
 	// create version call by webhook, the commit is not empty
@@ -230,6 +251,8 @@ func (vm *Manager) findVcsForService(service *api.Service) (VCS, error) {
 		return &provider.Git{}, nil
 	case api.Svn:
 		return &provider.Svn{}, nil
+	case api.Mercurial:
+		return &provider.Mercurial{}, nil
 	case api.Fake:
 		return provider.NewFake(service.Repository.URL)
 	default:
@@ -0,0 +1,68 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provider
+
+import "strings"
+
+// unavailableOutputs are the outputs of git, hg and svn, in lower case, when
+// the repository does not exist or the credentials are refused.
+var unavailableOutputs = []string{
+	"authentication failed",
+	"authorization failed",
+	"could not read username",
+	"could not read password",
+	"permission denied",
+	"access denied",
+	"not found",
+	"does not appear to be a git repository",
+	"does not exist",
+	"http error 401",
+	"http error 403",
+	"http error 404",
+}
+
+// UnavailableError is the error when the repository does not exist or the
+// credentials are refused, cloning again does not help.
+type UnavailableError struct {
+	Err error
+}
+
+func (e *UnavailableError) Error() string {
+	return e.Err.Error()
+}
+
+// IsUnavailable returns whether the error is caused by the repository not
+// existing or the credentials refused.
+func IsUnavailable(err error) bool {
+	_, ok := err.(*UnavailableError)
+	return ok
+}
+
+// cloneError classifies the error of the clone by the output of the command,
+// the network errors are returned as they are.
+func cloneError(output []byte, err error) error {
+	if err == nil {
+		return nil
+	}
+	lower := strings.ToLower(string(output))
+	for _, unavailable := range unavailableOutputs {
+		if strings.Contains(lower, unavailable) {
+			return &UnavailableError{Err: err}
+		}
+	}
+	return err
+}
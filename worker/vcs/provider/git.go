@@ -17,8 +17,15 @@ limitations under the License.
 package provider
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	neturl "net/url"
+	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -32,6 +39,15 @@ import (
 	"golang.org/x/oauth2"
 )
 
+var (
+	// ErrGitLFSNotFound is the error when the Git LFS files are to be fetched
+	// but git-lfs is not installed in the worker.
+	ErrGitLFSNotFound = errors.New("git-lfs is not installed in the worker, it is required to fetch the Git LFS files")
+
+	// lookPath searches for the executable, it is replaced in tests.
+	lookPath = exec.LookPath
+)
+
 // Git is the type for git provider.
 type Git struct{}
 
@@ -44,14 +60,31 @@ func NewGit() *Git {
 func (g *Git) CloneRepo(url, destPath string, event *api.Event) error {
 	log.InfoWithFields("About to clone git repository.", log.Fields{"url": url, "destPath": destPath})
 
+	options := event.Service.Repository.CloneOptions
 	base := path.Base(destPath)
 	dir := path.Dir(destPath)
-	args := []string{"clone", url, base}
+	args := []string{"clone"}
+	if options.Depth > 0 {
+		// Fetch all the branches so that the versions of other branches can
+		// be checked out.
+		args = append(args, "--depth", strconv.Itoa(options.Depth), "--no-single-branch")
+	}
+	if len(options.SparsePaths) > 0 {
+		args = append(args, "--no-checkout")
+	}
+	args = append(args, url, base)
 
 	output, err := executil.RunInDir(dir, "git", args...)
 	if event.Version.VersionID != "" {
 		fmt.Fprintf(steplog.Output, "%s", string(output))
 	}
+	err = cloneError(output, err)
+	if err == nil && len(options.SparsePaths) > 0 {
+		err = sparseCheckout(destPath, options.SparsePaths)
+	}
+	if err == nil {
+		err = updateDependencies(destPath, options)
+	}
 
 	if err != nil {
 		log.ErrorWithFields("Error when clone", log.Fields{"error": err})
@@ -61,6 +94,87 @@ func (g *Git) CloneRepo(url, destPath string, event *api.Event) error {
 	return err
 }
 
+// sparseCheckout checks out only the paths in the repository cloned without
+// checkout.
+func sparseCheckout(repoPath string, paths []string) error {
+	output, err := executil.RunInDir(repoPath, "git", "config", "core.sparseCheckout", "true")
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+
+	patterns := make([]string, 0, len(paths))
+	for _, p := range paths {
+		patterns = append(patterns, "/"+strings.Trim(p, "/"))
+	}
+	file := filepath.Join(repoPath, ".git", "info", "sparse-checkout")
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, []byte(strings.Join(patterns, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+
+	output, err = executil.RunInDir(repoPath, "git", "read-tree", "-mu", "HEAD")
+	fmt.Fprintf(steplog.Output, "%s", string(output))
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}
+
+// updateDependencies fetches the submodules and the Git LFS files of the
+// commit checked out as the options tell.
+func updateDependencies(repoPath string, options api.CloneOptions) error {
+	if options.Submodules {
+		args := []string{}
+		output, err := executil.RunInDir(repoPath, "git", "config", "remote.origin.url")
+		if err == nil {
+			args = append(args, credentialRewrites(strings.TrimSpace(string(output)))...)
+		}
+		args = append(args, "submodule", "update", "--init", "--recursive")
+		if options.Depth > 0 {
+			args = append(args, "--depth", strconv.Itoa(options.Depth))
+		}
+		output, err = executil.RunInDir(repoPath, "git", args...)
+		fmt.Fprintf(steplog.Output, "%s", string(output))
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, output)
+		}
+	}
+
+	if options.LFS {
+		if _, err := lookPath("git-lfs"); err != nil {
+			return ErrGitLFSNotFound
+		}
+		args := []string{"lfs", "pull"}
+		if len(options.SparsePaths) > 0 {
+			args = append(args, "--include", strings.Join(options.SparsePaths, ","))
+		}
+		output, err := executil.RunInDir(repoPath, "git", args...)
+		fmt.Fprintf(steplog.Output, "%s", string(output))
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, output)
+		}
+	}
+	return nil
+}
+
+// credentialRewrites returns the git config arguments to fetch the
+// repositories on the same host as the rawurl with its credentials, so that
+// the submodules of private repositories can be cloned.
+func credentialRewrites(rawurl string) []string {
+	u, err := neturl.Parse(rawurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User == nil {
+		return nil
+	}
+
+	withCredentials := u.Scheme + "://" + u.User.String() + "@" + u.Host + "/"
+	return []string{
+		"-c", "url." + withCredentials + ".insteadOf=" + u.Scheme + "://" + u.Host + "/",
+		"-c", "url." + withCredentials + ".insteadOf=git@" + u.Host + ":",
+	}
+}
+
 // NewTagFromLatest implements VCS interface.
 func (g *Git) NewTagFromLatest(repoPath string, event *api.Event) error {
 	service := event.Service
@@ -169,10 +283,27 @@ func checkoutMaster(repoPath string) {
 func (g *Git) CheckOutByCommitID(commitID string, repoPath string, event *api.Event) error {
 	log.Infof("checkout commit: %s", commitID)
 
+	options := event.Service.Repository.CloneOptions
+	if options.Depth > 0 {
+		// The commit may be out of the shallow history, fetch it.
+		if _, err := executil.RunInDir(repoPath, "git", "cat-file", "-e", commitID+"^{commit}"); err != nil {
+			args := []string{"fetch", "--depth", strconv.Itoa(options.Depth), "origin", commitID}
+			output, err := executil.RunInDir(repoPath, "git", args...)
+			fmt.Fprintf(steplog.Output, "%s", string(output))
+			if err != nil {
+				log.ErrorWithFields("Error when fetch commit", log.Fields{"error": err})
+				return err
+			}
+		}
+	}
+
 	args := []string{"-C", repoPath, "reset", "--hard", commitID}
 
 	output, err := executil.RunInDir(repoPath, "git", args...)
 	fmt.Fprintf(steplog.Output, "%s", string(output))
+	if err == nil {
+		err = updateDependencies(repoPath, options)
+	}
 
 	if err != nil {
 		log.ErrorWithFields("Error when checkout", log.Fields{"error": err})
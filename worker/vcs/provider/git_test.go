@@ -0,0 +1,195 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/pkg/executil"
+	"github.com/caicloud/cyclone/pkg/filebuffer"
+	steplog "github.com/caicloud/cyclone/worker/log"
+)
+
+// TestCredentialRewrites tests rewriting the urls of the submodules.
+func TestCredentialRewrites(t *testing.T) {
+	cases := []struct {
+		url      string
+		expected []string
+	}{
+		{"https://token@github.com/caicloud/cyclone.git", []string{
+			"-c", "url.https://token@github.com/.insteadOf=https://github.com/",
+			"-c", "url.https://token@github.com/.insteadOf=git@github.com:",
+		}},
+		{"https://github.com/caicloud/cyclone.git", nil},
+		{"git@github.com:caicloud/cyclone.git", nil},
+	}
+	for _, c := range cases {
+		if args := credentialRewrites(c.url); !reflect.DeepEqual(args, c.expected) {
+			t.Errorf("Expect args %v, got %v", c.expected, args)
+		}
+	}
+}
+
+// git runs the git command in the directory, the test fails on error.
+func git(t *testing.T, dir string, args ...string) string {
+	output, err := executil.RunInDir(dir, "git", args...)
+	if err != nil {
+		t.Fatalf("git %v: %v, %s", args, err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// commit writes the file in the repository and commits it.
+func commit(t *testing.T, repo, file, content string) string {
+	if err := os.MkdirAll(filepath.Dir(filepath.Join(repo, file)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(repo, file), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, repo, "add", "-A")
+	git(t, repo, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-m", file)
+	return git(t, repo, "rev-parse", "HEAD")
+}
+
+// TestGitCloneOptions tests cloning with the clone options against local
+// repositories.
+func TestGitCloneOptions(t *testing.T) {
+	root, err := ioutil.TempDir("", "git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	logFile, err := os.Create(filepath.Join(root, "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	steplog.Output = filebuffer.NewFileBuffer(1<<20, logFile)
+
+	// Newer git refuses the submodules over the file protocol by default.
+	os.Setenv("GIT_CONFIG_COUNT", "1")
+	os.Setenv("GIT_CONFIG_KEY_0", "protocol.file.allow")
+	os.Setenv("GIT_CONFIG_VALUE_0", "always")
+	defer os.Unsetenv("GIT_CONFIG_COUNT")
+	defer os.Unsetenv("GIT_CONFIG_KEY_0")
+	defer os.Unsetenv("GIT_CONFIG_VALUE_0")
+
+	lib := filepath.Join(root, "lib")
+	origin := filepath.Join(root, "origin")
+	for _, repo := range []string{lib, origin} {
+		if err := os.Mkdir(repo, 0755); err != nil {
+			t.Fatal(err)
+		}
+		git(t, repo, "init")
+	}
+	commit(t, lib, "lib.go", "lib")
+	first := commit(t, origin, "app/main.go", "v1")
+	git(t, origin, "-c", "protocol.file.allow=always", "submodule", "add", "file://"+lib, "lib")
+	commit(t, origin, "docs/README.md", "docs")
+	commit(t, origin, "app/main.go", "v2")
+	url := "file://" + origin
+
+	g := NewGit()
+	event := &api.Event{}
+	event.Service.Repository.CloneOptions = api.CloneOptions{
+		Depth:       1,
+		Submodules:  true,
+		SparsePaths: []string{"app/", "lib", ".gitmodules"},
+	}
+	dest := filepath.Join(root, "clone")
+	if err := g.CloneRepo(url, dest, event); err != nil {
+		t.Fatal(err)
+	}
+	if count := git(t, dest, "rev-list", "--count", "HEAD"); count != "1" {
+		t.Errorf("Expect 1 commit in the shallow clone, got %s", count)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "docs", "README.md")); !os.IsNotExist(err) {
+		t.Errorf("Expect docs to be excluded from the sparse checkout, got %v", err)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(dest, "app", "main.go")); err != nil || string(content) != "v2" {
+		t.Errorf("Expect app/main.go to be v2, got %s, %v", content, err)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(dest, "lib", "lib.go")); err != nil || string(content) != "lib" {
+		t.Errorf("Expect the submodule to be fetched, got %s, %v", content, err)
+	}
+
+	// The first commit is out of the shallow history.
+	if err := g.CheckOutByCommitID(first, dest, event); err != nil {
+		t.Fatal(err)
+	}
+	if head := git(t, dest, "rev-parse", "HEAD"); head != first {
+		t.Errorf("Expect HEAD %s, got %s", first, head)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(dest, "app", "main.go")); err != nil || string(content) != "v1" {
+		t.Errorf("Expect app/main.go to be v1, got %s, %v", content, err)
+	}
+}
+
+// TestGitLFSNotFound tests fetching the Git LFS files fails clearly if git-lfs
+// is not installed.
+func TestGitLFSNotFound(t *testing.T) {
+	defer func(f func(string) (string, error)) { lookPath = f }(lookPath)
+	lookPath = func(file string) (string, error) {
+		return "", exec.ErrNotFound
+	}
+
+	err := updateDependencies(".", api.CloneOptions{LFS: true})
+	if err != ErrGitLFSNotFound {
+		t.Errorf("Expect error %v, got %v", ErrGitLFSNotFound, err)
+	}
+}
+
+// TestGitCloneUnavailable tests the clone of a missing repository is not
+// retried, while the network errors are.
+func TestGitCloneUnavailable(t *testing.T) {
+	root, err := ioutil.TempDir("", "git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	err = NewGit().CloneRepo(filepath.Join(root, "missing"), filepath.Join(root, "clone"), &api.Event{})
+	if !IsUnavailable(err) {
+		t.Errorf("Expect the missing repository unavailable, got %v", err)
+	}
+
+	cases := []struct {
+		output      string
+		unavailable bool
+	}{
+		{"remote: Repository not found.\nfatal: repository 'https://github.com/caicloud/missing/' not found", true},
+		{"fatal: Authentication failed for 'https://github.com/caicloud/cyclone/'", true},
+		{"git@github.com: Permission denied (publickey).\nfatal: Could not read from remote repository.", true},
+		{"abort: authorization failed", true},
+		{"fatal: unable to access 'https://github.com/caicloud/cyclone/': Could not resolve host: github.com", false},
+		{"ssh: connect to host github.com port 22: Connection timed out\nfatal: Could not read from remote repository.", false},
+	}
+	for _, c := range cases {
+		err := cloneError([]byte(c.output), exec.ErrNotFound)
+		if IsUnavailable(err) != c.unavailable {
+			t.Errorf("Expect unavailable %v for output %q, got %v", c.unavailable, c.output, err)
+		}
+	}
+}
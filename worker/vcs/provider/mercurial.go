@@ -0,0 +1,148 @@
+/*
+Copyright 2016 caicloud authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provider
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/caicloud/cyclone/api"
+	"github.com/caicloud/cyclone/pkg/executil"
+	"github.com/caicloud/cyclone/pkg/log"
+	steplog "github.com/caicloud/cyclone/worker/log"
+)
+
+const (
+	// hgDefaultBranch is the default branch of mercurial, the latest commit is
+	// asked by the branch master of git.
+	hgDefaultBranch = "default"
+	// hgUser is the user to commit the tags created by cyclone.
+	hgUser = "circle <circle@caicloud.io>"
+)
+
+// Mercurial is the type for mercurial provider.
+type Mercurial struct{}
+
+// NewMercurial returns a new Mercurial worker.
+func NewMercurial() *Mercurial {
+	return &Mercurial{}
+}
+
+// CloneRepo implements VCS interface.
+func (m *Mercurial) CloneRepo(url, destPath string, event *api.Event) error {
+	log.InfoWithFields("About to clone mercurial repository.", log.Fields{"url": url, "destPath": destPath})
+
+	repository := event.Service.Repository
+	base := path.Base(destPath)
+	dir := path.Dir(destPath)
+	args := []string{"clone", "--noninteractive", hgURLWithCredentials(url, repository.Username, repository.Password), base}
+
+	output, err := executil.RunInDir(dir, "hg", args...)
+	if event.Version.VersionID != "" {
+		fmt.Fprintf(steplog.Output, "%s", string(output))
+	}
+	err = cloneError(output, err)
+
+	if err != nil {
+		log.ErrorWithFields("Error when clone", log.Fields{"error": err})
+	} else {
+		log.InfoWithFields("Successfully cloned mercurial repository.", log.Fields{"url": url, "destPath": destPath})
+	}
+	return err
+}
+
+// hgURLWithCredentials adds the username and password to the http(s) url.
+func hgURLWithCredentials(rawurl, username, password string) string {
+	if username == "" {
+		return rawurl
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User != nil {
+		return rawurl
+	}
+	u.User = url.UserPassword(username, password)
+	return u.String()
+}
+
+// NewTagFromLatest implements VCS interface.
+func (m *Mercurial) NewTagFromLatest(repoPath string, event *api.Event) error {
+	version := event.Version
+	tagName := version.Name + api.AutoCreateTagFlag
+
+	args := []string{"tag", "--noninteractive", "-u", hgUser, "-m", "Cyclone auto tag " + version.Name}
+	if version.Commit != "" {
+		args = append(args, "-r", version.Commit)
+	}
+	args = append(args, tagName)
+	output, err := executil.RunInDir(repoPath, "hg", args...)
+	log.Infof("Command output: %+v", string(output))
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+
+	// Push the changeset of .hgtags to the repository cloned from.
+	output, err = executil.RunInDir(repoPath, "hg", "push", "--noninteractive", "-r", ".")
+	log.Infof("Command output: %+v", string(output))
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	log.InfoWithFields("Successfully created mercurial tag.", log.Fields{"repoPath": repoPath, "tag": tagName})
+	return nil
+}
+
+// CheckoutTag implements VCS interface.
+func (m *Mercurial) CheckoutTag(repoPath string, tag string) error {
+	args := []string{"update", "--noninteractive", "--clean", "-r", tag}
+	output, err := executil.RunInDir(repoPath, "hg", args...)
+
+	log.Debugf("Command output: %+v", string(output))
+	if err == nil {
+		log.InfoWithFields("Successfully checked out to mercurial tag.", log.Fields{"repoPath": repoPath, "tag": tag})
+	}
+	return err
+}
+
+// GetTagCommit implements VCS interface.
+func (m *Mercurial) GetTagCommit(repoPath string, tag string) (string, error) {
+	if tag == "master" {
+		tag = hgDefaultBranch
+	}
+	args := []string{"log", "--noninteractive", "-r", tag, "--template", "{node}"}
+	output, err := executil.RunInDir(repoPath, "hg", args...)
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, output)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// CheckOutByCommitID checks out code in repo by special commit id.
+func (m *Mercurial) CheckOutByCommitID(commitID string, repoPath string, event *api.Event) error {
+	log.Infof("checkout commit: %s", commitID)
+
+	args := []string{"update", "--noninteractive", "--clean", "-r", commitID}
+	output, err := executil.RunInDir(repoPath, "hg", args...)
+	fmt.Fprintf(steplog.Output, "%s", string(output))
+
+	if err != nil {
+		log.ErrorWithFields("Error when checkout", log.Fields{"error": err})
+	} else {
+		log.Info("Successfully checkout commit.")
+	}
+	return err
+}
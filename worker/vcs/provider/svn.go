@@ -45,6 +45,7 @@ func (s *Svn) CloneRepo(url, destPath string, event *api.Event) error {
 	if event.Version.VersionID != "" {
 		fmt.Fprintf(steplog.Output, "%q", string(output))
 	}
+	err = cloneError(output, err)
 
 	if err != nil {
 		log.ErrorWithFields("Error when clone", log.Fields{"error": err})